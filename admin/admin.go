@@ -0,0 +1,151 @@
+// Package admin holds the operations behind the order-up admin command which
+// on-call engineers use to inspect and fix orders without poking at MongoDB by
+// hand. Reading orders, forcing a status and the reconciliation report go to
+// storage directly while replaying a charge or a fulfillment goes through the
+// HTTP API since only the service talks to the charge and fulfillment services.
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/levenlabs/order-up/storage"
+)
+
+// Store is where the orders are read from and written to. It's implemented by
+// *storage.Instance.
+type Store interface {
+	// GetOrder should return the order with the given ID. If that ID isn't found
+	// then the special ErrOrderNotFound error should be returned.
+	GetOrder(ctx context.Context, id string) (storage.Order, error)
+	// EachOrder should call fn with every order matching the filter, sorted by
+	// ID. If fn returns an error then EachOrder should stop and return it.
+	EachOrder(ctx context.Context, filter storage.OrderFilter, fn func(storage.Order) error) error
+	// SetOrderStatus should update the order with the given ID, set the status
+	// field, increment its version and append an entry to the order's history
+	// with the actor and reason from the context. If its version changed then
+	// ErrVersionConflict should be returned.
+	SetOrderStatus(ctx context.Context, id string, status storage.OrderStatus, version int64) error
+}
+
+// ensure *storage.Instance can be used as the Store
+var _ Store = (*storage.Instance)(nil)
+
+// ErrReasonRequired is returned by ForceStatus when there's no reason
+var ErrReasonRequired = errors.New("a reason is required")
+
+// knownStatus returns true if status has a name
+func knownStatus(status storage.OrderStatus) bool {
+	_, err := storage.ParseOrderStatus(status.String())
+	return err == nil
+}
+
+// StatusName returns the name of status, like charged, or its number if it's
+// not a known status
+func StatusName(status storage.OrderStatus) string {
+	if !knownStatus(status) {
+		return strconv.FormatInt(int64(status), 10)
+	}
+	return status.String()
+}
+
+// ParseStatus returns the status with the given name, like charged. The empty
+// string and "any" return storage.OrderStatusAny for filtering.
+func ParseStatus(name string) (storage.OrderStatus, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || name == "any" {
+		return storage.OrderStatusAny, nil
+	}
+	status, err := storage.ParseOrderStatus(name)
+	if err != nil {
+		return 0, fmt.Errorf("unknown status: %q", name)
+	}
+	return status, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// errLimit stops EachOrder once enough orders were found
+var errLimit = errors.New("limit reached")
+
+// List returns up to limit orders matching filter, sorted by ID. A limit of 0
+// returns every matching order.
+func List(ctx context.Context, store Store, filter storage.OrderFilter, limit int) ([]storage.Order, error) {
+	return Search(ctx, store, filter, "", limit)
+}
+
+// Search returns up to limit orders matching filter whose ID, customer email,
+// notes, promo codes or line item descriptions contain query, ignoring case.
+// Every order is scanned so it's slow for large collections but it never loads
+// more than a batch of orders into memory at a time. A limit of 0 returns every
+// matching order.
+func Search(ctx context.Context, store Store, filter storage.OrderFilter, query string, limit int) ([]storage.Order, error) {
+	query = strings.ToLower(query)
+	orders := []storage.Order{}
+	err := store.EachOrder(ctx, filter, func(order storage.Order) error {
+		if !matches(order, query) {
+			return nil
+		}
+		orders = append(orders, order)
+		if limit > 0 && len(orders) >= limit {
+			return errLimit
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errLimit) {
+		return nil, fmt.Errorf("error searching orders: %w", err)
+	}
+	return orders, nil
+}
+
+// matches returns true if any of the order's searchable fields contain query,
+// which is already lowercase
+func matches(order storage.Order, query string) bool {
+	if query == "" {
+		return true
+	}
+	fields := []string{order.ID, order.CustomerEmail, order.Notes}
+	fields = append(fields, order.PromoCodes...)
+	for _, li := range order.LineItems {
+		fields = append(fields, li.Description)
+	}
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ForceStatus sets the status of the order with the given ID regardless of
+// whether the API allows that transition and returns the updated order. The
+// reason is required and recorded in the order's history along with the actor
+// from ctx, see storage.WithActor. Nothing is charged, refunded or fulfilled.
+func ForceStatus(ctx context.Context, store Store, id string, status storage.OrderStatus, reason string) (storage.Order, error) {
+	if strings.TrimSpace(reason) == "" {
+		return storage.Order{}, ErrReasonRequired
+	}
+	if !knownStatus(status) {
+		return storage.Order{}, fmt.Errorf("unknown status: %d", status)
+	}
+	order, err := store.GetOrder(ctx, id)
+	if err != nil {
+		return storage.Order{}, fmt.Errorf("error getting order: %w", err)
+	}
+	// the version makes sure we don't overwrite a change that happened since the
+	// order was loaded, like the service charging it
+	err = store.SetOrderStatus(storage.WithReason(ctx, reason), id, status, order.Version)
+	if err != nil {
+		return storage.Order{}, fmt.Errorf("error setting status: %w", err)
+	}
+	order, err = store.GetOrder(ctx, id)
+	if err != nil {
+		return storage.Order{}, fmt.Errorf("error getting updated order: %w", err)
+	}
+	return order, nil
+}
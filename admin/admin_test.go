@@ -0,0 +1,141 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// onEachOrder sets up a mocked EachOrder call with filter that calls fn with
+// every order until it returns an error, which is returned like the real
+// EachOrder
+func onEachOrder(stor *mocks.MockStorageInstance, filter storage.OrderFilter, orders []storage.Order) {
+	stor.On("EachOrder", mock.Anything, filter, mock.Anything).
+		Return(func(_ context.Context, _ storage.OrderFilter, fn func(storage.Order) error) error {
+			for _, order := range orders {
+				if err := fn(order); err != nil {
+					return err
+				}
+			}
+			return nil
+		}).
+		Once()
+}
+
+func TestParseStatus(t *testing.T) {
+	for name, status := range map[string]storage.OrderStatus{
+		"":          storage.OrderStatusAny,
+		"any":       storage.OrderStatusAny,
+		"pending":   storage.OrderStatusPending,
+		"Charged":   storage.OrderStatusCharged,
+		"fulfilled": storage.OrderStatusFulfilled,
+		"cancelled": storage.OrderStatusCancelled,
+	} {
+		got, err := ParseStatus(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, status, got, name)
+	}
+
+	_, err := ParseStatus("shipped")
+	assert.EqualError(t, err, `unknown status: "shipped"`)
+
+	assert.Equal(t, "charged", StatusName(storage.OrderStatusCharged))
+	assert.Equal(t, "7", StatusName(7))
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	filter := storage.OrderFilter{Status: storage.OrderStatusAny}
+	orders := []storage.Order{
+		{ID: "a", CustomerEmail: "alice@test", LineItems: []storage.LineItem{{Description: "widget"}}},
+		{ID: "b", CustomerEmail: "bob@test", Notes: "leave it with the WIDGET shop"},
+		{ID: "c", CustomerEmail: "carol@test", PromoCodes: []string{"SPRING"}},
+		{ID: "d", CustomerEmail: "dave@test", LineItems: []storage.LineItem{{Description: "gadget"}}},
+	}
+
+	// the query is matched against several fields, ignoring case
+	{
+		stor := new(mocks.MockStorageInstance)
+		onEachOrder(stor, filter, orders)
+		got, err := Search(ctx, stor, filter, "Widget", 0)
+		require.NoError(t, err)
+		if assert.Len(t, got, 2) {
+			assert.Equal(t, "a", got[0].ID)
+			assert.Equal(t, "b", got[1].ID)
+		}
+		stor.AssertExpectations(t)
+	}
+
+	// stops once the limit is reached
+	{
+		stor := new(mocks.MockStorageInstance)
+		onEachOrder(stor, filter, orders)
+		got, err := List(ctx, stor, filter, 3)
+		require.NoError(t, err)
+		assert.Len(t, got, 3)
+		stor.AssertExpectations(t)
+	}
+
+	// returns other errors
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("EachOrder", mock.Anything, filter, mock.Anything).Return(assert.AnError).Once()
+		_, err := Search(ctx, stor, filter, "spring", 0)
+		assert.ErrorIs(t, err, assert.AnError)
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestForceStatus(t *testing.T) {
+	ctx := storage.WithActor(context.Background(), "admin:test")
+	order := storage.Order{ID: "a", Status: storage.OrderStatusCharged, Version: 3}
+
+	// the reason and actor are passed along so they're recorded in the history
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(order, nil).Once()
+		stor.On("SetOrderStatus", mock.MatchedBy(func(ctx context.Context) bool {
+			return storage.ActorFromContext(ctx) == "admin:test" && storage.ReasonFromContext(ctx) == "charge failed"
+		}), "a", storage.OrderStatusPending, int64(3)).Return(nil).Once()
+		updated := order
+		updated.Status = storage.OrderStatusPending
+		updated.Version = 4
+		stor.On("GetOrder", mock.Anything, "a").Return(updated, nil).Once()
+		got, err := ForceStatus(ctx, stor, "a", storage.OrderStatusPending, "charge failed")
+		require.NoError(t, err)
+		assert.Equal(t, updated, got)
+		stor.AssertExpectations(t)
+	}
+
+	// a reason is required
+	{
+		stor := new(mocks.MockStorageInstance)
+		_, err := ForceStatus(ctx, stor, "a", storage.OrderStatusPending, " ")
+		assert.ErrorIs(t, err, ErrReasonRequired)
+		stor.AssertExpectations(t)
+	}
+
+	// the status has to be a real one
+	{
+		stor := new(mocks.MockStorageInstance)
+		_, err := ForceStatus(ctx, stor, "a", storage.OrderStatusAny, "reason")
+		assert.Error(t, err)
+		stor.AssertExpectations(t)
+	}
+
+	// returns the conflict if the order changed in the meantime
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(order, nil).Once()
+		stor.On("SetOrderStatus", mock.Anything, "a", storage.OrderStatusPending, int64(3)).Return(storage.ErrVersionConflict).Once()
+		_, err := ForceStatus(ctx, stor, "a", storage.OrderStatusPending, "charge failed")
+		assert.True(t, errors.Is(err, storage.ErrVersionConflict), "%#v", err)
+		stor.AssertExpectations(t)
+	}
+}
@@ -0,0 +1,139 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/levenlabs/order-up/storage"
+)
+
+// Format is how results are written
+type Format string
+
+const (
+	// FormatTable writes aligned columns meant for a terminal
+	FormatTable Format = "table"
+	// FormatJSON writes indented JSON meant for scripts and jq
+	FormatJSON Format = "json"
+)
+
+// ParseFormat returns the format with the given name
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(name); f {
+	case FormatTable, FormatJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown output format: %q", name)
+	}
+}
+
+// writeJSON writes v as indented JSON
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// newTable returns a tabwriter that aligns columns separated by tabs. It has to
+// be flushed once everything is written.
+func newTable(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// WriteOrders writes a row for each order with its ID, customer, status, total,
+// number of line items and version
+func WriteOrders(w io.Writer, format Format, orders []storage.Order) error {
+	if format == FormatJSON {
+		return writeJSON(w, orders)
+	}
+	tw := newTable(w)
+	fmt.Fprintln(tw, "ID\tCUSTOMER\tSTATUS\tTOTAL\tITEMS\tVERSION")
+	for _, order := range orders {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\n",
+			order.ID, order.CustomerEmail, StatusName(order.Status), order.Total(), len(order.LineItems), order.Version)
+	}
+	return tw.Flush()
+}
+
+// WriteOrder writes the order's fields followed by its line items and history
+func WriteOrder(w io.Writer, format Format, order storage.Order) error {
+	if format == FormatJSON {
+		return writeJSON(w, order)
+	}
+	tw := newTable(w)
+	fmt.Fprintf(tw, "ID:\t%s\n", order.ID)
+	fmt.Fprintf(tw, "Customer:\t%s\n", order.CustomerEmail)
+	fmt.Fprintf(tw, "Status:\t%s\n", StatusName(order.Status))
+	fmt.Fprintf(tw, "Total:\t%s\n", order.Total())
+	fmt.Fprintf(tw, "Version:\t%d\n", order.Version)
+	if order.Jurisdiction != "" {
+		fmt.Fprintf(tw, "Jurisdiction:\t%s\n", order.Jurisdiction)
+	}
+	if order.ShippingMethod != "" {
+		fmt.Fprintf(tw, "Shipping method:\t%s\n", order.ShippingMethod)
+	}
+	if len(order.PromoCodes) > 0 {
+		fmt.Fprintf(tw, "Promo codes:\t%s\n", strings.Join(order.PromoCodes, ", "))
+	}
+	if order.Notes != "" {
+		fmt.Fprintf(tw, "Notes:\t%s\n", order.Notes)
+	}
+
+	fmt.Fprintln(tw, "\nDESCRIPTION\tKIND\tQUANTITY\tPRICE")
+	currency := order.Currency.OrDefault()
+	for _, li := range order.LineItems {
+		kind := string(li.Kind)
+		if kind == "" {
+			kind = "product"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", li.Description, kind, li.Quantity, storage.Money{Amount: li.PriceCents, Currency: currency})
+	}
+
+	if len(order.History) > 0 {
+		fmt.Fprintln(tw, "\nAT\tSTATUS\tACTOR\tREASON\tCHANGES")
+		for _, entry := range order.History {
+			fields := make([]string, len(entry.Changes))
+			for idx, change := range entry.Changes {
+				fields[idx] = change.Field
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.At.Format(time.RFC3339), StatusName(entry.Status),
+				orDash(entry.Actor), orDash(entry.Reason), orDash(strings.Join(fields, ", ")))
+		}
+	}
+	return tw.Flush()
+}
+
+// WriteReport writes the totals of the report followed by its issues
+func WriteReport(w io.Writer, format Format, report Report) error {
+	if format == FormatJSON {
+		return writeJSON(w, report)
+	}
+	tw := newTable(w)
+	fmt.Fprintln(tw, "STATUS\tCURRENCY\tORDERS\tTOTAL")
+	for _, st := range report.Totals {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", st.Status, st.Currency, st.Orders, storage.Money{Amount: st.TotalCents, Currency: st.Currency})
+	}
+	if len(report.Issues) == 0 {
+		fmt.Fprintln(tw, "\nno issues found")
+	} else {
+		fmt.Fprintf(tw, "\nissues found: %d\nORDER\tPROBLEM\n", len(report.Issues))
+		for _, issue := range report.Issues {
+			fmt.Fprintf(tw, "%s\t%s\n", issue.OrderID, issue.Problem)
+		}
+	}
+	return tw.Flush()
+}
+
+// orDash returns s or - if it's empty so empty columns are still visible
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
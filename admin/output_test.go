@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteOrders(t *testing.T) {
+	orders := []storage.Order{
+		{ID: "a", CustomerEmail: "test@test", Status: storage.OrderStatusCharged, Version: 2,
+			LineItems: []storage.LineItem{{Description: "item", Quantity: 2, PriceCents: 150}}},
+		{ID: "bb", CustomerEmail: "other@test", Currency: "JPY", Version: 1},
+	}
+
+	// tables have aligned columns
+	{
+		var buf bytes.Buffer
+		require.NoError(t, WriteOrders(&buf, FormatTable, orders))
+		assert.Equal(t, strings.Join([]string{
+			"ID  CUSTOMER    STATUS   TOTAL     ITEMS  VERSION",
+			"a   test@test   charged  3.00 USD  1      2",
+			"bb  other@test  pending  0 JPY     0      1",
+			"",
+		}, "\n"), buf.String())
+	}
+
+	// JSON is the same as the API returns
+	{
+		var buf bytes.Buffer
+		require.NoError(t, WriteOrders(&buf, FormatJSON, orders))
+		var got []storage.Order
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+		assert.Equal(t, orders, got)
+	}
+
+	// an empty list is still a JSON array
+	{
+		var buf bytes.Buffer
+		require.NoError(t, WriteOrders(&buf, FormatJSON, []storage.Order{}))
+		assert.Equal(t, "[]\n", buf.String())
+	}
+}
+
+func TestWriteOrder(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteOrder(&buf, FormatTable, storage.Order{
+		ID:            "a",
+		CustomerEmail: "test@test",
+		Status:        storage.OrderStatusPending,
+		LineItems:     []storage.LineItem{{Description: "item", Quantity: 1, PriceCents: 500}},
+		History: []storage.HistoryEntry{
+			{Status: storage.OrderStatusCharged, At: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), Actor: "apikey:1"},
+			{Status: storage.OrderStatusPending, At: time.Date(2026, 1, 3, 3, 4, 5, 0, time.UTC), Actor: "admin:test", Reason: "charge bounced"},
+		},
+		Version: 3,
+	})
+	require.NoError(t, err)
+	out := buf.String()
+	assert.Contains(t, out, "Status:    pending\n")
+	assert.Contains(t, out, "item         product  1         5.00 USD\n")
+	assert.Contains(t, out, "2026-01-03T03:04:05Z  pending  admin:test  charge bounced  -\n")
+}
+
+func TestWriteReport(t *testing.T) {
+	report := Report{
+		Totals: []StatusTotal{{Status: "charged", Currency: "USD", Orders: 2, TotalCents: 1234}},
+		Issues: []Issue{{OrderID: "a", Problem: "something is off"}},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, WriteReport(&buf, FormatTable, report))
+	assert.Equal(t, strings.Join([]string{
+		"STATUS   CURRENCY  ORDERS  TOTAL",
+		"charged  USD       2       12.34 USD",
+		"",
+		"issues found: 1",
+		"ORDER  PROBLEM",
+		"a      something is off",
+		"",
+	}, "\n"), buf.String())
+
+	_, err := ParseFormat("yaml")
+	assert.EqualError(t, err, `unknown output format: "yaml"`)
+}
@@ -0,0 +1,130 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/levenlabs/order-up/storage"
+)
+
+// StatusTotal is how many orders have a status in a currency and what they add
+// up to
+type StatusTotal struct {
+	// Status is the name of the status, like charged
+	Status   string           `json:"status"`
+	Currency storage.Currency `json:"currency"`
+	Orders   int              `json:"orders"`
+	// TotalCents is the sum of the orders' totals in the currency's minor unit
+	TotalCents int64 `json:"totalCents"`
+}
+
+// Issue is an order whose stored state doesn't add up
+type Issue struct {
+	OrderID string `json:"orderId"`
+	Problem string `json:"problem"`
+}
+
+// Report is the result of Reconcile
+type Report struct {
+	// Totals has an entry for each status and currency that has orders, sorted
+	// by status and then currency
+	Totals []StatusTotal `json:"totals"`
+	// Issues has every inconsistency that was found, sorted by order ID
+	Issues []Issue `json:"issues"`
+}
+
+// totalKey groups orders for the report
+type totalKey struct {
+	status   storage.OrderStatus
+	currency storage.Currency
+}
+
+// Reconcile goes through every order and totals them by status and currency so
+// the charged totals can be compared with the charge service. It also reports
+// orders that look like they were left in a bad state, like a status that
+// doesn't match the order's history, a status that a request never finished
+// changing or tax line items that don't match the tax breakdown.
+func Reconcile(ctx context.Context, store Store) (Report, error) {
+	totals := map[totalKey]*StatusTotal{}
+	report := Report{Issues: []Issue{}}
+	err := store.EachOrder(ctx, storage.OrderFilter{Status: storage.OrderStatusAny}, func(order storage.Order) error {
+		total := order.Total()
+		key := totalKey{status: order.Status, currency: total.Currency}
+		st, ok := totals[key]
+		if !ok {
+			st = &StatusTotal{Status: StatusName(order.Status), Currency: total.Currency}
+			totals[key] = st
+		}
+		st.Orders++
+		st.TotalCents += total.Amount
+
+		for _, problem := range orderProblems(order) {
+			report.Issues = append(report.Issues, Issue{OrderID: order.ID, Problem: problem})
+		}
+		return nil
+	})
+	if err != nil {
+		return Report{}, fmt.Errorf("error reading orders: %w", err)
+	}
+
+	keys := make([]totalKey, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		if keys[a].status != keys[b].status {
+			return keys[a].status < keys[b].status
+		}
+		return keys[a].currency < keys[b].currency
+	})
+	report.Totals = make([]StatusTotal, len(keys))
+	for idx, key := range keys {
+		report.Totals[idx] = *totals[key]
+	}
+	// EachOrder already returns the orders sorted by ID so the issues are too
+	return report, nil
+}
+
+// orderProblems returns a description of everything that's inconsistent about
+// order
+func orderProblems(order storage.Order) []string {
+	var problems []string
+	if !knownStatus(order.Status) {
+		problems = append(problems, fmt.Sprintf("unknown status %d", order.Status))
+	}
+	// orders stored before history existed don't have any, so only the orders
+	// that do can be checked
+	if n := len(order.History); n > 0 && order.History[n-1].Status != order.Status {
+		problems = append(problems, fmt.Sprintf("status is %s but the last history entry is %s",
+			StatusName(order.Status), StatusName(order.History[n-1].Status)))
+	}
+	// requests only leave an order charging, cancelling or fulfilling if they
+	// failed to finish, so the charge or fulfillment service needs to be
+	// checked to see which status it should have
+	if order.Status.InProgress() {
+		problems = append(problems, fmt.Sprintf("status is %s so a request didn't finish changing it", StatusName(order.Status)))
+	}
+	if order.Status == storage.OrderStatusCharged || order.Status == storage.OrderStatusFulfilled {
+		if order.TotalCents() < 0 {
+			problems = append(problems, fmt.Sprintf("%s with a negative total of %s", StatusName(order.Status), order.Total()))
+		}
+	}
+
+	currency := order.Currency.OrDefault()
+	var taxCents int64
+	for _, li := range order.LineItems {
+		if li.Currency != "" && li.Currency != currency {
+			problems = append(problems, fmt.Sprintf("line item %q is in %s but the order is in %s", li.Description, li.Currency, currency))
+		}
+		if li.Kind == storage.LineItemKindTax {
+			taxCents += li.PriceCents * li.Quantity
+		}
+	}
+	if order.Tax != nil && order.Tax.TotalCents != taxCents {
+		problems = append(problems, fmt.Sprintf("tax line items total %s but the tax breakdown is %s",
+			storage.Money{Amount: taxCents, Currency: currency},
+			storage.Money{Amount: order.Tax.TotalCents, Currency: currency}))
+	}
+	return problems
+}
@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	orders := []storage.Order{
+		{
+			ID:        "a",
+			Status:    storage.OrderStatusCharged,
+			LineItems: []storage.LineItem{{Description: "item", Quantity: 2, PriceCents: 500}},
+			History:   []storage.HistoryEntry{{Status: storage.OrderStatusPending}, {Status: storage.OrderStatusCharged}},
+		},
+		{
+			ID:        "b",
+			Status:    storage.OrderStatusCharged,
+			Currency:  "EUR",
+			LineItems: []storage.LineItem{{Description: "item", Quantity: 1, PriceCents: 700}},
+			// someone changed the status without going through the API
+			History: []storage.HistoryEntry{{Status: storage.OrderStatusPending}},
+		},
+		{
+			ID:     "c",
+			Status: storage.OrderStatusPending,
+			LineItems: []storage.LineItem{
+				{Description: "item", Quantity: 1, PriceCents: 1000},
+				{Description: "tax", Quantity: 1, PriceCents: 80, Kind: storage.LineItemKindTax},
+			},
+			Tax: &storage.TaxBreakdown{TotalCents: 90},
+		},
+		{
+			ID:        "d",
+			Status:    storage.OrderStatusFulfilled,
+			LineItems: []storage.LineItem{{Description: "discount", Quantity: 1, PriceCents: -100}},
+		},
+		{
+			ID:        "e",
+			Status:    storage.OrderStatusCharged,
+			LineItems: []storage.LineItem{{Description: "item", Quantity: 1, PriceCents: 300, Currency: "EUR"}},
+		},
+		{
+			ID:        "f",
+			Status:    storage.OrderStatusCancelling,
+			LineItems: []storage.LineItem{{Description: "item", Quantity: 1, PriceCents: 200}},
+			// the request crashed after refunding the charge
+			History: []storage.HistoryEntry{{Status: storage.OrderStatusCharged}, {Status: storage.OrderStatusCancelling}},
+		},
+	}
+
+	stor := new(mocks.MockStorageInstance)
+	onEachOrder(stor, storage.OrderFilter{Status: storage.OrderStatusAny}, orders)
+	report, err := Reconcile(ctx, stor)
+	require.NoError(t, err)
+	assert.Equal(t, []StatusTotal{
+		{Status: "pending", Currency: "USD", Orders: 1, TotalCents: 1080},
+		{Status: "charged", Currency: "EUR", Orders: 1, TotalCents: 700},
+		{Status: "charged", Currency: "USD", Orders: 2, TotalCents: 1300},
+		{Status: "fulfilled", Currency: "USD", Orders: 1, TotalCents: -100},
+		{Status: "cancelling", Currency: "USD", Orders: 1, TotalCents: 200},
+	}, report.Totals)
+	assert.Equal(t, []Issue{
+		{OrderID: "b", Problem: "status is charged but the last history entry is pending"},
+		{OrderID: "c", Problem: "tax line items total 0.80 USD but the tax breakdown is 0.90 USD"},
+		{OrderID: "d", Problem: "fulfilled with a negative total of -1.00 USD"},
+		{OrderID: "e", Problem: `line item "item" is in EUR but the order is in USD`},
+		{OrderID: "f", Problem: "status is cancelling so a request didn't finish changing it"},
+	}, report.Issues)
+	stor.AssertExpectations(t)
+}
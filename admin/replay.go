@@ -0,0 +1,113 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// API replays charges and fulfillments through a running order-up service so
+// they go through the same checks and downstream calls as any other request
+type API struct {
+	// URL is the base URL of the service, like http://localhost:8888
+	URL string
+	// APIKey is sent in the X-API-Key header if it's set. Replaying a charge
+	// needs the orders:charge scope and a fulfillment needs orders:write.
+	APIKey string
+	// Client is used to make the requests, it defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// APIError is returned when the service responds with an error
+type APIError struct {
+	// Status is the HTTP status code of the response
+	Status int
+	// Code and Message come from the error in the response body, they're empty
+	// if the body wasn't a JSON error
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RequestID can be used to find the request in the service's logs
+	RequestID string `json:"requestId"`
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("order-up responded with %d", e.Status)
+	}
+	msg := fmt.Sprintf("order-up responded with %d %s: %s", e.Status, e.Code, e.Message)
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// ReplayCharge charges the order with the given ID with POST
+// /v1/orders/:id/charge. The order has to be pending, use ForceStatus first if a
+// charge that failed left it in another status.
+func (a API) ReplayCharge(ctx context.Context, id, cardToken string) error {
+	body := struct {
+		CardToken string `json:"cardToken"`
+	}{CardToken: cardToken}
+	return a.do(ctx, http.MethodPost, "/v1/orders/"+url.PathEscape(id)+"/charge", body)
+}
+
+// ReplayFulfill fulfills the order with the given ID with PUT
+// /v1/orders/:id/fulfill. The order has to be charged.
+func (a API) ReplayFulfill(ctx context.Context, id string) error {
+	return a.do(ctx, http.MethodPut, "/v1/orders/"+url.PathEscape(id)+"/fulfill", nil)
+}
+
+// do makes a request with body encoded as JSON, if it's not nil, and returns an
+// *APIError if the response isn't a 2xx
+func (a API) do(ctx context.Context, method, path string, body interface{}) error {
+	var r io.Reader
+	if body != nil {
+		byts, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error encoding body: %w", err)
+		}
+		r = bytes.NewReader(byts)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(a.URL, "/")+path, r)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if a.APIKey != "" {
+		req.Header.Set("X-API-Key", a.APIKey)
+	}
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		// drain the body so the connection can be reused
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+
+	apiErr := &APIError{Status: resp.StatusCode}
+	var res struct {
+		Error *APIError `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err == nil && res.Error != nil {
+		res.Error.Status = resp.StatusCode
+		apiErr = res.Error
+	}
+	return apiErr
+}
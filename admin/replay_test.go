@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/levenlabs/order-up/api"
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/services"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayCharge(t *testing.T) {
+	ctx := context.Background()
+	order := storage.Order{
+		ID:        "a",
+		Status:    storage.OrderStatusPending,
+		LineItems: []storage.LineItem{{Description: "item", Quantity: 1, PriceCents: 500}},
+		Version:   2,
+	}
+
+	// the charge goes through the API which calls the charge service
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(order, nil).Once()
+		stor.On("SetOrderStatus", mock.Anything, "a", storage.OrderStatusCharging, int64(2)).Return(nil).Once()
+		stor.On("AddOrderPayment", mock.Anything, "a", mock.Anything).Return(nil).Once()
+		stor.On("SetOrderStatus", mock.Anything, "a", storage.OrderStatusCharged, int64(4)).Return(nil).Once()
+		var charged int64
+		chgServ := mocks.NewMockedService(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var args struct {
+				CardToken   string `json:"cardToken"`
+				AmountCents int64  `json:"amountCents"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&args))
+			assert.Equal(t, "tok", args.CardToken)
+			charged = args.AmountCents
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(services.Charge{ID: "ch_1", AmountCents: args.AmountCents})
+		}))
+		srv := httptest.NewServer(api.Handler(stor, nil, services.NewHTTPChargeService(chgServ)))
+		defer srv.Close()
+
+		err := API{URL: srv.URL}.ReplayCharge(ctx, "a", "tok")
+		require.NoError(t, err)
+		assert.EqualValues(t, 500, charged)
+		stor.AssertExpectations(t)
+	}
+
+	// errors from the API are returned as an *APIError
+	{
+		charged := order
+		charged.Status = storage.OrderStatusCharged
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(charged, nil).Once()
+		srv := httptest.NewServer(api.Handler(stor, nil, nil))
+		defer srv.Close()
+
+		err := API{URL: srv.URL + "/"}.ReplayCharge(ctx, "a", "tok")
+		var apiErr *APIError
+		if assert.ErrorAs(t, err, &apiErr) {
+			assert.Equal(t, http.StatusConflict, apiErr.Status)
+			assert.Equal(t, "invalid_transition", apiErr.Code)
+			assert.NotEmpty(t, apiErr.RequestID)
+		}
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestReplayFulfill(t *testing.T) {
+	ctx := context.Background()
+
+	// the API key is sent and a response without a JSON error still fails
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "/v1/orders/a%2Fb/fulfill", r.URL.EscapedPath())
+		assert.Equal(t, "ou_key", r.Header.Get("X-API-Key"))
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	err := API{URL: srv.URL, APIKey: "ou_key"}.ReplayFulfill(ctx, "a/b")
+	assert.EqualError(t, err, "order-up responded with 502")
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/levenlabs/order-up/admin"
+	"github.com/levenlabs/order-up/storage"
+)
+
+// adminUsage lists the admin commands
+const adminUsage = `usage: %s admin <command> [flags] [args]
+
+commands that use storage directly:
+  get <id>                              show an order with its line items and history
+  list [-status s] [-customer email]    list orders sorted by ID
+  search [-status s] <query>            find orders by ID, email, notes, promo code or line item
+  force-status -reason r <id> <status>  set an order's status without charging or fulfilling it
+  reconcile                             total orders by status and currency and report issues
+
+commands that use the HTTP API, see -api-url and ORDER_UP_API_KEY:
+  replay-charge -card-token t <id>      charge a pending order again
+  replay-fulfill <id>                   fulfill a charged order again
+
+run "%[1]s admin <command> -h" for a command's flags
+`
+
+// adminFlags are the flags every admin command has
+type adminFlags struct {
+	output       *string
+	startTimeout *time.Duration
+	apiURL       *string
+}
+
+// newAdminFlagSet returns a flag set for the admin command with the flags that
+// every command shares
+func newAdminFlagSet(command, args string) (*flag.FlagSet, adminFlags) {
+	fs := flag.NewFlagSet("admin "+command, flag.ContinueOnError)
+	f := adminFlags{
+		output:       fs.String("output", string(admin.FormatTable), "how to write the results, table or json"),
+		startTimeout: fs.Duration("start-timeout", 15*time.Second, "how long to wait for storage to be ready"),
+		apiURL:       fs.String("api-url", "http://localhost:8888", "the base URL of the service for the replay commands"),
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s admin %s [flags] %s\n", os.Args[0], command, args)
+		fs.PrintDefaults()
+	}
+	return fs, f
+}
+
+// runAdmin runs the admin subcommand with args, everything after "admin", and
+// returns the exit code
+func runAdmin(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "help" {
+		fmt.Fprintf(os.Stderr, adminUsage, os.Args[0])
+		return 2
+	}
+	command, args := args[0], args[1:]
+
+	var (
+		fs        *flag.FlagSet
+		flags     adminFlags
+		nargs     int
+		status    *string
+		customer  *string
+		limit     *int
+		reason    *string
+		actor     *string
+		cardToken *string
+	)
+	switch command {
+	case "get":
+		fs, flags = newAdminFlagSet(command, "<id>")
+		nargs = 1
+	case "list":
+		fs, flags = newAdminFlagSet(command, "")
+		nargs = 0
+	case "search":
+		fs, flags = newAdminFlagSet(command, "<query>")
+		nargs = 1
+	case "force-status":
+		fs, flags = newAdminFlagSet(command, "<id> <status>")
+		nargs = 2
+		reason = fs.String("reason", "", "why the status is being forced, it's required and recorded in the order's history")
+		actor = fs.String("actor", "admin:"+os.Getenv("USER"), "who is forcing the status, it's recorded in the order's history")
+	case "reconcile":
+		fs, flags = newAdminFlagSet(command, "")
+		nargs = 0
+	case "replay-charge":
+		fs, flags = newAdminFlagSet(command, "<id>")
+		nargs = 1
+		cardToken = fs.String("card-token", "", "the card token to charge")
+	case "replay-fulfill":
+		fs, flags = newAdminFlagSet(command, "<id>")
+		nargs = 1
+	default:
+		fmt.Fprintf(os.Stderr, "unknown admin command: %q\n", command)
+		fmt.Fprintf(os.Stderr, adminUsage, os.Args[0])
+		return 2
+	}
+	if command == "list" || command == "search" {
+		status = fs.String("status", "any", "only include orders with this status")
+		limit = fs.Int("limit", 100, "the most orders to return, 0 returns all of them")
+	}
+	if command == "list" {
+		customer = fs.String("customer", "", "only include orders placed by this customer email")
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != nargs {
+		fs.Usage()
+		return 2
+	}
+	if command == "force-status" && *reason == "" {
+		fmt.Fprintln(os.Stderr, "-reason is required")
+		return 2
+	}
+	format, err := admin.ParseFormat(*flags.output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// the replay commands go through the API so they don't need storage
+	switch command {
+	case "replay-charge", "replay-fulfill":
+		api := admin.API{URL: *flags.apiURL, APIKey: os.Getenv("ORDER_UP_API_KEY")}
+		id := fs.Arg(0)
+		if command == "replay-charge" {
+			err = api.ReplayCharge(ctx, id, *cardToken)
+		} else {
+			err = api.ReplayFulfill(ctx, id)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error replaying %s: %v\n", command[len("replay-"):], err)
+			return 1
+		}
+		fmt.Fprintf(os.Stdout, "replayed %s of order %s\n", command[len("replay-"):], id)
+		return 0
+	}
+
+	openCtx, cancel := context.WithTimeout(ctx, *flags.startTimeout)
+	stor, err := storage.Open(openCtx, "")
+	cancel()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error opening storage: %v\n", err)
+		return 1
+	}
+	defer stor.Close(context.Background())
+
+	if err := runAdminCommand(ctx, os.Stdout, stor, format, command, fs.Args(), adminCommandFlags{
+		status:   status,
+		customer: customer,
+		limit:    limit,
+		reason:   reason,
+		actor:    actor,
+	}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// adminCommandFlags are the command specific flags, they're nil for commands
+// that don't have them
+type adminCommandFlags struct {
+	status   *string
+	customer *string
+	limit    *int
+	reason   *string
+	actor    *string
+}
+
+// runAdminCommand runs one of the commands that use storage and writes the
+// result to w
+func runAdminCommand(ctx context.Context, w io.Writer, store admin.Store, format admin.Format, command string, args []string, flags adminCommandFlags) error {
+	switch command {
+	case "get":
+		order, err := store.GetOrder(ctx, args[0])
+		if err != nil {
+			return fmt.Errorf("error getting order: %w", err)
+		}
+		return admin.WriteOrder(w, format, order)
+	case "list", "search":
+		status, err := admin.ParseStatus(*flags.status)
+		if err != nil {
+			return err
+		}
+		filter := storage.OrderFilter{Status: status}
+		var orders []storage.Order
+		if command == "list" {
+			filter.CustomerEmail = *flags.customer
+			orders, err = admin.List(ctx, store, filter, *flags.limit)
+		} else {
+			orders, err = admin.Search(ctx, store, filter, args[0], *flags.limit)
+		}
+		if err != nil {
+			return err
+		}
+		return admin.WriteOrders(w, format, orders)
+	case "force-status":
+		status, err := admin.ParseStatus(args[1])
+		if err != nil {
+			return err
+		}
+		if status == storage.OrderStatusAny {
+			return fmt.Errorf("a status is required")
+		}
+		order, err := admin.ForceStatus(storage.WithActor(ctx, *flags.actor), store, args[0], status, *flags.reason)
+		if err != nil {
+			return err
+		}
+		return admin.WriteOrder(w, format, order)
+	case "reconcile":
+		report, err := admin.Reconcile(ctx, store)
+		if err != nil {
+			return err
+		}
+		return admin.WriteReport(w, format, report)
+	default:
+		return fmt.Errorf("unknown admin command: %q", command)
+	}
+}
@@ -3,18 +3,16 @@
 package api
 
 import (
-	"bytes"
-	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"strings"
-	"sync"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/levenlabs/order-up/auth"
 	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/orders"
+	"github.com/levenlabs/order-up/services"
 	"github.com/levenlabs/order-up/storage"
 )
 
@@ -24,40 +22,127 @@ import (
 type instance struct {
 	stor               mocks.StorageInstance
 	router             *gin.Engine
-	fulfillmentService *http.Client
-	chargeService      *http.Client
-	mu                 sync.Mutex
+	fulfillmentService services.FulfillmentService
+	chargeService      services.ChargeService
+	// orders creates, lists, charges, cancels and fulfills orders through the 2
+	// services above
+	orders *orders.Service
+	// orderOpts are passed to orders.New once every Option was applied
+	orderOpts []orders.Option
+	readiness *Readiness
+	// auth is nil when authentication is disabled
+	auth *auth.Authenticator
+	// rateLimit is nil when rate limiting is disabled
+	rateLimit *RateLimitConfig
+	// unversionedSunset is sent in the Sunset header of the unprefixed routes
+	unversionedSunset time.Time
+}
+
+// Option configures optional behavior on the Handler. Options are applied in
+// order after the required dependencies are set.
+type Option func(*instance)
+
+// WithOrderOptions configures the orders.Service behind the handlers, like
+// orders.WithTaxCalculator to tax new orders
+func WithOrderOptions(opts ...orders.Option) Option {
+	return func(i *instance) {
+		i.orderOpts = append(i.orderOpts, opts...)
+	}
 }
 
 // Handler returns an implementation of the http.Handler interface that can be
 // passed to an http.Server to handle incoming HTTP requests. This accepts
-// an interface for the storage.Instance and http.Client's for the 2 dependent
-// services. Typically this would accept just a *storage.Instance but the mock
-// allows us to separate the api tests from the storage tests.
-func Handler(stor mocks.StorageInstance, fulfillmentService, chargeService *http.Client) http.Handler {
+// an interface for the storage.Instance and for each of the 2 dependent
+// services, see the services package for the HTTP ones. Typically this would accept just a *storage.Instance but the mock
+// allows us to separate the api tests from the storage tests. Any number of
+// Options can be passed to further configure the handler.
+func Handler(stor mocks.StorageInstance, fulfillmentService services.FulfillmentService, chargeService services.ChargeService, opts ...Option) http.Handler {
 	// inst is pointer to a new instance that's holding a new storage.Instance for
 	// talking to the underlying database
 	inst := &instance{
 		stor:               stor,
-		router:             gin.Default(),
+		router:             gin.New(),
 		fulfillmentService: fulfillmentService,
 		chargeService:      chargeService,
+		// by default the handler is always ready unless the caller passes their own
+		// Readiness to control it
+		readiness:         new(Readiness),
+		unversionedSunset: DefaultUnversionedSunset,
 	}
-
-	// set up the various REST endpoints that are exposed publicly over HTTP
-	// go implicitly binds these functions to inst
-	inst.router.GET("/orders", inst.getOrders)
-	inst.router.POST("/orders", inst.postOrders)
-	inst.router.GET("/orders/:id", inst.getOrder)
-	inst.router.POST("/orders/:id/charge", inst.chargeOrder)
-	inst.router.POST("/orders/:id/cancel", inst.cancelOrder)
-	inst.router.PUT("/orders/:id/fulfill", inst.fulFillOrder)
+	for _, opt := range opts {
+		opt(inst)
+	}
+	inst.orders = orders.New(stor, chargeService, fulfillmentService, inst.orderOpts...)
+
+	// this is the same as gin.Default() except that every request gets an ID and
+	// panics and unknown routes respond with the same error body as everything
+	// else
+	inst.router.Use(gin.Logger(), requestID, gin.CustomRecovery(recovered))
+	inst.router.NoRoute(notFound)
+
+	// the health endpoints are used by the orchestrator to decide whether to
+	// restart the process or send it traffic
+	inst.router.GET("/healthz", inst.getHealthz)
+	inst.router.GET("/readyz", inst.getReadyz)
+	// the OpenAPI document describes every route below so clients can be
+	// generated from it, see openAPIRoutes
+	inst.router.GET("/openapi.json", getOpenAPI)
+
+	// every other endpoint is versioned. Each version is a group with its own
+	// prefix that's mounted with routes and the handlers check
+	// apiVersionFromContext, or pass their response through versioned, wherever
+	// a version accepts or sends something different. The unprefixed routes from
+	// before the API was versioned are kept as deprecated aliases of v1.
+	for _, v := range apiVersions {
+		inst.routes(inst.router.Group(v.prefix(), withAPIVersion(v)))
+	}
+	inst.routes(inst.router.Group("", inst.deprecated(apiVersion1.prefix()), withAPIVersion(apiVersion1)))
 
 	// *instance implements the http.Handler interface with the ServeHTTP method
 	// below so we can just return inst
 	return inst
 }
 
+// routes registers the versioned endpoints on g. Every version calls it so
+// they all share the same handlers.
+func (i *instance) routes(g *gin.RouterGroup) {
+	// every versioned endpoint requires the caller to be authenticated, if
+	// authentication is enabled, and to have the scope for that endpoint
+	authed := g.Group("", i.authenticate)
+
+	// set up the various REST endpoints that are exposed over HTTP
+	// go implicitly binds these functions to i
+	// requests are rate limited after checking the scope so that clients can't
+	// use up someone else's bucket with requests that would be rejected anyway
+	// writes to orders go through idempotent so clients can safely retry them
+	// with the same Idempotency-Key header
+	authed.GET("/orders", i.requireScope(ScopeOrdersRead), i.limitRate(RateLimitRead), i.getOrders)
+	authed.POST("/orders", i.requireScope(ScopeOrdersWrite), i.limitRate(RateLimitWrite), i.idempotent, i.postOrders)
+	// gin treats everything after /orders as the action parameter, so this is
+	// /orders:batch and any other /orders:<action>
+	authed.POST("/orders:action", i.requireScope(ScopeOrdersWrite), i.limitRate(RateLimitWrite), i.idempotent, i.postOrdersAction)
+	authed.GET("/orders/export", i.requireScope(ScopeOrdersRead), i.limitRate(RateLimitRead), i.exportOrders)
+	authed.GET("/orders/:id", i.requireScope(ScopeOrdersRead), i.limitRate(RateLimitRead), i.getOrder)
+	authed.PATCH("/orders/:id", i.requireScope(ScopeOrdersWrite), i.limitRate(RateLimitWrite), i.idempotent, i.patchOrder)
+	authed.POST("/orders/:id/charge", i.requireScope(ScopeOrdersCharge), i.limitRate(RateLimitCharge), i.idempotent, i.chargeOrder)
+	authed.POST("/orders/:id/cancel", i.requireScope(ScopeOrdersRefund), i.limitRate(RateLimitCharge), i.idempotent, i.cancelOrder)
+	authed.PUT("/orders/:id/fulfill", i.requireScope(ScopeOrdersWrite), i.limitRate(RateLimitWrite), i.idempotent, i.fulFillOrder)
+	authed.PUT("/orders/:id/shipping", i.requireScope(ScopeOrdersWrite), i.limitRate(RateLimitWrite), i.idempotent, i.putOrderShipping)
+
+	// API keys are managed by admins and the key itself is only ever returned
+	// when it's created
+	authed.POST("/admin/apikeys", i.requireScope(ScopeAdmin), i.limitRate(RateLimitAdmin), i.postAPIKeys)
+	authed.GET("/admin/apikeys", i.requireScope(ScopeAdmin), i.limitRate(RateLimitAdmin), i.getAPIKeys)
+	authed.DELETE("/admin/apikeys/:id", i.requireScope(ScopeAdmin), i.limitRate(RateLimitAdmin), i.deleteAPIKey)
+
+	// promotions are also managed by admins and customers use them by passing
+	// their codes when creating an order
+	authed.POST("/promotions", i.requireScope(ScopeAdmin), i.limitRate(RateLimitAdmin), i.postPromotions)
+	authed.GET("/promotions", i.requireScope(ScopeAdmin), i.limitRate(RateLimitAdmin), i.getPromotions)
+	authed.GET("/promotions/:code", i.requireScope(ScopeAdmin), i.limitRate(RateLimitAdmin), i.getPromotion)
+	authed.DELETE("/promotions/:code", i.requireScope(ScopeAdmin), i.limitRate(RateLimitAdmin), i.deletePromotion)
+}
+
 // ServeHTTP implements the http.Handler interface and passes incoming HTTP
 // requests to the underlying *gin.Engine
 func (i *instance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -68,6 +153,34 @@ func (i *instance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 type getOrdersRes struct {
 	Orders []storage.Order `json:"orders"`
+	// NextCursor is only set when a limit was sent and the page is full. It's
+	// sent as after to get the next page.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// orderFilter returns the filter for the orders the request asked for with its
+// query parameters
+func orderFilter(c *gin.Context) (storage.OrderFilter, error) {
+	// get and parse the optional status query parameter from the request
+	// this lets you do /orders?status=pending to limit the orders to only those that
+	// are currently pending
+	// customers only ever see their own orders and the filtering happens in the
+	// database so we never load anyone else's orders
+	// OrderStatusAny indicates that orders with any status should be returned
+	// unless the status is set, which is the same name that orders are sent with
+	// in v2, like charged
+	filter := storage.OrderFilter{
+		Status:        storage.OrderStatusAny,
+		CustomerEmail: customerEmail(c),
+	}
+	if q := c.Query("status"); q != "" {
+		status, err := storage.ParseOrderStatus(q)
+		if err != nil {
+			return storage.OrderFilter{}, newError(http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("unknown value for status: %v", q)).withCause(err)
+		}
+		filter.Status = status
+	}
+	return filter, nil
 }
 
 // getOrders is called by incoming HTTP GET requests to /orders
@@ -77,46 +190,37 @@ func (i *instance) getOrders(c *gin.Context) {
 	// the tracing context is kept throughout the whole request
 	ctx := c.Request.Context()
 
-	// get and parse the optional status query parameter from the request
-	// this lets you do /orders?status=pending to limit the orders to only those that
-	// are currently pending
-	var status storage.OrderStatus
-	switch c.Query("status") {
-	case "pending":
-		status = storage.OrderStatusPending
-		// the final break is implied if there's no fallthrough keyword
-	case "charged":
-		status = storage.OrderStatusCharged
-	case "fulfilled":
-		status = storage.OrderStatusFulfilled
-		// Add case for cancelled.
-	case "":
-		// GetAllOrders accepts a -1 to indicate that all orders should be returned
-		status = -1
-	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown value for status: %v"})
+	filter, err := orderFilter(c)
+	if err != nil {
+		respondError(c, err)
 		return
 	}
 
-	// pass along the status and get all of the resulting orders from the storage
-	// instance
-	orders, err := i.stor.GetOrders(ctx, status)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("error getting orders: %v", err)})
-		return
+	// the orders are sorted by ID so a page starts after the last ID of the
+	// previous one, without a limit every order is returned like before paging
+	// was added
+	if s := c.Query("limit"); s != "" {
+		limit, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || limit < 1 {
+			respondError(c, newError(http.StatusBadRequest, CodeInvalidRequest, orders.ErrInvalidLimit.Error()))
+			return
+		}
+		filter.Limit = limit
 	}
+	filter.AfterID = c.Query("after")
 
-	// by default slices are nil and if we return that the resulting JSON would be
-	// {"orders":null} which some languages/clients have a problem with
-	// instead set it to an empty slice
-	if orders == nil {
-		orders = []storage.Order{}
+	// respondError returns a 400 for a limit that's too big
+	orders, next, err := i.orders.List(ctx, filter)
+	if err != nil {
+		respondError(c, err)
+		return
 	}
 
 	// respond with a success and return the orders
-	c.JSON(http.StatusOK, getOrdersRes{
-		Orders: orders,
-	})
+	c.JSON(http.StatusOK, versioned(c, getOrdersRes{
+		Orders:     orders,
+		NextCursor: next,
+	}))
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -140,30 +244,51 @@ func (i *instance) getOrder(c *gin.Context) {
 	// the Param function
 	id := c.Param("id")
 
-	order, err := i.stor.GetOrder(ctx, id)
+	order, err := i.orders.Get(ctx, id)
 	if err != nil {
-		// if the error is a ErrOrderNotFound error then we return 404 otherwise we
-		// return a 500 error
-		if errors.Is(err, storage.ErrOrderNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("error getting order: %v", err)})
-		}
+		// respondError returns a 404 for a ErrOrderNotFound error and a 500 for
+		// anything else
+		respondError(c, err)
+		return
+	}
+	if !canAccessOrder(c, order) {
+		respondError(c, errOrderNotFound)
+		return
+	}
+
+	// the ETag lets clients skip downloading an order they already have and
+	// send it back in If-Match when changing the order
+	etag := orderETag(order.Version)
+	c.Header("ETag", etag)
+	if h := c.GetHeader("If-None-Match"); h != "" && etagMatches(h, etag, true) {
+		c.Status(http.StatusNotModified)
 		return
 	}
 
 	// respond with a success and return the order
-	c.JSON(http.StatusOK, getOrderRes{
+	c.JSON(http.StatusOK, versioned(c, getOrderRes{
 		Order: order,
-	})
+	}))
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
-// postOrderArgs is the expected body for the POST /orders handler
+// postOrderArgs is the expected body for the POST /orders handler. It has the
+// same fields as orders.CreateArgs so it can be converted to it.
 type postOrderArgs struct {
-	CustomerEmail string             `json:"customerEmail"`
-	LineItems     []storage.LineItem `json:"lineItems"`
+	CustomerEmail string `json:"customerEmail"`
+	// Currency defaults to storage.DefaultCurrency if it's not set
+	Currency storage.Currency `json:"currency"`
+	// Jurisdiction decides how the order is taxed. It defaults to the shipping
+	// address's jurisdiction and orders without either aren't taxed.
+	Jurisdiction    string             `json:"jurisdiction"`
+	ShippingAddress *storage.Address   `json:"shippingAddress"`
+	BillingAddress  *storage.Address   `json:"billingAddress"`
+	ShippingMethod  string             `json:"shippingMethod"`
+	LineItems       []storage.LineItem `json:"lineItems"`
+	// PromoCodes are promotions to apply to the order, the discounts are added as
+	// line items
+	PromoCodes []string `json:"promoCodes"`
 }
 
 // chargeOrderRes is the result of the POST /orders/:id/charge handler
@@ -180,104 +305,40 @@ func (i *instance) postOrders(c *gin.Context) {
 
 	// parse the body as JSON into the newOrderArgs struct
 	var args postOrderArgs
-	err := c.BindJSON(&args)
+	err := c.ShouldBindJSON(&args)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("error decoding body: %v", err)})
+		respondError(c, invalidBody(err))
 		return
 	}
 
-	// do some light validation
-	// we could use something like https://pkg.go.dev/gopkg.in/validator.v2
-	// so we could set struct tags but since we only do validation in this one
-	// spot that feels like overkill
-	if !strings.Contains(args.CustomerEmail, "@") {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid customerEmail"})
-		return
-	}
-	if len(args.LineItems) < 1 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "an order must contain at least one line item"})
-		return
-	}
-
-	order := storage.Order{
-		CustomerEmail: args.CustomerEmail,
-		LineItems:     args.LineItems,
-		Status:        storage.OrderStatusPending,
-	}
-	if order.TotalCents() < 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "an order's total cannot be less than 0"})
-	}
-
-	id, err := i.stor.InsertOrder(ctx, order)
+	order, err := i.orders.Create(ctx, orders.CreateArgs(args), customerEmail(c))
 	if err != nil {
-		// if the error is a ErrOrderExists error then we return 409 otherwise we
-		// return a 500 error
-		if errors.Is(err, storage.ErrOrderExists) {
-			c.JSON(http.StatusConflict, gin.H{"error": "order already exists"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("error inserting order: %v", err)})
-		}
+		// respondError returns a 400 for an invalid order, a 409 for a
+		// ErrOrderExists error and a 500 for anything unexpected
+		respondError(c, err)
 		return
 	}
-	order.ID = id
 
 	// respond with a success and return the order
-	c.JSON(http.StatusCreated, postOrderRes{
+	c.JSON(http.StatusCreated, versioned(c, postOrderRes{
 		Order: order,
-	})
+	}))
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
-// chargeServiceChargeArgs is the expected body for the POST /charge method of
-// the charge service
-// we could use a map[string]interface{}{} or something else but this makes it
-// easier to use in tests and makes the API contract clear
-// we could also be importing something from the charge service instead if that
-// actually existed
-type chargeServiceChargeArgs struct {
-	CardToken   string `json:"cardToken"`
-	AmountCents int64  `json:"amountCents"`
-}
-
-// innerChargeOrder actually does the charging or refunding (negative amount) by
-// making at POST request to the charge service
-func (i *instance) innerChargeOrder(ctx context.Context, args chargeServiceChargeArgs) error {
-	// encode the charge service's charge arguments as JSON so we can POST them to
-	// the /charge path on the charge service
-	// this method returns a byte slice that we can later pass to the Post message
-	// as the body of the POST request
-	// there's a package called "bytes" so we call the variable byts
-	byts, err := json.Marshal(args)
-	if err != nil {
-		return fmt.Errorf("error encoding charge body: %w", err)
-	}
-
-	// make a POST request to the /charge endpoint on the charge service
-	// the body is JSON but this method accepts a io.Reader so we need to wrap the
-	// byte slice in bytes.NewReader which simply reads over the sent byte slice
-	i.mu.Lock()
-	resp, err := i.chargeService.Post("/charge", "application/json", bytes.NewReader(byts))
-	i.mu.Unlock()
-
-	if err != nil {
-		return fmt.Errorf("error making charge request: %w", err)
-	}
-	// we need to make sure we close the body otherwise this will leak memory
-	defer resp.Body.Close()
-	// /charge creates a new charge so we expect a 201 response, if we didn't get
-	// that then we must've errored
-	if resp.StatusCode != http.StatusCreated {
-		// we opportunistically try to read the body in case it contains an error but
-		// if it fails then that's not the end of the world so we ignore the error
-		body, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("error charging body: %d %s", resp.StatusCode, body)
+// orderPrecondition is checked by the orders.Service before it changes an order
+// so customers can only change their own orders and the If-Match header is
+// honored
+func orderPrecondition(c *gin.Context) orders.Precondition {
+	return func(order storage.Order) error {
+		if !canAccessOrder(c, order) {
+			return errOrderNotFound
+		}
+		return checkIfMatch(c, order)
 	}
-	return nil
 }
 
-////////////////////////////////////////////////////////////////////////////////
-
 // chargeOrderArgs is the expected body for the POST /orders/:id/charge handler
 type chargeOrderArgs struct {
 	CardToken string `json:"cardToken"`
@@ -285,7 +346,11 @@ type chargeOrderArgs struct {
 
 // chargeOrderRes is the result of the POST /orders/:id/charge handler
 type chargeOrderRes struct {
-	ChargedCents int64 `json:"chargedCents"`
+	ChargedCents int64            `json:"chargedCents"`
+	Currency     storage.Currency `json:"currency"`
+	// ChargeID is the charge service's ID for the charge, it's omitted when the
+	// order's total was zero
+	ChargeID string `json:"chargeID,omitempty"`
 }
 
 // chargeOrder is called by incoming HTTP POST requests to /orders/:id/charge
@@ -297,256 +362,84 @@ func (i *instance) chargeOrder(c *gin.Context) {
 
 	// parse the body as JSON into the chargeOrderArgs struct
 	var args chargeOrderArgs
-	err := c.BindJSON(&args)
+	err := c.ShouldBindJSON(&args)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("error decoding body: %v", err)})
+		respondError(c, invalidBody(err))
 		return
 	}
 
 	// since the path includes a param :id we can get the value for that by calling
 	// the Param function
-	id := c.Param("id")
-
-	fmt.Println("HERE!!!")
-
-	// make a call to the storage instance to get the current state of the order
-	// so we can make sure that its ready for charging and get the amount to charge
-	order, err := i.stor.GetOrder(c.Request.Context(), id)
+	res, err := i.orders.Charge(ctx, c.Param("id"), args.CardToken, orderPrecondition(c))
 	if err != nil {
-		if errors.Is(err, storage.ErrOrderNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("error getting order: %v", err)})
-		}
-		return
-	}
-
-	// Based on the test cases I'm assuming this should error if already charged.
-	// Or fulfilled.
-	if order.Status == storage.OrderStatusCharged || order.Status == storage.OrderStatusFulfilled {
-		c.JSON(http.StatusConflict, gin.H{"error": "order ineligible for charging"})
-		return
-	}
-
-	// We know that you can charge a negative cents amount, so I'm opting to just
-	// Error out if it is explicitly zero, not if it's negative.
-	if order.TotalCents() != 0 {
-		err = i.innerChargeOrder(ctx, chargeServiceChargeArgs{
-			CardToken:   args.CardToken,
-			AmountCents: order.TotalCents(),
-		})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-	}
-
-	// in a real-world scenario we would do a two-phase change where we set it to
-	// charging ahead of time and then mark it as charged after so we would be able
-	// to understand if this was retried that we already tried to charge
-	// as it's written if this service crashed before this line then we would've
-	// charged the customer and not reflected that on the order but for now we're
-	// ignoring this scenario
-	err = i.stor.SetOrderStatus(ctx, order.ID, storage.OrderStatusCharged)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("error updating order to charged: %v", err)})
+		respondError(c, err)
 		return
 	}
 
 	// since we successfully charged the order and updated the order status we can
 	// return a success to the caller
+	c.Header("ETag", orderETag(res.Version))
 	c.JSON(http.StatusOK, chargeOrderRes{
-		ChargedCents: order.TotalCents(),
+		ChargedCents: res.Charged.Amount,
+		Currency:     res.Charged.Currency,
+		ChargeID:     res.ChargeID,
 	})
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
-func (i *instance) refundLineItems(ctx context.Context, lineItems []storage.LineItem, cardToken string) (int64, error) {
-	var totalRefund int64
-
-	// Calculate total refund
-	for _, lineItem := range lineItems {
-		totalRefund += lineItem.PriceCents * lineItem.Quantity
-	}
-
-	// Then send to inner charge
-
-	err := i.innerChargeOrder(ctx, chargeServiceChargeArgs{
-		CardToken:   cardToken,
-		AmountCents: -totalRefund,
-	})
-	if err != nil {
-		return 0, err
-	}
-
-	return -totalRefund, nil
-
-}
-
-type cancelOrderArgs struct {
-	CardToken string `json:"cardToken"`
-}
-
-// chargeOrderRes is the result of the POST /orders/:id/charge handler
+// cancelOrderRes is the result of the POST /orders/:id/cancel handler
 type cancelOrderRes struct {
-	OrderStatus  string `json:"orderStatus"`
-	ChargedCents int64  `json:"chargedCents"`
+	OrderStatus  string           `json:"orderStatus"`
+	ChargedCents int64            `json:"chargedCents"`
+	Currency     storage.Currency `json:"currency"`
+	// RefundIDs are the charge service's IDs for the refunds that were made
+	RefundIDs []string `json:"refundIDs,omitempty"`
 }
 
-// TODO: cancel args, res, function
 // cancelOrder is called by incoming HTTP POST requests to /orders/:id/cancel
 func (i *instance) cancelOrder(c *gin.Context) {
 	ctx := c.Request.Context()
 
-	// parse the body as JSON into the chargeOrderArgs struct
-	var args cancelOrderArgs
-	err := c.BindJSON(&args)
+	// there's no body since refunds go back to the charges recorded on the order,
+	// a cardToken sent by older clients is ignored
+	res, err := i.orders.Cancel(ctx, c.Param("id"), orderPrecondition(c))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("error decoding body: %v", err)})
+		respondError(c, err)
 		return
 	}
-
-	id := c.Param("id")
-
-	// Get order
-	order, err := i.stor.GetOrder(ctx, id)
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("error getting order: %v", err)})
-		return
+	if res.Cancelled {
+		c.Header("ETag", orderETag(res.Version))
 	}
 
-	var refundAmt int64
-	// If order is charged
-	// Refund charge on line items.
-	// Update to cancelled.
-	if order.Status == storage.OrderStatusCharged {
-		refundAmt, err = i.refundLineItems(ctx, order.LineItems, args.CardToken)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("error refunding line items: %v", err)})
-			return
-		}
-
-		err = i.stor.SetOrderStatus(ctx, id, storage.OrderStatusCancelled)
-		if err != nil {
-			// At this point it would just be an issue with setting the status. The refund has already occurred.
-			// Would likely be good to log internally for a manual fix or handle as part of a retry to set the status.
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("error cancelling order: %v", err)})
-			return
-		}
-	} else if order.Status == storage.OrderStatusFulfilled {
-		c.JSON(http.StatusConflict, gin.H{"error": "order has already been fulfilled"})
-		return
+	refundIDs := make([]string, len(res.Refunds))
+	for idx, refund := range res.Refunds {
+		refundIDs[idx] = refund.ID
 	}
-
 	c.JSON(http.StatusOK, cancelOrderRes{
 		OrderStatus:  "cancelled",
-		ChargedCents: refundAmt,
+		ChargedCents: res.Refunded.Amount,
+		Currency:     res.Refunded.Currency,
+		RefundIDs:    refundIDs,
 	})
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
-// fulfillmentServiceFulfillArgs are the arguments for the PUT /fulfill endpoint
-// exposed by the fulfillment service
-type fulfillmentServiceFulfillArgs struct {
-	Description string `json:"description"`
-	Quantity    int64  `json:"quantity"`
-	OrderID     string `json:"orderID"`
+// fulfillOrderRes is the result of the PUT /orders/:id/fulfill handler
+type fulfillOrderRes struct {
+	Fulfilled string `json:"fulfilled"`
 }
 
-// innerChargeOrder actually does the charging or refunding (negative amount) by
-// making at POST request to the charge service
-func (i *instance) innerFulfillOrder(ctx context.Context, args fulfillmentServiceFulfillArgs) error {
-	byts, err := json.Marshal(args)
-	if err != nil {
-		return fmt.Errorf("error encoding fulfill body: %w", err)
-	}
-
-	req, err := http.NewRequest(http.MethodPut, "/fulfill", bytes.NewReader(byts))
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := i.fulfillmentService.Do(req)
-
-	if err != nil {
-		return fmt.Errorf("error making fulfillment request: %w", err)
-	}
-	// we need to make sure we close the body otherwise this will leak memory
-	defer resp.Body.Close()
-	// /fulfill expects a 200 OK, if we didn't get
-	// that then we must've errored
-	if resp.StatusCode != http.StatusOK {
-		// we opportunistically try to read the body in case it contains an error but
-		// if it fails then that's not the end of the world so we ignore the error
-		body, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("error fulfilling body: %d %s", resp.StatusCode, body)
-	}
-
-	// For the purposes of this exercise we'll assume that a 200 means the entire order was fulfilled.
-	// In reality this is more complicated.
-	return nil
-}
-
-func (i *instance) fulfillOrders(ctx context.Context, orderID string, lineItems []storage.LineItem) (bool, error) {
-	// A variable to track if the entire order has been fulfilled.
-	for _, item := range lineItems {
-		args := fulfillmentServiceFulfillArgs{
-			Description: item.Description,
-			OrderID:     orderID,
-			Quantity:    item.Quantity,
-		}
-
-		err := i.innerFulfillOrder(ctx, args)
-
-		if err != nil {
-			return false, fmt.Errorf("fulfillOrders: %v", err)
-		}
-
-		// Assume the fulfillment service handles the logic behind saying if a given
-		// Line item has been fulfilled or not,
-		// based off of the quantity.
-	}
-
-	// Made it to the end, has been fulfilled on the order.
-	return true, nil
-}
-
-// TODO: fulfill args, res, function
+// fulFillOrder is called by incoming HTTP PUT requests to /orders/:id/fulfill
 func (i *instance) fulFillOrder(c *gin.Context) {
 	ctx := c.Request.Context()
 
-	id := c.Param("id")
-
-	// Get order
-	order, err := i.stor.GetOrder(ctx, id)
-
+	res, err := i.orders.Fulfill(ctx, c.Param("id"), orderPrecondition(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("error getting order: %v", err)})
-		return
-	}
-
-	if order.Status != storage.OrderStatusCharged {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "order cannot be fulfilled, order has not been charged"})
-		return
-	} else {
-		allFulfilled, err := i.fulfillOrders(ctx, id, order.LineItems)
-
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("error fulfilling line items: %v", err)})
-			return
-		}
-
-		if allFulfilled {
-			// If allFulfilled is true, update status.
-			err = i.stor.SetOrderStatus(ctx, id, storage.OrderStatusFulfilled)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("error updating order to fulfilled: %v", err)})
-				return
-			}
-		}
-
-		c.JSON(http.StatusOK, gin.H{"fulfilled": "true"})
+		respondError(c, err)
 		return
 	}
+	c.Header("ETag", orderETag(res.Version))
+	c.JSON(http.StatusOK, fulfillOrderRes{Fulfilled: "true"})
 }
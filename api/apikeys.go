@@ -0,0 +1,131 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levenlabs/order-up/auth"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/validation"
+)
+
+// apiKeyPrefix makes API keys easy to recognize, for example by secret scanners
+const apiKeyPrefix = "ou_"
+
+// generateAPIKey returns a new random API key
+func generateAPIKey() (string, error) {
+	// 32 random bytes is the same strength as a 256-bit symmetric key
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// postAPIKeyArgs is the expected body for the POST /admin/apikeys handler
+type postAPIKeyArgs struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// postAPIKeyRes is the result of the POST /admin/apikeys handler
+type postAPIKeyRes struct {
+	APIKey storage.APIKey `json:"apiKey"`
+	// Key is the actual key and this is the only time it's returned since we only
+	// store the hash
+	Key string `json:"key"`
+}
+
+// postAPIKeys is called by incoming HTTP POST requests to /admin/apikeys
+func (i *instance) postAPIKeys(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var args postAPIKeyArgs
+	err := c.ShouldBindJSON(&args)
+	if err != nil {
+		respondError(c, invalidBody(err))
+		return
+	}
+	var vs validation.Violations
+	if args.Name == "" {
+		vs = append(vs, validation.Violation{Field: "name", Message: "name is required"})
+	}
+	if len(args.Scopes) < 1 {
+		vs = append(vs, validation.Violation{Field: "scopes", Message: "an api key must have at least one scope"})
+	}
+	for idx, scope := range args.Scopes {
+		if !auth.KnownScope(scope) {
+			vs = append(vs, validation.Violation{Field: fmt.Sprintf("scopes[%d]", idx), Message: fmt.Sprintf("unknown scope: %v", scope)})
+		}
+	}
+	if len(vs) > 0 {
+		respondError(c, validationFailed(vs))
+		return
+	}
+
+	key, err := generateAPIKey()
+	if err != nil {
+		respondError(c, fmt.Errorf("error generating api key: %w", err))
+		return
+	}
+	apiKey := storage.APIKey{
+		Name:      args.Name,
+		Hash:      auth.HashAPIKey(key),
+		Scopes:    args.Scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	id, err := i.stor.InsertAPIKey(ctx, apiKey)
+	if err != nil {
+		respondError(c, fmt.Errorf("error inserting api key: %w", err))
+		return
+	}
+	apiKey.ID = id
+
+	c.JSON(http.StatusCreated, postAPIKeyRes{
+		APIKey: apiKey,
+		Key:    key,
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// getAPIKeysRes is the result of the GET /admin/apikeys handler
+type getAPIKeysRes struct {
+	APIKeys []storage.APIKey `json:"apiKeys"`
+}
+
+// getAPIKeys is called by incoming HTTP GET requests to /admin/apikeys
+func (i *instance) getAPIKeys(c *gin.Context) {
+	keys, err := i.stor.GetAPIKeys(c.Request.Context())
+	if err != nil {
+		respondError(c, fmt.Errorf("error getting api keys: %w", err))
+		return
+	}
+	// return [] instead of null for the same reason as getOrders
+	if keys == nil {
+		keys = []storage.APIKey{}
+	}
+	c.JSON(http.StatusOK, getAPIKeysRes{
+		APIKeys: keys,
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// deleteAPIKey is called by incoming HTTP DELETE requests to /admin/apikeys/:id
+func (i *instance) deleteAPIKey(c *gin.Context) {
+	err := i.stor.DeleteAPIKey(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		// respondError returns a 404 for a ErrAPIKeyNotFound error and a 500 for
+		// anything else
+		respondError(c, fmt.Errorf("error deleting api key: %w", err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
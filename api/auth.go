@@ -0,0 +1,108 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levenlabs/order-up/auth"
+	"github.com/levenlabs/order-up/storage"
+)
+
+// These are the scopes that can be granted to API keys and JWTs, see the auth
+// package. Each route requires exactly one of them.
+const (
+	ScopeOrdersRead   = auth.ScopeOrdersRead
+	ScopeOrdersWrite  = auth.ScopeOrdersWrite
+	ScopeOrdersCharge = auth.ScopeOrdersCharge
+	ScopeOrdersRefund = auth.ScopeOrdersRefund
+	ScopeAdmin        = auth.ScopeAdmin
+)
+
+// AuthConfig holds the keys used to verify JWTs. API keys are always accepted
+// once authentication is enabled since they're looked up in storage.
+type AuthConfig = auth.Config
+
+// WithAuth enables authentication on every route except the health endpoints.
+// Without it every route is public, which is only meant for tests and local
+// development.
+func WithAuth(cfg AuthConfig) Option {
+	return func(i *instance) {
+		i.auth = auth.New(i.stor, cfg)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// principalKey is the key the authenticated principal is stored under in the
+// gin.Context
+const principalKey = "principal"
+
+// principalFromContext returns the authenticated principal for the request.
+// It returns false if authentication is disabled.
+func principalFromContext(c *gin.Context) (auth.Principal, bool) {
+	p, ok := c.Get(principalKey)
+	if !ok {
+		return auth.Principal{}, false
+	}
+	return p.(auth.Principal), true
+}
+
+// customerEmail returns the email of the customer making the request or an
+// empty string if the request isn't from a customer
+func customerEmail(c *gin.Context) string {
+	p, _ := principalFromContext(c)
+	return p.CustomerEmail
+}
+
+// canAccessOrder returns false if the request is from a customer and the order
+// isn't theirs. Callers should respond as if the order doesn't exist so
+// customers can't discover other customers' order IDs.
+func canAccessOrder(c *gin.Context, order storage.Order) bool {
+	p, _ := principalFromContext(c)
+	return p.CanAccessOrder(order)
+}
+
+// authenticate is a middleware that figures out who is making the request and
+// aborts with a 401 if they can't be identified
+func (i *instance) authenticate(c *gin.Context) {
+	if i.auth == nil {
+		c.Next()
+		return
+	}
+
+	p, err := i.auth.Authenticate(c.Request.Context(), c.GetHeader("X-API-Key"), c.GetHeader("Authorization"))
+	if err != nil {
+		var storErr auth.StorageError
+		if errors.As(err, &storErr) {
+			respondError(c, storErr.Err)
+			return
+		}
+		c.Header("WWW-Authenticate", `Bearer realm="order-up"`)
+		respondError(c, newError(http.StatusUnauthorized, CodeUnauthenticated, err.Error()))
+		return
+	}
+
+	c.Set(principalKey, p)
+	// storage records the actor on any history entries written for this request
+	c.Request = c.Request.WithContext(storage.WithActor(c.Request.Context(), p.Actor))
+	c.Next()
+}
+
+// requireScope returns a middleware that aborts with a 403 if the authenticated
+// principal wasn't granted scope. It must run after authenticate.
+func (i *instance) requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if i.auth == nil {
+			c.Next()
+			return
+		}
+		p := c.MustGet(principalKey).(auth.Principal)
+		if !p.Scopes[scope] {
+			respondError(c, newError(http.StatusForbidden, CodeForbidden, fmt.Sprintf("missing required scope %s", scope)))
+			return
+		}
+		c.Next()
+	}
+}
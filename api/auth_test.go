@@ -0,0 +1,425 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/levenlabs/order-up/auth"
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// testHS256Secret is the secret the tests sign HS256 tokens with
+var testHS256Secret = []byte("test-secret")
+
+// signToken returns a JWT signed with method and key for the given subject and
+// scopes that expires in an hour
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, subject, scope string) string {
+	token, err := jwt.NewWithClaims(method, auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			Issuer:    "test-issuer",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Scope: scope,
+	}).SignedString(key)
+	require.NoError(t, err)
+	return token
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestAuthenticate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	cfg := AuthConfig{
+		HS256Secrets:    [][]byte{testHS256Secret},
+		RS256PublicKeys: []*rsa.PublicKey{&rsaKey.PublicKey},
+		Issuer:          "test-issuer",
+	}
+
+	// the health endpoints don't require authentication
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil, WithAuth(cfg))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/healthz", nil)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		stor.AssertExpectations(t)
+	}
+
+	// should 401 without any credentials
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil, WithAuth(cfg))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/orders", nil)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+		stor.AssertExpectations(t)
+	}
+
+	// should 401 with an unknown api key
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetAPIKeyByHash", mock.Anything, auth.HashAPIKey("ou_unknown")).Return(storage.APIKey{}, storage.ErrAPIKeyNotFound).Once()
+		h := Handler(stor, nil, nil, WithAuth(cfg))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/orders", nil)
+		r.Header.Set("X-API-Key", "ou_unknown")
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		stor.AssertExpectations(t)
+	}
+
+	// should allow an api key with the right scope
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetAPIKeyByHash", mock.Anything, auth.HashAPIKey("ou_reader")).Return(storage.APIKey{
+			ID:     "reader",
+			Scopes: []string{ScopeOrdersRead},
+		}, nil).Once()
+		stor.On("GetOrders", mock.Anything, storage.OrderFilter{Status: storage.OrderStatusAny}).Return([]storage.Order{}, nil).Once()
+		h := Handler(stor, nil, nil, WithAuth(cfg))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/orders", nil)
+		r.Header.Set("X-API-Key", "ou_reader")
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		stor.AssertExpectations(t)
+	}
+
+	// should 403 an api key without the right scope
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetAPIKeyByHash", mock.Anything, auth.HashAPIKey("ou_reader")).Return(storage.APIKey{
+			ID:     "reader",
+			Scopes: []string{ScopeOrdersRead},
+		}, nil).Once()
+		h := Handler(stor, nil, nil, WithAuth(cfg))
+		w := httptest.NewRecorder()
+		byts, err := json.Marshal(chargeOrderArgs{CardToken: "amex"})
+		require.NoError(t, err)
+		r := httptest.NewRequest("POST", "/orders/test/charge", bytes.NewReader(byts))
+		r.Header.Set("X-API-Key", "ou_reader")
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		stor.AssertExpectations(t)
+	}
+
+	// should allow HS256 and RS256 tokens with the right scope
+	for _, token := range []string{
+		signToken(t, jwt.SigningMethodHS256, testHS256Secret, "user-1", ScopeOrdersRead),
+		signToken(t, jwt.SigningMethodRS256, rsaKey, "user-1", ScopeOrdersWrite+" "+ScopeOrdersRead),
+	} {
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrders", mock.Anything, storage.OrderFilter{Status: storage.OrderStatusAny}).Return([]storage.Order{}, nil).Once()
+		h := Handler(stor, nil, nil, WithAuth(cfg))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/orders", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		stor.AssertExpectations(t)
+	}
+
+	// should 401 invalid tokens
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	for name, token := range map[string]string{
+		"wrong secret": signToken(t, jwt.SigningMethodHS256, []byte("wrong"), "user-1", ScopeOrdersRead),
+		"wrong key":    signToken(t, jwt.SigningMethodRS256, otherKey, "user-1", ScopeOrdersRead),
+		"no subject":   signToken(t, jwt.SigningMethodHS256, testHS256Secret, "", ScopeOrdersRead),
+		"expired": func() string {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject:   "user-1",
+					Issuer:    "test-issuer",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+				},
+				Scope: ScopeOrdersRead,
+			}).SignedString(testHS256Secret)
+			require.NoError(t, err)
+			return token
+		}(),
+		"never expires": func() string {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject: "user-1",
+					Issuer:  "test-issuer",
+				},
+				Scope: ScopeOrdersRead,
+			}).SignedString(testHS256Secret)
+			require.NoError(t, err)
+			return token
+		}(),
+		"wrong issuer": func() string {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject:   "user-1",
+					Issuer:    "someone-else",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				},
+				Scope: ScopeOrdersRead,
+			}).SignedString(testHS256Secret)
+			require.NoError(t, err)
+			return token
+		}(),
+	} {
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil, WithAuth(cfg))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/orders", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, name)
+		stor.AssertExpectations(t)
+	}
+
+	// should record the principal on the status history
+	{
+		order := storage.Order{
+			ID:            "test",
+			CustomerEmail: "test@test",
+			LineItems:     []storage.LineItem{},
+			Status:        storage.OrderStatusPending,
+		}
+		// mock.MatchedBy lets us make assertions on the context that's passed to
+		// storage rather than matching it exactly
+		hasActor := mock.MatchedBy(func(ctx context.Context) bool {
+			return storage.ActorFromContext(ctx) == "jwt:user-1"
+		})
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, order.ID).Return(order, nil).Once()
+		stor.On("SetOrderStatus", hasActor, order.ID, storage.OrderStatusCharging, int64(0)).Return(nil).Once()
+		stor.On("SetOrderStatus", hasActor, order.ID, storage.OrderStatusCharged, int64(1)).Return(nil).Once()
+		h := Handler(stor, nil, nil, WithAuth(cfg))
+		w := httptest.NewRecorder()
+		byts, err := json.Marshal(chargeOrderArgs{CardToken: "amex"})
+		require.NoError(t, err)
+		r := httptest.NewRequest("POST", "/orders/test/charge", bytes.NewReader(byts))
+		r.Header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodHS256, testHS256Secret, "user-1", ScopeOrdersCharge))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		stor.AssertExpectations(t)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestAPIKeys(t *testing.T) {
+	// the context just needs to be something static so we can include it in the
+	// mocked arguments
+	ctx := context.Background()
+
+	// should create a key and only store its hash
+	{
+		stor := new(mocks.MockStorageInstance)
+		var inserted storage.APIKey
+		stor.On("InsertAPIKey", ctx, mock.AnythingOfType("storage.APIKey")).Run(func(args mock.Arguments) {
+			inserted = args.Get(1).(storage.APIKey)
+		}).Return("key-1", nil).Once()
+		h := Handler(stor, nil, nil)
+		w := httptest.NewRecorder()
+		byts, err := json.Marshal(postAPIKeyArgs{
+			Name:   "storefront",
+			Scopes: []string{ScopeOrdersRead, ScopeOrdersWrite},
+		})
+		require.NoError(t, err)
+		r := httptest.NewRequest("POST", "/admin/apikeys", bytes.NewReader(byts)).WithContext(ctx)
+		h.ServeHTTP(w, r)
+		if assert.Equal(t, http.StatusCreated, w.Code) {
+			var res postAPIKeyRes
+			err := json.Unmarshal(w.Body.Bytes(), &res)
+			require.NoError(t, err)
+			assert.Equal(t, "key-1", res.APIKey.ID)
+			assert.Equal(t, "storefront", res.APIKey.Name)
+			assert.Equal(t, []string{ScopeOrdersRead, ScopeOrdersWrite}, res.APIKey.Scopes)
+			assert.Contains(t, res.Key, apiKeyPrefix)
+			assert.Equal(t, auth.HashAPIKey(res.Key), inserted.Hash)
+			// the hash should never be returned
+			assert.NotContains(t, w.Body.String(), inserted.Hash)
+		}
+		stor.AssertExpectations(t)
+	}
+
+	// should error on an unknown scope
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil)
+		w := httptest.NewRecorder()
+		byts, err := json.Marshal(postAPIKeyArgs{
+			Name:   "storefront",
+			Scopes: []string{"orders:everything"},
+		})
+		require.NoError(t, err)
+		r := httptest.NewRequest("POST", "/admin/apikeys", bytes.NewReader(byts)).WithContext(ctx)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		stor.AssertExpectations(t)
+	}
+
+	// should list keys
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetAPIKeys", ctx).Return(nil, nil).Once()
+		h := Handler(stor, nil, nil)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/admin/apikeys", nil).WithContext(ctx)
+		h.ServeHTTP(w, r)
+		if assert.Equal(t, http.StatusOK, w.Code) {
+			assert.Equal(t, `{"apiKeys":[]}`, w.Body.String())
+		}
+		stor.AssertExpectations(t)
+	}
+
+	// should delete keys and 404 on unknown keys
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("DeleteAPIKey", ctx, "key-1").Return(nil).Once()
+		stor.On("DeleteAPIKey", ctx, "key-2").Return(storage.ErrAPIKeyNotFound).Once()
+		h := Handler(stor, nil, nil)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("DELETE", "/admin/apikeys/key-1", nil).WithContext(ctx)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("DELETE", "/admin/apikeys/key-2", nil).WithContext(ctx)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		stor.AssertExpectations(t)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestCustomerAccess(t *testing.T) {
+	cfg := AuthConfig{
+		HS256Secrets: [][]byte{testHS256Secret},
+	}
+	// customerToken returns a customer token for email with every order scope
+	customerToken := func(email string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   "customer-1",
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+			Scope: ScopeOrdersRead + " " + ScopeOrdersWrite + " " + ScopeOrdersCharge + " " + ScopeOrdersRefund,
+			Role:  auth.RoleCustomer,
+			Email: email,
+		}).SignedString(testHS256Secret)
+		require.NoError(t, err)
+		return token
+	}
+	theirs := storage.Order{
+		ID:            "theirs",
+		CustomerEmail: "someone@else",
+		LineItems:     []storage.LineItem{},
+		Status:        storage.OrderStatusPending,
+	}
+	mine := storage.Order{
+		ID:            "mine",
+		CustomerEmail: "me@test",
+		LineItems:     []storage.LineItem{},
+		Status:        storage.OrderStatusPending,
+	}
+
+	// should filter the orders in storage to only the customer's
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrders", mock.Anything, storage.OrderFilter{
+			Status:        storage.OrderStatusPending,
+			CustomerEmail: "me@test",
+		}).Return([]storage.Order{mine}, nil).Once()
+		h := Handler(stor, nil, nil, WithAuth(cfg))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/orders?status=pending", nil)
+		r.Header.Set("Authorization", "Bearer "+customerToken("me@test"))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		stor.AssertExpectations(t)
+	}
+
+	// should get their own order but 404 on anyone else's
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, mine.ID).Return(mine, nil).Once()
+		stor.On("GetOrder", mock.Anything, theirs.ID).Return(theirs, nil).Once()
+		h := Handler(stor, nil, nil, WithAuth(cfg))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/orders/mine", nil)
+		r.Header.Set("Authorization", "Bearer "+customerToken("me@test"))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("GET", "/orders/theirs", nil)
+		r.Header.Set("Authorization", "Bearer "+customerToken("me@test"))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		stor.AssertExpectations(t)
+	}
+
+	// should not charge or cancel anyone else's order
+	for _, action := range []string{"charge", "cancel"} {
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, theirs.ID).Return(theirs, nil).Once()
+		h := Handler(stor, nil, nil, WithAuth(cfg))
+		w := httptest.NewRecorder()
+		byts, err := json.Marshal(chargeOrderArgs{CardToken: "amex"})
+		require.NoError(t, err)
+		r := httptest.NewRequest("POST", "/orders/theirs/"+action, bytes.NewReader(byts))
+		r.Header.Set("Authorization", "Bearer "+customerToken("me@test"))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code, action)
+		stor.AssertExpectations(t)
+	}
+
+	// should not place orders for anyone else
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil, WithAuth(cfg))
+		w := httptest.NewRecorder()
+		byts, err := json.Marshal(postOrderArgs{
+			CustomerEmail: "someone@else",
+			LineItems: []storage.LineItem{
+				{
+					Description: "item 1",
+					Quantity:    1,
+					PriceCents:  1000,
+				},
+			},
+		})
+		require.NoError(t, err)
+		r := httptest.NewRequest("POST", "/orders", bytes.NewReader(byts))
+		r.Header.Set("Authorization", "Bearer "+customerToken("me@test"))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		stor.AssertExpectations(t)
+	}
+
+	// should reject customer tokens without an email
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil, WithAuth(cfg))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/orders", nil)
+		r.Header.Set("Authorization", "Bearer "+customerToken(""))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		stor.AssertExpectations(t)
+	}
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levenlabs/order-up/orders"
+	"github.com/levenlabs/order-up/storage"
+)
+
+// MaxBatchOrders is the most orders a single POST /orders:batch request can
+// create
+const MaxBatchOrders = orders.MaxBatchOrders
+
+// postOrdersAction is called by incoming HTTP POST requests to /orders:<action>.
+// The router treats everything after /orders as a parameter so the actions are
+// dispatched here instead.
+func (i *instance) postOrdersAction(c *gin.Context) {
+	switch c.Param("action") {
+	case ":batch":
+		i.postOrdersBatch(c)
+	default:
+		notFound(c)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// postOrdersBatchArgs is the expected body for the POST /orders:batch handler
+type postOrdersBatchArgs struct {
+	Orders []postOrderArgs `json:"orders"`
+}
+
+// batchOrderResult is the result for a single order of a batch. Exactly one of
+// Order and Error is set.
+type batchOrderResult struct {
+	Order *storage.Order `json:"order,omitempty"`
+	Error *Error         `json:"error,omitempty"`
+}
+
+// postOrdersBatchRes is the result of the POST /orders:batch handler. Results
+// has one result for every order in the request, in the same order.
+type postOrdersBatchRes struct {
+	Results []batchOrderResult `json:"results"`
+}
+
+// postOrdersBatch is called by incoming HTTP POST requests to /orders:batch.
+// Every order is validated and created just like with POST /orders but a
+// failed order doesn't stop the rest unless the atomic query parameter is true
+// in which case either every order is created or none are.
+func (i *instance) postOrdersBatch(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	atomic := false
+	if q := c.Query("atomic"); q != "" {
+		var err error
+		atomic, err = strconv.ParseBool(q)
+		if err != nil {
+			respondError(c, newError(http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("unknown value for atomic: %v", q)))
+			return
+		}
+	}
+
+	var args postOrdersBatchArgs
+	err := c.ShouldBindJSON(&args)
+	if err != nil {
+		respondError(c, invalidBody(err))
+		return
+	}
+
+	createArgs := make([]orders.CreateArgs, len(args.Orders))
+	for idx, orderArgs := range args.Orders {
+		createArgs[idx] = orders.CreateArgs(orderArgs)
+	}
+	batch, errs, err := i.orders.CreateBatch(ctx, createArgs, atomic, customerEmail(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondBatch(c, batch, errs)
+}
+
+// respondBatch responds with the result of every order in a batch. The status
+// is always 200 since each order has its own result, even if every one of them
+// failed.
+func respondBatch(c *gin.Context, orders []storage.Order, errs []error) {
+	res := postOrdersBatchRes{
+		Results: make([]batchOrderResult, len(orders)),
+	}
+	for idx := range orders {
+		if errs[idx] != nil {
+			res.Results[idx].Error = requestError(c, errs[idx])
+		} else {
+			res.Results[idx].Order = &orders[idx]
+		}
+	}
+	c.JSON(http.StatusOK, versioned(c, res))
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostOrdersBatch(t *testing.T) {
+	percent := storage.Promotion{Code: "SUMMER10", Kind: storage.PromotionKindPercentage, PercentOff: 10}
+	valid := postOrderArgs{
+		CustomerEmail: "test@test",
+		LineItems:     []storage.LineItem{{Description: "item 1", Quantity: 1, PriceCents: 1000}},
+	}
+	// expValid is valid once it's been built by newOrder
+	expValid := storage.Order{
+		CustomerEmail: "test@test",
+		Currency:      storage.DefaultCurrency,
+		LineItems:     valid.LineItems,
+	}
+	promo := valid
+	promo.PromoCodes = []string{"SUMMER10"}
+	expPromo := expValid
+	expPromo.LineItems = append(expValid.LineItems, storage.LineItem{
+		Description: "Promotion SUMMER10", Quantity: 1, PriceCents: -100, Kind: storage.LineItemKindDiscount,
+	})
+	expPromo.PromoCodes = []string{"SUMMER10"}
+	invalid := postOrderArgs{CustomerEmail: "test@test"}
+
+	postBatch := func(h http.Handler, query string, orders ...postOrderArgs) *httptest.ResponseRecorder {
+		byts, err := json.Marshal(postOrdersBatchArgs{Orders: orders})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/orders:batch"+query, bytes.NewReader(byts)))
+		return w
+	}
+	decodeResults := func(w *httptest.ResponseRecorder) []batchOrderResult {
+		require.Equal(t, http.StatusOK, w.Code)
+		var res postOrdersBatchRes
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res.Results
+	}
+
+	// every order gets its own result and failures don't stop the rest
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetPromotion", mock.Anything, "SUMMER10").Return(percent, nil).Once()
+		stor.On("RedeemPromotion", mock.Anything, "SUMMER10", "test@test").Return(nil).Once()
+		stor.On("InsertOrders", mock.Anything, []storage.Order{expValid, expPromo}, false).
+			Return([]string{"id1", "id2"}, []error{nil, storage.ErrOrderExists}, nil).Once()
+		// the promotion of the order that wasn't inserted is given back
+		stor.On("ReleasePromotion", mock.Anything, "SUMMER10", "test@test").Return(nil).Once()
+		h := Handler(stor, nil, nil)
+		results := decodeResults(postBatch(h, "", valid, invalid, promo))
+		require.Len(t, results, 3)
+		if assert.NotNil(t, results[0].Order) {
+			assert.Equal(t, "id1", results[0].Order.ID)
+			assert.EqualValues(t, 1, results[0].Order.Version)
+		}
+		if assert.NotNil(t, results[1].Error) {
+			assert.Equal(t, CodeValidationFailed, results[1].Error.Code)
+			assert.Equal(t, []FieldError{{Field: "lineItems", Message: "an order must contain at least one line item"}}, results[1].Error.Details)
+		}
+		if assert.NotNil(t, results[2].Error) {
+			assert.Equal(t, CodeOrderExists, results[2].Error.Code)
+		}
+		stor.AssertExpectations(t)
+	}
+
+	// with atomic=true nothing is inserted if any order is invalid
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil)
+		results := decodeResults(postBatch(h, "?atomic=true", valid, invalid))
+		require.Len(t, results, 2)
+		if assert.NotNil(t, results[0].Error) {
+			assert.Equal(t, CodeBatchAborted, results[0].Error.Code)
+		}
+		if assert.NotNil(t, results[1].Error) {
+			assert.Equal(t, CodeValidationFailed, results[1].Error.Code)
+		}
+		stor.AssertExpectations(t)
+	}
+
+	// with atomic=true storage decides which orders were aborted
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetPromotion", mock.Anything, "SUMMER10").Return(percent, nil).Once()
+		stor.On("RedeemPromotion", mock.Anything, "SUMMER10", "test@test").Return(nil).Once()
+		stor.On("InsertOrders", mock.Anything, []storage.Order{expPromo, expValid}, true).
+			Return([]string{"id1", "id2"}, []error{storage.ErrBatchAborted, storage.ErrOrderExists}, nil).Once()
+		stor.On("ReleasePromotion", mock.Anything, "SUMMER10", "test@test").Return(nil).Once()
+		h := Handler(stor, nil, nil)
+		results := decodeResults(postBatch(h, "?atomic=1", promo, valid))
+		require.Len(t, results, 2)
+		if assert.NotNil(t, results[0].Error) {
+			assert.Equal(t, CodeBatchAborted, results[0].Error.Code)
+		}
+		if assert.NotNil(t, results[1].Error) {
+			assert.Equal(t, CodeOrderExists, results[1].Error.Code)
+		}
+		stor.AssertExpectations(t)
+	}
+
+	// the whole batch fails if storage does
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("InsertOrders", mock.Anything, []storage.Order{expValid}, false).
+			Return(nil, nil, assert.AnError).Once()
+		h := Handler(stor, nil, nil)
+		w := postBatch(h, "", valid)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, CodeInternal, decodeError(t, w).Code)
+		stor.AssertExpectations(t)
+	}
+
+	// the batch itself is validated
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil)
+		w := postBatch(h, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, CodeValidationFailed, decodeError(t, w).Code)
+
+		orders := make([]postOrderArgs, MaxBatchOrders+1)
+		for idx := range orders {
+			orders[idx] = valid
+		}
+		w = postBatch(h, "", orders...)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, CodeValidationFailed, decodeError(t, w).Code)
+
+		w = postBatch(h, "?atomic=maybe", valid)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, CodeInvalidRequest, decodeError(t, w).Code)
+		stor.AssertExpectations(t)
+	}
+
+	// other actions don't exist
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/orders:bogus", bytes.NewReader([]byte(`{}`))))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, CodeNotFound, decodeError(t, w).Code)
+	}
+}
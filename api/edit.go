@@ -0,0 +1,166 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levenlabs/order-up/orders"
+	"github.com/levenlabs/order-up/storage"
+)
+
+// orderEdit is the part of an order that can be edited with PATCH /orders/:id.
+// Tax, shipping and discount line items aren't included since they're
+// recalculated from the rest of the order.
+type orderEdit struct {
+	CustomerEmail   string             `json:"customerEmail"`
+	LineItems       []storage.LineItem `json:"lineItems"`
+	ShippingAddress *storage.Address   `json:"shippingAddress"`
+	BillingAddress  *storage.Address   `json:"billingAddress"`
+	ShippingMethod  string             `json:"shippingMethod"`
+	Jurisdiction    string             `json:"jurisdiction"`
+	Notes           string             `json:"notes"`
+}
+
+// newOrderEdit returns the editable part of order
+func newOrderEdit(order storage.Order) orderEdit {
+	var products []storage.LineItem
+	for _, li := range order.LineItems {
+		if li.Kind == storage.LineItemKindProduct {
+			products = append(products, li)
+		}
+	}
+	return orderEdit{
+		CustomerEmail:   order.CustomerEmail,
+		LineItems:       products,
+		ShippingAddress: order.ShippingAddress,
+		BillingAddress:  order.BillingAddress,
+		ShippingMethod:  order.ShippingMethod,
+		Jurisdiction:    order.Jurisdiction,
+		Notes:           order.Notes,
+	}
+}
+
+// applyMergePatch applies patch, a JSON Merge Patch (RFC 7386) document, to
+// edit. Fields that aren't part of orderEdit can't be patched.
+func applyMergePatch(edit orderEdit, patch map[string]interface{}) (orderEdit, error) {
+	byts, err := json.Marshal(edit)
+	if err != nil {
+		return orderEdit{}, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(byts, &doc); err != nil {
+		return orderEdit{}, err
+	}
+	byts, err = json.Marshal(mergePatch(doc, patch))
+	if err != nil {
+		return orderEdit{}, err
+	}
+
+	var patched orderEdit
+	dec := json.NewDecoder(bytes.NewReader(byts))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patched); err != nil {
+		return orderEdit{}, err
+	}
+	return patched, nil
+}
+
+// mergePatch implements the MergePatch function from RFC 7386. Objects are
+// merged recursively, null removes a field and anything else, including arrays,
+// replaces the target.
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+	for k, v := range patchObj {
+		if v == nil {
+			delete(targetObj, k)
+		} else {
+			targetObj[k] = mergePatch(targetObj[k], v)
+		}
+	}
+	return targetObj
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// patchOrderRes is the result of the PATCH /orders/:id handler
+type patchOrderRes struct {
+	Order storage.Order `json:"order"`
+}
+
+// patchOrder is called by incoming HTTP PATCH requests to /orders/:id. The body
+// is a JSON Merge Patch of the order's editable fields and the If-Match header
+// has to have the ETag of the version of the order the patch was based on.
+func (i *instance) patchOrder(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// without If-Match a client could overwrite changes it never saw
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		respondError(c, newError(http.StatusPreconditionRequired, CodePreconditionRequired, "If-Match header is required to edit an order"))
+		return
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		respondError(c, invalidBody(err))
+		return
+	}
+	// a merge patch that isn't an object would replace the whole order which we
+	// don't allow
+	var patch map[string]interface{}
+	if err := json.Unmarshal(body, &patch); err != nil {
+		respondError(c, invalidBody(err))
+		return
+	}
+
+	order, err := i.orders.Get(ctx, c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if !canAccessOrder(c, order) {
+		respondError(c, errOrderNotFound)
+		return
+	}
+	if err := checkIfMatch(c, order); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	edit, err := applyMergePatch(newOrderEdit(order), patch)
+	if err != nil {
+		respondError(c, invalidBody(err))
+		return
+	}
+	// a new shipping address without a jurisdiction means the jurisdiction should
+	// be the new address's, just like when the order was created
+	_, patchedAddress := patch["shippingAddress"]
+	_, patchedJurisdiction := patch["jurisdiction"]
+	if patchedAddress && !patchedJurisdiction {
+		edit.Jurisdiction = ""
+	}
+
+	// respondError returns a 409 if the order isn't pending, a 403 if a customer
+	// gives their order to someone else, a 412 for a ErrVersionConflict error
+	// and a 500 for anything else
+	updated, err := i.orders.Edit(ctx, order, orders.EditArgs(edit), customerEmail(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Header("ETag", orderETag(updated.Version))
+	c.JSON(http.StatusOK, versioned(c, patchOrderRes{
+		Order: updated,
+	}))
+}
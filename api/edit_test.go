@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergePatch(t *testing.T) {
+	// these are examples from RFC 7386
+	for _, test := range []struct {
+		target, patch, exp string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	} {
+		var target, patch interface{}
+		require.NoError(t, json.Unmarshal([]byte(test.target), &target))
+		require.NoError(t, json.Unmarshal([]byte(test.patch), &patch))
+		byts, err := json.Marshal(mergePatch(target, patch))
+		require.NoError(t, err)
+		assert.JSONEq(t, test.exp, string(byts), "%s + %s", test.target, test.patch)
+	}
+}
+
+func TestPatchOrder(t *testing.T) {
+	percent := storage.Promotion{Code: "SUMMER10", Kind: storage.PromotionKindPercentage, PercentOff: 10}
+	pending := storage.Order{
+		ID:            "order1",
+		CustomerEmail: "test@test",
+		Currency:      storage.DefaultCurrency,
+		LineItems: []storage.LineItem{
+			{Description: "item 1", Quantity: 1, PriceCents: 1000},
+			{Description: "Promotion SUMMER10", Quantity: 1, PriceCents: -100, Kind: storage.LineItemKindDiscount},
+		},
+		PromoCodes: []string{"SUMMER10"},
+		Status:     storage.OrderStatusPending,
+		Version:    3,
+	}
+	patchOrder := func(h http.Handler, ifMatch, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("PATCH", "/orders/order1", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/merge-patch+json")
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// line items and notes are replaced and the discount is recalculated
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "order1").Return(pending, nil).Once()
+		stor.On("GetPromotion", mock.Anything, "SUMMER10").Return(percent, nil).Once()
+		expOrder := pending
+		expOrder.LineItems = []storage.LineItem{
+			{Description: "item 1", Quantity: 3, PriceCents: 1000},
+			{Description: "Promotion SUMMER10", Quantity: 1, PriceCents: -300, Kind: storage.LineItemKindDiscount},
+		}
+		expOrder.Notes = "leave it on the porch"
+		stor.On("UpdateOrder", mock.Anything, expOrder, mock.MatchedBy(func(changes []storage.FieldChange) bool {
+			return len(changes) == 2 && changes[0].Field == "lineItems" &&
+				changes[1].Field == "notes" && string(changes[1].From) == `""` && string(changes[1].To) == `"leave it on the porch"`
+		})).Return(nil).Once()
+		h := Handler(stor, nil, nil)
+		w := patchOrder(h, `"3"`, `{"lineItems": [{"description": "item 1", "quantity": 3, "priceCents": 1000}], "notes": "leave it on the porch"}`)
+		if assert.Equal(t, http.StatusOK, w.Code) {
+			assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+			var res patchOrderRes
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			assert.EqualValues(t, 2700, res.Order.TotalCents())
+			assert.EqualValues(t, 4, res.Order.Version)
+		}
+		stor.AssertExpectations(t)
+	}
+
+	// a patch that doesn't change anything isn't saved
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "order1").Return(pending, nil).Once()
+		stor.On("GetPromotion", mock.Anything, "SUMMER10").Return(percent, nil).Once()
+		h := Handler(stor, nil, nil)
+		w := patchOrder(h, `"3"`, `{"customerEmail": "test@test"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+		stor.AssertExpectations(t)
+	}
+
+	// If-Match is required and has to match the current version
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "order1").Return(pending, nil).Once()
+		h := Handler(stor, nil, nil)
+		w := patchOrder(h, "", `{"notes": "hi"}`)
+		assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+		assert.Equal(t, CodePreconditionRequired, decodeError(t, w).Code)
+
+		w = patchOrder(h, `"2"`, `{"notes": "hi"}`)
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		assert.Equal(t, CodeVersionConflict, decodeError(t, w).Code)
+		stor.AssertExpectations(t)
+	}
+
+	// the order might have changed since it was loaded
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "order1").Return(pending, nil).Once()
+		stor.On("GetPromotion", mock.Anything, "SUMMER10").Return(percent, nil).Once()
+		stor.On("UpdateOrder", mock.Anything, mock.Anything, mock.Anything).Return(storage.ErrVersionConflict).Once()
+		h := Handler(stor, nil, nil)
+		w := patchOrder(h, `"3"`, `{"notes": "hi"}`)
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		assert.Equal(t, CodeVersionConflict, decodeError(t, w).Code)
+		stor.AssertExpectations(t)
+	}
+
+	// only the editable fields can be patched and they're validated
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "order1").Return(pending, nil).Twice()
+		h := Handler(stor, nil, nil)
+		w := patchOrder(h, `"3"`, `{"status": 2}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, CodeInvalidRequest, decodeError(t, w).Code)
+
+		w = patchOrder(h, `"3"`, `{"customerEmail": null, "lineItems": [{"description": "tax", "quantity": 1, "priceCents": 1, "kind": "tax"}]}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, []FieldError{
+			{Field: "customerEmail", Message: "must be an email address"},
+			{Field: "lineItems[0].kind", Message: "tax, shipping and promotion line items are added automatically"},
+		}, decodeError(t, w).Details)
+		stor.AssertExpectations(t)
+	}
+
+	// only pending orders can be edited
+	{
+		charged := pending
+		charged.Status = storage.OrderStatusCharged
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "order1").Return(charged, nil).Once()
+		h := Handler(stor, nil, nil)
+		w := patchOrder(h, `"3"`, `{"notes": "hi"}`)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, CodeInvalidTransition, decodeError(t, w).Code)
+		stor.AssertExpectations(t)
+	}
+}
@@ -0,0 +1,335 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levenlabs/go-llog"
+	"github.com/levenlabs/order-up/orders"
+	"github.com/levenlabs/order-up/services"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/validation"
+)
+
+// ErrorCode is a stable, machine-readable identifier for an error. Unlike the
+// message, codes are never changed once they're added so clients can switch on
+// them.
+type ErrorCode string
+
+// These are all of the error codes the API can return
+const (
+	// CodeInvalidRequest means the request couldn't be parsed, like a malformed
+	// body or an unknown query parameter value
+	CodeInvalidRequest ErrorCode = "invalid_request"
+	// CodeValidationFailed means the request was parsed but some fields are
+	// invalid, the Details list every invalid field
+	CodeValidationFailed ErrorCode = "validation_failed"
+	// CodeUnauthenticated means the request didn't have valid credentials
+	CodeUnauthenticated ErrorCode = "unauthenticated"
+	// CodeForbidden means the credentials aren't allowed to make the request
+	CodeForbidden ErrorCode = "forbidden"
+	// CodeNotFound means there's no route for the request
+	CodeNotFound ErrorCode = "not_found"
+	// CodeOrderNotFound means the order doesn't exist
+	CodeOrderNotFound ErrorCode = "order_not_found"
+	// CodeOrderExists means an order with the same ID already exists
+	CodeOrderExists ErrorCode = "order_exists"
+	// CodeAPIKeyNotFound means the API key doesn't exist
+	CodeAPIKeyNotFound ErrorCode = "api_key_not_found"
+	// CodePromotionNotFound means the promotion doesn't exist
+	CodePromotionNotFound ErrorCode = "promotion_not_found"
+	// CodePromotionExists means a promotion with the same code already exists
+	CodePromotionExists ErrorCode = "promotion_exists"
+	// CodePromotionExpired means the promotion expired while the order was being
+	// created
+	CodePromotionExpired ErrorCode = "promotion_expired"
+	// CodePromotionLimitReached means the promotion was already used the maximum
+	// number of times in total or by the customer
+	CodePromotionLimitReached ErrorCode = "promotion_limit_reached"
+	// CodeInvalidTransition means the order's current status doesn't allow the
+	// requested action, like charging an order that was already charged
+	CodeInvalidTransition ErrorCode = "invalid_transition"
+	// CodePreconditionRequired means the request has to have an If-Match header
+	// with the order's current ETag
+	CodePreconditionRequired ErrorCode = "precondition_required"
+	// CodeVersionConflict means the order was changed since the ETag in the
+	// If-Match header was returned, get the order again and retry
+	CodeVersionConflict ErrorCode = "version_conflict"
+	// CodeBatchAborted means the order in an atomic batch wasn't created because
+	// a different order in the batch couldn't be
+	CodeBatchAborted ErrorCode = "batch_aborted"
+	// CodeIdempotencyKeyInUse means another request with the same
+	// Idempotency-Key is still being handled, retry once it's done
+	CodeIdempotencyKeyInUse ErrorCode = "idempotency_key_in_use"
+	// CodeIdempotencyKeyReused means the Idempotency-Key was already used for a
+	// request with a different method, path or body
+	CodeIdempotencyKeyReused ErrorCode = "idempotency_key_reused"
+	// CodeChargeDeclined means the charge service declined the card
+	CodeChargeDeclined ErrorCode = "charge_declined"
+	// CodeChargeFailed means the charge service couldn't be reached or errored
+	CodeChargeFailed ErrorCode = "charge_failed"
+	// CodeFulfillmentFailed means the fulfillment service couldn't be reached or
+	// errored
+	CodeFulfillmentFailed ErrorCode = "fulfillment_failed"
+	// CodeRateLimited means the client made too many requests, see the
+	// Retry-After header
+	CodeRateLimited ErrorCode = "rate_limited"
+	// CodeUnavailable means the service isn't ready to handle requests yet
+	CodeUnavailable ErrorCode = "unavailable"
+	// CodeInternal means something unexpected went wrong, the details are only
+	// logged and can be found with the request ID
+	CodeInternal ErrorCode = "internal_error"
+)
+
+// FieldError describes a single invalid field
+type FieldError struct {
+	// Field is the path to the field in the request body, like
+	// lineItems[0].quantity
+	Field string `json:"field"`
+	// Message describes what's wrong with the field
+	Message string `json:"message"`
+}
+
+// Error is returned by every endpoint when a request fails. It's sent as
+// {"error": {...}} so it can't be confused with a successful response.
+type Error struct {
+	// Status is the HTTP status code to respond with
+	Status int `json:"-"`
+	// Code is the stable identifier for the error
+	Code ErrorCode `json:"code"`
+	// Message is a human readable description of the error
+	Message string `json:"message"`
+	// Details lists the invalid fields when Code is CodeValidationFailed
+	Details []FieldError `json:"details,omitempty"`
+	// RequestID is the ID of the request which can be used to find it in the logs
+	RequestID string `json:"requestId,omitempty"`
+	// cause is the underlying error which is logged but never sent to the client
+	cause error
+}
+
+// newError returns an Error with the given status, code and message
+func newError(status int, code ErrorCode, message string) *Error {
+	return &Error{
+		Status:  status,
+		Code:    code,
+		Message: message,
+	}
+}
+
+// withCause sets the underlying error that caused e and returns e
+func (e *Error) withCause(err error) *Error {
+	e.cause = err
+	return e
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.cause)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// errorRes is the body of every error response
+type errorRes struct {
+	Error *Error `json:"error"`
+}
+
+// errOrderNotFound is returned when a customer tries to access someone else's
+// order so it looks the same as an order that doesn't exist
+var errOrderNotFound = newError(http.StatusNotFound, CodeOrderNotFound, "order not found")
+
+// invalidBody returns the error for a request body that couldn't be decoded
+func invalidBody(err error) *Error {
+	return newError(http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("error decoding body: %v", err)).withCause(err)
+}
+
+// validationFailed returns the error for a request with invalid fields
+func validationFailed(vs validation.Violations) *Error {
+	e := newError(http.StatusBadRequest, CodeValidationFailed, "the request has invalid fields")
+	e.Details = make([]FieldError, len(vs))
+	for i, v := range vs {
+		e.Details[i] = FieldError{Field: v.Field, Message: v.Message}
+	}
+	return e
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// toError converts any error into an *Error. Errors from storage are mapped
+// here rather than in each handler so they're always returned the same way.
+// Anything unrecognized is an internal error.
+func toError(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	var vs validation.Violations
+	if errors.As(err, &vs) {
+		return validationFailed(vs).withCause(err)
+	}
+	var transitionErr *orders.TransitionError
+	if errors.As(err, &transitionErr) {
+		return transitionError(transitionErr).withCause(err)
+	}
+	switch {
+	case errors.Is(err, storage.ErrOrderNotFound):
+		return newError(http.StatusNotFound, CodeOrderNotFound, "order not found").withCause(err)
+	case errors.Is(err, storage.ErrOrderExists):
+		return newError(http.StatusConflict, CodeOrderExists, "order already exists").withCause(err)
+	case errors.Is(err, storage.ErrOrderNotPending):
+		return newError(http.StatusConflict, CodeInvalidTransition, "order can only be changed while it's pending").withCause(err)
+	case errors.Is(err, storage.ErrAPIKeyNotFound):
+		return newError(http.StatusNotFound, CodeAPIKeyNotFound, "api key not found").withCause(err)
+	case errors.Is(err, storage.ErrPromotionNotFound):
+		return newError(http.StatusNotFound, CodePromotionNotFound, "promotion not found").withCause(err)
+	case errors.Is(err, storage.ErrPromotionExists):
+		return newError(http.StatusConflict, CodePromotionExists, "promotion already exists").withCause(err)
+	case errors.Is(err, storage.ErrPromotionExpired):
+		return newError(http.StatusConflict, CodePromotionExpired, "promotion has expired").withCause(err)
+	case errors.Is(err, storage.ErrPromotionLimitReached):
+		return newError(http.StatusConflict, CodePromotionLimitReached, "promotion can't be used any more times").withCause(err)
+	case errors.Is(err, storage.ErrVersionConflict):
+		return newError(http.StatusPreconditionFailed, CodeVersionConflict, "order was changed by another request").withCause(err)
+	case errors.Is(err, storage.ErrBatchAborted):
+		return newError(http.StatusConflict, CodeBatchAborted, "another order in the batch failed").withCause(err)
+	case errors.Is(err, orders.ErrCustomerMismatch):
+		return newError(http.StatusForbidden, CodeForbidden, orders.ErrCustomerMismatch.Error()).withCause(err)
+	case errors.Is(err, orders.ErrInvalidLimit):
+		return newError(http.StatusBadRequest, CodeInvalidRequest, orders.ErrInvalidLimit.Error()).withCause(err)
+	case errors.Is(err, orders.ErrChargeNotRecorded):
+		return newError(http.StatusConflict, CodeInvalidTransition, "order has no recorded charge to refund").withCause(err)
+	case errors.Is(err, orders.ErrNegativeTotal):
+		return newError(http.StatusConflict, CodeInvalidTransition, orders.ErrNegativeTotal.Error()).withCause(err)
+	case errors.Is(err, services.ErrChargeDeclined):
+		return newError(http.StatusPaymentRequired, CodeChargeDeclined, "the card was declined").withCause(err)
+	case errors.Is(err, services.ErrChargeFailed):
+		return newError(http.StatusInternalServerError, CodeChargeFailed, "error charging order").withCause(err)
+	case errors.Is(err, services.ErrFulfillmentFailed):
+		return newError(http.StatusInternalServerError, CodeFulfillmentFailed, "error fulfilling order").withCause(err)
+	case errors.Is(err, storage.ErrSchemaNotReady):
+		return newError(http.StatusServiceUnavailable, CodeUnavailable, "service is not ready").withCause(err)
+	default:
+		return newError(http.StatusInternalServerError, CodeInternal, "internal error").withCause(err)
+	}
+}
+
+// transitionError returns the error for an action that the order's status
+// doesn't allow. Fulfilling has always responded with a 400 and the other
+// actions with a 409. Orders that another request is in the middle of changing
+// are always a 409 since retrying might work once that request is done.
+func transitionError(err *orders.TransitionError) *Error {
+	if err.Status.InProgress() {
+		return newError(http.StatusConflict, CodeInvalidTransition, fmt.Sprintf("order is %s in another request", err.Status))
+	}
+	switch err.Action {
+	case orders.ActionCharge:
+		return newError(http.StatusConflict, CodeInvalidTransition, "order ineligible for charging")
+	case orders.ActionCancel:
+		return newError(http.StatusConflict, CodeInvalidTransition, "order has already been fulfilled")
+	case orders.ActionFulfill:
+		return newError(http.StatusBadRequest, CodeInvalidTransition, "order cannot be fulfilled, order has not been charged")
+	case orders.ActionEdit:
+		return newError(http.StatusConflict, CodeInvalidTransition, "order can only be edited while it's pending")
+	case orders.ActionSetShipping:
+		return newError(http.StatusConflict, CodeInvalidTransition, "shipping can only be changed while the order is pending")
+	default:
+		return newError(http.StatusConflict, CodeInvalidTransition, err.Error())
+	}
+}
+
+// respondError aborts the request and writes err as an error response
+func respondError(c *gin.Context, err error) {
+	apiErr := requestError(c, err)
+	c.AbortWithStatusJSON(apiErr.Status, errorRes{Error: apiErr})
+}
+
+// requestError converts err into an *Error for the request, with its request ID
+// set. Server errors are logged along with their cause since the client only
+// sees a generic message.
+func requestError(c *gin.Context, err error) *Error {
+	// copy the error so that setting the request ID doesn't modify an error that
+	// might be shared
+	apiErr := *toError(err)
+	apiErr.RequestID = requestIDFromContext(c)
+	if apiErr.Status >= http.StatusInternalServerError {
+		llog.Error("error handling request", llog.ErrKV(err), llog.KV{
+			"requestID": apiErr.RequestID,
+			"path":      c.FullPath(),
+			"code":      apiErr.Code,
+		})
+	}
+	return &apiErr
+}
+
+// notFound is used for any route that doesn't exist
+func notFound(c *gin.Context) {
+	respondError(c, newError(http.StatusNotFound, CodeNotFound, "no such endpoint"))
+}
+
+// recovered responds with an internal error when a handler panics
+func recovered(c *gin.Context, v interface{}) {
+	respondError(c, fmt.Errorf("panic: %v", v))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// requestIDKey is the key the request ID is stored under in the gin.Context
+const requestIDKey = "requestID"
+
+// requestIDHeader is the header the request ID is read from and written to
+const requestIDHeader = "X-Request-ID"
+
+// requestID is a middleware that assigns every request an ID that's returned in
+// the X-Request-ID header and in error responses. If the caller, like a load
+// balancer, already set an ID then it's reused so the request can be followed
+// across services.
+func requestID(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	c.Set(requestIDKey, id)
+	c.Header(requestIDHeader, id)
+	c.Next()
+}
+
+// requestIDFromContext returns the ID of the request or an empty string if the
+// requestID middleware didn't run
+func requestIDFromContext(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// validRequestID limits IDs from callers to a reasonable length of printable
+// characters since they end up in logs and headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 128-bit hex-encoded ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	// crypto/rand only fails if the OS can't provide randomness at which point
+	// there isn't much else we can do
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("error generating request ID: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/services"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeError decodes an error response body
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) Error {
+	var res struct {
+		Error Error `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	return res.Error
+}
+
+func TestToError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   ErrorCode
+	}{
+		{fmt.Errorf("wrapped: %w", storage.ErrOrderNotFound), http.StatusNotFound, CodeOrderNotFound},
+		{storage.ErrOrderExists, http.StatusConflict, CodeOrderExists},
+		{storage.ErrAPIKeyNotFound, http.StatusNotFound, CodeAPIKeyNotFound},
+		{storage.ErrBatchAborted, http.StatusConflict, CodeBatchAborted},
+		{storage.ErrSchemaNotReady, http.StatusServiceUnavailable, CodeUnavailable},
+		{errors.New("boom"), http.StatusInternalServerError, CodeInternal},
+		{fmt.Errorf("wrapped: %w", newError(http.StatusPaymentRequired, CodeChargeDeclined, "declined")), http.StatusPaymentRequired, CodeChargeDeclined},
+	}
+	for _, test := range tests {
+		apiErr := toError(test.err)
+		assert.Equal(t, test.status, apiErr.Status, test.err.Error())
+		assert.Equal(t, test.code, apiErr.Code, test.err.Error())
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	// the message is formatted and the request ID is returned
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/orders?status=bogus", nil)
+		r.Header.Set("X-Request-ID", "abc-123")
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "abc-123", w.Header().Get("X-Request-ID"))
+		assert.Equal(t, Error{
+			Code:      CodeInvalidRequest,
+			Message:   "unknown value for status: bogus",
+			RequestID: "abc-123",
+		}, decodeError(t, w))
+		stor.AssertExpectations(t)
+	}
+
+	// storage errors are mapped and internal details aren't leaked
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "missing").Return(storage.Order{}, storage.ErrOrderNotFound).Once()
+		stor.On("GetOrder", mock.Anything, "broken").Return(storage.Order{}, errors.New("connection reset")).Once()
+		h := Handler(stor, nil, nil)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/orders/missing", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		res := decodeError(t, w)
+		assert.Equal(t, CodeOrderNotFound, res.Code)
+		// an ID is generated if the caller didn't send one
+		assert.Len(t, res.RequestID, 32)
+		assert.Equal(t, res.RequestID, w.Header().Get("X-Request-ID"))
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/orders/broken", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		res = decodeError(t, w)
+		assert.Equal(t, CodeInternal, res.Code)
+		assert.NotContains(t, res.Message, "connection reset")
+		stor.AssertExpectations(t)
+	}
+
+	// validation failures list every invalid field
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/orders", bytes.NewReader([]byte(`{"customerEmail":"nope"}`)))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		res := decodeError(t, w)
+		assert.Equal(t, CodeValidationFailed, res.Code)
+		assert.Equal(t, []FieldError{
+			{Field: "customerEmail", Message: "must be an email address"},
+			{Field: "lineItems", Message: "an order must contain at least one line item"},
+		}, res.Details)
+		stor.AssertExpectations(t)
+	}
+
+	// a declined card is reported as such and the order is released back to
+	// pending so it can be charged again
+	{
+		chgServ := mocks.NewMockedService(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusPaymentRequired)
+		}))
+		order := storage.Order{
+			ID:            "test",
+			CustomerEmail: "test@test",
+			LineItems:     []storage.LineItem{{Description: "item 1", Quantity: 1, PriceCents: 100}},
+			Status:        storage.OrderStatusPending,
+		}
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, order.ID).Return(order, nil).Once()
+		stor.On("SetOrderStatus", mock.Anything, order.ID, storage.OrderStatusCharging, int64(0)).Return(nil).Once()
+		stor.On("SetOrderStatus", mock.Anything, order.ID, storage.OrderStatusPending, int64(1)).Return(nil).Once()
+		h := Handler(stor, nil, services.NewHTTPChargeService(chgServ))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/orders/test/charge", bytes.NewReader([]byte(`{"cardToken":"amex"}`)))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusPaymentRequired, w.Code)
+		assert.Equal(t, CodeChargeDeclined, decodeError(t, w).Code)
+		stor.AssertExpectations(t)
+	}
+
+	// unknown routes use the same envelope
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/nope", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, CodeNotFound, decodeError(t, w).Code)
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levenlabs/order-up/storage"
+)
+
+// errVersionConflict is returned when the If-Match header doesn't match the
+// order's current version
+var errVersionConflict = newError(http.StatusPreconditionFailed, CodeVersionConflict, "order was changed by another request")
+
+// orderETag returns the ETag for the given order version. It's a strong ETag
+// since the version changes whenever anything in the order changes.
+func orderETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// etagMatches returns true if header, the value of an If-Match or
+// If-None-Match header, contains etag or is *. If-None-Match uses the weak
+// comparison which ignores the W/ prefix while If-Match uses the strong one.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if weak {
+			part = strings.TrimPrefix(part, "W/")
+		}
+		if part == "*" || part == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch returns an error if the request has an If-Match header that
+// doesn't match the order's current version. Requests without the header are
+// allowed through but storage still rejects the write if the order changes
+// after it was loaded.
+func checkIfMatch(c *gin.Context, order storage.Order) error {
+	if h := c.GetHeader("If-Match"); h != "" && !etagMatches(h, orderETag(order.Version), false) {
+		return errVersionConflict
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/services"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestETagMatches(t *testing.T) {
+	assert.True(t, etagMatches(`"3"`, `"3"`, false))
+	assert.True(t, etagMatches(`"1", "3"`, `"3"`, false))
+	assert.True(t, etagMatches(`*`, `"3"`, false))
+	assert.False(t, etagMatches(`"4"`, `"3"`, false))
+	// weak ETags only match with the weak comparison
+	assert.False(t, etagMatches(`W/"3"`, `"3"`, false))
+	assert.True(t, etagMatches(`W/"3"`, `"3"`, true))
+}
+
+func TestGetOrderETag(t *testing.T) {
+	order := storage.Order{
+		ID:            "order1",
+		CustomerEmail: "test@test",
+		LineItems:     []storage.LineItem{{Description: "item 1", Quantity: 1, PriceCents: 100}},
+		Status:        storage.OrderStatusPending,
+		Version:       2,
+	}
+	stor := new(mocks.MockStorageInstance)
+	stor.On("GetOrder", mock.Anything, order.ID).Return(order, nil).Times(3)
+	h := Handler(stor, nil, nil)
+	getOrder := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/orders/order1", nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// the ETag is the order's version
+	{
+		w := getOrder("")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	}
+
+	// the order isn't sent again if the client already has it
+	{
+		w := getOrder(`W/"2"`)
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Empty(t, w.Body.Bytes())
+		assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	}
+
+	// an old version gets the whole order
+	{
+		w := getOrder(`"1"`)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	stor.AssertExpectations(t)
+}
+
+func TestChargeOrderVersions(t *testing.T) {
+	order := storage.Order{
+		ID:            "order1",
+		CustomerEmail: "test@test",
+		Currency:      storage.DefaultCurrency,
+		LineItems:     []storage.LineItem{{Description: "item 1", Quantity: 1, PriceCents: 100}},
+		Status:        storage.OrderStatusPending,
+		Version:       2,
+	}
+
+	// charges holds the amount of every request to the charge service
+	var chargesL sync.Mutex
+	var charges []int64
+	chgServ := mocks.NewMockedService(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var args services.ChargeArgs
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&args))
+		charge := services.Charge{ID: "ch_1", AmountCents: args.AmountCents}
+		// refunds are sent to the charge with a positive amount but they take
+		// money back
+		if r.URL.Path == "/charges/ch_1/refunds" {
+			charge = services.Charge{ID: "re_1", AmountCents: -args.AmountCents}
+		}
+		chargesL.Lock()
+		charges = append(charges, charge.AmountCents)
+		chargesL.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(charge)
+	}))
+	chargeOrder := func(h http.Handler, ifMatch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/orders/order1/charge", strings.NewReader(`{"cardToken": "amex"}`))
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// a matching If-Match charges the order and returns the new ETag
+	{
+		charges = nil
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, order.ID).Return(order, nil).Once()
+		stor.On("SetOrderStatus", mock.Anything, order.ID, storage.OrderStatusCharging, int64(2)).Return(nil).Once()
+		stor.On("AddOrderPayment", mock.Anything, order.ID, mock.Anything).Return(nil).Once()
+		stor.On("SetOrderStatus", mock.Anything, order.ID, storage.OrderStatusCharged, int64(4)).Return(nil).Once()
+		h := Handler(stor, nil, services.NewHTTPChargeService(chgServ))
+		w := chargeOrder(h, `"2"`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		// marking the order as charging, recording the charge and marking the
+		// order as charged are three changes
+		assert.Equal(t, `"5"`, w.Header().Get("ETag"))
+		assert.Equal(t, []int64{100}, charges)
+		stor.AssertExpectations(t)
+	}
+
+	// an old If-Match doesn't charge anything
+	{
+		charges = nil
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, order.ID).Return(order, nil).Once()
+		h := Handler(stor, nil, services.NewHTTPChargeService(chgServ))
+		w := chargeOrder(h, `"1"`)
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		assert.Equal(t, CodeVersionConflict, decodeError(t, w).Code)
+		assert.Empty(t, charges)
+		stor.AssertExpectations(t)
+	}
+
+	// if the order changes before it's marked as charging then nothing is
+	// charged
+	{
+		charges = nil
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, order.ID).Return(order, nil).Once()
+		stor.On("SetOrderStatus", mock.Anything, order.ID, storage.OrderStatusCharging, int64(2)).Return(storage.ErrVersionConflict).Once()
+		h := Handler(stor, nil, services.NewHTTPChargeService(chgServ))
+		w := chargeOrder(h, "")
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		assert.Equal(t, CodeVersionConflict, decodeError(t, w).Code)
+		assert.Empty(t, charges)
+		stor.AssertExpectations(t)
+	}
+
+	// if the order changes while it's being charged then the charge is refunded
+	{
+		charges = nil
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, order.ID).Return(order, nil).Once()
+		stor.On("SetOrderStatus", mock.Anything, order.ID, storage.OrderStatusCharging, int64(2)).Return(nil).Once()
+		// both the charge and its refund are recorded
+		stor.On("AddOrderPayment", mock.Anything, order.ID, mock.Anything).Return(nil).Twice()
+		stor.On("SetOrderStatus", mock.Anything, order.ID, storage.OrderStatusCharged, int64(4)).Return(storage.ErrVersionConflict).Once()
+		h := Handler(stor, nil, services.NewHTTPChargeService(chgServ))
+		w := chargeOrder(h, "")
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		assert.Equal(t, CodeVersionConflict, decodeError(t, w).Code)
+		assert.Equal(t, []int64{100, -100}, charges)
+		stor.AssertExpectations(t)
+	}
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levenlabs/go-llog"
+	"github.com/levenlabs/order-up/storage"
+)
+
+// exportStatusTrailer is the trailer that says whether an export finished. The
+// status code is sent before the first order so a failure halfway through can
+// only be reported after the body.
+const exportStatusTrailer = "X-Export-Status"
+
+// orderTotals is an order's total broken down by the kinds of its line items,
+// all in the minor unit of the order's currency
+type orderTotals struct {
+	ProductsCents int64 `json:"productsCents"`
+	DiscountCents int64 `json:"discountCents"`
+	ShippingCents int64 `json:"shippingCents"`
+	TaxCents      int64 `json:"taxCents"`
+	TotalCents    int64 `json:"totalCents"`
+}
+
+// newOrderTotals adds up the line items of order by their kind
+func newOrderTotals(order storage.Order) orderTotals {
+	var t orderTotals
+	for _, li := range order.LineItems {
+		amount := li.PriceCents * li.Quantity
+		switch li.Kind {
+		case storage.LineItemKindDiscount:
+			t.DiscountCents += amount
+		case storage.LineItemKindShipping:
+			t.ShippingCents += amount
+		case storage.LineItemKindTax:
+			t.TaxCents += amount
+		default:
+			t.ProductsCents += amount
+		}
+	}
+	t.TotalCents = order.TotalCents()
+	return t
+}
+
+// orderExporter writes orders in one of the export formats
+type orderExporter interface {
+	// Write writes a single order
+	Write(order storage.Order) error
+	// Flush writes anything that's buffered
+	Flush() error
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// csvOrderColumns are the order-level columns of the CSV export which are
+// repeated on every line item's row
+var csvOrderColumns = []string{"orderId", "customerEmail", "status", "currency", "jurisdiction", "shippingMethod", "version"}
+
+// csvTotalsColumns are the columns added when totals are exported
+var csvTotalsColumns = []string{"productsCents", "discountCents", "shippingCents", "taxCents", "totalCents"}
+
+// csvLineItemColumns are the columns of each line item
+var csvLineItemColumns = []string{"lineItem", "description", "kind", "taxCategory", "quantity", "priceCents", "amountCents"}
+
+// csvExporter writes one row per line item with the order's columns repeated on
+// each. Orders without line items still get a row with empty line item columns.
+type csvExporter struct {
+	w      *csv.Writer
+	totals bool
+	// statusNames is set when the status column has the status's name instead
+	// of its integer, which is only for v2 and later
+	statusNames bool
+}
+
+// newCSVExporter writes the header row and returns a csvExporter
+func newCSVExporter(w io.Writer, totals, statusNames bool) (*csvExporter, error) {
+	e := &csvExporter{w: csv.NewWriter(w), totals: totals, statusNames: statusNames}
+	header := append([]string{}, csvOrderColumns...)
+	if totals {
+		header = append(header, csvTotalsColumns...)
+	}
+	header = append(header, csvLineItemColumns...)
+	if err := e.w.Write(header); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Write implements the orderExporter interface
+func (e *csvExporter) Write(order storage.Order) error {
+	status := strconv.FormatInt(int64(order.Status), 10)
+	if e.statusNames {
+		status = order.Status.String()
+	}
+	row := []string{
+		csvText(order.ID),
+		csvText(order.CustomerEmail),
+		status,
+		string(order.Currency),
+		csvText(order.Jurisdiction),
+		csvText(order.ShippingMethod),
+		strconv.FormatInt(order.Version, 10),
+	}
+	if e.totals {
+		t := newOrderTotals(order)
+		for _, cents := range []int64{t.ProductsCents, t.DiscountCents, t.ShippingCents, t.TaxCents, t.TotalCents} {
+			row = append(row, strconv.FormatInt(cents, 10))
+		}
+	}
+	if len(order.LineItems) == 0 {
+		return e.w.Write(append(row, make([]string, len(csvLineItemColumns))...))
+	}
+	for idx, li := range order.LineItems {
+		// the order's columns are shared by every row so each row gets a copy
+		liRow := append(row[:len(row):len(row)],
+			strconv.Itoa(idx),
+			csvText(li.Description),
+			string(li.Kind),
+			csvText(li.TaxCategory),
+			strconv.FormatInt(li.Quantity, 10),
+			strconv.FormatInt(li.PriceCents, 10),
+			strconv.FormatInt(li.PriceCents*li.Quantity, 10),
+		)
+		if err := e.w.Write(liRow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush implements the orderExporter interface
+func (e *csvExporter) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvText prefixes text that a spreadsheet would treat as a formula with a '
+// so that something like a line item's description can't run in finance's
+// spreadsheet
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ndjsonOrder is a line of the NDJSON export
+type ndjsonOrder struct {
+	storage.Order
+	Totals *orderTotals `json:"totals,omitempty"`
+}
+
+// v1NDJSONOrder is a line of the NDJSON export in v1, with integer statuses
+type v1NDJSONOrder struct {
+	v1Order
+	Totals *orderTotals `json:"totals,omitempty"`
+}
+
+// ndjsonExporter writes each order as JSON on its own line
+type ndjsonExporter struct {
+	enc     *json.Encoder
+	totals  bool
+	version apiVersion
+}
+
+// Write implements the orderExporter interface
+func (e *ndjsonExporter) Write(order storage.Order) error {
+	var totals *orderTotals
+	if e.totals {
+		t := newOrderTotals(order)
+		totals = &t
+	}
+	// Encode adds the newline after every order
+	if e.version < apiVersion2 {
+		return e.enc.Encode(v1NDJSONOrder{v1Order: newV1Order(order), Totals: totals})
+	}
+	return e.enc.Encode(ndjsonOrder{Order: order, Totals: totals})
+}
+
+// Flush implements the orderExporter interface. The encoder writes every order
+// as it's encoded so there's nothing to flush.
+func (e *ndjsonExporter) Flush() error {
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// exportOrders is called by incoming HTTP GET requests to /orders/export. It
+// takes the same filters as GET /orders and streams the orders as they're read
+// from storage so any number of orders can be exported without holding them
+// all in memory.
+func (i *instance) exportOrders(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	filter, err := orderFilter(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	totals := false
+	if q := c.Query("totals"); q != "" {
+		totals, err = strconv.ParseBool(q)
+		if err != nil {
+			respondError(c, newError(http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("unknown value for totals: %v", q)))
+			return
+		}
+	}
+
+	// v1 exports statuses as integers like v1 responses do
+	version := apiVersionFromContext(c)
+	var contentType string
+	var newExporter func(w io.Writer) (orderExporter, error)
+	format := c.DefaultQuery("format", "csv")
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+		newExporter = func(w io.Writer) (orderExporter, error) {
+			return newCSVExporter(w, totals, version >= apiVersion2)
+		}
+	case "ndjson":
+		contentType = "application/x-ndjson"
+		newExporter = func(w io.Writer) (orderExporter, error) {
+			return &ndjsonExporter{enc: json.NewEncoder(w), totals: totals, version: version}, nil
+		}
+	default:
+		respondError(c, newError(http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("unknown value for format: %v", format)))
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="orders.%s"`, format))
+	c.Header("Trailer", exportStatusTrailer)
+	c.Status(http.StatusOK)
+	exporter, err := newExporter(c.Writer)
+	if err == nil {
+		err = i.stor.EachOrder(ctx, filter, func(order storage.Order) error {
+			if err := exporter.Write(order); err != nil {
+				return err
+			}
+			// flush every order so the client gets the rows as they're read rather
+			// than buffering them here
+			if err := exporter.Flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+			return nil
+		})
+	}
+	if err == nil {
+		err = exporter.Flush()
+	}
+
+	// if nothing was sent yet then it's not too late for a regular error response
+	if err != nil && !c.Writer.Written() {
+		for _, h := range []string{"Content-Type", "Content-Disposition", "Trailer"} {
+			c.Writer.Header().Del(h)
+		}
+		respondError(c, fmt.Errorf("error exporting orders: %w", err))
+		return
+	}
+	// otherwise the status was already sent so the trailer is the only way to
+	// tell the client that the export is incomplete
+	if err != nil {
+		llog.Error("error exporting orders", llog.ErrKV(err), llog.KV{
+			"requestID": requestIDFromContext(c),
+		})
+		c.Writer.Header().Set(exportStatusTrailer, "error")
+		return
+	}
+	c.Writer.Header().Set(exportStatusTrailer, "complete")
+}
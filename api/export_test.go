@@ -0,0 +1,159 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/orders"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportOrders(t *testing.T) {
+	orders := []storage.Order{
+		{
+			ID:            "order1",
+			CustomerEmail: "test@test",
+			Currency:      storage.DefaultCurrency,
+			LineItems: []storage.LineItem{
+				{Description: "=HYPERLINK(\"x\")", Quantity: 2, PriceCents: 1000},
+				{Description: "Promotion SUMMER10", Quantity: 1, PriceCents: -200, Kind: storage.LineItemKindDiscount},
+				{Description: "Standard", Quantity: 1, PriceCents: 500, TaxCategory: orders.ShippingTaxCategory, Kind: storage.LineItemKindShipping},
+				{Description: "Tax", Quantity: 1, PriceCents: 100, Kind: storage.LineItemKindTax},
+			},
+			Jurisdiction:   "US-CA",
+			ShippingMethod: "standard",
+			Status:         storage.OrderStatusCharged,
+			Version:        2,
+		},
+		{
+			ID:            "order2",
+			CustomerEmail: "test@test",
+			Currency:      "EUR",
+			Version:       1,
+		},
+	}
+	// eachOrder makes the mocked EachOrder call fn with every order
+	eachOrder := func(orders []storage.Order) func(mock.Arguments) {
+		return func(args mock.Arguments) {
+			fn := args.Get(2).(func(storage.Order) error)
+			for _, order := range orders {
+				if err := fn(order); err != nil {
+					return
+				}
+			}
+		}
+	}
+	export := func(h http.Handler, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/orders/export"+query, nil))
+		return w
+	}
+
+	// CSV has a row per line item with the order's columns repeated
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("EachOrder", mock.Anything, storage.OrderFilter{Status: storage.OrderStatusCharged}, mock.Anything).
+			Run(eachOrder(orders[:1])).Return(nil).Once()
+		h := Handler(stor, nil, nil)
+		w := export(h, "?status=charged")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, "complete", w.Result().Trailer.Get(exportStatusTrailer))
+		rows, err := csv.NewReader(w.Body).ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{
+			{"orderId", "customerEmail", "status", "currency", "jurisdiction", "shippingMethod", "version", "lineItem", "description", "kind", "taxCategory", "quantity", "priceCents", "amountCents"},
+			{"order1", "test@test", "1", "USD", "US-CA", "standard", "2", "0", "'=HYPERLINK(\"x\")", "", "", "2", "1000", "2000"},
+			{"order1", "test@test", "1", "USD", "US-CA", "standard", "2", "1", "Promotion SUMMER10", "discount", "", "1", "-200", "-200"},
+			{"order1", "test@test", "1", "USD", "US-CA", "standard", "2", "2", "Standard", "shipping", "shipping", "1", "500", "500"},
+			{"order1", "test@test", "1", "USD", "US-CA", "standard", "2", "3", "Tax", "tax", "", "1", "100", "100"},
+		}, rows)
+		stor.AssertExpectations(t)
+	}
+
+	// totals are flattened into columns and orders without line items get a row
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("EachOrder", mock.Anything, storage.OrderFilter{Status: storage.OrderStatusAny}, mock.Anything).
+			Run(eachOrder(orders)).Return(nil).Once()
+		h := Handler(stor, nil, nil)
+		w := export(h, "?format=csv&totals=true")
+		require.Equal(t, http.StatusOK, w.Code)
+		rows, err := csv.NewReader(w.Body).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, rows, 6)
+		assert.Equal(t, []string{"productsCents", "discountCents", "shippingCents", "taxCents", "totalCents"}, rows[0][7:12])
+		assert.Equal(t, []string{"2000", "-200", "500", "100", "2400"}, rows[1][7:12])
+		assert.Equal(t, []string{"order2", "test@test", "0", "EUR", "", "", "1", "0", "0", "0", "0", "0", "", "", "", "", "", "", ""}, rows[5])
+		stor.AssertExpectations(t)
+	}
+
+	// NDJSON has an order per line
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("EachOrder", mock.Anything, storage.OrderFilter{Status: storage.OrderStatusAny}, mock.Anything).
+			Run(eachOrder(orders)).Return(nil).Once()
+		h := Handler(stor, nil, nil)
+		w := export(h, "?format=ndjson&totals=1")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+		scanner := bufio.NewScanner(w.Body)
+		var lines []ndjsonOrder
+		for scanner.Scan() {
+			var line ndjsonOrder
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+			lines = append(lines, line)
+		}
+		require.Len(t, lines, 2)
+		assert.Equal(t, orders[0], lines[0].Order)
+		if assert.NotNil(t, lines[0].Totals) {
+			assert.EqualValues(t, 2400, lines[0].Totals.TotalCents)
+		}
+		assert.Equal(t, orders[1], lines[1].Order)
+		stor.AssertExpectations(t)
+	}
+
+	// errors before anything was sent get a regular error response
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("EachOrder", mock.Anything, mock.Anything, mock.Anything).Return(assert.AnError).Once()
+		h := Handler(stor, nil, nil)
+		w := export(h, "")
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, CodeInternal, decodeError(t, w).Code)
+		assert.Empty(t, w.Header().Get("Content-Disposition"))
+		stor.AssertExpectations(t)
+	}
+
+	// errors after the first order are reported in the trailer
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("EachOrder", mock.Anything, mock.Anything, mock.Anything).
+			Run(eachOrder(orders[:1])).Return(assert.AnError).Once()
+		h := Handler(stor, nil, nil)
+		w := export(h, "?format=ndjson")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "error", w.Result().Trailer.Get(exportStatusTrailer))
+		stor.AssertExpectations(t)
+	}
+
+	// the query parameters are validated
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil)
+		for _, query := range []string{"?format=xml", "?totals=maybe", "?status=bogus"} {
+			w := export(h, query)
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+			assert.Equal(t, CodeInvalidRequest, decodeError(t, w).Code, query)
+		}
+		stor.AssertExpectations(t)
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessCheckTimeout is how long each individual dependency has to respond
+// before it's considered unreachable
+const readinessCheckTimeout = 2 * time.Second
+
+// Readiness tracks whether the process wants to receive traffic. The zero value
+// is ready. main flips it to not ready right before draining so the orchestrator
+// stops sending new requests while in-flight requests finish.
+type Readiness struct {
+	// notReady is an int32 rather than a bool so it can be safely read and
+	// written from multiple goroutines with the atomic package
+	notReady int32
+}
+
+// SetReady marks the process as ready or not ready to receive traffic
+func (r *Readiness) SetReady(ready bool) {
+	if ready {
+		atomic.StoreInt32(&r.notReady, 0)
+	} else {
+		atomic.StoreInt32(&r.notReady, 1)
+	}
+}
+
+// Ready returns true if the process wants to receive traffic
+func (r *Readiness) Ready() bool {
+	return atomic.LoadInt32(&r.notReady) == 0
+}
+
+// WithReadiness sets the Readiness that's reported by the /readyz endpoint
+func WithReadiness(r *Readiness) Option {
+	return func(i *instance) {
+		i.readiness = r
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	checkStatusOK            = "ok"
+	checkStatusError         = "error"
+	checkStatusNotConfigured = "not configured"
+)
+
+// healthCheck is the result of checking a single dependency
+type healthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// healthRes is the result of the GET /healthz and GET /readyz handlers
+type healthRes struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks"`
+}
+
+// getHealthz is called by incoming HTTP GET requests to /healthz
+// it only reports that the process is up and able to serve HTTP requests so it
+// doesn't check any dependencies, otherwise a database outage would cause the
+// orchestrator to restart every replica
+func (i *instance) getHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, healthRes{
+		Status: checkStatusOK,
+		Checks: map[string]healthCheck{
+			"process": {Status: checkStatusOK},
+		},
+	})
+}
+
+// getReadyz is called by incoming HTTP GET requests to /readyz
+func (i *instance) getReadyz(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	checks := map[string]func(context.Context) error{
+		"storage": i.stor.Ping,
+	}
+	// the services are nil in tests that don't need them so we only check the
+	// ones that were actually configured and can be pinged, which the HTTP ones
+	// can
+	if p, ok := i.chargeService.(pinger); ok {
+		checks["chargeService"] = p.Ping
+	}
+	if p, ok := i.fulfillmentService.(pinger); ok {
+		checks["fulfillmentService"] = p.Ping
+	}
+
+	res := healthRes{
+		Status: checkStatusOK,
+		Checks: make(map[string]healthCheck, len(checks)+3),
+	}
+	if i.chargeService == nil {
+		res.Checks["chargeService"] = healthCheck{Status: checkStatusNotConfigured}
+	}
+	if i.fulfillmentService == nil {
+		res.Checks["fulfillmentService"] = healthCheck{Status: checkStatusNotConfigured}
+	}
+	if i.readiness.Ready() {
+		res.Checks["lifecycle"] = healthCheck{Status: checkStatusOK}
+	} else {
+		res.Status = checkStatusError
+		res.Checks["lifecycle"] = healthCheck{Status: checkStatusError, Error: "shutting down"}
+	}
+
+	// run all of the checks concurrently so the slowest dependency determines how
+	// long this takes rather than the sum of all of them
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+			defer cancel()
+			err := check(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				res.Status = checkStatusError
+				res.Checks[name] = healthCheck{Status: checkStatusError, Error: err.Error()}
+			} else {
+				res.Checks[name] = healthCheck{Status: checkStatusOK}
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	if res.Status != checkStatusOK {
+		c.JSON(http.StatusServiceUnavailable, res)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// pinger is implemented by services that can check whether they're reachable,
+// like *services.HTTPChargeService
+type pinger interface {
+	Ping(ctx context.Context) error
+}
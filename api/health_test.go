@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestGetHealthz(t *testing.T) {
+	// healthz shouldn't touch any dependencies so we don't set up any expected
+	// calls on the storage mock
+	stor := new(mocks.MockStorageInstance)
+	h := Handler(stor, nil, nil)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/healthz", nil)
+	h.ServeHTTP(w, r)
+	if assert.Equal(t, http.StatusOK, w.Code) {
+		var res healthRes
+		err := json.Unmarshal(w.Body.Bytes(), &res)
+		require.NoError(t, err)
+		assert.Equal(t, checkStatusOK, res.Status)
+		assert.Equal(t, checkStatusOK, res.Checks["process"].Status)
+	}
+	stor.AssertExpectations(t)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestGetReadyz(t *testing.T) {
+	// a service that answers anything is considered reachable
+	reachable := mocks.NewMockedService(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/healthz", r.URL.Path)
+		w.WriteHeader(http.StatusNotImplemented)
+	}))
+
+	// should be ready when all dependencies are reachable
+	{
+		stor := new(mocks.MockStorageInstance)
+		// the checks run with a timeout so the context won't be the request's
+		stor.On("Ping", mock.Anything).Return(nil).Once()
+		h := Handler(stor, services.NewHTTPFulfillmentService(reachable), services.NewHTTPChargeService(reachable))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/readyz", nil)
+		h.ServeHTTP(w, r)
+		if assert.Equal(t, http.StatusOK, w.Code) {
+			var res healthRes
+			err := json.Unmarshal(w.Body.Bytes(), &res)
+			require.NoError(t, err)
+			assert.Equal(t, checkStatusOK, res.Status)
+			assert.Equal(t, checkStatusOK, res.Checks["storage"].Status)
+			assert.Equal(t, checkStatusOK, res.Checks["chargeService"].Status)
+			assert.Equal(t, checkStatusOK, res.Checks["fulfillmentService"].Status)
+			assert.Equal(t, checkStatusOK, res.Checks["lifecycle"].Status)
+		}
+		stor.AssertExpectations(t)
+	}
+
+	// should not be ready when storage is unreachable
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("Ping", mock.Anything).Return(errors.New("connection refused")).Once()
+		h := Handler(stor, nil, nil)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/readyz", nil)
+		h.ServeHTTP(w, r)
+		if assert.Equal(t, http.StatusServiceUnavailable, w.Code) {
+			var res healthRes
+			err := json.Unmarshal(w.Body.Bytes(), &res)
+			require.NoError(t, err)
+			assert.Equal(t, checkStatusError, res.Status)
+			assert.Equal(t, checkStatusError, res.Checks["storage"].Status)
+			assert.Equal(t, "connection refused", res.Checks["storage"].Error)
+			assert.Equal(t, checkStatusNotConfigured, res.Checks["chargeService"].Status)
+		}
+		stor.AssertExpectations(t)
+	}
+
+	// should not be ready when a downstream service is unreachable
+	{
+		// the requests use relative URLs which a plain *http.Client can never dial
+		// so this simulates a service we can't reach
+		unreachable := new(http.Client)
+		stor := new(mocks.MockStorageInstance)
+		stor.On("Ping", mock.Anything).Return(nil).Once()
+		h := Handler(stor, services.NewHTTPFulfillmentService(reachable), services.NewHTTPChargeService(unreachable))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/readyz", nil)
+		h.ServeHTTP(w, r)
+		if assert.Equal(t, http.StatusServiceUnavailable, w.Code) {
+			var res healthRes
+			err := json.Unmarshal(w.Body.Bytes(), &res)
+			require.NoError(t, err)
+			assert.Equal(t, checkStatusError, res.Checks["chargeService"].Status)
+			assert.Equal(t, checkStatusOK, res.Checks["fulfillmentService"].Status)
+		}
+		stor.AssertExpectations(t)
+	}
+
+	// should not be ready while shutting down
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("Ping", mock.Anything).Return(nil).Once()
+		readiness := new(Readiness)
+		readiness.SetReady(false)
+		h := Handler(stor, nil, nil, WithReadiness(readiness))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/readyz", nil)
+		h.ServeHTTP(w, r)
+		if assert.Equal(t, http.StatusServiceUnavailable, w.Code) {
+			var res healthRes
+			err := json.Unmarshal(w.Body.Bytes(), &res)
+			require.NoError(t, err)
+			assert.Equal(t, checkStatusError, res.Checks["lifecycle"].Status)
+		}
+		stor.AssertExpectations(t)
+	}
+}
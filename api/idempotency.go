@@ -0,0 +1,182 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levenlabs/go-llog"
+	"github.com/levenlabs/order-up/storage"
+)
+
+// IdempotencyKeyHeader is the request header that makes retrying a write safe.
+// A request with the same key, from the same caller, gets the response to the
+// first request instead of being handled again.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader is set to true on responses that were saved from an
+// earlier request with the same Idempotency-Key
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// idempotencyTTL is how long the response to a request with an Idempotency-Key
+// is kept for retries
+const idempotencyTTL = 24 * time.Hour
+
+// maxIdempotencyKeyLength is the longest Idempotency-Key that's accepted
+const maxIdempotencyKeyLength = 255
+
+// replayedHeaders are the response headers that are saved and sent again when a
+// response is replayed
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// captureWriter records the response body as it's written so it can be saved
+type captureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Write implements the http.ResponseWriter interface
+func (w *captureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// WriteString implements the io.StringWriter interface which gin uses for some
+// responses
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotent saves the response to requests that have an Idempotency-Key header
+// and replays it for any later request with the same key. Requests without the
+// header are handled normally. Server errors and panics aren't saved so the
+// request can be retried with the same key.
+func (i *instance) idempotent(c *gin.Context) {
+	key := c.GetHeader(IdempotencyKeyHeader)
+	if key == "" {
+		c.Next()
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		respondError(c, newError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("%s can't be longer than %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength)))
+		return
+	}
+	ctx := c.Request.Context()
+
+	// the body is part of the request hash so it's read here and then put back
+	// for the handler
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		respondError(c, invalidBody(err))
+		return
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	// keys are scoped to the caller so one client can't see another's responses
+	// and the actor's length is included so an actor and key can't be combined
+	// to look like a different actor and key
+	actor := storage.ActorFromContext(ctx)
+	rec := storage.IdempotencyRecord{
+		Key:         strconv.Itoa(len(actor)) + ":" + actor + ":" + key,
+		RequestHash: requestHash(c.Request, body),
+		CreatedAt:   time.Now().UTC(),
+	}
+	err = i.stor.InsertIdempotencyRecord(ctx, rec, rec.CreatedAt.Add(idempotencyTTL))
+	if errors.Is(err, storage.ErrIdempotencyRecordExists) {
+		i.replayIdempotent(c, rec)
+		return
+	} else if err != nil {
+		respondError(c, fmt.Errorf("error inserting idempotency record: %w", err))
+		return
+	}
+
+	// a handler that panics never has its response saved so the record is
+	// deleted before the panic carries on to the recovery middleware, otherwise
+	// retries would be a conflict until the record expired
+	defer func() {
+		if r := recover(); r != nil {
+			i.deleteIdempotencyRecord(rec.Key)
+			panic(r)
+		}
+	}()
+
+	cw := &captureWriter{ResponseWriter: c.Writer}
+	c.Writer = cw
+	c.Next()
+
+	if cw.Status() >= http.StatusInternalServerError {
+		i.deleteIdempotencyRecord(rec.Key)
+		return
+	}
+	rec.Status = cw.Status()
+	rec.Header = map[string]string{}
+	for _, name := range replayedHeaders {
+		if v := cw.Header().Get(name); v != "" {
+			rec.Header[name] = v
+		}
+	}
+	rec.Body = cw.body.Bytes()
+	// the response is saved even if the client went away since the client is
+	// the most likely to retry, so the request's context isn't used
+	if err := i.stor.CompleteIdempotencyRecord(context.Background(), rec); err != nil {
+		// retries with the key will get CodeIdempotencyKeyInUse until the record
+		// expires which is safer than handling the request twice
+		llog.Error("error saving idempotent response", llog.ErrKV(err), llog.KV{"key": rec.Key})
+	}
+}
+
+// deleteIdempotencyRecord deletes the record for a request that failed so it
+// can be retried with the same key. Like saving the response, it's done even if
+// the client went away.
+func (i *instance) deleteIdempotencyRecord(key string) {
+	if err := i.stor.DeleteIdempotencyRecord(context.Background(), key); err != nil {
+		llog.Error("error deleting idempotency record", llog.ErrKV(err), llog.KV{"key": key})
+	}
+}
+
+// replayIdempotent responds with the saved response for rec's key
+func (i *instance) replayIdempotent(c *gin.Context, rec storage.IdempotencyRecord) {
+	saved, err := i.stor.GetIdempotencyRecord(c.Request.Context(), rec.Key)
+	if errors.Is(err, storage.ErrIdempotencyRecordNotFound) {
+		// the first request failed and its record was deleted after our insert
+		// failed, the client can retry to handle the request again
+		respondError(c, newError(http.StatusConflict, CodeIdempotencyKeyInUse, "a request with this idempotency key is in progress"))
+		return
+	} else if err != nil {
+		respondError(c, fmt.Errorf("error getting idempotency record: %w", err))
+		return
+	}
+	if saved.RequestHash != rec.RequestHash {
+		respondError(c, newError(http.StatusUnprocessableEntity, CodeIdempotencyKeyReused, "the idempotency key was used for a different request"))
+		return
+	}
+	if saved.Status == 0 {
+		respondError(c, newError(http.StatusConflict, CodeIdempotencyKeyInUse, "a request with this idempotency key is in progress"))
+		return
+	}
+
+	for name, v := range saved.Header {
+		c.Header(name, v)
+	}
+	c.Header(IdempotentReplayedHeader, "true")
+	c.Data(saved.Status, saved.Header["Content-Type"], saved.Body)
+	c.Abort()
+}
+
+// requestHash identifies a request by its method, path, query and body
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
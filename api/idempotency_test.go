@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestIdempotent(t *testing.T) {
+	body := `{"customerEmail":"test@test","lineItems":[{"description":"item 1","quantity":1,"priceCents":1000}]}`
+	order := storage.Order{
+		CustomerEmail: "test@test",
+		Currency:      storage.DefaultCurrency,
+		LineItems:     []storage.LineItem{{Description: "item 1", Quantity: 1, PriceCents: 1000}},
+	}
+	post := func(h http.Handler, key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+		r.Header.Set(IdempotencyKeyHeader, key)
+		h.ServeHTTP(w, r)
+		return w
+	}
+	// without authentication the actor is empty so the key is only prefixed
+	// with its length
+	isKey := mock.MatchedBy(func(rec storage.IdempotencyRecord) bool {
+		return rec.Key == "0::key1" && rec.RequestHash != "" && rec.Status == 0
+	})
+
+	// the first response is saved
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("InsertIdempotencyRecord", mock.Anything, isKey, mock.Anything).Return(nil).Once()
+		stor.On("InsertOrder", mock.Anything, order).Return("random", nil).Once()
+		stor.On("CompleteIdempotencyRecord", mock.Anything, mock.MatchedBy(func(rec storage.IdempotencyRecord) bool {
+			return rec.Key == "0::key1" && rec.Status == http.StatusCreated &&
+				strings.Contains(string(rec.Body), `"id":"random"`) &&
+				strings.Contains(rec.Header["Content-Type"], "application/json")
+		})).Return(nil).Once()
+		w := post(Handler(stor, nil, nil), "key1", body)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Empty(t, w.Header().Get(IdempotentReplayedHeader))
+		stor.AssertExpectations(t)
+	}
+
+	// a retry gets the saved response without creating another order
+	{
+		saved := storage.IdempotencyRecord{
+			Status: http.StatusCreated,
+			Header: map[string]string{"Content-Type": "application/json; charset=utf-8", "ETag": `"1"`},
+			Body:   []byte(`{"id":"random"}`),
+		}
+		var hash string
+		stor := new(mocks.MockStorageInstance)
+		stor.On("InsertIdempotencyRecord", mock.Anything, isKey, mock.Anything).
+			Run(func(args mock.Arguments) { hash = args.Get(1).(storage.IdempotencyRecord).RequestHash }).
+			Return(storage.ErrIdempotencyRecordExists).Once()
+		stor.On("GetIdempotencyRecord", mock.Anything, "0::key1").
+			Return(func(context.Context, string) storage.IdempotencyRecord {
+				saved.RequestHash = hash
+				return saved
+			}, nil).Once()
+		w := post(Handler(stor, nil, nil), "key1", body)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, `{"id":"random"}`, w.Body.String())
+		assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+		assert.Equal(t, "true", w.Header().Get(IdempotentReplayedHeader))
+		stor.AssertExpectations(t)
+	}
+
+	// the key can't be used for a different request
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("InsertIdempotencyRecord", mock.Anything, isKey, mock.Anything).Return(storage.ErrIdempotencyRecordExists).Once()
+		stor.On("GetIdempotencyRecord", mock.Anything, "0::key1").
+			Return(storage.IdempotencyRecord{RequestHash: "other", Status: http.StatusCreated}, nil).Once()
+		w := post(Handler(stor, nil, nil), "key1", body)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), string(CodeIdempotencyKeyReused))
+		stor.AssertExpectations(t)
+	}
+
+	// a request that's still being handled is a conflict
+	{
+		var hash string
+		stor := new(mocks.MockStorageInstance)
+		stor.On("InsertIdempotencyRecord", mock.Anything, isKey, mock.Anything).
+			Run(func(args mock.Arguments) { hash = args.Get(1).(storage.IdempotencyRecord).RequestHash }).
+			Return(storage.ErrIdempotencyRecordExists).Once()
+		stor.On("GetIdempotencyRecord", mock.Anything, "0::key1").
+			Return(func(context.Context, string) storage.IdempotencyRecord {
+				return storage.IdempotencyRecord{RequestHash: hash}
+			}, nil).Once()
+		w := post(Handler(stor, nil, nil), "key1", body)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), string(CodeIdempotencyKeyInUse))
+		stor.AssertExpectations(t)
+	}
+
+	// server errors aren't saved so the request can be retried
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("InsertIdempotencyRecord", mock.Anything, isKey, mock.Anything).Return(nil).Once()
+		stor.On("InsertOrder", mock.Anything, order).Return("", assert.AnError).Once()
+		stor.On("DeleteIdempotencyRecord", mock.Anything, "0::key1").Return(nil).Once()
+		w := post(Handler(stor, nil, nil), "key1", body)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		stor.AssertExpectations(t)
+	}
+
+	// neither are requests whose handler panicked
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("InsertIdempotencyRecord", mock.Anything, isKey, mock.Anything).Return(nil).Once()
+		stor.On("InsertOrder", mock.Anything, order).Run(func(mock.Arguments) { panic("boom") }).Once()
+		stor.On("DeleteIdempotencyRecord", mock.Anything, "0::key1").Return(nil).Once()
+		w := post(Handler(stor, nil, nil), "key1", body)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		stor.AssertExpectations(t)
+	}
+
+	// requests without a key aren't saved and long keys are rejected
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("InsertOrder", mock.Anything, order).Return("random", nil).Once()
+		h := Handler(stor, nil, nil)
+		w := post(h, "", body)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		w = post(h, strings.Repeat("k", maxIdempotencyKeyLength+1), body)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		stor.AssertExpectations(t)
+	}
+}
@@ -0,0 +1,704 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levenlabs/order-up/storage"
+)
+
+// openAPISchema is a JSON schema in an OpenAPI document. The zero value allows
+// any JSON value.
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Enum                 []interface{}             `json:"enum,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+}
+
+// openAPIParameter is a path, query or header parameter of an operation
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+// openAPIMediaType is the schema of a body with a single content type
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+// openAPIRequestBody is the body of an operation's request
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+// openAPIResponse is one of an operation's responses
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+// openAPIOperation is a single method of a path
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary"`
+	Description string                     `json:"description,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+	Deprecated  bool                       `json:"deprecated,omitempty"`
+}
+
+// openAPISecurityScheme is a way of authenticating requests
+type openAPISecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+}
+
+// openAPIComponents holds the schemas that are referenced by operations
+type openAPIComponents struct {
+	Schemas         map[string]*openAPISchema        `json:"schemas"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+// openAPIDocument is the OpenAPI 3 document served at /openapi.json
+type openAPIDocument struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	} `json:"info"`
+	// Paths maps each path to its operations by lowercase method
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// openAPIText is used in place of a response type for bodies that aren't JSON,
+// it lists the body's content types
+type openAPIText []string
+
+// openAPIRoute documents a route registered in Handler. Request and response
+// schemas are derived from the Go types the handlers use so they can't drift
+// from what's actually sent.
+type openAPIRoute struct {
+	method string
+	// route is the path as it's registered with gin and path is the path in the
+	// document, which defaults to route with its parameters in braces
+	route string
+	path  string
+	// unversioned is set for the routes that aren't mounted under a version,
+	// the rest are documented under every version and as their deprecated
+	// unprefixed aliases
+	unversioned bool
+	id          string
+	tag         string
+	// summary is a short description of what the route does
+	summary string
+	// scope is the scope the route requires, routes without one don't require
+	// authentication
+	scope string
+	// idempotent is set for routes that accept an Idempotency-Key
+	idempotent bool
+	params     []openAPIParameter
+	// body is a value of the request body's type, if there is one, and
+	// bodyContentType defaults to application/json
+	body            interface{}
+	bodyContentType string
+	// responses maps the status codes to a value of the response body's type.
+	// nil means the response has no body and openAPIText means it isn't JSON.
+	// Error responses are added automatically.
+	responses map[int]interface{}
+}
+
+// These are the parameters shared by multiple routes
+var (
+	openAPIStatusParam = openAPIParameter{
+		Name:        "status",
+		In:          "query",
+		Description: "Only returns orders with this status",
+		Schema:      &openAPISchema{Type: "string", Enum: []interface{}{"pending", "charged", "fulfilled", "cancelled", "charging", "cancelling", "fulfilling"}},
+	}
+	openAPIIfMatchParam = openAPIParameter{
+		Name:        "If-Match",
+		In:          "header",
+		Description: "Fails with a 412 unless the order's ETag still matches",
+		Schema:      &openAPISchema{Type: "string"},
+	}
+)
+
+// openAPIRoutes documents every route registered in Handler. TestOpenAPI fails
+// if a route is registered without being documented here.
+var openAPIRoutes = []openAPIRoute{
+	{
+		method: http.MethodGet, route: "/healthz", unversioned: true, id: "getHealthz", tag: "health",
+		summary:   "Reports whether the process is alive",
+		responses: map[int]interface{}{http.StatusOK: healthRes{}},
+	},
+	{
+		method: http.MethodGet, route: "/readyz", unversioned: true, id: "getReadyz", tag: "health",
+		summary:   "Reports whether the service and its dependencies are ready for traffic",
+		responses: map[int]interface{}{http.StatusOK: healthRes{}, http.StatusServiceUnavailable: healthRes{}},
+	},
+	{
+		method: http.MethodGet, route: "/openapi.json", unversioned: true, id: "getOpenAPI", tag: "health",
+		summary:   "Returns this document",
+		responses: map[int]interface{}{http.StatusOK: map[string]interface{}{}},
+	},
+	{
+		method: http.MethodGet, route: "/orders", id: "listOrders", tag: "orders",
+		summary: "Lists orders sorted by ID",
+		scope:   ScopeOrdersRead,
+		params: []openAPIParameter{
+			openAPIStatusParam,
+			{Name: "limit", In: "query", Description: "Returns at most this many orders and a nextCursor if there might be more", Schema: &openAPISchema{Type: "integer", Format: "int64"}},
+			{Name: "after", In: "query", Description: "Returns the orders after this ID, it's the previous page's nextCursor", Schema: &openAPISchema{Type: "string"}},
+		},
+		responses: map[int]interface{}{http.StatusOK: getOrdersRes{}, http.StatusBadRequest: errorRes{}},
+	},
+	{
+		method: http.MethodPost, route: "/orders", id: "createOrder", tag: "orders",
+		summary: "Creates a pending order",
+		scope:   ScopeOrdersWrite, idempotent: true,
+		body: postOrderArgs{},
+		responses: map[int]interface{}{
+			http.StatusCreated:    postOrderRes{},
+			http.StatusBadRequest: errorRes{},
+			http.StatusConflict:   errorRes{},
+		},
+	},
+	{
+		method: http.MethodPost, route: "/orders:action", path: "/orders:batch", id: "createOrders", tag: "orders",
+		summary: "Creates many orders at once",
+		scope:   ScopeOrdersWrite, idempotent: true,
+		params: []openAPIParameter{
+			{Name: "atomic", In: "query", Description: "Creates either every order or none of them", Schema: &openAPISchema{Type: "boolean"}},
+		},
+		body: postOrdersBatchArgs{},
+		responses: map[int]interface{}{
+			http.StatusOK:         postOrdersBatchRes{},
+			http.StatusBadRequest: errorRes{},
+		},
+	},
+	{
+		method: http.MethodGet, route: "/orders/export", id: "exportOrders", tag: "orders",
+		summary: "Streams the orders as CSV or NDJSON",
+		scope:   ScopeOrdersRead,
+		params: []openAPIParameter{
+			openAPIStatusParam,
+			{Name: "format", In: "query", Schema: &openAPISchema{Type: "string", Enum: []interface{}{"csv", "ndjson"}}},
+			{Name: "totals", In: "query", Description: "Adds the subtotal, tax, shipping, discount and total of each order", Schema: &openAPISchema{Type: "boolean"}},
+		},
+		responses: map[int]interface{}{
+			http.StatusOK:         openAPIText{"text/csv", "application/x-ndjson"},
+			http.StatusBadRequest: errorRes{},
+		},
+	},
+	{
+		method: http.MethodGet, route: "/orders/:id", id: "getOrder", tag: "orders",
+		summary: "Gets an order",
+		scope:   ScopeOrdersRead,
+		params: []openAPIParameter{
+			{Name: "If-None-Match", In: "header", Description: "Returns a 304 if the order's ETag still matches", Schema: &openAPISchema{Type: "string"}},
+		},
+		responses: map[int]interface{}{
+			http.StatusOK:          getOrderRes{},
+			http.StatusNotModified: nil,
+			http.StatusNotFound:    errorRes{},
+		},
+	},
+	{
+		method: http.MethodPatch, route: "/orders/:id", id: "editOrder", tag: "orders",
+		summary: "Edits a pending order with a JSON merge patch",
+		scope:   ScopeOrdersWrite, idempotent: true,
+		params:          []openAPIParameter{openAPIIfMatchParam},
+		body:            orderEdit{},
+		bodyContentType: "application/merge-patch+json",
+		responses: map[int]interface{}{
+			http.StatusOK:                   patchOrderRes{},
+			http.StatusBadRequest:           errorRes{},
+			http.StatusNotFound:             errorRes{},
+			http.StatusConflict:             errorRes{},
+			http.StatusPreconditionFailed:   errorRes{},
+			http.StatusPreconditionRequired: errorRes{},
+		},
+	},
+	{
+		method: http.MethodPost, route: "/orders/:id/charge", id: "chargeOrder", tag: "orders",
+		summary: "Charges the card for a pending order",
+		scope:   ScopeOrdersCharge, idempotent: true,
+		params: []openAPIParameter{openAPIIfMatchParam},
+		body:   chargeOrderArgs{},
+		responses: map[int]interface{}{
+			http.StatusOK:                 chargeOrderRes{},
+			http.StatusPaymentRequired:    errorRes{},
+			http.StatusNotFound:           errorRes{},
+			http.StatusConflict:           errorRes{},
+			http.StatusPreconditionFailed: errorRes{},
+		},
+	},
+	{
+		method: http.MethodPost, route: "/orders/:id/cancel", id: "cancelOrder", tag: "orders",
+		summary: "Cancels an order and refunds it if it was charged",
+		scope:   ScopeOrdersRefund, idempotent: true,
+		params: []openAPIParameter{openAPIIfMatchParam},
+		responses: map[int]interface{}{
+			http.StatusOK:                 cancelOrderRes{},
+			http.StatusNotFound:           errorRes{},
+			http.StatusConflict:           errorRes{},
+			http.StatusPreconditionFailed: errorRes{},
+		},
+	},
+	{
+		method: http.MethodPut, route: "/orders/:id/fulfill", id: "fulfillOrder", tag: "orders",
+		summary: "Ships a charged order",
+		scope:   ScopeOrdersWrite, idempotent: true,
+		params: []openAPIParameter{openAPIIfMatchParam},
+		responses: map[int]interface{}{
+			http.StatusOK:                 fulfillOrderRes{},
+			http.StatusBadRequest:         errorRes{},
+			http.StatusNotFound:           errorRes{},
+			http.StatusConflict:           errorRes{},
+			http.StatusPreconditionFailed: errorRes{},
+		},
+	},
+	{
+		method: http.MethodPut, route: "/orders/:id/shipping", id: "setOrderShipping", tag: "orders",
+		summary: "Changes a pending order's addresses and shipping method",
+		scope:   ScopeOrdersWrite, idempotent: true,
+		params: []openAPIParameter{openAPIIfMatchParam},
+		body:   putOrderShippingArgs{},
+		responses: map[int]interface{}{
+			http.StatusOK:                 putOrderShippingRes{},
+			http.StatusBadRequest:         errorRes{},
+			http.StatusNotFound:           errorRes{},
+			http.StatusConflict:           errorRes{},
+			http.StatusPreconditionFailed: errorRes{},
+		},
+	},
+	{
+		method: http.MethodPost, route: "/admin/apikeys", id: "createAPIKey", tag: "admin",
+		summary: "Creates an API key, the key is only returned once",
+		scope:   ScopeAdmin,
+		body:    postAPIKeyArgs{},
+		responses: map[int]interface{}{
+			http.StatusCreated:    postAPIKeyRes{},
+			http.StatusBadRequest: errorRes{},
+		},
+	},
+	{
+		method: http.MethodGet, route: "/admin/apikeys", id: "listAPIKeys", tag: "admin",
+		summary:   "Lists the API keys",
+		scope:     ScopeAdmin,
+		responses: map[int]interface{}{http.StatusOK: getAPIKeysRes{}},
+	},
+	{
+		method: http.MethodDelete, route: "/admin/apikeys/:id", id: "deleteAPIKey", tag: "admin",
+		summary: "Deletes an API key",
+		scope:   ScopeAdmin,
+		responses: map[int]interface{}{
+			http.StatusNoContent: nil,
+			http.StatusNotFound:  errorRes{},
+		},
+	},
+	{
+		method: http.MethodPost, route: "/promotions", id: "createPromotion", tag: "promotions",
+		summary: "Creates a promotion",
+		scope:   ScopeAdmin,
+		body:    postPromotionArgs{},
+		responses: map[int]interface{}{
+			http.StatusCreated:    promotionRes{},
+			http.StatusBadRequest: errorRes{},
+			http.StatusConflict:   errorRes{},
+		},
+	},
+	{
+		method: http.MethodGet, route: "/promotions", id: "listPromotions", tag: "promotions",
+		summary:   "Lists the promotions",
+		scope:     ScopeAdmin,
+		responses: map[int]interface{}{http.StatusOK: getPromotionsRes{}},
+	},
+	{
+		method: http.MethodGet, route: "/promotions/:code", id: "getPromotion", tag: "promotions",
+		summary: "Gets a promotion",
+		scope:   ScopeAdmin,
+		responses: map[int]interface{}{
+			http.StatusOK:       promotionRes{},
+			http.StatusNotFound: errorRes{},
+		},
+	},
+	{
+		method: http.MethodDelete, route: "/promotions/:code", id: "deletePromotion", tag: "promotions",
+		summary: "Deletes a promotion",
+		scope:   ScopeAdmin,
+		responses: map[int]interface{}{
+			http.StatusNoContent: nil,
+			http.StatusNotFound:  errorRes{},
+		},
+	},
+}
+
+// openAPIPath returns the path of r in the document without the version
+// prefix
+func openAPIPath(r openAPIRoute) string {
+	if r.path != "" {
+		return r.path
+	}
+	parts := strings.Split(r.route, "/")
+	for idx, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			parts[idx] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// pathParams returns the parameters for the braced segments of path
+func pathParams(path string) []openAPIParameter {
+	var params []openAPIParameter
+	for _, part := range strings.Split(path, "/") {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			params = append(params, openAPIParameter{
+				Name:     part[1 : len(part)-1],
+				In:       "path",
+				Required: true,
+				Schema:   &openAPISchema{Type: "string"},
+			})
+		}
+	}
+	return params
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// schemaGenerator derives schemas from Go types the same way encoding/json
+// encodes them for a version of the API. Named structs are added to schemas
+// once and referenced.
+type schemaGenerator struct {
+	schemas map[string]*openAPISchema
+	version apiVersion
+	// hasStatus caches whether a struct type has an order status anywhere in it
+	hasStatus map[reflect.Type]bool
+}
+
+// newSchemaGenerator returns a schemaGenerator for the version that adds its
+// schemas to schemas, which can be shared with the other versions
+func newSchemaGenerator(schemas map[string]*openAPISchema, version apiVersion) *schemaGenerator {
+	return &schemaGenerator{
+		schemas:   schemas,
+		version:   version,
+		hasStatus: map[reflect.Type]bool{},
+	}
+}
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	rawMessageType  = reflect.TypeOf(json.RawMessage{})
+	orderStatusType = reflect.TypeOf(storage.OrderStatus(0))
+)
+
+// schema returns the schema for t
+func (g *schemaGenerator) schema(t reflect.Type) *openAPISchema {
+	switch t {
+	case timeType:
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &openAPISchema{}
+	case orderStatusType:
+		// v1 converts the statuses back to integers, see versioned
+		if g.version < apiVersion2 {
+			return &openAPISchema{
+				Type:        "integer",
+				Format:      "int64",
+				Description: "0 is pending, 1 is charged, 2 is fulfilled, 3 is cancelled, 4 is charging, 5 is cancelling and 6 is fulfilling",
+				Enum:        []interface{}{0, 1, 2, 3, 4, 5, 6},
+			}
+		}
+		return &openAPISchema{Type: "string", Enum: []interface{}{"pending", "charged", "fulfilled", "cancelled", "charging", "cancelling", "fulfilling"}}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		// encoding/json encodes []byte as base64
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &openAPISchema{Type: "string", Format: "byte"}
+		}
+		return &openAPISchema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		// anonymous structs are inlined since they don't have a name to reference
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := g.schemaName(t)
+		if _, ok := g.schemas[name]; !ok {
+			// the name is reserved first so recursive types don't loop forever
+			g.schemas[name] = nil
+			g.schemas[name] = g.structSchema(t)
+		}
+		return &openAPISchema{Ref: "#/components/schemas/" + name}
+	default:
+		// interface{} and anything else can be any value
+		return &openAPISchema{}
+	}
+}
+
+// structSchema returns the object schema for the struct type t. None of the
+// properties are marked as required since the same types are used in requests,
+// where most fields are optional and the handlers validate the rest, and in
+// responses.
+func (g *schemaGenerator) structSchema(t reflect.Type) *openAPISchema {
+	s := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+	g.addFields(s, t)
+	return s
+}
+
+// addFields adds the encoded fields of t to s, including those of embedded
+// structs
+func (g *schemaGenerator) addFields(s *openAPISchema, t reflect.Type) {
+	for idx := 0; idx < t.NumField(); idx++ {
+		f := t.Field(idx)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := tag
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			name = tag[:idx]
+		}
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(s, ft)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = g.schema(ft)
+	}
+}
+
+// schemaName returns the name of the named type t in the document. Unexported
+// types are capitalized and types that are encoded differently after v1 are
+// suffixed with their version, like OrderV2.
+func (g *schemaGenerator) schemaName(t reflect.Type) string {
+	r, size := utf8.DecodeRuneInString(t.Name())
+	name := string(unicode.ToUpper(r)) + t.Name()[size:]
+	if g.version >= apiVersion2 && g.containsStatus(t) {
+		name += "V" + strconv.Itoa(int(g.version))
+	}
+	return name
+}
+
+// containsStatus returns true if an order status is encoded anywhere in t
+func (g *schemaGenerator) containsStatus(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return g.containsStatus(t.Elem())
+	case reflect.Struct:
+	default:
+		return t == orderStatusType
+	}
+	if has, ok := g.hasStatus[t]; ok {
+		return has
+	}
+	// recursive types are assumed not to have one until they're checked
+	g.hasStatus[t] = false
+	for idx := 0; idx < t.NumField(); idx++ {
+		if g.containsStatus(t.Field(idx).Type) {
+			g.hasStatus[t] = true
+			break
+		}
+	}
+	return g.hasStatus[t]
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// newOpenAPIDocument builds the document from openAPIRoutes
+func newOpenAPIDocument() *openAPIDocument {
+	schemas := map[string]*openAPISchema{}
+	doc := &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Paths:   map[string]map[string]*openAPIOperation{},
+		Components: openAPIComponents{
+			Schemas: schemas,
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				"apiKey":     {Type: "apiKey", In: "header", Name: "X-API-Key"},
+				"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			},
+		},
+	}
+	doc.Info.Title = "order-up"
+	doc.Info.Version = "1.0.0"
+
+	// the versions share the schemas that are encoded the same way in each
+	generators := map[apiVersion]*schemaGenerator{}
+	for _, v := range apiVersions {
+		generators[v] = newSchemaGenerator(schemas, v)
+	}
+	for _, r := range openAPIRoutes {
+		path := openAPIPath(r)
+		if r.unversioned {
+			doc.addOperation(path, r.method, newOpenAPIOperation(generators[apiVersion1], r, path))
+			continue
+		}
+		for _, v := range apiVersions {
+			op := newOpenAPIOperation(generators[v], r, path)
+			if v > apiVersion1 {
+				op.OperationID += "V" + strconv.Itoa(int(v))
+			}
+			doc.addOperation(v.prefix()+path, r.method, op)
+		}
+		// the unprefixed alias is the same operation as v1 but deprecated
+		alias := newOpenAPIOperation(generators[apiVersion1], r, path)
+		alias.OperationID += "Unversioned"
+		alias.Description = strings.TrimSpace(alias.Description + " Deprecated, use " + apiVersion1.prefix() + path + " instead.")
+		alias.Deprecated = true
+		doc.addOperation(path, r.method, alias)
+	}
+	return doc
+}
+
+// newOpenAPIOperation returns the operation for r with the schemas from g
+func newOpenAPIOperation(g *schemaGenerator, r openAPIRoute, path string) *openAPIOperation {
+	jsonContent := func(v interface{}) map[string]openAPIMediaType {
+		return map[string]openAPIMediaType{
+			"application/json": {Schema: g.schema(reflect.TypeOf(v))},
+		}
+	}
+	op := &openAPIOperation{
+		OperationID: r.id,
+		Summary:     r.summary,
+		Parameters:  append(pathParams(path), r.params...),
+		Responses:   map[string]openAPIResponse{},
+	}
+	if r.tag != "" {
+		op.Tags = []string{r.tag}
+	}
+	if r.body != nil {
+		contentType := r.bodyContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		op.RequestBody = &openAPIRequestBody{
+			Required: true,
+			Content: map[string]openAPIMediaType{
+				contentType: {Schema: g.schema(reflect.TypeOf(r.body))},
+			},
+		}
+	}
+
+	responses := map[int]interface{}{}
+	for status, v := range r.responses {
+		responses[status] = v
+	}
+	// every authenticated route can fail the same ways before the handler is
+	// called
+	if r.scope != "" {
+		op.Security = []map[string][]string{{"apiKey": {}}, {"bearerAuth": {}}}
+		op.Description = "Requires the " + r.scope + " scope."
+		responses[http.StatusUnauthorized] = errorRes{}
+		responses[http.StatusForbidden] = errorRes{}
+		responses[http.StatusTooManyRequests] = errorRes{}
+	}
+	if r.idempotent {
+		op.Parameters = append(op.Parameters, openAPIParameter{
+			Name:        IdempotencyKeyHeader,
+			In:          "header",
+			Description: "Returns the first response again for a retry with the same key",
+			Schema:      &openAPISchema{Type: "string"},
+		})
+		responses[http.StatusConflict] = errorRes{}
+		responses[http.StatusUnprocessableEntity] = errorRes{}
+	}
+	for status, v := range responses {
+		res := openAPIResponse{Description: http.StatusText(status)}
+		switch v := v.(type) {
+		case nil:
+		case openAPIText:
+			res.Content = map[string]openAPIMediaType{}
+			for _, contentType := range v {
+				res.Content[contentType] = openAPIMediaType{Schema: &openAPISchema{Type: "string"}}
+			}
+		default:
+			res.Content = jsonContent(v)
+		}
+		op.Responses[strconv.Itoa(status)] = res
+	}
+	op.Responses["default"] = openAPIResponse{
+		Description: "Unexpected error",
+		Content:     jsonContent(errorRes{}),
+	}
+	return op
+}
+
+// addOperation adds op to the document for the path and method
+func (doc *openAPIDocument) addOperation(path, method string, op *openAPIOperation) {
+	if doc.Paths[path] == nil {
+		doc.Paths[path] = map[string]*openAPIOperation{}
+	}
+	doc.Paths[path][strings.ToLower(method)] = op
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+// getOpenAPI is called by incoming HTTP GET requests to /openapi.json. The
+// document only depends on the code so it's built once.
+func getOpenAPI(c *gin.Context) {
+	openAPIOnce.Do(func() {
+		var err error
+		openAPIJSON, err = json.Marshal(newOpenAPIDocument())
+		if err != nil {
+			// nothing in the document can fail to encode
+			panic(err)
+		}
+	})
+	c.Data(http.StatusOK, "application/json; charset=utf-8", openAPIJSON)
+}
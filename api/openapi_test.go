@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPI(t *testing.T) {
+	inst := Handler(new(mocks.MockStorageInstance), nil, nil).(*instance)
+
+	w := httptest.NewRecorder()
+	inst.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	var doc openAPIDocument
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+
+	// every registered route has to be documented
+	documented := map[string]string{}
+	for _, r := range openAPIRoutes {
+		documented[r.method+" "+r.route] = openAPIPath(r)
+		if r.unversioned {
+			continue
+		}
+		for _, v := range apiVersions {
+			documented[r.method+" "+v.prefix()+r.route] = v.prefix() + openAPIPath(r)
+		}
+	}
+	for _, r := range inst.router.Routes() {
+		path, ok := documented[r.Method+" "+r.Path]
+		if !assert.True(t, ok, "%s %s is missing from openAPIRoutes", r.Method, r.Path) {
+			continue
+		}
+		assert.NotNil(t, doc.Paths[path][strings.ToLower(r.Method)], "%s %s is missing from the document", r.Method, path)
+		delete(documented, r.Method+" "+r.Path)
+	}
+	// and nothing is documented that isn't registered
+	assert.Empty(t, documented)
+
+	// every reference points to a schema in the document
+	var refs func(s *openAPISchema)
+	refs = func(s *openAPISchema) {
+		if s == nil {
+			return
+		}
+		if s.Ref != "" {
+			name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+			assert.NotNil(t, doc.Components.Schemas[name], "%s isn't defined", s.Ref)
+		}
+		refs(s.Items)
+		refs(s.AdditionalProperties)
+		for _, p := range s.Properties {
+			refs(p)
+		}
+	}
+	for _, s := range doc.Components.Schemas {
+		refs(s)
+	}
+	for _, ops := range doc.Paths {
+		for _, op := range ops {
+			assert.NotEmpty(t, op.Responses)
+			if op.RequestBody != nil {
+				for _, m := range op.RequestBody.Content {
+					refs(m.Schema)
+				}
+			}
+			for _, res := range op.Responses {
+				for _, m := range res.Content {
+					refs(m.Schema)
+				}
+			}
+		}
+	}
+
+	// the unprefixed aliases are deprecated
+	{
+		assert.False(t, doc.Paths["/v1/orders"]["get"].Deprecated)
+		assert.Equal(t, "listOrders", doc.Paths["/v1/orders"]["get"].OperationID)
+		assert.True(t, doc.Paths["/orders"]["get"].Deprecated)
+		assert.Equal(t, "listOrdersUnversioned", doc.Paths["/orders"]["get"].OperationID)
+		assert.False(t, doc.Paths["/healthz"]["get"].Deprecated)
+		assert.NotContains(t, doc.Paths, "/v1/healthz")
+	}
+
+	// the schemas match what the handlers send
+	{
+		op := doc.Paths["/v1/orders"]["post"]
+		require.NotNil(t, op)
+		assert.Contains(t, op.Responses, "201")
+		assert.NotContains(t, op.Responses, "200")
+		assert.Equal(t, "#/components/schemas/PostOrderArgs", op.RequestBody.Content["application/json"].Schema.Ref)
+
+		order := doc.Components.Schemas["Order"]
+		require.NotNil(t, order)
+		assert.Equal(t, "#/components/schemas/LineItem", order.Properties["lineItems"].Items.Ref)
+		assert.Contains(t, order.Properties, "notes")
+		assert.Equal(t, "integer", order.Properties["version"].Type)
+
+		entry := doc.Components.Schemas["HistoryEntry"]
+		require.NotNil(t, entry)
+		assert.Equal(t, "date-time", entry.Properties["at"].Format)
+
+		// fields tagged with - aren't encoded
+		assert.NotContains(t, doc.Components.Schemas["APIKey"].Properties, "Hash")
+	}
+
+	// v2 has its own schemas for the types with statuses and shares the rest
+	{
+		op := doc.Paths["/v2/orders/{id}"]["get"]
+		require.NotNil(t, op)
+		assert.Equal(t, "getOrderV2", op.OperationID)
+		assert.Equal(t, "integer", doc.Components.Schemas["Order"].Properties["status"].Type)
+		order := doc.Components.Schemas["OrderV2"]
+		require.NotNil(t, order)
+		assert.Equal(t, "string", order.Properties["status"].Type)
+		assert.Contains(t, order.Properties["status"].Enum, "cancelled")
+		assert.Equal(t, "#/components/schemas/HistoryEntryV2", order.Properties["history"].Items.Ref)
+		assert.Equal(t, "#/components/schemas/LineItem", order.Properties["lineItems"].Items.Ref)
+		assert.NotContains(t, doc.Components.Schemas, "LineItemV2")
+	}
+
+	// path parameters and the Idempotency-Key are documented
+	{
+		op := doc.Paths["/v1/orders/{id}/charge"]["post"]
+		require.NotNil(t, op)
+		var names []string
+		for _, p := range op.Parameters {
+			names = append(names, p.In+":"+p.Name)
+		}
+		assert.Contains(t, names, "path:id")
+		assert.Contains(t, names, "header:"+IdempotencyKeyHeader)
+		assert.Contains(t, op.Responses, "401")
+		assert.NotEmpty(t, op.Security)
+
+		assert.Empty(t, doc.Paths["/healthz"]["get"].Security)
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/validation"
+)
+
+// postPromotionArgs is the expected body for the POST /promotions handler
+type postPromotionArgs struct {
+	Code                      string                `json:"code"`
+	Kind                      storage.PromotionKind `json:"kind"`
+	PercentOff                int64                 `json:"percentOff"`
+	AmountOffCents            int64                 `json:"amountOffCents"`
+	Currency                  storage.Currency      `json:"currency"`
+	ExpiresAt                 *time.Time            `json:"expiresAt"`
+	MaxRedemptions            int64                 `json:"maxRedemptions"`
+	MaxRedemptionsPerCustomer int64                 `json:"maxRedemptionsPerCustomer"`
+	MinOrderCents             int64                 `json:"minOrderCents"`
+}
+
+// promotionRes is the result of the handlers that return a single promotion
+type promotionRes struct {
+	Promotion storage.Promotion `json:"promotion"`
+}
+
+// postPromotions is called by incoming HTTP POST requests to /promotions
+func (i *instance) postPromotions(c *gin.Context) {
+	var args postPromotionArgs
+	err := c.ShouldBindJSON(&args)
+	if err != nil {
+		respondError(c, invalidBody(err))
+		return
+	}
+
+	promo := storage.Promotion{
+		Code:                      validation.NormalizePromoCode(args.Code),
+		Kind:                      args.Kind,
+		PercentOff:                args.PercentOff,
+		AmountOffCents:            args.AmountOffCents,
+		Currency:                  args.Currency,
+		ExpiresAt:                 args.ExpiresAt,
+		MaxRedemptions:            args.MaxRedemptions,
+		MaxRedemptionsPerCustomer: args.MaxRedemptionsPerCustomer,
+		MinOrderCents:             args.MinOrderCents,
+		CreatedAt:                 time.Now().UTC(),
+	}
+	if vs := validation.Promotion(promo); len(vs) > 0 {
+		respondError(c, validationFailed(vs))
+		return
+	}
+
+	err = i.stor.InsertPromotion(c.Request.Context(), promo)
+	if err != nil {
+		// respondError returns a 409 for a ErrPromotionExists error and a 500 for
+		// anything else
+		respondError(c, fmt.Errorf("error inserting promotion: %w", err))
+		return
+	}
+	c.JSON(http.StatusCreated, promotionRes{
+		Promotion: promo,
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// getPromotionsRes is the result of the GET /promotions handler
+type getPromotionsRes struct {
+	Promotions []storage.Promotion `json:"promotions"`
+}
+
+// getPromotions is called by incoming HTTP GET requests to /promotions
+func (i *instance) getPromotions(c *gin.Context) {
+	promos, err := i.stor.GetPromotions(c.Request.Context())
+	if err != nil {
+		respondError(c, fmt.Errorf("error getting promotions: %w", err))
+		return
+	}
+	// return [] instead of null for the same reason as getOrders
+	if promos == nil {
+		promos = []storage.Promotion{}
+	}
+	c.JSON(http.StatusOK, getPromotionsRes{
+		Promotions: promos,
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// getPromotion is called by incoming HTTP GET requests to /promotions/:code
+func (i *instance) getPromotion(c *gin.Context) {
+	promo, err := i.stor.GetPromotion(c.Request.Context(), validation.NormalizePromoCode(c.Param("code")))
+	if err != nil {
+		respondError(c, fmt.Errorf("error getting promotion: %w", err))
+		return
+	}
+	c.JSON(http.StatusOK, promotionRes{
+		Promotion: promo,
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// deletePromotion is called by incoming HTTP DELETE requests to
+// /promotions/:code
+func (i *instance) deletePromotion(c *gin.Context) {
+	err := i.stor.DeletePromotion(c.Request.Context(), validation.NormalizePromoCode(c.Param("code")))
+	if err != nil {
+		respondError(c, fmt.Errorf("error deleting promotion: %w", err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromotions(t *testing.T) {
+	// creates a promotion with a normalized code
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("InsertPromotion", mock.Anything, mock.MatchedBy(func(p storage.Promotion) bool {
+			return p.Code == "SUMMER10" && p.Kind == storage.PromotionKindPercentage && p.PercentOff == 10 && !p.CreatedAt.IsZero()
+		})).Return(nil).Once()
+		h := Handler(stor, nil, nil)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/promotions", bytes.NewReader([]byte(`{"code":" summer10 ","kind":"percentage","percentOff":10}`)))
+		h.ServeHTTP(w, r)
+		if assert.Equal(t, http.StatusCreated, w.Code) {
+			var res promotionRes
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			assert.Equal(t, "SUMMER10", res.Promotion.Code)
+		}
+		stor.AssertExpectations(t)
+	}
+
+	// rejects invalid promotions
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/promotions", bytes.NewReader([]byte(`{"code":"TENOFF","kind":"fixed","amountOffCents":1000}`)))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, []FieldError{
+			{Field: "currency", Message: "currency is required for fixed promotions and minimum order totals"},
+		}, decodeError(t, w).Details)
+		stor.AssertExpectations(t)
+	}
+
+	// duplicate codes are a conflict
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("InsertPromotion", mock.Anything, mock.Anything).Return(storage.ErrPromotionExists).Once()
+		h := Handler(stor, nil, nil)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/promotions", bytes.NewReader([]byte(`{"code":"SUMMER10","kind":"percentage","percentOff":10}`)))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, CodePromotionExists, decodeError(t, w).Code)
+		stor.AssertExpectations(t)
+	}
+
+	// lists, gets and deletes promotions
+	{
+		promo := storage.Promotion{Code: "SUMMER10", Kind: storage.PromotionKindPercentage, PercentOff: 10}
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetPromotions", mock.Anything).Return(nil, nil).Once()
+		stor.On("GetPromotion", mock.Anything, "SUMMER10").Return(promo, nil).Once()
+		stor.On("GetPromotion", mock.Anything, "NOPE").Return(storage.Promotion{}, storage.ErrPromotionNotFound).Once()
+		stor.On("DeletePromotion", mock.Anything, "SUMMER10").Return(nil).Once()
+		h := Handler(stor, nil, nil)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/promotions", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `{"promotions":[]}`, w.Body.String())
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/promotions/summer10", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/promotions/nope", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, CodePromotionNotFound, decodeError(t, w).Code)
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("DELETE", "/promotions/SUMMER10", nil))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestPostOrdersWithPromotions(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	percent := storage.Promotion{Code: "SUMMER10", Kind: storage.PromotionKindPercentage, PercentOff: 10}
+	fixed := storage.Promotion{Code: "FIVEOFF", Kind: storage.PromotionKindFixed, AmountOffCents: 500, Currency: "USD", MinOrderCents: 2000}
+	expired := storage.Promotion{Code: "OLD", Kind: storage.PromotionKindPercentage, PercentOff: 50, ExpiresAt: &past}
+	lineItems := []storage.LineItem{
+		{Description: "item 1", Quantity: 1, PriceCents: 3000},
+	}
+	postOrder := func(h http.Handler, codes ...string) *httptest.ResponseRecorder {
+		byts, err := json.Marshal(postOrderArgs{
+			CustomerEmail: "test@test",
+			LineItems:     lineItems,
+			PromoCodes:    codes,
+		})
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/orders", bytes.NewReader(byts)))
+		return w
+	}
+
+	// discounts are added as line items and the promotions are redeemed
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetPromotion", mock.Anything, "SUMMER10").Return(percent, nil).Once()
+		stor.On("GetPromotion", mock.Anything, "FIVEOFF").Return(fixed, nil).Once()
+		stor.On("RedeemPromotion", mock.Anything, "SUMMER10", "test@test").Return(nil).Once()
+		stor.On("RedeemPromotion", mock.Anything, "FIVEOFF", "test@test").Return(nil).Once()
+		stor.On("InsertOrder", mock.Anything, storage.Order{
+			CustomerEmail: "test@test",
+			Currency:      storage.DefaultCurrency,
+			LineItems: []storage.LineItem{
+				lineItems[0],
+				{Description: "Promotion SUMMER10", Quantity: 1, PriceCents: -300, Kind: storage.LineItemKindDiscount},
+				{Description: "Promotion FIVEOFF", Quantity: 1, PriceCents: -500, Kind: storage.LineItemKindDiscount},
+			},
+			PromoCodes: []string{"SUMMER10", "FIVEOFF"},
+		}).Return("id", nil).Once()
+		h := Handler(stor, nil, nil)
+		w := postOrder(h, "summer10", "FIVEOFF")
+		if assert.Equal(t, http.StatusCreated, w.Code) {
+			var res postOrderRes
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			assert.EqualValues(t, 2200, res.Order.TotalCents())
+		}
+		stor.AssertExpectations(t)
+	}
+
+	// ineligible codes are all reported at once
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetPromotion", mock.Anything, "OLD").Return(expired, nil).Once()
+		stor.On("GetPromotion", mock.Anything, "NOPE").Return(storage.Promotion{}, storage.ErrPromotionNotFound).Once()
+		stor.On("GetPromotion", mock.Anything, "SUMMER10").Return(percent, nil).Once()
+		h := Handler(stor, nil, nil)
+		w := postOrder(h, "OLD", "NOPE", "SUMMER10", "summer10")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, []FieldError{
+			{Field: "promoCodes[0]", Message: "promotion has expired"},
+			{Field: "promoCodes[1]", Message: "unknown promotion code"},
+			{Field: "promoCodes[3]", Message: "promotion code was already applied"},
+		}, decodeError(t, w).Details)
+		stor.AssertExpectations(t)
+	}
+
+	// the minimum order total is enforced
+	{
+		stor := new(mocks.MockStorageInstance)
+		minimum := fixed
+		minimum.MinOrderCents = 5000
+		stor.On("GetPromotion", mock.Anything, "FIVEOFF").Return(minimum, nil).Once()
+		h := Handler(stor, nil, nil)
+		w := postOrder(h, "FIVEOFF")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, []FieldError{
+			{Field: "promoCodes[0]", Message: "order total must be at least 50.00 USD"},
+		}, decodeError(t, w).Details)
+		stor.AssertExpectations(t)
+	}
+
+	// if a promotion hit its limit the earlier ones are released
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetPromotion", mock.Anything, "SUMMER10").Return(percent, nil).Once()
+		stor.On("GetPromotion", mock.Anything, "FIVEOFF").Return(fixed, nil).Once()
+		stor.On("RedeemPromotion", mock.Anything, "SUMMER10", "test@test").Return(nil).Once()
+		stor.On("RedeemPromotion", mock.Anything, "FIVEOFF", "test@test").Return(storage.ErrPromotionLimitReached).Once()
+		stor.On("ReleasePromotion", mock.Anything, "SUMMER10", "test@test").Return(nil).Once()
+		h := Handler(stor, nil, nil)
+		w := postOrder(h, "SUMMER10", "FIVEOFF")
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, CodePromotionLimitReached, decodeError(t, w).Code)
+		stor.AssertExpectations(t)
+	}
+
+	// if the order can't be inserted the promotions are released
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetPromotion", mock.Anything, "SUMMER10").Return(percent, nil).Once()
+		stor.On("RedeemPromotion", mock.Anything, "SUMMER10", "test@test").Return(nil).Once()
+		stor.On("InsertOrder", mock.Anything, mock.Anything).Return("", errors.New("boom")).Once()
+		stor.On("ReleasePromotion", mock.Anything, "SUMMER10", "test@test").Return(nil).Once()
+		h := Handler(stor, nil, nil)
+		w := postOrder(h, "SUMMER10")
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		stor.AssertExpectations(t)
+	}
+}
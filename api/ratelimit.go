@@ -0,0 +1,120 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levenlabs/go-llog"
+	"github.com/levenlabs/order-up/ratelimit"
+)
+
+// These are the route groups that rate limits can be configured for. Each route
+// belongs to exactly one of them and each group has its own bucket per client.
+const (
+	// RateLimitRead covers listing and getting orders
+	RateLimitRead = "read"
+	// RateLimitWrite covers creating and fulfilling orders
+	RateLimitWrite = "write"
+	// RateLimitCharge covers charging and cancelling orders which both call the
+	// charge service
+	RateLimitCharge = "charge"
+	// RateLimitAdmin covers managing API keys and promotions
+	RateLimitAdmin = "admin"
+)
+
+// RateLimitConfig configures rate limiting for each route group
+type RateLimitConfig struct {
+	// Limiter holds the buckets, use ratelimit.NewMemory for a single replica or
+	// ratelimit.NewShared when running multiple replicas
+	Limiter ratelimit.Limiter
+	// Limits are the limits for each route group. A group without a limit isn't
+	// rate limited.
+	Limits map[string]ratelimit.Limit
+}
+
+// DefaultRateLimits returns reasonable limits for every route group. Charging
+// is limited the most since every request calls the charge service.
+func DefaultRateLimits() map[string]ratelimit.Limit {
+	return map[string]ratelimit.Limit{
+		RateLimitRead:   {Rate: 20, Burst: 40},
+		RateLimitWrite:  {Rate: 5, Burst: 10},
+		RateLimitCharge: {Rate: 1, Burst: 5},
+		RateLimitAdmin:  {Rate: 1, Burst: 5},
+	}
+}
+
+// WithRateLimit enables rate limiting on every route except the health
+// endpoints
+func WithRateLimit(cfg RateLimitConfig) Option {
+	return func(i *instance) {
+		i.rateLimit = &cfg
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// limitRate returns a middleware that takes a token from the client's bucket for
+// group and aborts with a 429 if there aren't any left. It must run after
+// authenticate so clients can be identified by their credentials.
+func (i *instance) limitRate(group string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if i.rateLimit == nil {
+			c.Next()
+			return
+		}
+		limit, ok := i.rateLimit.Limits[group]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		res, err := i.rateLimit.Limiter.Take(c.Request.Context(), group+":"+rateLimitClient(c), limit)
+		if err != nil {
+			// we'd rather let requests through than have the whole API go down
+			// because the shared buckets can't be reached
+			llog.Warn("error taking rate limit token", llog.ErrKV(err), llog.KV{"group": group})
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(ceilSeconds(res.Reset), 10))
+		if !res.Allowed {
+			c.Header("Retry-After", strconv.FormatInt(ceilSeconds(res.RetryAfter), 10))
+			respondError(c, newError(http.StatusTooManyRequests, CodeRateLimited, "rate limit exceeded"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// rateLimitClient identifies who a request should be counted against. Customers
+// are counted by their email so that a customer with multiple tokens still
+// shares one bucket, otherwise the API key or JWT subject is used and
+// unauthenticated requests fall back to the client's IP.
+func rateLimitClient(c *gin.Context) string {
+	p, ok := principalFromContext(c)
+	switch {
+	case ok && p.CustomerEmail != "":
+		return "customer:" + p.CustomerEmail
+	case ok && p.Actor != "":
+		return p.Actor
+	default:
+		return "ip:" + c.ClientIP()
+	}
+}
+
+// ceilSeconds rounds d up to whole seconds since the headers don't allow
+// fractions and rounding down would have clients retry too early
+func ceilSeconds(d time.Duration) int64 {
+	// limits without a rate never refill so we cap the value at something that
+	// still fits in the header
+	if d >= time.Duration(math.MaxInt64) {
+		return int64(math.MaxInt32)
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/ratelimit"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// failingLimiter is a ratelimit.Limiter that always errors
+type failingLimiter struct{}
+
+func (failingLimiter) Take(context.Context, string, ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("unreachable")
+}
+
+func TestRateLimit(t *testing.T) {
+	limits := map[string]ratelimit.Limit{
+		RateLimitRead: {Rate: 0.5, Burst: 1},
+	}
+
+	// requests over the limit are rejected with the headers set
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil, WithRateLimit(RateLimitConfig{
+			Limiter: ratelimit.NewMemory(),
+			Limits:  limits,
+		}))
+		stor.On("GetOrders", mock.Anything, storage.OrderFilter{Status: storage.OrderStatusAny}).Return([]storage.Order{}, nil).Once()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/orders", nil)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "1", w.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+		assert.Equal(t, "2", w.Header().Get("X-RateLimit-Reset"))
+		assert.Empty(t, w.Header().Get("Retry-After"))
+
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("GET", "/orders", nil)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "2", w.Header().Get("Retry-After"))
+		assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+
+		// a different client IP has its own bucket
+		stor.On("GetOrders", mock.Anything, storage.OrderFilter{Status: storage.OrderStatusAny}).Return([]storage.Order{}, nil).Once()
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("GET", "/orders", nil)
+		r.RemoteAddr = "192.0.2.2:1234"
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// and route groups without a limit aren't limited
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("POST", "/orders", nil)
+		h.ServeHTTP(w, r)
+		assert.NotEqual(t, http.StatusTooManyRequests, w.Code)
+		assert.Empty(t, w.Header().Get("X-RateLimit-Limit"))
+	}
+
+	// authenticated clients are limited by their credentials
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil,
+			WithAuth(AuthConfig{HS256Secrets: [][]byte{testHS256Secret}}),
+			WithRateLimit(RateLimitConfig{
+				Limiter: ratelimit.NewMemory(),
+				Limits:  limits,
+			}),
+		)
+		stor.On("GetOrders", mock.Anything, storage.OrderFilter{Status: storage.OrderStatusAny}).Return([]storage.Order{}, nil).Twice()
+
+		for _, subject := range []string{"first", "second"} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/orders", nil)
+			r.Header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodHS256, testHS256Secret, subject, ScopeOrdersRead))
+			h.ServeHTTP(w, r)
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/orders", nil)
+		r.Header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodHS256, testHS256Secret, "first", ScopeOrdersRead))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		stor.AssertExpectations(t)
+	}
+
+	// requests are let through if the limiter fails
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil, WithRateLimit(RateLimitConfig{
+			Limiter: failingLimiter{},
+			Limits:  limits,
+		}))
+		stor.On("GetOrders", mock.Anything, storage.OrderFilter{Status: storage.OrderStatusAny}).Return([]storage.Order{}, nil).Once()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/orders", nil)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		stor.AssertExpectations(t)
+	}
+}
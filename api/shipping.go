@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levenlabs/order-up/orders"
+	"github.com/levenlabs/order-up/storage"
+)
+
+// putOrderShippingArgs is the expected body for the PUT /orders/:id/shipping
+// handler. Every field replaces the order's current value, including unset
+// ones.
+type putOrderShippingArgs struct {
+	ShippingAddress *storage.Address `json:"shippingAddress"`
+	BillingAddress  *storage.Address `json:"billingAddress"`
+	ShippingMethod  string           `json:"shippingMethod"`
+	// Jurisdiction defaults to the shipping address's jurisdiction, just like
+	// when creating an order
+	Jurisdiction string `json:"jurisdiction"`
+}
+
+// putOrderShippingRes is the result of the PUT /orders/:id/shipping handler
+type putOrderShippingRes struct {
+	Order storage.Order `json:"order"`
+}
+
+// putOrderShipping is called by incoming HTTP PUT requests to
+// /orders/:id/shipping. The shipping line item and tax are recalculated since
+// they depend on the address and method.
+func (i *instance) putOrderShipping(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var args putOrderShippingArgs
+	err := c.ShouldBindJSON(&args)
+	if err != nil {
+		respondError(c, invalidBody(err))
+		return
+	}
+
+	order, err := i.orders.Get(ctx, c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if !canAccessOrder(c, order) {
+		respondError(c, errOrderNotFound)
+		return
+	}
+	if err := checkIfMatch(c, order); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// respondError returns a 409 if the order isn't pending or was charged since
+	// we got it, a 412 if it was edited since we got it and a 500 for anything
+	// else
+	updated, err := i.orders.SetShipping(ctx, order, orders.ShippingArgs(args))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Header("ETag", orderETag(updated.Version))
+	c.JSON(http.StatusOK, versioned(c, putOrderShippingRes{
+		Order: updated,
+	}))
+}
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/orders"
+	"github.com/levenlabs/order-up/services"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var testShippingMethods = orders.ShippingMethods{
+	"standard": {Name: "Standard shipping", Prices: map[storage.Currency]int64{"USD": 500}},
+}
+
+var testAddress = storage.Address{
+	Name:       "Wile E. Coyote",
+	Line1:      "1 Mesa Rd",
+	City:       "Needles",
+	Region:     "CA",
+	PostalCode: "92363",
+	Country:    "US",
+}
+
+func TestPostOrdersWithShipping(t *testing.T) {
+	lineItem := storage.LineItem{Description: "item 1", Quantity: 2, PriceCents: 1000}
+	postOrder := func(h http.Handler, args postOrderArgs) *httptest.ResponseRecorder {
+		byts, err := json.Marshal(args)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/orders", bytes.NewReader(byts)))
+		return w
+	}
+
+	// shipping is added as a line item and taxed in the address's jurisdiction
+	{
+		taxCalc := tax.RuleTable{"US-CA": {Rates: map[string]tax.Rate{"": 100000, orders.ShippingTaxCategory: 0}}}
+		addr := testAddress
+		stor := new(mocks.MockStorageInstance)
+		stor.On("InsertOrder", mock.Anything, storage.Order{
+			CustomerEmail: "test@test",
+			Currency:      storage.DefaultCurrency,
+			Jurisdiction:  "US-CA",
+			LineItems: []storage.LineItem{
+				lineItem,
+				{Description: "Standard shipping", Quantity: 1, PriceCents: 500, TaxCategory: orders.ShippingTaxCategory, Kind: storage.LineItemKindShipping},
+				{Description: "Tax US-CA 10%", Quantity: 1, PriceCents: 200, Kind: storage.LineItemKindTax},
+			},
+			ShippingAddress: &addr,
+			BillingAddress:  &addr,
+			ShippingMethod:  "standard",
+			Status:          storage.OrderStatusPending,
+			Tax: &storage.TaxBreakdown{
+				Jurisdiction: "US-CA",
+				Lines: []storage.TaxLine{
+					{RatePPM: 100000, TaxableCents: 2000, TaxCents: 200},
+					{Category: orders.ShippingTaxCategory, TaxableCents: 500},
+				},
+				TotalCents: 200,
+			},
+		}).Return("shipped", nil).Once()
+		h := Handler(stor, nil, nil, WithOrderOptions(orders.WithTaxCalculator(taxCalc), orders.WithShippingMethods(testShippingMethods)))
+		w := postOrder(h, postOrderArgs{
+			CustomerEmail:   "test@test",
+			ShippingAddress: &addr,
+			BillingAddress:  &addr,
+			ShippingMethod:  "standard",
+			LineItems:       []storage.LineItem{lineItem},
+		})
+		if assert.Equal(t, http.StatusCreated, w.Code) {
+			var res postOrderRes
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			assert.EqualValues(t, 2700, res.Order.TotalCents())
+		}
+		stor.AssertExpectations(t)
+	}
+
+	// addresses and shipping methods are validated
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil, WithOrderOptions(orders.WithShippingMethods(testShippingMethods)))
+		w := postOrder(h, postOrderArgs{
+			CustomerEmail:  "test@test",
+			BillingAddress: &storage.Address{Name: "Wile E. Coyote", Line1: "1 Mesa Rd", City: "Needles", Country: "usa"},
+			LineItems:      []storage.LineItem{lineItem},
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, []FieldError{
+			{Field: "billingAddress.country", Message: "must be an ISO 3166-1 alpha-2 country code like US"},
+		}, decodeError(t, w).Details)
+
+		w = postOrder(h, postOrderArgs{
+			CustomerEmail:  "test@test",
+			Currency:       "EUR",
+			ShippingMethod: "standard",
+			LineItems:      []storage.LineItem{lineItem},
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, []FieldError{
+			{Field: "shippingMethod", Message: "shipping method isn't available for orders in EUR"},
+			{Field: "shippingAddress", Message: "shippingAddress is required with a shipping method"},
+		}, decodeError(t, w).Details)
+
+		w = postOrder(h, postOrderArgs{
+			CustomerEmail:   "test@test",
+			ShippingAddress: &testAddress,
+			ShippingMethod:  "overnight",
+			LineItems:       []storage.LineItem{lineItem},
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, []FieldError{
+			{Field: "shippingMethod", Message: "unknown shipping method"},
+		}, decodeError(t, w).Details)
+		stor.AssertExpectations(t)
+	}
+
+	// a shipping address outside of the tax rules is rejected
+	{
+		taxCalc := tax.RuleTable{"US-CA": {Rates: map[string]tax.Rate{"": 100000}}}
+		addr := testAddress
+		addr.Region = "NY"
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil, WithOrderOptions(orders.WithTaxCalculator(taxCalc)))
+		w := postOrder(h, postOrderArgs{
+			CustomerEmail:   "test@test",
+			ShippingAddress: &addr,
+			LineItems:       []storage.LineItem{lineItem},
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, []FieldError{
+			{Field: "shippingAddress", Message: "orders can't be shipped to this jurisdiction"},
+		}, decodeError(t, w).Details)
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestPutOrderShipping(t *testing.T) {
+	taxCalc := tax.RuleTable{"US-CA": {Rates: map[string]tax.Rate{"": 100000}}}
+	pending := storage.Order{
+		ID:            "order1",
+		CustomerEmail: "test@test",
+		Currency:      storage.DefaultCurrency,
+		Jurisdiction:  "US-CA",
+		LineItems: []storage.LineItem{
+			{Description: "item 1", Quantity: 1, PriceCents: 1000},
+			{Description: "Tax US-CA 10%", Quantity: 1, PriceCents: 100, Kind: storage.LineItemKindTax},
+		},
+		ShippingAddress: &testAddress,
+		Tax: &storage.TaxBreakdown{
+			Jurisdiction: "US-CA",
+			Lines:        []storage.TaxLine{{RatePPM: 100000, TaxableCents: 1000, TaxCents: 100}},
+			TotalCents:   100,
+		},
+		Status: storage.OrderStatusPending,
+	}
+	putShipping := func(h http.Handler, id string, args putOrderShippingArgs) *httptest.ResponseRecorder {
+		byts, err := json.Marshal(args)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("PUT", "/orders/"+id+"/shipping", bytes.NewReader(byts)))
+		return w
+	}
+
+	// the shipping line item and tax are recalculated
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "order1").Return(pending, nil).Once()
+		stor.On("UpdateOrder", mock.Anything, storage.Order{
+			ID:            "order1",
+			CustomerEmail: "test@test",
+			Currency:      storage.DefaultCurrency,
+			Jurisdiction:  "US-CA",
+			LineItems: []storage.LineItem{
+				{Description: "item 1", Quantity: 1, PriceCents: 1000},
+				{Description: "Standard shipping", Quantity: 1, PriceCents: 500, TaxCategory: orders.ShippingTaxCategory, Kind: storage.LineItemKindShipping},
+				{Description: "Tax US-CA 10%", Quantity: 1, PriceCents: 100, Kind: storage.LineItemKindTax},
+				{Description: "Tax US-CA shipping 10%", Quantity: 1, PriceCents: 50, TaxCategory: orders.ShippingTaxCategory, Kind: storage.LineItemKindTax},
+			},
+			ShippingAddress: &testAddress,
+			ShippingMethod:  "standard",
+			Tax: &storage.TaxBreakdown{
+				Jurisdiction: "US-CA",
+				Lines: []storage.TaxLine{
+					{RatePPM: 100000, TaxableCents: 1000, TaxCents: 100},
+					{Category: orders.ShippingTaxCategory, RatePPM: 100000, TaxableCents: 500, TaxCents: 50},
+				},
+				TotalCents: 150,
+			},
+			Status: storage.OrderStatusPending,
+		}, mock.Anything).Return(nil).Once()
+		h := Handler(stor, nil, nil, WithOrderOptions(orders.WithTaxCalculator(taxCalc), orders.WithShippingMethods(testShippingMethods)))
+		w := putShipping(h, "order1", putOrderShippingArgs{ShippingAddress: &testAddress, ShippingMethod: "standard"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		stor.AssertExpectations(t)
+	}
+
+	// removing the address removes the tax
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "order1").Return(pending, nil).Once()
+		stor.On("UpdateOrder", mock.Anything, storage.Order{
+			ID:            "order1",
+			CustomerEmail: "test@test",
+			Currency:      storage.DefaultCurrency,
+			LineItems:     []storage.LineItem{{Description: "item 1", Quantity: 1, PriceCents: 1000}},
+			Status:        storage.OrderStatusPending,
+		}, mock.Anything).Return(nil).Once()
+		h := Handler(stor, nil, nil, WithOrderOptions(orders.WithTaxCalculator(taxCalc)))
+		w := putShipping(h, "order1", putOrderShippingArgs{})
+		assert.Equal(t, http.StatusOK, w.Code)
+		stor.AssertExpectations(t)
+	}
+
+	// only pending orders can be changed
+	{
+		charged := pending
+		charged.Status = storage.OrderStatusCharged
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "order1").Return(charged, nil).Once()
+		h := Handler(stor, nil, nil)
+		w := putShipping(h, "order1", putOrderShippingArgs{ShippingAddress: &testAddress})
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, CodeInvalidTransition, decodeError(t, w).Code)
+		stor.AssertExpectations(t)
+	}
+
+	// the order might be charged after it was loaded
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "order1").Return(pending, nil).Once()
+		stor.On("UpdateOrder", mock.Anything, mock.Anything, mock.Anything).Return(storage.ErrOrderNotPending).Once()
+		h := Handler(stor, nil, nil)
+		w := putShipping(h, "order1", putOrderShippingArgs{ShippingAddress: &testAddress})
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, CodeInvalidTransition, decodeError(t, w).Code)
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestFulfillOrderWithShipping(t *testing.T) {
+	var got []services.FulfillArgs
+	fulfillServ := mocks.NewMockedService(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var args services.FulfillArgs
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&args))
+		got = append(got, args)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	stor := new(mocks.MockStorageInstance)
+	stor.On("GetOrder", mock.Anything, "order1").Return(storage.Order{
+		ID:            "order1",
+		CustomerEmail: "test@test",
+		LineItems: []storage.LineItem{
+			{Description: "item 1", Quantity: 2, PriceCents: 1000},
+			{Description: "Standard shipping", Quantity: 1, PriceCents: 500, Kind: storage.LineItemKindShipping},
+		},
+		ShippingAddress: &testAddress,
+		ShippingMethod:  "standard",
+		Status:          storage.OrderStatusCharged,
+	}, nil).Once()
+	stor.On("SetOrderStatus", mock.Anything, "order1", storage.OrderStatusFulfilling, int64(0)).Return(nil).Once()
+	stor.On("SetOrderStatus", mock.Anything, "order1", storage.OrderStatusFulfilled, int64(1)).Return(nil).Once()
+	h := Handler(stor, services.NewHTTPFulfillmentService(fulfillServ), nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("PUT", "/orders/order1/fulfill", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	// the shipping line item isn't something that's shipped
+	assert.Equal(t, []services.FulfillArgs{{
+		Description:     "item 1",
+		Quantity:        2,
+		OrderID:         "order1",
+		ShippingAddress: &testAddress,
+		ShippingMethod:  "standard",
+	}}, got)
+	stor.AssertExpectations(t)
+}
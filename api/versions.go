@@ -0,0 +1,157 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levenlabs/order-up/storage"
+)
+
+// apiVersion is a version of the routes under /v<version>. Every version
+// shares the same instance, and so the same storage and service clients, and
+// handlers call apiVersionFromContext when a version changes what they accept
+// or send.
+type apiVersion int
+
+const (
+	// apiVersion1 is the first version, it's also used for the unprefixed
+	// routes from before the API was versioned. Order statuses are integers.
+	apiVersion1 apiVersion = 1
+	// apiVersion2 sends order statuses as their names, like charged
+	apiVersion2 apiVersion = 2
+)
+
+// apiVersions are every version, each is mounted under its prefix
+var apiVersions = []apiVersion{apiVersion1, apiVersion2}
+
+// prefix returns the prefix of the version's routes, like /v1
+func (v apiVersion) prefix() string {
+	return "/v" + strconv.Itoa(int(v))
+}
+
+// apiVersionKey is the key in the gin context for the request's apiVersion
+const apiVersionKey = "apiVersion"
+
+// DefaultUnversionedSunset is when the unprefixed routes, like /orders instead
+// of /v1/orders, stop working unless WithUnversionedSunset is passed
+var DefaultUnversionedSunset = time.Date(2027, time.June, 30, 0, 0, 0, 0, time.UTC)
+
+// WithUnversionedSunset sets when the unprefixed routes stop working. It's only
+// sent to clients in the Sunset header, the routes keep working until they're
+// removed.
+func WithUnversionedSunset(t time.Time) Option {
+	return func(i *instance) {
+		i.unversionedSunset = t
+	}
+}
+
+// withAPIVersion is the middleware that sets the apiVersion for every route in
+// a version's group
+func withAPIVersion(v apiVersion) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(apiVersionKey, v)
+	}
+}
+
+// apiVersionFromContext returns the apiVersion of the route the request was
+// made to
+func apiVersionFromContext(c *gin.Context) apiVersion {
+	if v, ok := c.Get(apiVersionKey); ok {
+		return v.(apiVersion)
+	}
+	return apiVersion1
+}
+
+// deprecated is the middleware for the unprefixed routes. It tells clients
+// that the route is deprecated, when it'll be removed and where it moved to,
+// following RFC 8594 for Sunset and RFC 8288 for Link.
+func (i *instance) deprecated(successorPrefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Sunset", i.unversionedSunset.UTC().Format(http.TimeFormat))
+		c.Header("Link", "<"+successorPrefix+c.Request.URL.Path+`>; rel="successor-version"`)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// v1Order is an order as it's sent in v1 responses. storage.OrderStatus is
+// encoded as its name so the statuses are replaced with integers, the outer
+// fields hide the embedded ones with the same JSON names.
+type v1Order struct {
+	storage.Order
+	Status  int64            `json:"status"`
+	History []v1HistoryEntry `json:"history,omitempty"`
+}
+
+// v1HistoryEntry is a history entry as it's sent in v1 responses
+type v1HistoryEntry struct {
+	storage.HistoryEntry
+	Status int64 `json:"status"`
+}
+
+// newV1Order returns order as it's sent in v1 responses
+func newV1Order(order storage.Order) v1Order {
+	o := v1Order{Order: order, Status: int64(order.Status)}
+	for _, entry := range order.History {
+		o.History = append(o.History, v1HistoryEntry{HistoryEntry: entry, Status: int64(entry.Status)})
+	}
+	return o
+}
+
+// newV1Orders returns orders as they're sent in v1 responses
+func newV1Orders(orders []storage.Order) []v1Order {
+	res := make([]v1Order, len(orders))
+	for idx, order := range orders {
+		res[idx] = newV1Order(order)
+	}
+	return res
+}
+
+// versioned returns res as it's sent in the request's API version. Every
+// response that has orders has to be passed through here before it's sent so
+// v1 clients keep getting integer statuses.
+func versioned(c *gin.Context, res interface{}) interface{} {
+	if apiVersionFromContext(c) >= apiVersion2 {
+		return res
+	}
+	// the responses with a single order all have the same shape
+	type v1OrderRes struct {
+		Order v1Order `json:"order"`
+	}
+	switch res := res.(type) {
+	case getOrdersRes:
+		return struct {
+			Orders     []v1Order `json:"orders"`
+			NextCursor string    `json:"nextCursor,omitempty"`
+		}{newV1Orders(res.Orders), res.NextCursor}
+	case getOrderRes:
+		return v1OrderRes{newV1Order(res.Order)}
+	case postOrderRes:
+		return v1OrderRes{newV1Order(res.Order)}
+	case patchOrderRes:
+		return v1OrderRes{newV1Order(res.Order)}
+	case putOrderShippingRes:
+		return v1OrderRes{newV1Order(res.Order)}
+	case postOrdersBatchRes:
+		type v1BatchOrderResult struct {
+			Order *v1Order `json:"order,omitempty"`
+			Error *Error   `json:"error,omitempty"`
+		}
+		results := make([]v1BatchOrderResult, len(res.Results))
+		for idx, r := range res.Results {
+			results[idx].Error = r.Error
+			if r.Order != nil {
+				o := newV1Order(*r.Order)
+				results[idx].Order = &o
+			}
+		}
+		return struct {
+			Results []v1BatchOrderResult `json:"results"`
+		}{results}
+	default:
+		return res
+	}
+}
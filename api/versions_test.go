@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestVersionedRoutes(t *testing.T) {
+	order := storage.Order{ID: "a", LineItems: []storage.LineItem{}, Version: 1}
+
+	// the /v1 routes aren't deprecated
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(order, nil).Once()
+		h := Handler(stor, nil, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/v1/orders/a", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Deprecation"))
+		assert.Empty(t, w.Header().Get("Sunset"))
+		stor.AssertExpectations(t)
+	}
+
+	// the unprefixed routes still work but say they're deprecated and where
+	// they moved to
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(order, nil).Once()
+		h := Handler(stor, nil, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/orders/a?x=1", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "true", w.Header().Get("Deprecation"))
+		assert.Equal(t, "Wed, 30 Jun 2027 00:00:00 GMT", w.Header().Get("Sunset"))
+		assert.Equal(t, `</v1/orders/a>; rel="successor-version"`, w.Header().Get("Link"))
+		stor.AssertExpectations(t)
+	}
+
+	// the sunset can be changed and the headers are sent even if the request
+	// fails before reaching the handler
+	{
+		stor := new(mocks.MockStorageInstance)
+		sunset := time.Date(2027, time.January, 1, 12, 0, 0, 0, time.FixedZone("EST", -5*60*60))
+		h := Handler(stor, nil, nil, WithAuth(AuthConfig{}), WithUnversionedSunset(sunset))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/orders", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "true", w.Header().Get("Deprecation"))
+		assert.Equal(t, "Fri, 01 Jan 2027 17:00:00 GMT", w.Header().Get("Sunset"))
+		stor.AssertExpectations(t)
+	}
+
+	// routes that were never versioned aren't deprecated or prefixed
+	{
+		stor := new(mocks.MockStorageInstance)
+		h := Handler(stor, nil, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Deprecation"))
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/v1/healthz", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestVersionedStatuses(t *testing.T) {
+	order := storage.Order{
+		ID:        "a",
+		LineItems: []storage.LineItem{{Description: "item", Quantity: 1, PriceCents: 100}},
+		Status:    storage.OrderStatusCharged,
+		History:   []storage.HistoryEntry{{Status: storage.OrderStatusCharged}},
+		Version:   2,
+	}
+	get := func(h http.Handler, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	// decodeOrder returns the order from a response as generic JSON
+	decodeOrder := func(w *httptest.ResponseRecorder) map[string]interface{} {
+		var res struct {
+			Order map[string]interface{} `json:"order"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res.Order
+	}
+
+	// v2 sends the status's name
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(order, nil).Once()
+		w := get(Handler(stor, nil, nil), "/v2/orders/a")
+		assert.Equal(t, http.StatusOK, w.Code)
+		res := decodeOrder(w)
+		assert.Equal(t, "charged", res["status"])
+		assert.Equal(t, "charged", res["history"].([]interface{})[0].(map[string]interface{})["status"])
+		stor.AssertExpectations(t)
+	}
+
+	// v1 and the unprefixed routes still send integers
+	for _, path := range []string{"/v1/orders/a", "/orders/a"} {
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(order, nil).Once()
+		w := get(Handler(stor, nil, nil), path)
+		assert.Equal(t, http.StatusOK, w.Code)
+		res := decodeOrder(w)
+		assert.Equal(t, float64(1), res["status"], path)
+		assert.Equal(t, float64(1), res["history"].([]interface{})[0].(map[string]interface{})["status"], path)
+		assert.Equal(t, "a", res["id"], path)
+		stor.AssertExpectations(t)
+	}
+
+	// every version can filter by cancelled
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrders", mock.Anything, storage.OrderFilter{Status: storage.OrderStatusCancelled}).Return([]storage.Order{}, nil).Twice()
+		h := Handler(stor, nil, nil)
+		assert.Equal(t, http.StatusOK, get(h, "/v1/orders?status=cancelled").Code)
+		assert.Equal(t, http.StatusOK, get(h, "/v2/orders?status=cancelled").Code)
+		assert.Equal(t, http.StatusBadRequest, get(h, "/v2/orders?status=1").Code)
+		stor.AssertExpectations(t)
+	}
+
+	// exports have the status's name in v2 and its integer in v1
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("EachOrder", mock.Anything, storage.OrderFilter{Status: storage.OrderStatusAny}, mock.Anything).
+			Run(func(args mock.Arguments) {
+				args.Get(2).(func(storage.Order) error)(order)
+			}).Return(nil).Times(4)
+		h := Handler(stor, nil, nil)
+		assert.Contains(t, get(h, "/v2/orders/export").Body.String(), ",charged,")
+		assert.Contains(t, get(h, "/v1/orders/export").Body.String(), ",1,")
+		assert.Contains(t, get(h, "/v2/orders/export?format=ndjson").Body.String(), `"status":"charged"`)
+		assert.Contains(t, get(h, "/v1/orders/export?format=ndjson").Body.String(), `"status":1`)
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestAPIVersionFromContext(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	// requests that didn't go through a version's group are treated as v1
+	assert.Equal(t, apiVersion1, apiVersionFromContext(c))
+	withAPIVersion(apiVersion(2))(c)
+	assert.Equal(t, apiVersion(2), apiVersionFromContext(c))
+}
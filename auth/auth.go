@@ -0,0 +1,235 @@
+// Package auth identifies who is making a request from their API key or JWT.
+// It's shared by the HTTP and gRPC servers so both accept the same credentials
+// and grant the same scopes.
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/levenlabs/order-up/storage"
+)
+
+// These are the scopes that can be granted to API keys and JWTs. Each endpoint
+// requires exactly one of them.
+const (
+	// ScopeOrdersRead allows listing and getting orders
+	ScopeOrdersRead = "orders:read"
+	// ScopeOrdersWrite allows creating and fulfilling orders
+	ScopeOrdersWrite = "orders:write"
+	// ScopeOrdersCharge allows charging orders
+	ScopeOrdersCharge = "orders:charge"
+	// ScopeOrdersRefund allows cancelling and refunding orders
+	ScopeOrdersRefund = "orders:refund"
+	// ScopeAdmin allows managing API keys and promotions
+	ScopeAdmin = "admin"
+)
+
+// knownScopes is used to reject typos when creating API keys
+var knownScopes = map[string]bool{
+	ScopeOrdersRead:   true,
+	ScopeOrdersWrite:  true,
+	ScopeOrdersCharge: true,
+	ScopeOrdersRefund: true,
+	ScopeAdmin:        true,
+}
+
+// KnownScope returns true if scope is one of the scopes above
+func KnownScope(scope string) bool {
+	return knownScopes[scope]
+}
+
+// Config holds the keys used to verify JWTs. API keys are always accepted once
+// authentication is enabled since they're looked up in storage.
+type Config struct {
+	// HS256Secrets are the shared secrets HS256 tokens can be signed with. Having
+	// more than one allows rotating secrets without downtime.
+	HS256Secrets [][]byte
+	// RS256PublicKeys are the public keys RS256 tokens can be signed with
+	RS256PublicKeys []*rsa.PublicKey
+	// Issuer, if set, must match the token's iss claim
+	Issuer string
+	// Audience, if set, must be one of the token's aud claims
+	Audience string
+}
+
+// Principal is whoever authenticated the request
+type Principal struct {
+	// Actor identifies the principal in order history, like apikey:<id> or
+	// jwt:<subject>
+	Actor string
+	// Scopes are the scopes that were granted to the principal
+	Scopes map[string]bool
+	// CustomerEmail is set when the principal is a customer in which case they
+	// can only see and act on their own orders
+	CustomerEmail string
+}
+
+// CanAccessOrder returns false if the principal is a customer and the order
+// isn't theirs. Callers should respond as if the order doesn't exist so
+// customers can't discover other customers' order IDs.
+func (p Principal) CanAccessOrder(order storage.Order) bool {
+	return p.CustomerEmail == "" || strings.EqualFold(p.CustomerEmail, order.CustomerEmail)
+}
+
+// Store is the storage the Authenticator looks API keys up in. It's implemented
+// by *storage.Instance.
+type Store interface {
+	GetAPIKeyByHash(ctx context.Context, hash string) (storage.APIKey, error)
+}
+
+// Authenticator verifies API keys and JWTs
+type Authenticator struct {
+	store Store
+	cfg   Config
+}
+
+// New returns an Authenticator that looks API keys up in store and verifies
+// JWTs with the keys in cfg
+func New(store Store, cfg Config) *Authenticator {
+	return &Authenticator{store: store, cfg: cfg}
+}
+
+// StorageError wraps errors from storage while authenticating so they can be
+// told apart from invalid credentials
+type StorageError struct {
+	Err error
+}
+
+func (e StorageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e StorageError) Unwrap() error {
+	return e.Err
+}
+
+// Authenticate identifies the principal from either an API key or the value of
+// an Authorization header with a bearer JWT. The API key is used if both are
+// set. The error's message explains why the credentials were rejected, unless
+// it's a StorageError.
+func (a *Authenticator) Authenticate(ctx context.Context, apiKey, authorization string) (Principal, error) {
+	if apiKey != "" {
+		key, err := a.store.GetAPIKeyByHash(ctx, HashAPIKey(apiKey))
+		if errors.Is(err, storage.ErrAPIKeyNotFound) {
+			return Principal{}, errors.New("invalid api key")
+		} else if err != nil {
+			return Principal{}, StorageError{fmt.Errorf("error getting api key: %w", err)}
+		}
+		return Principal{
+			Actor:  "apikey:" + key.ID,
+			Scopes: scopeSet(key.Scopes),
+		}, nil
+	}
+
+	if authorization == "" {
+		return Principal{}, errors.New("missing credentials")
+	}
+	// the scheme is case-insensitive according to RFC 7235
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "bearer ") {
+		return Principal{}, errors.New("unsupported authorization scheme")
+	}
+	claims, err := a.verifyJWT(strings.TrimSpace(authorization[7:]))
+	if err != nil {
+		return Principal{}, err
+	}
+	p := Principal{
+		Actor: "jwt:" + claims.Subject,
+		// scope is a space-delimited list as described by RFC 8693
+		Scopes: scopeSet(strings.Fields(claims.Scope)),
+	}
+	if claims.Role == RoleCustomer {
+		p.CustomerEmail = storage.NormalizeEmail(claims.Email)
+	}
+	return p, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// RoleCustomer is the role claim given to shoppers' tokens
+const RoleCustomer = "customer"
+
+// Claims are the claims we expect in a JWT
+type Claims struct {
+	jwt.RegisteredClaims
+	Scope string `json:"scope"`
+	// Role is RoleCustomer for tokens issued to shoppers and empty for tokens
+	// issued to other services
+	Role string `json:"role,omitempty"`
+	// Email is the customer's email and is required for customer tokens
+	Email string `json:"email,omitempty"`
+}
+
+// verifyJWT checks the token's signature against every configured key until one
+// matches and then validates the claims
+func (a *Authenticator) verifyJWT(token string) (Claims, error) {
+	type candidate struct {
+		alg string
+		key interface{}
+	}
+	var candidates []candidate
+	for _, secret := range a.cfg.HS256Secrets {
+		candidates = append(candidates, candidate{jwt.SigningMethodHS256.Alg(), secret})
+	}
+	for _, key := range a.cfg.RS256PublicKeys {
+		candidates = append(candidates, candidate{jwt.SigningMethodRS256.Alg(), key})
+	}
+
+	err := errors.New("no keys configured to verify tokens")
+	for _, cand := range candidates {
+		var claims Claims
+		// limiting the valid methods to the key's algorithm prevents someone from
+		// signing an HS256 token with an RSA public key
+		_, err = jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+			return cand.key, nil
+		}, jwt.WithValidMethods([]string{cand.alg}))
+		if err != nil {
+			continue
+		}
+
+		// Valid already checked exp, nbf and iat if they're present but we don't
+		// want to accept tokens that never expire
+		if claims.ExpiresAt == nil {
+			return Claims{}, errors.New("invalid token: missing exp claim")
+		}
+		if claims.Subject == "" {
+			return Claims{}, errors.New("invalid token: missing sub claim")
+		}
+		// a customer token without an email would otherwise be able to see every
+		// order
+		if claims.Role == RoleCustomer && claims.Email == "" {
+			return Claims{}, errors.New("invalid token: missing email claim for customer")
+		}
+		if a.cfg.Issuer != "" && !claims.VerifyIssuer(a.cfg.Issuer, true) {
+			return Claims{}, errors.New("invalid token: unexpected issuer")
+		}
+		if a.cfg.Audience != "" && !claims.VerifyAudience(a.cfg.Audience, true) {
+			return Claims{}, errors.New("invalid token: unexpected audience")
+		}
+		return claims, nil
+	}
+	return Claims{}, fmt.Errorf("invalid token: %w", err)
+}
+
+// scopeSet converts a list of scopes into a set for quick lookups
+func scopeSet(scopes []string) map[string]bool {
+	set := make(map[string]bool, len(scopes))
+	for _, scope := range scopes {
+		set[scope] = true
+	}
+	return set
+}
+
+// HashAPIKey returns the hex-encoded SHA-256 hash of key
+// API keys are long random strings so a fast hash is fine, unlike passwords
+// they can't be brute-forced from the hash
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
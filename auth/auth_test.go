@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// testHS256Secret is the secret the tests sign tokens with
+var testHS256Secret = []byte("test-secret")
+
+// signToken returns a bearer authorization value for claims
+func signToken(t *testing.T, claims Claims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testHS256Secret)
+	require.NoError(t, err)
+	return "Bearer " + token
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{HS256Secrets: [][]byte{testHS256Secret}}
+	expiresAt := jwt.NewNumericDate(time.Now().Add(time.Hour))
+
+	// api keys are looked up by their hash and take precedence over tokens
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetAPIKeyByHash", mock.Anything, HashAPIKey("ou_key")).Return(storage.APIKey{
+			ID:     "1",
+			Scopes: []string{ScopeOrdersRead},
+		}, nil).Once()
+		p, err := New(stor, cfg).Authenticate(ctx, "ou_key", "Bearer nope")
+		require.NoError(t, err)
+		assert.Equal(t, "apikey:1", p.Actor)
+		assert.Equal(t, map[string]bool{ScopeOrdersRead: true}, p.Scopes)
+		assert.Empty(t, p.CustomerEmail)
+		stor.AssertExpectations(t)
+	}
+
+	// storage errors are a StorageError instead of invalid credentials
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetAPIKeyByHash", mock.Anything, HashAPIKey("ou_key")).Return(storage.APIKey{}, assert.AnError).Once()
+		_, err := New(stor, cfg).Authenticate(ctx, "ou_key", "")
+		assert.ErrorAs(t, err, new(StorageError))
+		assert.ErrorIs(t, err, assert.AnError)
+		stor.AssertExpectations(t)
+	}
+
+	// customer tokens are limited to the customer's orders
+	{
+		authz := signToken(t, Claims{
+			RegisteredClaims: jwt.RegisteredClaims{Subject: "cust", ExpiresAt: expiresAt},
+			Scope:            "orders:read orders:write",
+			Role:             RoleCustomer,
+			Email:            "test@test",
+		})
+		p, err := New(nil, cfg).Authenticate(ctx, "", authz)
+		require.NoError(t, err)
+		assert.Equal(t, "jwt:cust", p.Actor)
+		assert.True(t, p.Scopes[ScopeOrdersWrite])
+		assert.Equal(t, "test@test", p.CustomerEmail)
+		assert.True(t, p.CanAccessOrder(storage.Order{CustomerEmail: "test@test"}))
+		assert.False(t, p.CanAccessOrder(storage.Order{CustomerEmail: "other@test"}))
+		// emails are compared case-insensitively
+		assert.True(t, p.CanAccessOrder(storage.Order{CustomerEmail: "Test@Test"}))
+	}
+
+	// invalid credentials explain what's wrong
+	{
+		authn := New(nil, cfg)
+		_, err := authn.Authenticate(ctx, "", "")
+		assert.EqualError(t, err, "missing credentials")
+		_, err = authn.Authenticate(ctx, "", "Basic dXNlcjpwYXNz")
+		assert.EqualError(t, err, "unsupported authorization scheme")
+		_, err = authn.Authenticate(ctx, "", signToken(t, Claims{
+			RegisteredClaims: jwt.RegisteredClaims{Subject: "cust", ExpiresAt: expiresAt},
+			Role:             RoleCustomer,
+		}))
+		assert.EqualError(t, err, "invalid token: missing email claim for customer")
+		_, err = New(nil, Config{}).Authenticate(ctx, "", signToken(t, Claims{
+			RegisteredClaims: jwt.RegisteredClaims{Subject: "svc", ExpiresAt: expiresAt},
+		}))
+		assert.EqualError(t, err, "invalid token: no keys configured to verify tokens")
+	}
+}
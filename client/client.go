@@ -0,0 +1,264 @@
+// Package client is a Go client for the order-up HTTP API so other services
+// don't have to hand-write requests against /orders. Orders are returned as
+// storage.Order, errors from the API are returned as *Error which can be
+// compared to this package's sentinel errors with errors.Is, and writes are
+// sent with a generated Idempotency-Key so they can be retried safely.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Client makes requests to the order-up API. It's safe to use from multiple
+// goroutines.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	apiKey     string
+	token      string
+	// maxRetries is how many times a request is retried after the first attempt
+	maxRetries int
+	// minBackoff and maxBackoff bound the wait between attempts which doubles
+	// after every attempt
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	// newKey generates the Idempotency-Key for writes, it's a field so tests can
+	// predict the keys
+	newKey func() string
+}
+
+// Option changes how the Client makes requests
+type Option func(*Client)
+
+// WithAPIKey sends key in the X-API-Key header of every request
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithBearerToken sends token as a bearer JWT in the Authorization header of
+// every request
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient makes the requests with hc instead of http.DefaultClient, like
+// to set a timeout or a custom transport
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithRetries sets how many times a request is retried after the first attempt
+// and the bounds of the wait between attempts. Retries can be turned off with a
+// max of 0.
+func WithRetries(max int, minBackoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = max
+		c.minBackoff = minBackoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// New returns a Client for the API at baseURL, like http://localhost:8888. By
+// default requests are retried 3 times waiting between 100 milliseconds and 5
+// seconds.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		maxRetries: 3,
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 5 * time.Second,
+		newKey:     func() string { return uuid.New().String() },
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// CallOption changes a single request
+type CallOption func(*request)
+
+// WithIdempotencyKey sends key as the Idempotency-Key instead of a generated
+// one. It's useful for retrying a write after the process restarted, as long as
+// the key was saved, since the API returns the first response for up to a day.
+func WithIdempotencyKey(key string) CallOption {
+	return func(r *request) {
+		r.idempotencyKey = key
+	}
+}
+
+// request is a single API call which may be attempted multiple times
+type request struct {
+	method string
+	path   string
+	// body is encoded once so it can be sent again on every attempt
+	body []byte
+	// idempotencyKey is sent with every attempt so the API only handles the
+	// request once. It's empty for reads which are always safe to retry.
+	idempotencyKey string
+}
+
+// do makes the request, retrying if it fails in a way that's safe to retry,
+// and decodes the response into res if it's not nil
+func (c *Client) do(ctx context.Context, method, path string, body, res interface{}, opts ...CallOption) error {
+	req := request{method: method, path: path}
+	if body != nil {
+		byts, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error encoding body: %w", err)
+		}
+		req.body = byts
+	}
+	if method != http.MethodGet {
+		req.idempotencyKey = c.newKey()
+	}
+	for _, opt := range opts {
+		opt(&req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.attempt(ctx, req)
+		retry, wait := c.shouldRetry(ctx, attempt, resp, err)
+		if !retry {
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			return decodeResponse(resp, res)
+		}
+		if resp != nil {
+			// drain the body so the connection can be reused
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// attempt makes a single HTTP request for req
+func (c *Client) attempt(ctx context.Context, req request) (*http.Response, error) {
+	var body io.Reader
+	if req.body != nil {
+		body = bytes.NewReader(req.body)
+	}
+	r, err := http.NewRequestWithContext(ctx, req.method, c.baseURL+req.path, body)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	r.Header.Set("Accept", "application/json")
+	if req.body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if req.idempotencyKey != "" {
+		r.Header.Set("Idempotency-Key", req.idempotencyKey)
+	}
+	if c.apiKey != "" {
+		r.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.token != "" {
+		r.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.httpClient.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %w", err)
+	}
+	return resp, nil
+}
+
+// shouldRetry returns whether the attempt should be retried and how long to
+// wait first. Connection errors, rate limits, unavailable gateways and
+// requests still in progress with the same Idempotency-Key are retried. Other
+// server errors aren't since the API might've done part of the work, like
+// charging a card, before failing.
+func (c *Client) shouldRetry(ctx context.Context, attempt int, resp *http.Response, err error) (bool, time.Duration) {
+	if attempt >= c.maxRetries || ctx.Err() != nil {
+		return false, 0
+	}
+	wait := c.backoff(attempt)
+	if err != nil {
+		return true, wait
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		// the API says exactly how long to wait when it knows
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+			wait = time.Duration(secs) * time.Second
+		}
+		return true, wait
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return true, wait
+	case http.StatusConflict:
+		// the body has to be read to know why and it's replaced so the error
+		// can still be decoded if this is the last attempt
+		byts, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(byts))
+		var res errorRes
+		if json.Unmarshal(byts, &res) == nil && res.Error != nil && res.Error.Code == codeIdempotencyKeyInUse {
+			return true, wait
+		}
+	}
+	return false, 0
+}
+
+// backoff returns how long to wait before retrying after attempt, which starts
+// at 0. It's between half and all of minBackoff doubled for every attempt, up to
+// maxBackoff, so clients that failed at the same time don't retry at the same
+// time.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.minBackoff
+	for i := 0; i < attempt && d < c.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// decodeResponse decodes a successful response into res or returns the *Error
+// for an unsuccessful one
+func decodeResponse(resp *http.Response, res interface{}) error {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newError(resp)
+	}
+	if res == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingServer responds to each request with the next of responses, after
+// the last one it keeps repeating it, and records the requests' headers
+type recordingServer struct {
+	mu        sync.Mutex
+	responses []func(w http.ResponseWriter)
+	headers   []http.Header
+}
+
+func (s *recordingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.headers = append(s.headers, r.Header.Clone())
+	idx := len(s.headers) - 1
+	if idx >= len(s.responses) {
+		idx = len(s.responses) - 1
+	}
+	s.responses[idx](w)
+}
+
+// respond returns a response with the status and JSON body
+func respond(status int, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+	newClient := func(rs *recordingServer, opts ...Option) *Client {
+		srv := httptest.NewServer(rs)
+		t.Cleanup(srv.Close)
+		opts = append([]Option{WithRetries(3, time.Millisecond, time.Millisecond)}, opts...)
+		return New(srv.URL+"/", opts...)
+	}
+	orderBody := `{"order":{"id":"a","customerEmail":"","currency":"USD","lineItems":[],"status":"pending","version":1}}`
+
+	// unavailable responses are retried with the same Idempotency-Key and the
+	// credentials are sent every time
+	{
+		rs := &recordingServer{responses: []func(http.ResponseWriter){
+			respond(http.StatusServiceUnavailable, `{"error":{"code":"unavailable","message":"service is not ready"}}`),
+			respond(http.StatusConflict, `{"error":{"code":"idempotency_key_in_use","message":"in progress"}}`),
+			respond(http.StatusCreated, orderBody),
+		}}
+		c := newClient(rs, WithAPIKey("ou_key"))
+		order, err := c.CreateOrder(ctx, CreateOrderRequest{CustomerEmail: "test@test"})
+		require.NoError(t, err)
+		assert.Equal(t, "a", order.ID)
+		if assert.Len(t, rs.headers, 3) {
+			key := rs.headers[0].Get("Idempotency-Key")
+			assert.NotEmpty(t, key)
+			for _, h := range rs.headers {
+				assert.Equal(t, key, h.Get("Idempotency-Key"))
+				assert.Equal(t, "ou_key", h.Get("X-API-Key"))
+			}
+		}
+	}
+
+	// each call gets its own key unless one is given
+	{
+		rs := &recordingServer{responses: []func(http.ResponseWriter){respond(http.StatusCreated, orderBody)}}
+		c := newClient(rs, WithBearerToken("jwt"))
+		_, err := c.CreateOrder(ctx, CreateOrderRequest{})
+		require.NoError(t, err)
+		_, err = c.CreateOrder(ctx, CreateOrderRequest{})
+		require.NoError(t, err)
+		_, err = c.CreateOrder(ctx, CreateOrderRequest{}, WithIdempotencyKey("mine"))
+		require.NoError(t, err)
+		if assert.Len(t, rs.headers, 3) {
+			assert.NotEqual(t, rs.headers[0].Get("Idempotency-Key"), rs.headers[1].Get("Idempotency-Key"))
+			assert.Equal(t, "mine", rs.headers[2].Get("Idempotency-Key"))
+			assert.Equal(t, "Bearer jwt", rs.headers[0].Get("Authorization"))
+		}
+	}
+
+	// reads don't have a key and the last error is returned once the retries
+	// run out
+	{
+		rs := &recordingServer{responses: []func(http.ResponseWriter){
+			func(w http.ResponseWriter) {
+				w.Header().Set("Retry-After", "0")
+				respond(http.StatusTooManyRequests, `{"error":{"code":"rate_limited","message":"rate limit exceeded"}}`)(w)
+			},
+		}}
+		c := newClient(rs)
+		_, err := c.GetOrder(ctx, "a")
+		assert.ErrorIs(t, err, ErrRateLimited)
+		if assert.Len(t, rs.headers, 4) {
+			assert.Empty(t, rs.headers[0].Get("Idempotency-Key"))
+		}
+	}
+
+	// other server errors and other conflicts aren't retried
+	for _, res := range []func(http.ResponseWriter){
+		respond(http.StatusInternalServerError, `{"error":{"code":"charge_failed","message":"error charging order"}}`),
+		respond(http.StatusConflict, `{"error":{"code":"invalid_transition","message":"order ineligible for charging"}}`),
+	} {
+		rs := &recordingServer{responses: []func(http.ResponseWriter){res}}
+		c := newClient(rs)
+		_, err := c.Charge(ctx, "a", "tok")
+		assert.Error(t, err)
+		assert.Len(t, rs.headers, 1)
+	}
+
+	// responses that aren't JSON errors still return an *Error
+	{
+		rs := &recordingServer{responses: []func(http.ResponseWriter){
+			func(w http.ResponseWriter) { http.Error(w, "teapot", http.StatusTeapot) },
+		}}
+		c := newClient(rs)
+		err := c.Fulfill(ctx, "a")
+		assert.EqualError(t, err, "order-up: 418 I'm a teapot")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := New("http://localhost", WithRetries(5, 100*time.Millisecond, time.Second))
+	for attempt, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		d := c.backoff(attempt)
+		assert.True(t, d >= max/2 && d <= max, "attempt %d: %v", attempt, d)
+	}
+}
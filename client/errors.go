@@ -0,0 +1,110 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// These are the errors an *Error can be compared to with errors.Is. Errors
+// without a matching sentinel can still be told apart by their Code.
+var (
+	// ErrInvalidRequest means the request couldn't be parsed
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrValidationFailed means some fields are invalid, see the Error's Details
+	ErrValidationFailed = errors.New("validation failed")
+	// ErrUnauthenticated means the client's credentials are missing or invalid
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrForbidden means the credentials don't have the scope for the request
+	ErrForbidden = errors.New("forbidden")
+	// ErrOrderNotFound means the order doesn't exist or belongs to another
+	// customer
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrOrderExists means an order with the same ID already exists
+	ErrOrderExists = errors.New("order already exists")
+	// ErrInvalidTransition means the order's status doesn't allow the request,
+	// like charging an order that was already charged
+	ErrInvalidTransition = errors.New("invalid transition")
+	// ErrVersionConflict means the order changed since it was read
+	ErrVersionConflict = errors.New("version conflict")
+	// ErrChargeDeclined means the card was declined
+	ErrChargeDeclined = errors.New("charge declined")
+	// ErrRateLimited means the client made too many requests and ran out of
+	// retries
+	ErrRateLimited = errors.New("rate limited")
+	// ErrIdempotencyKeyReused means the Idempotency-Key was already used for a
+	// different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused")
+)
+
+// codeIdempotencyKeyInUse is the code for a request that's still in progress
+// with the same Idempotency-Key, it's retried rather than returned
+const codeIdempotencyKeyInUse = "idempotency_key_in_use"
+
+// codeErrors maps the API's error codes to the sentinel errors
+var codeErrors = map[string]error{
+	"invalid_request":        ErrInvalidRequest,
+	"validation_failed":      ErrValidationFailed,
+	"unauthenticated":        ErrUnauthenticated,
+	"forbidden":              ErrForbidden,
+	"order_not_found":        ErrOrderNotFound,
+	"order_exists":           ErrOrderExists,
+	"invalid_transition":     ErrInvalidTransition,
+	"version_conflict":       ErrVersionConflict,
+	"charge_declined":        ErrChargeDeclined,
+	"rate_limited":           ErrRateLimited,
+	"idempotency_key_reused": ErrIdempotencyKeyReused,
+}
+
+// FieldError describes a single invalid field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is returned when the API responds with an error
+type Error struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int `json:"-"`
+	// Code is the API's stable identifier for the error, like order_not_found.
+	// It's empty if the response wasn't a JSON error, like from a proxy.
+	Code string `json:"code"`
+	// Message is a human readable description of the error
+	Message string `json:"message"`
+	// Details lists the invalid fields when Code is validation_failed
+	Details []FieldError `json:"details,omitempty"`
+	// RequestID can be used to find the request in the API's logs
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("order-up: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("order-up: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is lets errors.Is compare e to the sentinel error for its code
+func (e *Error) Is(target error) bool {
+	sentinel, ok := codeErrors[e.Code]
+	return ok && sentinel == target
+}
+
+// errorRes is the body of every error response
+type errorRes struct {
+	Error *Error `json:"error"`
+}
+
+// newError returns the *Error for an unsuccessful response
+func newError(resp *http.Response) *Error {
+	byts, _ := ioutil.ReadAll(resp.Body)
+	var res errorRes
+	if err := json.Unmarshal(byts, &res); err != nil || res.Error == nil {
+		return &Error{StatusCode: resp.StatusCode}
+	}
+	res.Error.StatusCode = resp.StatusCode
+	return res.Error
+}
@@ -0,0 +1,200 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/levenlabs/order-up/storage"
+)
+
+// CreateOrderRequest is the body of POST /v2/orders
+type CreateOrderRequest struct {
+	CustomerEmail string `json:"customerEmail"`
+	// Currency defaults to USD if it's not set
+	Currency storage.Currency `json:"currency,omitempty"`
+	// Jurisdiction decides how the order is taxed. It defaults to the shipping
+	// address's jurisdiction.
+	Jurisdiction    string             `json:"jurisdiction,omitempty"`
+	ShippingAddress *storage.Address   `json:"shippingAddress,omitempty"`
+	BillingAddress  *storage.Address   `json:"billingAddress,omitempty"`
+	ShippingMethod  string             `json:"shippingMethod,omitempty"`
+	LineItems       []storage.LineItem `json:"lineItems"`
+	PromoCodes      []string           `json:"promoCodes,omitempty"`
+}
+
+// orderRes is the response of the endpoints that return a single order
+type orderRes struct {
+	Order storage.Order `json:"order"`
+}
+
+// CreateOrder creates a pending order and returns it with its ID, tax,
+// shipping and discount line items filled in
+func (c *Client) CreateOrder(ctx context.Context, req CreateOrderRequest, opts ...CallOption) (storage.Order, error) {
+	var res orderRes
+	if err := c.do(ctx, http.MethodPost, "/v2/orders", req, &res, opts...); err != nil {
+		return storage.Order{}, err
+	}
+	return res.Order, nil
+}
+
+// GetOrder returns the order with the given ID. If it doesn't exist the error
+// matches ErrOrderNotFound.
+func (c *Client) GetOrder(ctx context.Context, id string) (storage.Order, error) {
+	var res orderRes
+	if err := c.do(ctx, http.MethodGet, orderPath(id), nil, &res); err != nil {
+		return storage.Order{}, err
+	}
+	return res.Order, nil
+}
+
+// ChargeResult is the result of charging an order
+type ChargeResult struct {
+	ChargedCents int64            `json:"chargedCents"`
+	Currency     storage.Currency `json:"currency"`
+	// ChargeID is the charge service's ID for the charge, it's empty if the
+	// order's total was zero
+	ChargeID string `json:"chargeID"`
+}
+
+// Charge charges the card for the pending order with the given ID and marks it
+// as charged
+func (c *Client) Charge(ctx context.Context, id, cardToken string, opts ...CallOption) (ChargeResult, error) {
+	body := struct {
+		CardToken string `json:"cardToken"`
+	}{CardToken: cardToken}
+	var res ChargeResult
+	if err := c.do(ctx, http.MethodPost, orderPath(id)+"/charge", body, &res, opts...); err != nil {
+		return ChargeResult{}, err
+	}
+	return res, nil
+}
+
+// CancelResult is the result of cancelling an order
+type CancelResult struct {
+	// ChargedCents is negative for the amount that was refunded to the card and
+	// 0 if the order wasn't charged yet
+	ChargedCents int64            `json:"chargedCents"`
+	Currency     storage.Currency `json:"currency"`
+	// RefundIDs are the charge service's IDs for the refunds that were made
+	RefundIDs []string `json:"refundIDs"`
+}
+
+// Cancel cancels the order with the given ID and, if the order was already
+// charged, refunds its charges back to the cards they were made on
+func (c *Client) Cancel(ctx context.Context, id string, opts ...CallOption) (CancelResult, error) {
+	var res CancelResult
+	if err := c.do(ctx, http.MethodPost, orderPath(id)+"/cancel", nil, &res, opts...); err != nil {
+		return CancelResult{}, err
+	}
+	return res, nil
+}
+
+// Fulfill ships every line item of the charged order with the given ID and
+// marks it as fulfilled
+func (c *Client) Fulfill(ctx context.Context, id string, opts ...CallOption) error {
+	return c.do(ctx, http.MethodPut, orderPath(id)+"/fulfill", nil, nil, opts...)
+}
+
+// orderPath returns the path of the order with the given ID
+func orderPath(id string) string {
+	return "/v2/orders/" + url.PathEscape(id)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// DefaultPageSize is how many orders ListOrders gets per request unless
+// ListOrdersOptions.PageSize is set
+const DefaultPageSize = 100
+
+// ListOrdersOptions limits which orders ListOrders returns
+type ListOrdersOptions struct {
+	// Status, if set, limits the orders to ones with this status, like charged
+	Status string
+	// PageSize is how many orders are requested at a time, it defaults to
+	// DefaultPageSize
+	PageSize int
+}
+
+// listOrdersRes is the response of GET /v2/orders
+type listOrdersRes struct {
+	Orders     []storage.Order `json:"orders"`
+	NextCursor string          `json:"nextCursor"`
+}
+
+// OrderIterator goes through the orders a page at a time. It's used like:
+//
+//	it := c.ListOrders(opts)
+//	for it.Next(ctx) {
+//		order := it.Order()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type OrderIterator struct {
+	c    *Client
+	opts ListOrdersOptions
+	// page is the current page and idx is the index of the next order in it
+	page []storage.Order
+	idx  int
+	// after is the cursor for the next page and done is set once there isn't one
+	after string
+	done  bool
+	order storage.Order
+	err   error
+}
+
+// ListOrders returns an iterator over the orders matching opts, sorted by ID.
+// Nothing is requested until Next is called.
+func (c *Client) ListOrders(opts ListOrdersOptions) *OrderIterator {
+	if opts.PageSize <= 0 {
+		opts.PageSize = DefaultPageSize
+	}
+	return &OrderIterator{c: c, opts: opts}
+}
+
+// Next moves to the next order, getting the next page if needed, and returns
+// false once there are no more orders or an error happened
+func (it *OrderIterator) Next(ctx context.Context) bool {
+	for it.idx >= len(it.page) {
+		if it.done || it.err != nil {
+			return false
+		}
+		it.err = it.fetch(ctx)
+	}
+	it.order = it.page[it.idx]
+	it.idx++
+	return true
+}
+
+// fetch gets the page after the current one
+func (it *OrderIterator) fetch(ctx context.Context) error {
+	q := url.Values{}
+	q.Set("limit", strconv.Itoa(it.opts.PageSize))
+	if it.after != "" {
+		q.Set("after", it.after)
+	}
+	if it.opts.Status != "" {
+		q.Set("status", it.opts.Status)
+	}
+	var res listOrdersRes
+	if err := it.c.do(ctx, http.MethodGet, "/v2/orders?"+q.Encode(), nil, &res); err != nil {
+		return err
+	}
+	it.page = res.Orders
+	it.idx = 0
+	it.after = res.NextCursor
+	it.done = res.NextCursor == ""
+	return nil
+}
+
+// Order returns the order Next moved to
+func (it *OrderIterator) Order() storage.Order {
+	return it.order
+}
+
+// Err returns the error that stopped Next, if any
+func (it *OrderIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,232 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/levenlabs/order-up/api"
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/services"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClient returns a Client for api.Handler with stor and the given
+// services that retries without waiting
+func newTestClient(t *testing.T, stor *mocks.MockStorageInstance, fulfillmentService services.FulfillmentService, chargeService services.ChargeService) *Client {
+	srv := httptest.NewServer(api.Handler(stor, fulfillmentService, chargeService))
+	t.Cleanup(srv.Close)
+	return New(srv.URL, WithRetries(3, time.Millisecond, time.Millisecond))
+}
+
+// expectIdempotent sets up the idempotency record calls for a write that
+// succeeds
+func expectIdempotent(stor *mocks.MockStorageInstance) {
+	stor.On("InsertIdempotencyRecord", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+	stor.On("CompleteIdempotencyRecord", mock.Anything, mock.Anything).Return(nil).Once()
+}
+
+func TestCreateOrder(t *testing.T) {
+	ctx := context.Background()
+	order := storage.Order{
+		CustomerEmail: "test@test",
+		Currency:      storage.DefaultCurrency,
+		LineItems:     []storage.LineItem{{Description: "item 1", Quantity: 1, PriceCents: 1000}},
+	}
+
+	// the order is returned with its ID and a generated Idempotency-Key is sent
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("InsertIdempotencyRecord", mock.Anything, mock.MatchedBy(func(rec storage.IdempotencyRecord) bool {
+			return len(rec.Key) > len("0::")
+		}), mock.Anything).Return(nil).Once()
+		stor.On("CompleteIdempotencyRecord", mock.Anything, mock.Anything).Return(nil).Once()
+		stor.On("InsertOrder", mock.Anything, order).Return("random", nil).Once()
+		c := newTestClient(t, stor, nil, nil)
+		got, err := c.CreateOrder(ctx, CreateOrderRequest{CustomerEmail: order.CustomerEmail, LineItems: order.LineItems})
+		require.NoError(t, err)
+		assert.Equal(t, "random", got.ID)
+		assert.Equal(t, order.LineItems, got.LineItems)
+		stor.AssertExpectations(t)
+	}
+
+	// invalid orders return the details
+	{
+		stor := new(mocks.MockStorageInstance)
+		expectIdempotent(stor)
+		c := newTestClient(t, stor, nil, nil)
+		_, err := c.CreateOrder(ctx, CreateOrderRequest{CustomerEmail: "test@test"})
+		assert.ErrorIs(t, err, ErrValidationFailed)
+		var apiErr *Error
+		if assert.ErrorAs(t, err, &apiErr) {
+			assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+			assert.NotEmpty(t, apiErr.Details)
+		}
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestGetOrder(t *testing.T) {
+	ctx := context.Background()
+	order := storage.Order{
+		ID:        "a",
+		Currency:  storage.DefaultCurrency,
+		LineItems: []storage.LineItem{},
+		Status:    storage.OrderStatusCharged,
+		Version:   2,
+	}
+
+	stor := new(mocks.MockStorageInstance)
+	stor.On("GetOrder", mock.Anything, "a").Return(order, nil).Once()
+	stor.On("GetOrder", mock.Anything, "b").Return(storage.Order{}, storage.ErrOrderNotFound).Once()
+	c := newTestClient(t, stor, nil, nil)
+
+	got, err := c.GetOrder(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, order, got)
+
+	_, err = c.GetOrder(ctx, "b")
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+	assert.False(t, errors.Is(err, ErrOrderExists))
+	var apiErr *Error
+	if assert.ErrorAs(t, err, &apiErr) {
+		assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+		assert.Equal(t, "order_not_found", apiErr.Code)
+		assert.NotEmpty(t, apiErr.RequestID)
+	}
+	stor.AssertExpectations(t)
+}
+
+func TestListOrders(t *testing.T) {
+	ctx := context.Background()
+	orders := []storage.Order{
+		{ID: "a", LineItems: []storage.LineItem{}, Status: storage.OrderStatusCharged},
+		{ID: "b", LineItems: []storage.LineItem{}, Status: storage.OrderStatusCharged},
+		{ID: "c", LineItems: []storage.LineItem{}, Status: storage.OrderStatusCharged},
+	}
+
+	// every page is requested until there's no cursor
+	{
+		stor := new(mocks.MockStorageInstance)
+		filter := storage.OrderFilter{Status: storage.OrderStatusCharged, Limit: 2}
+		stor.On("GetOrders", mock.Anything, filter).Return(orders[:2], nil).Once()
+		filter.AfterID = "b"
+		stor.On("GetOrders", mock.Anything, filter).Return(orders[2:], nil).Once()
+		c := newTestClient(t, stor, nil, nil)
+
+		it := c.ListOrders(ListOrdersOptions{Status: "charged", PageSize: 2})
+		var ids []string
+		for it.Next(ctx) {
+			ids = append(ids, it.Order().ID)
+		}
+		require.NoError(t, it.Err())
+		assert.Equal(t, []string{"a", "b", "c"}, ids)
+		stor.AssertExpectations(t)
+	}
+
+	// errors stop the iterator
+	{
+		stor := new(mocks.MockStorageInstance)
+		c := newTestClient(t, stor, nil, nil)
+		it := c.ListOrders(ListOrdersOptions{Status: "unknown"})
+		assert.False(t, it.Next(ctx))
+		assert.ErrorIs(t, it.Err(), ErrInvalidRequest)
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestChargeCancelFulfill(t *testing.T) {
+	ctx := context.Background()
+	order := storage.Order{
+		ID:        "a",
+		LineItems: []storage.LineItem{{Description: "item 1", Quantity: 2, PriceCents: 500}},
+		Status:    storage.OrderStatusPending,
+		Version:   1,
+	}
+	// the charge service accepts every charge and refund
+	var amounts []int64
+	chgServ := services.NewHTTPChargeService(mocks.NewMockedService(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var args struct {
+			AmountCents int64 `json:"amountCents"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&args))
+		charge := services.Charge{ID: "ch_1", AmountCents: args.AmountCents}
+		if r.URL.Path == "/charges/ch_1/refunds" {
+			charge = services.Charge{ID: "re_1", AmountCents: -args.AmountCents}
+		}
+		amounts = append(amounts, charge.AmountCents)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(charge)
+	})))
+	fulfillServ := services.NewHTTPFulfillmentService(mocks.NewMockedService(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	// charging a pending order
+	{
+		stor := new(mocks.MockStorageInstance)
+		expectIdempotent(stor)
+		stor.On("GetOrder", mock.Anything, "a").Return(order, nil).Once()
+		stor.On("SetOrderStatus", mock.Anything, "a", storage.OrderStatusCharging, int64(1)).Return(nil).Once()
+		stor.On("AddOrderPayment", mock.Anything, "a", mock.Anything).Return(nil).Once()
+		stor.On("SetOrderStatus", mock.Anything, "a", storage.OrderStatusCharged, int64(3)).Return(nil).Once()
+		c := newTestClient(t, stor, fulfillServ, chgServ)
+		res, err := c.Charge(ctx, "a", "tok")
+		require.NoError(t, err)
+		assert.Equal(t, ChargeResult{ChargedCents: 1000, Currency: storage.DefaultCurrency, ChargeID: "ch_1"}, res)
+		stor.AssertExpectations(t)
+	}
+
+	// charging it again is an invalid transition
+	charged := order
+	charged.Status = storage.OrderStatusCharged
+	charged.Version = 2
+	charged.Payments = []storage.Payment{
+		{ID: "ch_1", Kind: storage.PaymentKindCharge, Amount: storage.Money{Amount: 1000, Currency: storage.DefaultCurrency}},
+	}
+	{
+		stor := new(mocks.MockStorageInstance)
+		expectIdempotent(stor)
+		stor.On("GetOrder", mock.Anything, "a").Return(charged, nil).Once()
+		c := newTestClient(t, stor, fulfillServ, chgServ)
+		_, err := c.Charge(ctx, "a", "tok")
+		assert.ErrorIs(t, err, ErrInvalidTransition)
+		stor.AssertExpectations(t)
+	}
+
+	// fulfilling the charged order
+	{
+		stor := new(mocks.MockStorageInstance)
+		expectIdempotent(stor)
+		stor.On("GetOrder", mock.Anything, "a").Return(charged, nil).Once()
+		stor.On("SetOrderStatus", mock.Anything, "a", storage.OrderStatusFulfilling, int64(2)).Return(nil).Once()
+		stor.On("SetOrderStatus", mock.Anything, "a", storage.OrderStatusFulfilled, int64(3)).Return(nil).Once()
+		c := newTestClient(t, stor, fulfillServ, chgServ)
+		require.NoError(t, c.Fulfill(ctx, "a"))
+		stor.AssertExpectations(t)
+	}
+
+	// cancelling the charged order refunds its charge without needing the card
+	{
+		stor := new(mocks.MockStorageInstance)
+		expectIdempotent(stor)
+		stor.On("GetOrder", mock.Anything, "a").Return(charged, nil).Once()
+		stor.On("SetOrderStatus", mock.Anything, "a", storage.OrderStatusCancelling, int64(2)).Return(nil).Once()
+		stor.On("AddOrderPayment", mock.Anything, "a", mock.Anything).Return(nil).Once()
+		stor.On("SetOrderStatus", mock.Anything, "a", storage.OrderStatusCancelled, int64(4)).Return(nil).Once()
+		c := newTestClient(t, stor, fulfillServ, chgServ)
+		res, err := c.Cancel(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, CancelResult{ChargedCents: -1000, Currency: storage.DefaultCurrency, RefundIDs: []string{"re_1"}}, res)
+		stor.AssertExpectations(t)
+	}
+
+	assert.Equal(t, []int64{1000, -1000}, amounts)
+}
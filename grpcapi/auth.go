@@ -0,0 +1,136 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/levenlabs/order-up/auth"
+	"github.com/levenlabs/order-up/grpcapi/orderspb"
+	"github.com/levenlabs/order-up/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// methodScopes is the scope each method requires, which is the same as the
+// scope of its HTTP endpoint
+var methodScopes = map[string]string{
+	orderspb.OrderService_CreateOrder_FullMethodName:  auth.ScopeOrdersWrite,
+	orderspb.OrderService_GetOrder_FullMethodName:     auth.ScopeOrdersRead,
+	orderspb.OrderService_ListOrders_FullMethodName:   auth.ScopeOrdersRead,
+	orderspb.OrderService_ChargeOrder_FullMethodName:  auth.ScopeOrdersCharge,
+	orderspb.OrderService_CancelOrder_FullMethodName:  auth.ScopeOrdersRefund,
+	orderspb.OrderService_FulfillOrder_FullMethodName: auth.ScopeOrdersWrite,
+	orderspb.OrderService_WatchOrder_FullMethodName:   auth.ScopeOrdersRead,
+}
+
+// WithAuth requires every call to have an API key in the x-api-key metadata or
+// a JWT in the authorization metadata, like the HTTP API's headers. The
+// credentials are checked by UnaryInterceptor and StreamInterceptor, so they
+// have to be passed to grpc.NewServer, and calls without them are rejected.
+func WithAuth(authn *auth.Authenticator) Option {
+	return func(s *Server) {
+		s.auth = authn
+	}
+}
+
+// UnaryInterceptor authenticates unary calls to a Server and checks that the
+// caller has the method's scope. Calls to other services are passed through.
+func UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	s, ok := info.Server.(*Server)
+	if !ok {
+		return handler(ctx, req)
+	}
+	ctx, err := s.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor is the UnaryInterceptor for streaming calls
+func StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	s, ok := srv.(*Server)
+	if !ok {
+		return handler(srv, ss)
+	}
+	ctx, err := s.authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authorizedStream replaces the context of a stream with one that has the
+// principal
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context implements grpc.ServerStream
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// principalKey is the context key the authenticated principal is stored under
+type principalKey struct{}
+
+// authorize authenticates the credentials in the call's metadata and checks that
+// the principal was granted the scope for method. The returned context has the
+// principal for the handler.
+func (s *Server) authorize(ctx context.Context, method string) (context.Context, error) {
+	if s.auth == nil {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	p, err := s.auth.Authenticate(ctx, firstValue(md, "x-api-key"), firstValue(md, "authorization"))
+	if err != nil {
+		var storErr auth.StorageError
+		if errors.As(err, &storErr) {
+			return nil, statusError(storErr.Err)
+		}
+		return nil, reasonError(codes.Unauthenticated, ReasonUnauthenticated, err.Error(), nil)
+	}
+	// methods without a scope can't be called at all rather than being public
+	scope, ok := methodScopes[method]
+	if !ok || !p.Scopes[scope] {
+		return nil, reasonError(codes.PermissionDenied, ReasonForbidden, fmt.Sprintf("missing required scope %s", scope), nil)
+	}
+	return context.WithValue(ctx, principalKey{}, p), nil
+}
+
+// principal returns the principal authorize stored in ctx. If authentication
+// is enabled but there isn't one then the interceptors weren't passed to
+// grpc.NewServer and the call is rejected instead of being treated as public.
+func (s *Server) principal(ctx context.Context) (auth.Principal, error) {
+	if s.auth == nil {
+		return auth.Principal{}, nil
+	}
+	p, ok := ctx.Value(principalKey{}).(auth.Principal)
+	if !ok {
+		return auth.Principal{}, reasonError(codes.Unauthenticated, ReasonUnauthenticated, "missing credentials", nil)
+	}
+	return p, nil
+}
+
+// actorContext records who made the call on any history entries written for it.
+// That's the principal if there is one, otherwise it's Actor.
+func actorContext(ctx context.Context, p auth.Principal) context.Context {
+	if p.Actor == "" {
+		return storage.WithActor(ctx, Actor)
+	}
+	return storage.WithActor(ctx, p.Actor)
+}
+
+// firstValue returns the first value for key in md or an empty string
+func firstValue(md metadata.MD, key string) string {
+	if vals := md.Get(key); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
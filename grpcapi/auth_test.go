@@ -0,0 +1,225 @@
+package grpcapi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/levenlabs/order-up/auth"
+	"github.com/levenlabs/order-up/grpcapi/orderspb"
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/orders"
+	"github.com/levenlabs/order-up/services"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// testHS256Secret is the secret the tests sign customer tokens with
+var testHS256Secret = []byte("test-secret")
+
+// withAPIKey returns a context that sends key in the x-api-key metadata
+func withAPIKey(ctx context.Context, key string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "x-api-key", key)
+}
+
+// withCustomerToken returns a context that sends a customer JWT for email with
+// the given scopes in the authorization metadata
+func withCustomerToken(t *testing.T, ctx context.Context, email, scope string) context.Context {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "customer-1",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Scope: scope,
+		Role:  auth.RoleCustomer,
+		Email: email,
+	}).SignedString(testHS256Secret)
+	require.NoError(t, err)
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+// withAuth returns the WithAuth option for stor and the test secret
+func withAuth(stor mocks.StorageInstance) Option {
+	return WithAuth(auth.New(stor, auth.Config{HS256Secrets: [][]byte{testHS256Secret}}))
+}
+
+func TestAuth(t *testing.T) {
+	ctx := context.Background()
+
+	// calls without credentials are Unauthenticated
+	{
+		stor := new(mocks.MockStorageInstance)
+		client := newClient(t, stor, nil, nil, withAuth(stor))
+		_, err := client.GetOrder(ctx, &orderspb.GetOrderRequest{Id: "a"})
+		assertStatus(t, err, codes.Unauthenticated, ReasonUnauthenticated)
+
+		stream, err := client.WatchOrder(ctx, &orderspb.WatchOrderRequest{Id: "a"})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assertStatus(t, err, codes.Unauthenticated, ReasonUnauthenticated)
+		stor.AssertExpectations(t)
+	}
+
+	// an unknown api key is Unauthenticated
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetAPIKeyByHash", mock.Anything, auth.HashAPIKey("ou_unknown")).Return(storage.APIKey{}, storage.ErrAPIKeyNotFound).Once()
+		client := newClient(t, stor, nil, nil, withAuth(stor))
+		_, err := client.GetOrder(withAPIKey(ctx, "ou_unknown"), &orderspb.GetOrderRequest{Id: "a"})
+		st := assertStatus(t, err, codes.Unauthenticated, ReasonUnauthenticated)
+		assert.Equal(t, "invalid api key", st.Message())
+		stor.AssertExpectations(t)
+	}
+
+	// a key without the method's scope is PermissionDenied
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetAPIKeyByHash", mock.Anything, auth.HashAPIKey("ou_reader")).Return(storage.APIKey{
+			ID:     "1",
+			Scopes: []string{auth.ScopeOrdersRead},
+		}, nil).Once()
+		client := newClient(t, stor, nil, nil, withAuth(stor))
+		_, err := client.CancelOrder(withAPIKey(ctx, "ou_reader"), &orderspb.CancelOrderRequest{Id: "a"})
+		st := assertStatus(t, err, codes.PermissionDenied, ReasonForbidden)
+		assert.Equal(t, "missing required scope orders:refund", st.Message())
+		stor.AssertExpectations(t)
+	}
+
+	// a key with the scope is recorded as the actor
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetAPIKeyByHash", mock.Anything, auth.HashAPIKey("ou_writer")).Return(storage.APIKey{
+			ID:     "1",
+			Scopes: []string{auth.ScopeOrdersWrite},
+		}, nil).Once()
+		byKey := mock.MatchedBy(func(ctx context.Context) bool {
+			return storage.ActorFromContext(ctx) == "apikey:1"
+		})
+		stor.On("InsertOrder", byKey, mock.Anything).Return("a", nil).Once()
+		client := newClient(t, stor, nil, nil, withAuth(stor))
+		res, err := client.CreateOrder(withAPIKey(ctx, "ou_writer"), &orderspb.CreateOrderRequest{
+			CustomerEmail: "test@test",
+			LineItems:     []*orderspb.LineItem{{Description: "item", Quantity: 1, PriceCents: 100}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "a", res.Order.Id)
+		stor.AssertExpectations(t)
+	}
+
+	// errors looking up the key aren't blamed on the credentials
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetAPIKeyByHash", mock.Anything, auth.HashAPIKey("ou_reader")).Return(storage.APIKey{}, storage.ErrSchemaNotReady).Once()
+		client := newClient(t, stor, nil, nil, withAuth(stor))
+		_, err := client.GetOrder(withAPIKey(ctx, "ou_reader"), &orderspb.GetOrderRequest{Id: "a"})
+		assertStatus(t, err, codes.Unavailable, ReasonUnavailable)
+		stor.AssertExpectations(t)
+	}
+
+	// without the interceptors calls are rejected instead of being public
+	{
+		stor := new(mocks.MockStorageInstance)
+		srv := NewServer(orders.New(stor, nil, nil), withAuth(stor))
+		_, err := srv.GetOrder(ctx, &orderspb.GetOrderRequest{Id: "a"})
+		assertStatus(t, err, codes.Unauthenticated, ReasonUnauthenticated)
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestAuthCustomer(t *testing.T) {
+	ctx := context.Background()
+	scopes := auth.ScopeOrdersRead + " " + auth.ScopeOrdersWrite + " " + auth.ScopeOrdersRefund
+
+	// customers can get their own orders
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(testOrder(storage.OrderStatusPending), nil).Once()
+		client := newClient(t, stor, nil, nil, withAuth(stor))
+		res, err := client.GetOrder(withCustomerToken(t, ctx, "test@test", scopes), &orderspb.GetOrderRequest{Id: "a"})
+		require.NoError(t, err)
+		assert.Equal(t, "a", res.Order.Id)
+		stor.AssertExpectations(t)
+	}
+
+	// customers can cancel their own orders
+	{
+		byCustomer := mock.MatchedBy(func(ctx context.Context) bool {
+			return storage.ActorFromContext(ctx) == "jwt:customer-1"
+		})
+		order := testOrder(storage.OrderStatusCharged)
+		order.Payments = []storage.Payment{
+			{ID: "ch_1", Kind: storage.PaymentKindCharge, Amount: storage.Money{Amount: 220, Currency: "EUR"}},
+		}
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(order, nil).Once()
+		stor.On("SetOrderStatus", byCustomer, "a", storage.OrderStatusCancelling, int64(3)).Return(nil).Once()
+		stor.On("AddOrderPayment", byCustomer, "a", storage.Payment{
+			ID:       "re_1",
+			Kind:     storage.PaymentKindRefund,
+			ChargeID: "ch_1",
+			Amount:   storage.Money{Amount: -220, Currency: "EUR"},
+			Actor:    "jwt:customer-1",
+		}).Return(nil).Once()
+		stor.On("SetOrderStatus", byCustomer, "a", storage.OrderStatusCancelled, int64(5)).Return(nil).Once()
+		charges := new(mocks.MockChargeService)
+		charges.On("Refund", mock.Anything, services.RefundArgs{ChargeID: "ch_1", AmountCents: 220, Currency: "EUR"}).
+			Return(services.Charge{ID: "re_1", AmountCents: -220, Currency: "EUR"}, nil).Once()
+		client := newClient(t, stor, nil, charges, withAuth(stor))
+		_, err := client.CancelOrder(withCustomerToken(t, ctx, "test@test", scopes), &orderspb.CancelOrderRequest{Id: "a"})
+		require.NoError(t, err)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// other customers' orders are NotFound
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(testOrder(storage.OrderStatusPending), nil)
+		client := newClient(t, stor, nil, nil, withAuth(stor))
+		otherCtx := withCustomerToken(t, ctx, "other@test", scopes)
+
+		_, err := client.GetOrder(otherCtx, &orderspb.GetOrderRequest{Id: "a"})
+		assertStatus(t, err, codes.NotFound, ReasonOrderNotFound)
+
+		// the order isn't touched
+		_, err = client.CancelOrder(otherCtx, &orderspb.CancelOrderRequest{Id: "a"})
+		assertStatus(t, err, codes.NotFound, ReasonOrderNotFound)
+
+		stream, err := client.WatchOrder(otherCtx, &orderspb.WatchOrderRequest{Id: "a"})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assertStatus(t, err, codes.NotFound, ReasonOrderNotFound)
+		stor.AssertNotCalled(t, "SetOrderStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	}
+
+	// customers only list their own orders whatever email they ask for
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrders", mock.Anything, storage.OrderFilter{
+			Status:        storage.OrderStatusAny,
+			CustomerEmail: "other@test",
+		}).Return(nil, nil).Once()
+		client := newClient(t, stor, nil, nil, withAuth(stor))
+		res, err := client.ListOrders(withCustomerToken(t, ctx, "other@test", scopes), &orderspb.ListOrdersRequest{CustomerEmail: "test@test"})
+		require.NoError(t, err)
+		assert.Empty(t, res.Orders)
+		stor.AssertExpectations(t)
+	}
+
+	// customers can't create orders for someone else
+	{
+		stor := new(mocks.MockStorageInstance)
+		client := newClient(t, stor, nil, nil, withAuth(stor))
+		_, err := client.CreateOrder(withCustomerToken(t, ctx, "other@test", scopes), &orderspb.CreateOrderRequest{
+			CustomerEmail: "test@test",
+			LineItems:     []*orderspb.LineItem{{Description: "item", Quantity: 1, PriceCents: 100}},
+		})
+		assertStatus(t, err, codes.PermissionDenied, ReasonForbidden)
+		stor.AssertExpectations(t)
+	}
+}
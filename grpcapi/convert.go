@@ -0,0 +1,149 @@
+package grpcapi
+
+import (
+	"fmt"
+
+	"github.com/levenlabs/order-up/grpcapi/orderspb"
+	"github.com/levenlabs/order-up/storage"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// statusesToProto maps each order status to its protobuf enum value
+var statusesToProto = map[storage.OrderStatus]orderspb.OrderStatus{
+	storage.OrderStatusPending:    orderspb.OrderStatus_ORDER_STATUS_PENDING,
+	storage.OrderStatusCharged:    orderspb.OrderStatus_ORDER_STATUS_CHARGED,
+	storage.OrderStatusFulfilled:  orderspb.OrderStatus_ORDER_STATUS_FULFILLED,
+	storage.OrderStatusCancelled:  orderspb.OrderStatus_ORDER_STATUS_CANCELLED,
+	storage.OrderStatusCharging:   orderspb.OrderStatus_ORDER_STATUS_CHARGING,
+	storage.OrderStatusCancelling: orderspb.OrderStatus_ORDER_STATUS_CANCELLING,
+	storage.OrderStatusFulfilling: orderspb.OrderStatus_ORDER_STATUS_FULFILLING,
+}
+
+// statusToProto returns the protobuf enum value of status. Unknown statuses are
+// ORDER_STATUS_UNSPECIFIED.
+func statusToProto(status storage.OrderStatus) orderspb.OrderStatus {
+	return statusesToProto[status]
+}
+
+// statusFromProto returns the order status for a ListOrdersRequest filter,
+// where ORDER_STATUS_UNSPECIFIED is storage.OrderStatusAny
+func statusFromProto(status orderspb.OrderStatus) (storage.OrderStatus, error) {
+	if status == orderspb.OrderStatus_ORDER_STATUS_UNSPECIFIED {
+		return storage.OrderStatusAny, nil
+	}
+	for s, ps := range statusesToProto {
+		if ps == status {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %v", errUnknownStatus, status)
+}
+
+// moneyToProto returns m as a protobuf message
+func moneyToProto(m storage.Money) *orderspb.Money {
+	return &orderspb.Money{Amount: m.Amount, Currency: string(m.Currency)}
+}
+
+// addressToProto returns a as a protobuf message, nil stays nil
+func addressToProto(a *storage.Address) *orderspb.Address {
+	if a == nil {
+		return nil
+	}
+	return &orderspb.Address{
+		Name:       a.Name,
+		Line1:      a.Line1,
+		Line2:      a.Line2,
+		City:       a.City,
+		Region:     a.Region,
+		PostalCode: a.PostalCode,
+		Country:    a.Country,
+	}
+}
+
+// addressFromProto returns the storage.Address for a, nil stays nil
+func addressFromProto(a *orderspb.Address) *storage.Address {
+	if a == nil {
+		return nil
+	}
+	return &storage.Address{
+		Name:       a.GetName(),
+		Line1:      a.GetLine1(),
+		Line2:      a.GetLine2(),
+		City:       a.GetCity(),
+		Region:     a.GetRegion(),
+		PostalCode: a.GetPostalCode(),
+		Country:    a.GetCountry(),
+	}
+}
+
+// lineItemsFromProto returns the storage.LineItems for lineItems
+func lineItemsFromProto(lineItems []*orderspb.LineItem) []storage.LineItem {
+	res := make([]storage.LineItem, len(lineItems))
+	for idx, li := range lineItems {
+		res[idx] = storage.LineItem{
+			Description: li.GetDescription(),
+			PriceCents:  li.GetPriceCents(),
+			Quantity:    li.GetQuantity(),
+			Currency:    storage.Currency(li.GetCurrency()),
+			TaxCategory: li.GetTaxCategory(),
+			Kind:        storage.LineItemKind(li.GetKind()),
+		}
+	}
+	return res
+}
+
+// orderToProto returns order as a protobuf message
+func orderToProto(order storage.Order) *orderspb.Order {
+	res := &orderspb.Order{
+		Id:              order.ID,
+		CustomerEmail:   order.CustomerEmail,
+		Currency:        string(order.Currency.OrDefault()),
+		LineItems:       make([]*orderspb.LineItem, len(order.LineItems)),
+		Jurisdiction:    order.Jurisdiction,
+		ShippingAddress: addressToProto(order.ShippingAddress),
+		BillingAddress:  addressToProto(order.BillingAddress),
+		ShippingMethod:  order.ShippingMethod,
+		Notes:           order.Notes,
+		PromoCodes:      order.PromoCodes,
+		Status:          statusToProto(order.Status),
+		Version:         order.Version,
+		Total:           moneyToProto(order.Total()),
+	}
+	for idx, li := range order.LineItems {
+		res.LineItems[idx] = &orderspb.LineItem{
+			Description: li.Description,
+			PriceCents:  li.PriceCents,
+			Quantity:    li.Quantity,
+			Currency:    string(li.Currency),
+			TaxCategory: li.TaxCategory,
+			Kind:        string(li.Kind),
+		}
+	}
+	for _, entry := range order.History {
+		pe := &orderspb.HistoryEntry{
+			Status: statusToProto(entry.Status),
+			At:     timestamppb.New(entry.At),
+			Actor:  entry.Actor,
+			Reason: entry.Reason,
+		}
+		for _, change := range entry.Changes {
+			pe.Changes = append(pe.Changes, &orderspb.FieldChange{
+				Field:    change.Field,
+				FromJson: string(change.From),
+				ToJson:   string(change.To),
+			})
+		}
+		res.History = append(res.History, pe)
+	}
+	for _, payment := range order.Payments {
+		res.Payments = append(res.Payments, &orderspb.Payment{
+			Id:       payment.ID,
+			Kind:     string(payment.Kind),
+			ChargeId: payment.ChargeID,
+			Amount:   moneyToProto(payment.Amount),
+			At:       timestamppb.New(payment.At),
+			Actor:    payment.Actor,
+		})
+	}
+	return res
+}
@@ -0,0 +1,126 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/levenlabs/go-llog"
+	"github.com/levenlabs/order-up/orders"
+	"github.com/levenlabs/order-up/services"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/validation"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorDomain is the domain of the ErrorInfo detail on every error the server
+// returns
+const ErrorDomain = "order-up"
+
+// These are the reasons in the ErrorInfo detail of the errors the server
+// returns. They're the same as the HTTP API's error codes so clients of both
+// can handle them the same way.
+const (
+	ReasonInvalidRequest        = "invalid_request"
+	ReasonUnauthenticated       = "unauthenticated"
+	ReasonValidationFailed      = "validation_failed"
+	ReasonForbidden             = "forbidden"
+	ReasonOrderNotFound         = "order_not_found"
+	ReasonOrderExists           = "order_exists"
+	ReasonPromotionNotFound     = "promotion_not_found"
+	ReasonPromotionExpired      = "promotion_expired"
+	ReasonPromotionLimitReached = "promotion_limit_reached"
+	ReasonInvalidTransition     = "invalid_transition"
+	ReasonVersionConflict       = "version_conflict"
+	ReasonChargeDeclined        = "charge_declined"
+	ReasonChargeFailed          = "charge_failed"
+	ReasonFulfillmentFailed     = "fulfillment_failed"
+	ReasonUnavailable           = "unavailable"
+	ReasonInternal              = "internal_error"
+)
+
+// errUnknownStatus is returned for a ListOrdersRequest with a status that isn't
+// in the enum
+var errUnknownStatus = errors.New("unknown value for status")
+
+// sentinelErrors maps the errors from the orders, storage and services packages
+// that a request can fail with to a gRPC code, reason and message. The first
+// one that matches with errors.Is is used.
+var sentinelErrors = []struct {
+	err     error
+	code    codes.Code
+	reason  string
+	message string
+}{
+	{errUnknownStatus, codes.InvalidArgument, ReasonInvalidRequest, errUnknownStatus.Error()},
+	{orders.ErrInvalidLimit, codes.InvalidArgument, ReasonInvalidRequest, orders.ErrInvalidLimit.Error()},
+	{orders.ErrCustomerMismatch, codes.PermissionDenied, ReasonForbidden, orders.ErrCustomerMismatch.Error()},
+	{orders.ErrChargeNotRecorded, codes.FailedPrecondition, ReasonInvalidTransition, orders.ErrChargeNotRecorded.Error()},
+	{orders.ErrNegativeTotal, codes.FailedPrecondition, ReasonInvalidTransition, orders.ErrNegativeTotal.Error()},
+	{storage.ErrOrderNotFound, codes.NotFound, ReasonOrderNotFound, "order not found"},
+	{storage.ErrOrderExists, codes.AlreadyExists, ReasonOrderExists, "order already exists"},
+	{storage.ErrPromotionNotFound, codes.NotFound, ReasonPromotionNotFound, "promotion not found"},
+	{storage.ErrPromotionExpired, codes.FailedPrecondition, ReasonPromotionExpired, "promotion has expired"},
+	{storage.ErrPromotionLimitReached, codes.FailedPrecondition, ReasonPromotionLimitReached, "promotion can't be used any more times"},
+	{storage.ErrVersionConflict, codes.Aborted, ReasonVersionConflict, "order was changed by another request"},
+	{storage.ErrSchemaNotReady, codes.Unavailable, ReasonUnavailable, "service is not ready"},
+	{services.ErrChargeDeclined, codes.FailedPrecondition, ReasonChargeDeclined, "the card was declined"},
+	{services.ErrChargeFailed, codes.Internal, ReasonChargeFailed, "error charging order"},
+	{services.ErrFulfillmentFailed, codes.Internal, ReasonFulfillmentFailed, "error fulfilling order"},
+}
+
+// statusError converts err into a gRPC status error with the reason in an
+// ErrorInfo detail. validation.Violations are listed in a BadRequest detail.
+// Internal errors are logged since the client only sees a generic message.
+func statusError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	code, reason, message := codes.Internal, ReasonInternal, "internal error"
+	var vs validation.Violations
+	var transitionErr *orders.TransitionError
+	switch {
+	case errors.As(err, &vs):
+		code, reason, message = codes.InvalidArgument, ReasonValidationFailed, "the request has invalid fields"
+	case errors.As(err, &transitionErr):
+		code, reason, message = codes.FailedPrecondition, ReasonInvalidTransition, transitionErr.Error()
+	default:
+		for _, se := range sentinelErrors {
+			if errors.Is(err, se.err) {
+				code, reason, message = se.code, se.reason, se.message
+				break
+			}
+		}
+	}
+	if code == codes.Internal {
+		llog.Error("error handling grpc request", llog.ErrKV(err), llog.KV{"reason": reason})
+	}
+	return reasonError(code, reason, message, vs)
+}
+
+// reasonError returns a gRPC status error with the reason in an ErrorInfo
+// detail and any violations in a BadRequest detail
+func reasonError(code codes.Code, reason, message string, vs validation.Violations) error {
+	st := status.New(code, message)
+	info := &errdetails.ErrorInfo{Reason: reason, Domain: ErrorDomain}
+	var err error
+	if len(vs) == 0 {
+		st, err = st.WithDetails(info)
+	} else {
+		badRequest := new(errdetails.BadRequest)
+		for _, v := range vs {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Field,
+				Description: v.Message,
+			})
+		}
+		st, err = st.WithDetails(info, badRequest)
+	}
+	if err != nil {
+		// the details are only missing if they couldn't be encoded, which should
+		// never happen, and the code and message are still there
+		return status.Error(code, message)
+	}
+	return st.Err()
+}
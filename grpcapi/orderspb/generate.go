@@ -0,0 +1,7 @@
+// Package orderspb holds the messages and the OrderService client and server
+// generated from orders.proto. Edit orders.proto and run go generate instead of
+// editing the generated files, which needs protoc, protoc-gen-go v1.30.0 and
+// protoc-gen-go-grpc v1.3.0 on the PATH.
+package orderspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative orders.proto
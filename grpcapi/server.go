@@ -0,0 +1,227 @@
+// Package grpcapi exposes the orders over gRPC for the internal services that
+// don't talk HTTP. The service is defined in orderspb/orders.proto and the
+// server calls the same orders.Service as the HTTP API, so both behave the
+// same.
+package grpcapi
+
+import (
+	"context"
+	"time"
+
+	"github.com/levenlabs/order-up/auth"
+	"github.com/levenlabs/order-up/grpcapi/orderspb"
+	"github.com/levenlabs/order-up/orders"
+	"github.com/levenlabs/order-up/storage"
+)
+
+// Actor is recorded in the history of orders changed through the gRPC server
+// when authentication is disabled, otherwise the principal's actor is
+const Actor = "grpc"
+
+// DefaultWatchInterval is how often WatchOrder checks the order for changes
+// unless WithWatchInterval is passed
+const DefaultWatchInterval = time.Second
+
+// Server implements orderspb.OrderServiceServer, register it on a *grpc.Server
+// with orderspb.RegisterOrderServiceServer
+type Server struct {
+	orderspb.UnimplementedOrderServiceServer
+	orders        *orders.Service
+	watchInterval time.Duration
+	// auth is nil when authentication is disabled
+	auth *auth.Authenticator
+}
+
+// Option configures optional behavior on the Server
+type Option func(*Server)
+
+// WithWatchInterval sets how often WatchOrder checks the order for changes
+func WithWatchInterval(d time.Duration) Option {
+	return func(s *Server) {
+		s.watchInterval = d
+	}
+}
+
+// NewServer returns a Server that calls svc
+func NewServer(svc *orders.Service, opts ...Option) *Server {
+	s := &Server{
+		orders:        svc,
+		watchInterval: DefaultWatchInterval,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// CreateOrder implements orderspb.OrderServiceServer
+func (s *Server) CreateOrder(ctx context.Context, req *orderspb.CreateOrderRequest) (*orderspb.CreateOrderResponse, error) {
+	p, err := s.principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	order, err := s.orders.Create(actorContext(ctx, p), orders.CreateArgs{
+		CustomerEmail:   req.GetCustomerEmail(),
+		Currency:        storage.Currency(req.GetCurrency()),
+		Jurisdiction:    req.GetJurisdiction(),
+		ShippingAddress: addressFromProto(req.GetShippingAddress()),
+		BillingAddress:  addressFromProto(req.GetBillingAddress()),
+		ShippingMethod:  req.GetShippingMethod(),
+		LineItems:       lineItemsFromProto(req.GetLineItems()),
+		PromoCodes:      req.GetPromoCodes(),
+	}, p.CustomerEmail)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &orderspb.CreateOrderResponse{Order: orderToProto(order)}, nil
+}
+
+// GetOrder implements orderspb.OrderServiceServer
+func (s *Server) GetOrder(ctx context.Context, req *orderspb.GetOrderRequest) (*orderspb.GetOrderResponse, error) {
+	p, err := s.principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	order, err := s.orders.Get(ctx, req.GetId())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	// customers get the same error as for a missing order so they can't
+	// discover other customers' order IDs
+	if !p.CanAccessOrder(order) {
+		return nil, statusError(storage.ErrOrderNotFound)
+	}
+	return &orderspb.GetOrderResponse{Order: orderToProto(order)}, nil
+}
+
+// ListOrders implements orderspb.OrderServiceServer
+func (s *Server) ListOrders(ctx context.Context, req *orderspb.ListOrdersRequest) (*orderspb.ListOrdersResponse, error) {
+	p, err := s.principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status, err := statusFromProto(req.GetStatus())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	filter := storage.OrderFilter{
+		Status:        status,
+		CustomerEmail: req.GetCustomerEmail(),
+		AfterID:       req.GetAfter(),
+		Limit:         req.GetLimit(),
+	}
+	// customers only ever see their own orders whatever they asked for
+	if p.CustomerEmail != "" {
+		filter.CustomerEmail = p.CustomerEmail
+	}
+	list, next, err := s.orders.List(ctx, filter)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	res := &orderspb.ListOrdersResponse{
+		Orders:     make([]*orderspb.Order, len(list)),
+		NextCursor: next,
+	}
+	for idx, order := range list {
+		res.Orders[idx] = orderToProto(order)
+	}
+	return res, nil
+}
+
+// preconditions returns the preconditions for changing an order: that p can
+// access it and, unless version is 0, that it's still at version
+func preconditions(p auth.Principal, version int64) []orders.Precondition {
+	pcs := []orders.Precondition{func(order storage.Order) error {
+		if !p.CanAccessOrder(order) {
+			return storage.ErrOrderNotFound
+		}
+		return nil
+	}}
+	if version != 0 {
+		pcs = append(pcs, orders.AtVersion(version))
+	}
+	return pcs
+}
+
+// ChargeOrder implements orderspb.OrderServiceServer
+func (s *Server) ChargeOrder(ctx context.Context, req *orderspb.ChargeOrderRequest) (*orderspb.ChargeOrderResponse, error) {
+	p, err := s.principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.orders.Charge(actorContext(ctx, p), req.GetId(), req.GetCardToken(), preconditions(p, req.GetVersion())...)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &orderspb.ChargeOrderResponse{Charged: moneyToProto(res.Charged)}, nil
+}
+
+// CancelOrder implements orderspb.OrderServiceServer
+func (s *Server) CancelOrder(ctx context.Context, req *orderspb.CancelOrderRequest) (*orderspb.CancelOrderResponse, error) {
+	p, err := s.principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.orders.Cancel(actorContext(ctx, p), req.GetId(), preconditions(p, req.GetVersion())...)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &orderspb.CancelOrderResponse{Refunded: moneyToProto(res.Refunded)}, nil
+}
+
+// FulfillOrder implements orderspb.OrderServiceServer
+func (s *Server) FulfillOrder(ctx context.Context, req *orderspb.FulfillOrderRequest) (*orderspb.FulfillOrderResponse, error) {
+	p, err := s.principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.orders.Fulfill(actorContext(ctx, p), req.GetId(), preconditions(p, req.GetVersion())...)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &orderspb.FulfillOrderResponse{}, nil
+}
+
+// WatchOrder implements orderspb.OrderServiceServer. Storage can't notify us
+// when an order changes so the order is loaded every watchInterval and sent
+// whenever its version changed.
+func (s *Server) WatchOrder(req *orderspb.WatchOrderRequest, stream orderspb.OrderService_WatchOrderServer) error {
+	ctx := stream.Context()
+	p, err := s.principal(ctx)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(s.watchInterval)
+	defer ticker.Stop()
+
+	// version is what the client last got, every order has at least version 1
+	// except ones stored before versions existed which are sent once
+	version := int64(-1)
+	for {
+		order, err := s.orders.Get(ctx, req.GetId())
+		if err != nil {
+			return statusError(err)
+		}
+		if !p.CanAccessOrder(order) {
+			return statusError(storage.ErrOrderNotFound)
+		}
+		if order.Version != version {
+			version = order.Version
+			if err := stream.Send(&orderspb.WatchOrderResponse{Order: orderToProto(order)}); err != nil {
+				return err
+			}
+		}
+		// nothing happens to an order once it's fulfilled or cancelled
+		if order.Status == storage.OrderStatusFulfilled || order.Status == storage.OrderStatusCancelled {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return statusError(ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
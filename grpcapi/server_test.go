@@ -0,0 +1,474 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/levenlabs/order-up/grpcapi/orderspb"
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/orders"
+	"github.com/levenlabs/order-up/services"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newClient serves a Server for stor and the services over an in-memory
+// listener and returns a client connected to it. Any opts are passed to
+// NewServer.
+func newClient(t *testing.T, stor mocks.StorageInstance, fulfillmentService services.FulfillmentService, chargeService services.ChargeService, opts ...Option) orderspb.OrderServiceClient {
+	ln := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer(grpc.UnaryInterceptor(UnaryInterceptor), grpc.StreamInterceptor(StreamInterceptor))
+	svc := orders.New(stor, chargeService, fulfillmentService)
+	opts = append([]Option{WithWatchInterval(time.Millisecond)}, opts...)
+	orderspb.RegisterOrderServiceServer(gs, NewServer(svc, opts...))
+	go gs.Serve(ln)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return ln.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return orderspb.NewOrderServiceClient(conn)
+}
+
+// assertStatus asserts that err is a gRPC status with code and an ErrorInfo
+// with reason
+func assertStatus(t *testing.T, err error, code codes.Code, reason string) *status.Status {
+	st, ok := status.FromError(err)
+	require.True(t, ok, "not a status: %v", err)
+	assert.Equal(t, code, st.Code(), st.Message())
+	var info *errdetails.ErrorInfo
+	for _, d := range st.Details() {
+		if d, ok := d.(*errdetails.ErrorInfo); ok {
+			info = d
+		}
+	}
+	if assert.NotNil(t, info) {
+		assert.Equal(t, reason, info.Reason)
+		assert.Equal(t, ErrorDomain, info.Domain)
+	}
+	return st
+}
+
+// withActor matches a context with the gRPC server's actor
+var withActor = mock.MatchedBy(func(ctx context.Context) bool {
+	return storage.ActorFromContext(ctx) == Actor
+})
+
+// testOrder returns an order with the given status, a product and tax
+func testOrder(status storage.OrderStatus) storage.Order {
+	return storage.Order{
+		ID:            "a",
+		CustomerEmail: "test@test",
+		Currency:      "EUR",
+		LineItems: []storage.LineItem{
+			{Description: "item 1", Quantity: 2, PriceCents: 100},
+			{Description: "tax", Quantity: 1, PriceCents: 20, Kind: storage.LineItemKindTax},
+		},
+		Status:  status,
+		Version: 3,
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestCreateOrder(t *testing.T) {
+	ctx := context.Background()
+
+	// a valid order is inserted like it is by POST /orders
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("InsertOrder", mock.Anything, storage.Order{
+			CustomerEmail: "test@test",
+			Currency:      storage.DefaultCurrency,
+			LineItems:     []storage.LineItem{{Description: "item 1", Quantity: 2, PriceCents: 100}},
+			ShippingAddress: &storage.Address{
+				Name: "Test", Line1: "1 Main St", City: "Springfield", Country: "US",
+			},
+			// the jurisdiction defaults to the shipping address's
+			Jurisdiction: "US",
+			Status:       storage.OrderStatusPending,
+		}).Return("a", nil).Once()
+		client := newClient(t, stor, nil, nil)
+		res, err := client.CreateOrder(ctx, &orderspb.CreateOrderRequest{
+			CustomerEmail: "test@test",
+			LineItems:     []*orderspb.LineItem{{Description: "item 1", Quantity: 2, PriceCents: 100}},
+			ShippingAddress: &orderspb.Address{
+				Name: "Test", Line1: "1 Main St", City: "Springfield", Country: "US",
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "a", res.Order.Id)
+		assert.Equal(t, orderspb.OrderStatus_ORDER_STATUS_PENDING, res.Order.Status)
+		assert.Equal(t, int64(1), res.Order.Version)
+		assert.Equal(t, int64(200), res.Order.Total.Amount)
+		assert.Equal(t, "USD", res.Order.Total.Currency)
+		assert.Equal(t, "Springfield", res.Order.ShippingAddress.City)
+		assert.Nil(t, res.Order.BillingAddress)
+		stor.AssertExpectations(t)
+	}
+
+	// invalid fields are listed in a BadRequest
+	{
+		stor := new(mocks.MockStorageInstance)
+		client := newClient(t, stor, nil, nil)
+		_, err := client.CreateOrder(ctx, &orderspb.CreateOrderRequest{
+			CustomerEmail: "test@test",
+			LineItems:     []*orderspb.LineItem{{Description: "item 1", Quantity: 0, PriceCents: 100}},
+		})
+		st := assertStatus(t, err, codes.InvalidArgument, ReasonValidationFailed)
+		var fields []string
+		for _, d := range st.Details() {
+			if d, ok := d.(*errdetails.BadRequest); ok {
+				for _, v := range d.FieldViolations {
+					fields = append(fields, v.Field)
+				}
+			}
+		}
+		assert.Equal(t, []string{"lineItems[0].quantity"}, fields)
+		stor.AssertExpectations(t)
+	}
+
+	// an existing ID is AlreadyExists
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("InsertOrder", mock.Anything, mock.Anything).Return("", storage.ErrOrderExists).Once()
+		client := newClient(t, stor, nil, nil)
+		_, err := client.CreateOrder(ctx, &orderspb.CreateOrderRequest{
+			CustomerEmail: "test@test",
+			LineItems:     []*orderspb.LineItem{{Description: "item 1", Quantity: 1, PriceCents: 100}},
+		})
+		assertStatus(t, err, codes.AlreadyExists, ReasonOrderExists)
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestGetOrder(t *testing.T) {
+	ctx := context.Background()
+
+	// the order is converted with its history
+	{
+		order := testOrder(storage.OrderStatusCharged)
+		at := time.Date(2026, time.January, 2, 3, 4, 5, 0, time.UTC)
+		order.History = []storage.HistoryEntry{
+			{Status: storage.OrderStatusPending, At: at, Actor: "apikey:1", Changes: []storage.FieldChange{
+				{Field: "notes", From: json.RawMessage(`null`), To: json.RawMessage(`"hi"`)},
+			}},
+			{Status: storage.OrderStatusCharged, At: at.Add(time.Hour)},
+		}
+		order.Payments = []storage.Payment{
+			{ID: "ch_1", Kind: storage.PaymentKindCharge, Amount: storage.Money{Amount: 220, Currency: "EUR"}, At: at.Add(time.Hour)},
+		}
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(order, nil).Once()
+		client := newClient(t, stor, nil, nil)
+		res, err := client.GetOrder(ctx, &orderspb.GetOrderRequest{Id: "a"})
+		require.NoError(t, err)
+		assert.Equal(t, "a", res.Order.Id)
+		assert.Equal(t, "EUR", res.Order.Currency)
+		assert.Equal(t, orderspb.OrderStatus_ORDER_STATUS_CHARGED, res.Order.Status)
+		assert.Equal(t, int64(3), res.Order.Version)
+		assert.Equal(t, int64(220), res.Order.Total.Amount)
+		require.Len(t, res.Order.LineItems, 2)
+		assert.Equal(t, "tax", res.Order.LineItems[1].Kind)
+		require.Len(t, res.Order.History, 2)
+		assert.Equal(t, at, res.Order.History[0].At.AsTime())
+		assert.Equal(t, "apikey:1", res.Order.History[0].Actor)
+		assert.Equal(t, `"hi"`, res.Order.History[0].Changes[0].ToJson)
+		assert.Equal(t, orderspb.OrderStatus_ORDER_STATUS_CHARGED, res.Order.History[1].Status)
+		require.Len(t, res.Order.Payments, 1)
+		assert.Equal(t, "ch_1", res.Order.Payments[0].Id)
+		assert.Equal(t, "charge", res.Order.Payments[0].Kind)
+		assert.Equal(t, &orderspb.Money{Amount: 220, Currency: "EUR"}, res.Order.Payments[0].Amount)
+		stor.AssertExpectations(t)
+	}
+
+	// a missing order is NotFound
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(storage.Order{}, storage.ErrOrderNotFound).Once()
+		client := newClient(t, stor, nil, nil)
+		_, err := client.GetOrder(ctx, &orderspb.GetOrderRequest{Id: "a"})
+		assertStatus(t, err, codes.NotFound, ReasonOrderNotFound)
+		stor.AssertExpectations(t)
+	}
+
+	// unexpected storage errors are Internal without their cause
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(storage.Order{}, assert.AnError).Once()
+		client := newClient(t, stor, nil, nil)
+		_, err := client.GetOrder(ctx, &orderspb.GetOrderRequest{Id: "a"})
+		st := assertStatus(t, err, codes.Internal, ReasonInternal)
+		assert.NotContains(t, st.Message(), assert.AnError.Error())
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestListOrders(t *testing.T) {
+	ctx := context.Background()
+
+	// the filters are passed along and a full page has a cursor
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrders", mock.Anything, storage.OrderFilter{
+			Status:        storage.OrderStatusCharged,
+			CustomerEmail: "test@test",
+			AfterID:       "0",
+			Limit:         1,
+		}).Return([]storage.Order{testOrder(storage.OrderStatusCharged)}, nil).Once()
+		client := newClient(t, stor, nil, nil)
+		res, err := client.ListOrders(ctx, &orderspb.ListOrdersRequest{
+			Status:        orderspb.OrderStatus_ORDER_STATUS_CHARGED,
+			CustomerEmail: "test@test",
+			Limit:         1,
+			After:         "0",
+		})
+		require.NoError(t, err)
+		require.Len(t, res.Orders, 1)
+		assert.Equal(t, "a", res.Orders[0].Id)
+		assert.Equal(t, "a", res.NextCursor)
+		stor.AssertExpectations(t)
+	}
+
+	// an unspecified status matches every order
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrders", mock.Anything, storage.OrderFilter{Status: storage.OrderStatusAny}).Return(nil, nil).Once()
+		client := newClient(t, stor, nil, nil)
+		res, err := client.ListOrders(ctx, &orderspb.ListOrdersRequest{})
+		require.NoError(t, err)
+		assert.Empty(t, res.Orders)
+		assert.Empty(t, res.NextCursor)
+		stor.AssertExpectations(t)
+	}
+
+	// the limit and status are checked before storage is called
+	{
+		stor := new(mocks.MockStorageInstance)
+		client := newClient(t, stor, nil, nil)
+		_, err := client.ListOrders(ctx, &orderspb.ListOrdersRequest{Limit: orders.MaxPageSize + 1})
+		assertStatus(t, err, codes.InvalidArgument, ReasonInvalidRequest)
+		_, err = client.ListOrders(ctx, &orderspb.ListOrdersRequest{Status: 42})
+		assertStatus(t, err, codes.InvalidArgument, ReasonInvalidRequest)
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestChargeOrder(t *testing.T) {
+	ctx := context.Background()
+
+	// the total is charged and the order is marked as charged by the gRPC actor
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(testOrder(storage.OrderStatusPending), nil).Once()
+		stor.On("SetOrderStatus", withActor, "a", storage.OrderStatusCharging, int64(3)).Return(nil).Once()
+		stor.On("AddOrderPayment", withActor, "a", storage.Payment{
+			ID:     "ch_1",
+			Kind:   storage.PaymentKindCharge,
+			Amount: storage.Money{Amount: 220, Currency: "EUR"},
+			Actor:  Actor,
+		}).Return(nil).Once()
+		stor.On("SetOrderStatus", withActor, "a", storage.OrderStatusCharged, int64(5)).Return(nil).Once()
+		charges := new(mocks.MockChargeService)
+		charges.On("Charge", mock.Anything, services.ChargeArgs{CardToken: "amex", AmountCents: 220, Currency: "EUR"}).
+			Return(services.Charge{ID: "ch_1", AmountCents: 220, Currency: "EUR"}, nil).Once()
+		client := newClient(t, stor, nil, charges)
+		res, err := client.ChargeOrder(ctx, &orderspb.ChargeOrderRequest{Id: "a", CardToken: "amex", Version: 3})
+		require.NoError(t, err)
+		assert.Equal(t, &orderspb.Money{Amount: 220, Currency: "EUR"}, res.Charged)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// an order can't be charged twice
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(testOrder(storage.OrderStatusCharged), nil).Once()
+		charges := new(mocks.MockChargeService)
+		client := newClient(t, stor, nil, charges)
+		_, err := client.ChargeOrder(ctx, &orderspb.ChargeOrderRequest{Id: "a", CardToken: "amex"})
+		assertStatus(t, err, codes.FailedPrecondition, ReasonInvalidTransition)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// a stale version is Aborted before anything is charged
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(testOrder(storage.OrderStatusPending), nil).Once()
+		charges := new(mocks.MockChargeService)
+		client := newClient(t, stor, nil, charges)
+		_, err := client.ChargeOrder(ctx, &orderspb.ChargeOrderRequest{Id: "a", CardToken: "amex", Version: 2})
+		assertStatus(t, err, codes.Aborted, ReasonVersionConflict)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// a declined card leaves the order pending
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(testOrder(storage.OrderStatusPending), nil).Once()
+		stor.On("SetOrderStatus", withActor, "a", storage.OrderStatusCharging, int64(3)).Return(nil).Once()
+		stor.On("SetOrderStatus", withActor, "a", storage.OrderStatusPending, int64(4)).Return(nil).Once()
+		charges := new(mocks.MockChargeService)
+		charges.On("Charge", mock.Anything, mock.Anything).Return(services.Charge{}, fmt.Errorf("%w: 402", services.ErrChargeDeclined)).Once()
+		client := newClient(t, stor, nil, charges)
+		_, err := client.ChargeOrder(ctx, &orderspb.ChargeOrderRequest{Id: "a", CardToken: "amex"})
+		assertStatus(t, err, codes.FailedPrecondition, ReasonChargeDeclined)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	ctx := context.Background()
+
+	// a charged order is refunded from its recorded charge
+	{
+		order := testOrder(storage.OrderStatusCharged)
+		order.Payments = []storage.Payment{
+			{ID: "ch_1", Kind: storage.PaymentKindCharge, Amount: storage.Money{Amount: 220, Currency: "EUR"}},
+		}
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(order, nil).Once()
+		stor.On("SetOrderStatus", withActor, "a", storage.OrderStatusCancelling, int64(3)).Return(nil).Once()
+		stor.On("AddOrderPayment", withActor, "a", storage.Payment{
+			ID:       "re_1",
+			Kind:     storage.PaymentKindRefund,
+			ChargeID: "ch_1",
+			Amount:   storage.Money{Amount: -220, Currency: "EUR"},
+			Actor:    Actor,
+		}).Return(nil).Once()
+		stor.On("SetOrderStatus", withActor, "a", storage.OrderStatusCancelled, int64(5)).Return(nil).Once()
+		charges := new(mocks.MockChargeService)
+		charges.On("Refund", mock.Anything, services.RefundArgs{ChargeID: "ch_1", AmountCents: 220, Currency: "EUR"}).
+			Return(services.Charge{ID: "re_1", AmountCents: -220, Currency: "EUR"}, nil).Once()
+		client := newClient(t, stor, nil, charges)
+		res, err := client.CancelOrder(ctx, &orderspb.CancelOrderRequest{Id: "a"})
+		require.NoError(t, err)
+		assert.Equal(t, &orderspb.Money{Amount: -220, Currency: "EUR"}, res.Refunded)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// a fulfilled order can't be cancelled
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(testOrder(storage.OrderStatusFulfilled), nil).Once()
+		client := newClient(t, stor, nil, nil)
+		_, err := client.CancelOrder(ctx, &orderspb.CancelOrderRequest{Id: "a"})
+		assertStatus(t, err, codes.FailedPrecondition, ReasonInvalidTransition)
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestFulfillOrder(t *testing.T) {
+	ctx := context.Background()
+
+	// only the products are sent to the fulfillment service
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(testOrder(storage.OrderStatusCharged), nil).Once()
+		stor.On("SetOrderStatus", withActor, "a", storage.OrderStatusFulfilling, int64(3)).Return(nil).Once()
+		stor.On("SetOrderStatus", withActor, "a", storage.OrderStatusFulfilled, int64(4)).Return(nil).Once()
+		fulfillments := new(mocks.MockFulfillmentService)
+		fulfillments.On("Fulfill", mock.Anything, services.FulfillArgs{Description: "item 1", Quantity: 2, OrderID: "a"}).Return(nil).Once()
+		client := newClient(t, stor, fulfillments, nil)
+		_, err := client.FulfillOrder(ctx, &orderspb.FulfillOrderRequest{Id: "a"})
+		require.NoError(t, err)
+		stor.AssertExpectations(t)
+		fulfillments.AssertExpectations(t)
+	}
+
+	// an order has to be charged first
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(testOrder(storage.OrderStatusPending), nil).Once()
+		client := newClient(t, stor, nil, nil)
+		_, err := client.FulfillOrder(ctx, &orderspb.FulfillOrderRequest{Id: "a"})
+		assertStatus(t, err, codes.FailedPrecondition, ReasonInvalidTransition)
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestWatchOrder(t *testing.T) {
+	ctx := context.Background()
+
+	// every new version is sent once and the stream ends once the order is
+	// fulfilled
+	{
+		pending := testOrder(storage.OrderStatusPending)
+		charged := testOrder(storage.OrderStatusCharged)
+		charged.Version = 4
+		fulfilled := testOrder(storage.OrderStatusFulfilled)
+		fulfilled.Version = 5
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(pending, nil).Twice()
+		stor.On("GetOrder", mock.Anything, "a").Return(charged, nil).Once()
+		stor.On("GetOrder", mock.Anything, "a").Return(fulfilled, nil).Once()
+		client := newClient(t, stor, nil, nil)
+
+		stream, err := client.WatchOrder(ctx, &orderspb.WatchOrderRequest{Id: "a"})
+		require.NoError(t, err)
+		var statuses []orderspb.OrderStatus
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			statuses = append(statuses, res.Order.Status)
+		}
+		assert.Equal(t, []orderspb.OrderStatus{
+			orderspb.OrderStatus_ORDER_STATUS_PENDING,
+			orderspb.OrderStatus_ORDER_STATUS_CHARGED,
+			orderspb.OrderStatus_ORDER_STATUS_FULFILLED,
+		}, statuses)
+		stor.AssertExpectations(t)
+	}
+
+	// a missing order ends the stream with NotFound
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(storage.Order{}, storage.ErrOrderNotFound).Once()
+		client := newClient(t, stor, nil, nil)
+		stream, err := client.WatchOrder(ctx, &orderspb.WatchOrderRequest{Id: "a"})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assertStatus(t, err, codes.NotFound, ReasonOrderNotFound)
+		stor.AssertExpectations(t)
+	}
+
+	// the stream ends when the client cancels it
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(testOrder(storage.OrderStatusPending), nil)
+		client := newClient(t, stor, nil, nil)
+		ctx, cancel := context.WithCancel(ctx)
+		stream, err := client.WatchOrder(ctx, &orderspb.WatchOrderRequest{Id: "a"})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.NoError(t, err)
+		cancel()
+		_, err = stream.Recv()
+		assert.Equal(t, codes.Canceled, status.Code(err))
+	}
+}
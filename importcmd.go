@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/levenlabs/order-up/importer"
+	"github.com/levenlabs/order-up/storage"
+)
+
+// runImport runs the import subcommand with args, everything after "import",
+// and returns the exit code
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "", "the format of the file, csv or ndjson, defaults to the file's extension")
+	dryRun := fs.Bool("dry-run", false, "validate the orders and check for conflicts without inserting anything")
+	checkpoint := fs.String("checkpoint", "", "path to a file to record progress in so an interrupted import can be resumed")
+	startTimeout := fs.Duration("start-timeout", 15*time.Second, "how long to wait for storage to be ready")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s import [flags] <file>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	path := fs.Arg(0)
+
+	f := importer.Format(*format)
+	if f == "" {
+		var err error
+		if f, err = importer.FormatFromPath(path); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error opening %s: %v\n", path, err)
+		return 1
+	}
+	defer file.Close()
+	r, err := importer.NewReader(file, f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading %s: %v\n", path, err)
+		return 1
+	}
+
+	// an interrupted import stops after the current order and writes the
+	// checkpoint so it can be resumed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	openCtx, cancel := context.WithTimeout(ctx, *startTimeout)
+	stor, err := storage.Open(openCtx, "")
+	cancel()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error opening storage: %v\n", err)
+		return 1
+	}
+	defer stor.Close(context.Background())
+
+	summary, err := importer.Run(ctx, stor, r, importer.Options{
+		DryRun:     *dryRun,
+		Checkpoint: *checkpoint,
+	})
+	printImportSummary(os.Stdout, summary, *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import stopped: %v\n", err)
+		if *checkpoint != "" && !*dryRun {
+			fmt.Fprintf(os.Stderr, "run the same command again to resume from %s\n", *checkpoint)
+		}
+		return 1
+	}
+	if len(summary.Failures) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// printImportSummary writes a report of the import to w
+func printImportSummary(w io.Writer, summary importer.Summary, dryRun bool) {
+	imported := "imported"
+	if dryRun {
+		imported = "would import"
+	}
+	for _, failure := range summary.Failures {
+		id := failure.ID
+		if id == "" {
+			id = "(no id)"
+		}
+		fmt.Fprintf(w, "order %d %s: %v\n", failure.Record, id, failure.Err)
+	}
+	fmt.Fprintf(w, "%s %d, already exist %d, invalid %d, skipped from checkpoint %d\n",
+		imported, summary.Imported, summary.Exists, summary.Invalid, summary.Skipped)
+}
@@ -0,0 +1,262 @@
+// Package importer reads orders from CSV or NDJSON, like the files returned by
+// GET /orders/export, validates them with the same rules as new orders and
+// inserts them through the storage package. It's used to migrate orders from
+// other systems.
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/validation"
+)
+
+// Store is where the imported orders are inserted. It's implemented by
+// *storage.Instance.
+type Store interface {
+	// GetOrder should return the order with the given ID. If that ID isn't found
+	// then the special ErrOrderNotFound error should be returned.
+	GetOrder(ctx context.Context, id string) (storage.Order, error)
+	// InsertOrder should fill in the order's ID with a unique identifier if it's
+	// not already set and then insert it into the database. It should return the
+	// order's ID. If the order already exists then ErrOrderExists should be
+	// returned.
+	InsertOrder(ctx context.Context, order storage.Order) (string, error)
+}
+
+// ensure *storage.Instance can be used as the Store
+var _ Store = (*storage.Instance)(nil)
+
+// Format is the format of the file being imported
+type Format string
+
+const (
+	// FormatCSV has a header row and a row per line item with the order's
+	// columns repeated on each, see NewCSVReader
+	FormatCSV Format = "csv"
+	// FormatNDJSON has an order per line encoded like the API returns them
+	FormatNDJSON Format = "ndjson"
+)
+
+// FormatFromPath returns the format based on the extension of path
+func FormatFromPath(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV, nil
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON, nil
+	default:
+		return "", fmt.Errorf("can't tell the format of %s from its extension", path)
+	}
+}
+
+// Reader returns the orders of a file one at a time
+type Reader interface {
+	// Next returns the next order or io.EOF once there are no more orders. Any
+	// other error means the file is malformed and the import can't continue.
+	Next() (storage.Order, error)
+}
+
+// NewReader returns a Reader for r in the given format
+func NewReader(r io.Reader, format Format) (Reader, error) {
+	switch format {
+	case FormatCSV:
+		return NewCSVReader(r)
+	case FormatNDJSON:
+		return NewNDJSONReader(r), nil
+	default:
+		return nil, fmt.Errorf("unknown format: %q", format)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Options changes how orders are imported
+type Options struct {
+	// DryRun validates the orders and checks whether they already exist without
+	// inserting anything
+	DryRun bool
+	// Checkpoint is the path to a file that records how many orders were already
+	// processed so that an interrupted import can be resumed by running it again
+	// with the same file. It's not used for dry runs.
+	Checkpoint string
+	// CheckpointEvery is how many orders are processed between writes of the
+	// checkpoint. It defaults to 100.
+	CheckpointEvery int
+}
+
+// Failure is an order that couldn't be imported
+type Failure struct {
+	// Record is the 1-based position of the order in the file
+	Record int
+	// ID is the order's ID, if it had one
+	ID string
+	// Err is why the order wasn't imported. It's a validation.Violations if the
+	// order was invalid or wraps storage.ErrOrderExists if it already exists.
+	Err error
+}
+
+// Summary is the result of an import
+type Summary struct {
+	// Skipped is how many orders were skipped because the checkpoint said they
+	// were already processed
+	Skipped int
+	// Imported is how many orders were inserted, or would've been for a dry run
+	Imported int
+	// Exists is how many orders weren't inserted because an order with the same
+	// ID already exists
+	Exists int
+	// Invalid is how many orders failed validation
+	Invalid int
+	// Failures has every order that wasn't imported
+	Failures []Failure
+}
+
+// Run imports every order from r into store. Orders that are invalid or already
+// exist are added to the summary and the import continues. Any other error,
+// like a malformed file or storage failing, stops the import and is returned
+// along with the summary so far. If there's a checkpoint then it's written
+// before returning so the import can be resumed.
+func Run(ctx context.Context, store Store, r Reader, opts Options) (Summary, error) {
+	var summary Summary
+	every := opts.CheckpointEvery
+	if every <= 0 {
+		every = 100
+	}
+	checkpoint := opts.Checkpoint
+	if opts.DryRun {
+		checkpoint = ""
+	}
+
+	done := 0
+	if checkpoint != "" {
+		var err error
+		done, err = readCheckpoint(checkpoint)
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	record := 0
+	err := func() error {
+		for {
+			order, err := r.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("error reading order %d: %w", record+1, err)
+			}
+			record++
+			if record <= done {
+				summary.Skipped++
+				continue
+			}
+
+			err = importOrder(ctx, store, order, opts.DryRun)
+			var vs validation.Violations
+			switch {
+			case err == nil:
+				summary.Imported++
+			case errors.Is(err, storage.ErrOrderExists):
+				summary.Exists++
+				summary.Failures = append(summary.Failures, Failure{Record: record, ID: order.ID, Err: err})
+			case errors.As(err, &vs):
+				summary.Invalid++
+				summary.Failures = append(summary.Failures, Failure{Record: record, ID: order.ID, Err: err})
+			default:
+				// the order wasn't processed so it has to be tried again when
+				// resuming from the checkpoint
+				record--
+				return fmt.Errorf("error importing order %d: %w", record+1, err)
+			}
+
+			if checkpoint != "" && record%every == 0 {
+				if err := writeCheckpoint(checkpoint, record); err != nil {
+					return err
+				}
+			}
+		}
+	}()
+	if checkpoint != "" && record > done {
+		if cerr := writeCheckpoint(checkpoint, record); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return summary, err
+}
+
+// importOrder validates order and inserts it unless it's a dry run. Unlike new
+// orders, imported ones keep the tax, shipping and discount line items they
+// were exported with.
+func importOrder(ctx context.Context, store Store, order storage.Order, dryRun bool) error {
+	order.Currency = order.Currency.OrDefault()
+	order.CustomerEmail = storage.NormalizeEmail(order.CustomerEmail)
+	vs := validation.ImportedOrder(order)
+	switch order.Status {
+	case storage.OrderStatusPending, storage.OrderStatusCharged, storage.OrderStatusFulfilled, storage.OrderStatusCancelled:
+	default:
+		vs = append(vs, validation.Violation{Field: "status", Message: "unknown status"})
+	}
+	if len(vs) > 0 {
+		return vs
+	}
+
+	if dryRun {
+		if order.ID == "" {
+			return nil
+		}
+		_, err := store.GetOrder(ctx, order.ID)
+		if errors.Is(err, storage.ErrOrderNotFound) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error getting order: %w", err)
+		}
+		return fmt.Errorf("order %s: %w", order.ID, storage.ErrOrderExists)
+	}
+
+	// the history and version start over since the order is new to this service
+	order.History = nil
+	order.Version = 0
+	if _, err := store.InsertOrder(ctx, order); err != nil {
+		return fmt.Errorf("error inserting order: %w", err)
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// readCheckpoint returns how many orders the checkpoint at path says were
+// already processed or 0 if there's no checkpoint yet
+func readCheckpoint(path string) (int, error) {
+	byts, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("error reading checkpoint: %w", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(byts)))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid checkpoint in %s: %q", path, byts)
+	}
+	return n, nil
+}
+
+// writeCheckpoint records that n orders were processed. The file is replaced
+// with a rename so a crash can't leave a partially written checkpoint.
+func writeCheckpoint(path string, n int) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(n)+"\n"), 0o644); err != nil {
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	return nil
+}
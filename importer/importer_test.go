@@ -0,0 +1,132 @@
+package importer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// testCSV has a valid order, an invalid one, one that already exists and
+// another valid one
+const testCSV = `orderId,customerEmail,status,description,quantity,priceCents
+a,test@test,1,item 1,1,100
+b,test@test,0,item 2,0,100
+c,test@test,0,item 3,1,100
+d,test@test,2,item 4,1,100
+`
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	newReader := func() Reader {
+		r, err := NewCSVReader(strings.NewReader(testCSV))
+		require.NoError(t, err)
+		return r
+	}
+	// order returns the order with id from testCSV after it was validated
+	order := func(id string, status storage.OrderStatus, description string) storage.Order {
+		return storage.Order{
+			ID:            id,
+			CustomerEmail: "test@test",
+			Currency:      storage.DefaultCurrency,
+			Status:        status,
+			LineItems:     []storage.LineItem{{Description: description, Quantity: 1, PriceCents: 100}},
+		}
+	}
+
+	// valid orders are inserted with their IDs and the rest are reported
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("InsertOrder", mock.Anything, order("a", storage.OrderStatusCharged, "item 1")).Return("a", nil).Once()
+		stor.On("InsertOrder", mock.Anything, order("c", storage.OrderStatusPending, "item 3")).Return("", storage.ErrOrderExists).Once()
+		stor.On("InsertOrder", mock.Anything, order("d", storage.OrderStatusFulfilled, "item 4")).Return("d", nil).Once()
+		summary, err := Run(ctx, stor, newReader(), Options{})
+		require.NoError(t, err)
+		assert.Equal(t, 2, summary.Imported)
+		assert.Equal(t, 1, summary.Exists)
+		assert.Equal(t, 1, summary.Invalid)
+		if assert.Len(t, summary.Failures, 2) {
+			assert.Equal(t, 2, summary.Failures[0].Record)
+			assert.Equal(t, "b", summary.Failures[0].ID)
+			assert.IsType(t, validation.Violations{}, summary.Failures[0].Err)
+			assert.Equal(t, 3, summary.Failures[1].Record)
+			assert.ErrorIs(t, summary.Failures[1].Err, storage.ErrOrderExists)
+		}
+		stor.AssertExpectations(t)
+	}
+
+	// a dry run only checks whether the orders exist
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", mock.Anything, "a").Return(storage.Order{}, storage.ErrOrderNotFound).Once()
+		stor.On("GetOrder", mock.Anything, "c").Return(order("c", storage.OrderStatusPending, "item 3"), nil).Once()
+		stor.On("GetOrder", mock.Anything, "d").Return(storage.Order{}, storage.ErrOrderNotFound).Once()
+		summary, err := Run(ctx, stor, newReader(), Options{DryRun: true, Checkpoint: filepath.Join(t.TempDir(), "checkpoint")})
+		require.NoError(t, err)
+		assert.Equal(t, 2, summary.Imported)
+		assert.Equal(t, 1, summary.Exists)
+		assert.Equal(t, 1, summary.Invalid)
+		stor.AssertExpectations(t)
+	}
+
+	// a failed import can be resumed from the checkpoint
+	{
+		checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+		stor := new(mocks.MockStorageInstance)
+		stor.On("InsertOrder", mock.Anything, order("a", storage.OrderStatusCharged, "item 1")).Return("a", nil).Once()
+		stor.On("InsertOrder", mock.Anything, order("c", storage.OrderStatusPending, "item 3")).Return("", assert.AnError).Once()
+		summary, err := Run(ctx, stor, newReader(), Options{Checkpoint: checkpoint, CheckpointEvery: 1})
+		assert.ErrorIs(t, err, assert.AnError)
+		assert.Equal(t, 1, summary.Imported)
+		byts, err := os.ReadFile(checkpoint)
+		require.NoError(t, err)
+		assert.Equal(t, "2\n", string(byts))
+		stor.AssertExpectations(t)
+
+		stor = new(mocks.MockStorageInstance)
+		stor.On("InsertOrder", mock.Anything, order("c", storage.OrderStatusPending, "item 3")).Return("c", nil).Once()
+		stor.On("InsertOrder", mock.Anything, order("d", storage.OrderStatusFulfilled, "item 4")).Return("d", nil).Once()
+		summary, err = Run(ctx, stor, newReader(), Options{Checkpoint: checkpoint})
+		require.NoError(t, err)
+		assert.Equal(t, 2, summary.Skipped)
+		assert.Equal(t, 2, summary.Imported)
+		byts, err = os.ReadFile(checkpoint)
+		require.NoError(t, err)
+		assert.Equal(t, "4\n", string(byts))
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestRunComputedLineItems(t *testing.T) {
+	ctx := context.Background()
+	// the tax, shipping and discount line items agree with the order's tax
+	// breakdown, shipping method and promo codes
+	const valid = `{"id": "a", "customerEmail": "test@test", "status": 1, "shippingMethod": "standard", "promoCodes": ["SUMMER10"], "tax": {"jurisdiction": "US-CA", "lines": [{"category": "", "ratePPM": 100000, "taxableCents": 900, "taxCents": 90}], "totalCents": 90}, "lineItems": [{"description": "item", "quantity": 1, "priceCents": 1000}, {"description": "Promotion SUMMER10", "quantity": 1, "priceCents": -100, "kind": "discount"}, {"description": "Standard shipping", "quantity": 1, "priceCents": 500, "kind": "shipping"}, {"description": "Tax", "quantity": 1, "priceCents": 90, "kind": "tax"}]}`
+	// the same order without the breakdown, shipping method or promo codes
+	const invalid = `{"id": "b", "customerEmail": "test@test", "status": 1, "lineItems": [{"description": "item", "quantity": 1, "priceCents": 1000}, {"description": "Promotion SUMMER10", "quantity": 1, "priceCents": -100, "kind": "discount"}, {"description": "Standard shipping", "quantity": 1, "priceCents": 500, "kind": "shipping"}, {"description": "Tax", "quantity": 1, "priceCents": 90, "kind": "tax"}]}`
+
+	stor := new(mocks.MockStorageInstance)
+	stor.On("InsertOrder", mock.Anything, mock.MatchedBy(func(order storage.Order) bool {
+		return order.ID == "a" && len(order.LineItems) == 4 && order.Total().Amount == 1490
+	})).Return("a", nil).Once()
+	summary, err := Run(ctx, stor, NewNDJSONReader(strings.NewReader(valid+"\n"+invalid+"\n")), Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Imported)
+	assert.Equal(t, 1, summary.Invalid)
+	if assert.Len(t, summary.Failures, 1) {
+		assert.Equal(t, validation.Violations{
+			{Field: "lineItems[1].kind", Message: "discount line items need the order's promoCodes"},
+			{Field: "lineItems[2].kind", Message: "shipping line items need the order's shippingMethod"},
+			{Field: "tax", Message: "tax line items need the order's tax breakdown"},
+		}, summary.Failures[0].Err)
+	}
+	stor.AssertExpectations(t)
+}
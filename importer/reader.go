@@ -0,0 +1,186 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/levenlabs/order-up/storage"
+)
+
+// csvRequiredColumns are the columns every CSV file has to have
+var csvRequiredColumns = []string{"customerEmail", "description", "quantity", "priceCents"}
+
+// CSVReader reads orders from CSV in the format of GET /orders/export from any
+// API version. Each row is a line item and consecutive rows with the same
+// orderId are the same order. Rows without an orderId are orders with a single
+// line item. Besides the required customerEmail, description, quantity and
+// priceCents columns it reads orderId, status, currency, jurisdiction,
+// shippingMethod, kind and taxCategory if they're there and ignores any other
+// column.
+type CSVReader struct {
+	r *csv.Reader
+	// columns maps each column's name to its index
+	columns map[string]int
+	// next is the first row of the next order, which was read while looking for
+	// the end of the previous one
+	next []string
+	// row is the number of the last row that was read, for errors
+	row int
+}
+
+// NewCSVReader reads the header row of r and returns a CSVReader
+func NewCSVReader(r io.Reader) (*CSVReader, error) {
+	cr := &CSVReader{r: csv.NewReader(r), columns: map[string]int{}}
+	header, err := cr.read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading header: %w", err)
+	}
+	for idx, name := range header {
+		cr.columns[strings.TrimSpace(name)] = idx
+	}
+	for _, name := range csvRequiredColumns {
+		if _, ok := cr.columns[name]; !ok {
+			return nil, fmt.Errorf("missing column %s", name)
+		}
+	}
+	return cr, nil
+}
+
+// read returns the next row
+func (cr *CSVReader) read() ([]string, error) {
+	row, err := cr.r.Read()
+	if err == nil {
+		cr.row++
+	}
+	return row, err
+}
+
+// get returns the value of the column in row or an empty string if there's no
+// such column. Values the export prefixed with ' so spreadsheets don't treat
+// them as formulas are returned without the prefix.
+func (cr *CSVReader) get(row []string, column string) string {
+	idx, ok := cr.columns[column]
+	if !ok || idx >= len(row) {
+		return ""
+	}
+	v := row[idx]
+	if len(v) > 1 && v[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(v[1])) {
+		return v[1:]
+	}
+	return v
+}
+
+// Next implements the Reader interface
+func (cr *CSVReader) Next() (storage.Order, error) {
+	row := cr.next
+	cr.next = nil
+	if row == nil {
+		var err error
+		if row, err = cr.read(); err != nil {
+			return storage.Order{}, err
+		}
+	}
+
+	order := storage.Order{
+		ID:             cr.get(row, "orderId"),
+		CustomerEmail:  cr.get(row, "customerEmail"),
+		Currency:       storage.Currency(cr.get(row, "currency")),
+		Jurisdiction:   cr.get(row, "jurisdiction"),
+		ShippingMethod: cr.get(row, "shippingMethod"),
+	}
+	if s := cr.get(row, "status"); s != "" {
+		// v2 exports have the status's name and v1 exports have its integer
+		status, err := storage.ParseOrderStatus(s)
+		if err != nil {
+			i, intErr := strconv.ParseInt(s, 10, 64)
+			if intErr != nil {
+				return storage.Order{}, fmt.Errorf("row %d: invalid status %q", cr.row, s)
+			}
+			status = storage.OrderStatus(i)
+		}
+		order.Status = status
+	}
+	for {
+		li, err := cr.lineItem(row)
+		if err != nil {
+			return storage.Order{}, err
+		}
+		order.LineItems = append(order.LineItems, li)
+		if order.ID == "" {
+			return order, nil
+		}
+
+		row, err = cr.read()
+		if errors.Is(err, io.EOF) {
+			return order, nil
+		} else if err != nil {
+			return storage.Order{}, err
+		}
+		if cr.get(row, "orderId") != order.ID {
+			cr.next = row
+			return order, nil
+		}
+	}
+}
+
+// lineItem returns the line item in row
+func (cr *CSVReader) lineItem(row []string) (storage.LineItem, error) {
+	li := storage.LineItem{
+		Description: cr.get(row, "description"),
+		Kind:        storage.LineItemKind(cr.get(row, "kind")),
+		TaxCategory: cr.get(row, "taxCategory"),
+	}
+	var err error
+	if li.Quantity, err = strconv.ParseInt(cr.get(row, "quantity"), 10, 64); err != nil {
+		return storage.LineItem{}, fmt.Errorf("row %d: invalid quantity %q", cr.row, cr.get(row, "quantity"))
+	}
+	if li.PriceCents, err = strconv.ParseInt(cr.get(row, "priceCents"), 10, 64); err != nil {
+		return storage.LineItem{}, fmt.Errorf("row %d: invalid priceCents %q", cr.row, cr.get(row, "priceCents"))
+	}
+	return li, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// maxNDJSONLine is the longest line the NDJSONReader accepts
+const maxNDJSONLine = 1 << 20
+
+// NDJSONReader reads orders from NDJSON in the format of GET /orders/export,
+// one JSON-encoded order per line. Blank lines are skipped.
+type NDJSONReader struct {
+	s *bufio.Scanner
+	// line is the number of the last line that was read, for errors
+	line int
+}
+
+// NewNDJSONReader returns an NDJSONReader that reads from r
+func NewNDJSONReader(r io.Reader) *NDJSONReader {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, maxNDJSONLine)
+	return &NDJSONReader{s: s}
+}
+
+// Next implements the Reader interface
+func (nr *NDJSONReader) Next() (storage.Order, error) {
+	for nr.s.Scan() {
+		nr.line++
+		if strings.TrimSpace(nr.s.Text()) == "" {
+			continue
+		}
+		var order storage.Order
+		if err := json.Unmarshal(nr.s.Bytes(), &order); err != nil {
+			return storage.Order{}, fmt.Errorf("line %d: %w", nr.line, err)
+		}
+		return order, nil
+	}
+	if err := nr.s.Err(); err != nil {
+		return storage.Order{}, err
+	}
+	return storage.Order{}, io.EOF
+}
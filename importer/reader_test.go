@@ -0,0 +1,106 @@
+package importer
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readAll returns every order from r
+func readAll(t *testing.T, r Reader) []storage.Order {
+	var orders []storage.Order
+	for {
+		order, err := r.Next()
+		if err == io.EOF {
+			return orders
+		}
+		require.NoError(t, err)
+		orders = append(orders, order)
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	// rows with the same orderId are one order and unknown columns are ignored
+	{
+		r, err := NewCSVReader(strings.NewReader(strings.Join([]string{
+			"orderId,customerEmail,status,currency,version,description,quantity,priceCents,amountCents",
+			"a,test@test,1,EUR,2,item 1,2,1000,2000",
+			"a,test@test,1,EUR,2,'=item 2,1,500,500",
+			"b,other@test,fulfilled,,1,item 3,1,100,100",
+			",third@test,,,,item 4,3,100,300",
+			",third@test,,,,item 5,1,100,100",
+		}, "\n")))
+		require.NoError(t, err)
+		assert.Equal(t, []storage.Order{
+			{
+				ID:            "a",
+				CustomerEmail: "test@test",
+				Currency:      "EUR",
+				Status:        storage.OrderStatusCharged,
+				LineItems: []storage.LineItem{
+					{Description: "item 1", Quantity: 2, PriceCents: 1000},
+					{Description: "=item 2", Quantity: 1, PriceCents: 500},
+				},
+			},
+			{
+				ID:            "b",
+				CustomerEmail: "other@test",
+				Status:        storage.OrderStatusFulfilled,
+				LineItems:     []storage.LineItem{{Description: "item 3", Quantity: 1, PriceCents: 100}},
+			},
+			{
+				CustomerEmail: "third@test",
+				LineItems:     []storage.LineItem{{Description: "item 4", Quantity: 3, PriceCents: 100}},
+			},
+			{
+				CustomerEmail: "third@test",
+				LineItems:     []storage.LineItem{{Description: "item 5", Quantity: 1, PriceCents: 100}},
+			},
+		}, readAll(t, r))
+	}
+
+	// the required columns have to be there
+	{
+		_, err := NewCSVReader(strings.NewReader("orderId,customerEmail,description,quantity\n"))
+		assert.EqualError(t, err, "missing column priceCents")
+	}
+
+	// numbers have to be numbers
+	{
+		r, err := NewCSVReader(strings.NewReader("customerEmail,description,quantity,priceCents\ntest@test,item,one,100\n"))
+		require.NoError(t, err)
+		_, err = r.Next()
+		assert.EqualError(t, err, `row 2: invalid quantity "one"`)
+	}
+}
+
+func TestNDJSONReader(t *testing.T) {
+	r := NewNDJSONReader(strings.NewReader(strings.Join([]string{
+		`{"id": "a", "customerEmail": "test@test", "lineItems": [{"description": "item 1", "quantity": 1, "priceCents": 100}], "status": 2}`,
+		``,
+		`{"customerEmail": "other@test", "currency": "EUR"}`,
+		`{"customerEmail": `,
+	}, "\n")))
+	order, err := r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, storage.Order{
+		ID:            "a",
+		CustomerEmail: "test@test",
+		LineItems:     []storage.LineItem{{Description: "item 1", Quantity: 1, PriceCents: 100}},
+		Status:        storage.OrderStatusFulfilled,
+	}, order)
+
+	// blank lines are skipped
+	order, err = r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "other@test", order.CustomerEmail)
+
+	_, err = r.Next()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "line 4")
+	}
+}
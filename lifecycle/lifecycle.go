@@ -0,0 +1,215 @@
+// Package lifecycle starts and stops the long-lived parts of the service, like
+// the HTTP server, background workers and storage, in a predictable order
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/levenlabs/go-llog"
+	"google.golang.org/grpc"
+)
+
+// Component is a single part of the service with a lifetime tied to the process
+type Component struct {
+	// Name is only used for logging and errors
+	Name string
+	// Start should return once the component is running. Anything long-running
+	// should happen in a goroutine that calls fail if it stops unexpectedly,
+	// which causes the Manager to stop everything. Start can be nil.
+	Start func(ctx context.Context, fail func(error)) error
+	// Stop should release everything the component holds and return before ctx
+	// is done. Stop can be nil.
+	Stop func(ctx context.Context) error
+}
+
+// HTTPServer returns a Component that starts serving on the server's Addr and
+// gracefully shuts the server down when stopped, waiting for in-flight requests
+// until the drain timeout
+func HTTPServer(server *http.Server) Component {
+	return Component{
+		Name: "http server",
+		Start: func(ctx context.Context, fail func(error)) error {
+			// we listen before returning so a bad address or a port that's already in
+			// use fails startup rather than being discovered later
+			ln, err := net.Listen("tcp", server.Addr)
+			if err != nil {
+				return err
+			}
+			go func() {
+				// Serve always returns ErrServerClosed after Shutdown so that's the one
+				// error that isn't a failure
+				if err := server.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+					fail(err)
+				}
+			}()
+			return nil
+		},
+		Stop: server.Shutdown,
+	}
+}
+
+// GRPCServer returns a Component that starts serving on addr and gracefully
+// stops the server when stopped, waiting for in-flight RPCs until the drain
+// timeout. Streams, like watching an order, are cut off at the drain timeout.
+func GRPCServer(server *grpc.Server, addr string) Component {
+	return Component{
+		Name: "grpc server",
+		Start: func(ctx context.Context, fail func(error)) error {
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				return err
+			}
+			go func() {
+				// Serve returns nil after GracefulStop or Stop
+				if err := server.Serve(ln); err != nil {
+					fail(err)
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			stopped := make(chan struct{})
+			go func() {
+				server.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+				return nil
+			case <-ctx.Done():
+				// this closes every connection which makes GracefulStop return
+				server.Stop()
+				return ctx.Err()
+			}
+		},
+	}
+}
+
+// Readiness is implemented by anything that tracks whether the process wants to
+// receive traffic, like *api.Readiness
+type Readiness interface {
+	SetReady(ready bool)
+}
+
+// Manager starts Components in the order they were added and stops them in the
+// reverse order so that, for example, the HTTP server stops before the storage
+// it depends on
+type Manager struct {
+	drainTimeout time.Duration
+	readiness    Readiness
+	components   []Component
+
+	// failures receives the first unexpected failure from a running component
+	// it's buffered so a failing component never blocks, later failures are
+	// dropped since we're already stopping by then
+	failures chan error
+}
+
+// New returns a Manager that gives the components drainTimeout in total to
+// stop. readiness is marked ready once everything has started and not ready
+// before anything is stopped. readiness can be nil.
+func New(drainTimeout time.Duration, readiness Readiness) *Manager {
+	return &Manager{
+		drainTimeout: drainTimeout,
+		readiness:    readiness,
+		failures:     make(chan error, 1),
+	}
+}
+
+// Add appends a component to be started after all of the previously added ones
+func (m *Manager) Add(c Component) {
+	m.components = append(m.components, c)
+}
+
+// Run starts every component and then blocks until ctx is done or a component
+// fails, at which point everything that was started is stopped. An error is
+// returned if any component failed to start, failed while running or failed to
+// stop.
+func (m *Manager) Run(ctx context.Context) error {
+	if m.readiness != nil {
+		// we're not ready until everything has started
+		m.readiness.SetReady(false)
+	}
+
+	started, err := m.start(ctx)
+	if err != nil {
+		// stop whatever already started so we don't leak connections on the way
+		// out, the start error is more interesting than any stop error though
+		m.stop(started)
+		return err
+	}
+	if m.readiness != nil {
+		m.readiness.SetReady(true)
+	}
+	llog.Info("started", llog.KV{"components": len(started)})
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		llog.Info("stopping", llog.KV{"reason": ctx.Err().Error()})
+	case runErr = <-m.failures:
+		llog.Error("stopping after failure", llog.ErrKV(runErr))
+	}
+
+	stopErr := m.stop(started)
+	if runErr != nil {
+		return runErr
+	}
+	return stopErr
+}
+
+// start starts the components in order and returns the ones that started
+func (m *Manager) start(ctx context.Context) ([]Component, error) {
+	started := make([]Component, 0, len(m.components))
+	for _, c := range m.components {
+		if c.Start != nil {
+			c := c
+			fail := func(err error) {
+				select {
+				case m.failures <- fmt.Errorf("%s failed: %w", c.Name, err):
+				default:
+				}
+			}
+			if err := c.Start(ctx, fail); err != nil {
+				return started, fmt.Errorf("error starting %s: %w", c.Name, err)
+			}
+		}
+		started = append(started, c)
+	}
+	return started, nil
+}
+
+// stop marks the process as not ready and then stops the components in reverse
+// order, sharing the drain timeout between all of them
+func (m *Manager) stop(started []Component) error {
+	if m.readiness != nil {
+		m.readiness.SetReady(false)
+	}
+
+	// the passed in context is most likely already cancelled at this point so we
+	// need a fresh one to bound how long stopping takes
+	ctx, cancel := context.WithTimeout(context.Background(), m.drainTimeout)
+	defer cancel()
+
+	var firstErr error
+	for idx := len(started) - 1; idx >= 0; idx-- {
+		c := started[idx]
+		if c.Stop == nil {
+			continue
+		}
+		// keep going on errors so that one stuck component doesn't prevent the
+		// others from releasing their resources
+		if err := c.Stop(ctx); err != nil {
+			llog.Error("error stopping component", llog.KV{"component": c.Name}, llog.ErrKV(err))
+			if firstErr == nil {
+				firstErr = fmt.Errorf("error stopping %s: %w", c.Name, err)
+			}
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,269 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// recorder keeps track of the order components were started and stopped in
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) record(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+// component returns a Component that records when it's started and stopped
+// and returns the passed errors
+func (r *recorder) component(name string, startErr, stopErr error) Component {
+	return Component{
+		Name: name,
+		Start: func(ctx context.Context, fail func(error)) error {
+			r.record("start " + name)
+			return startErr
+		},
+		Stop: func(ctx context.Context) error {
+			r.record("stop " + name)
+			return stopErr
+		},
+	}
+}
+
+// fakeReadiness records every readiness change
+type fakeReadiness struct {
+	rec *recorder
+}
+
+func (f fakeReadiness) SetReady(ready bool) {
+	if ready {
+		f.rec.record("ready")
+	} else {
+		f.rec.record("not ready")
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestManagerRun(t *testing.T) {
+	// starts in order and stops in reverse once the context is cancelled
+	{
+		rec := new(recorder)
+		m := New(time.Second, fakeReadiness{rec})
+		m.Add(rec.component("storage", nil, nil))
+		m.Add(rec.component("server", nil, nil))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := m.Run(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"not ready",
+			"start storage",
+			"start server",
+			"ready",
+			"not ready",
+			"stop server",
+			"stop storage",
+		}, rec.events)
+	}
+
+	// only stops the components that started when one fails to start
+	{
+		rec := new(recorder)
+		startErr := errors.New("port in use")
+		m := New(time.Second, fakeReadiness{rec})
+		m.Add(rec.component("storage", nil, nil))
+		m.Add(rec.component("server", startErr, nil))
+		m.Add(rec.component("worker", nil, nil))
+
+		err := m.Run(context.Background())
+		if assert.Error(t, err) {
+			assert.True(t, errors.Is(err, startErr), "%#v", err)
+		}
+		assert.Equal(t, []string{
+			"not ready",
+			"start storage",
+			"start server",
+			"not ready",
+			"stop storage",
+		}, rec.events)
+	}
+
+	// stops everything when a running component fails
+	{
+		rec := new(recorder)
+		runErr := errors.New("worker crashed")
+		m := New(time.Second, nil)
+		m.Add(rec.component("storage", nil, nil))
+		m.Add(Component{
+			Name: "worker",
+			Start: func(ctx context.Context, fail func(error)) error {
+				go fail(runErr)
+				return nil
+			},
+		})
+
+		err := m.Run(context.Background())
+		if assert.Error(t, err) {
+			assert.True(t, errors.Is(err, runErr), "%#v", err)
+		}
+		assert.Equal(t, []string{"start storage", "stop storage"}, rec.events)
+	}
+
+	// keeps stopping the rest when one component fails to stop
+	{
+		rec := new(recorder)
+		stopErr := errors.New("stuck")
+		m := New(time.Second, nil)
+		m.Add(rec.component("storage", nil, nil))
+		m.Add(rec.component("server", nil, stopErr))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := m.Run(ctx)
+		if assert.Error(t, err) {
+			assert.True(t, errors.Is(err, stopErr), "%#v", err)
+		}
+		assert.Contains(t, rec.events, "stop storage")
+	}
+
+	// bounds stopping by the drain timeout
+	{
+		m := New(10*time.Millisecond, nil)
+		m.Add(Component{
+			Name: "slow",
+			Stop: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := m.Run(ctx)
+		if assert.Error(t, err) {
+			assert.True(t, errors.Is(err, context.DeadlineExceeded), "%#v", err)
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestHTTPServer(t *testing.T) {
+	// fails to start when the address is already in use
+	{
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close()
+
+		c := HTTPServer(&http.Server{Addr: ln.Addr().String()})
+		err = c.Start(context.Background(), func(error) {})
+		assert.Error(t, err)
+	}
+
+	// serves requests until stopped
+	{
+		// grab a free port and release it so the server can listen on it
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := ln.Addr().String()
+		ln.Close()
+
+		server := &http.Server{
+			Addr: addr,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}),
+		}
+		c := HTTPServer(server)
+		err = c.Start(context.Background(), func(err error) {
+			t.Errorf("unexpected failure: %v", err)
+		})
+		require.NoError(t, err)
+
+		resp, err := http.Get("http://" + addr)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		err = c.Stop(context.Background())
+		require.NoError(t, err)
+	}
+}
+
+func TestGRPCServer(t *testing.T) {
+	// fails to start when the address is already in use
+	{
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close()
+
+		c := GRPCServer(grpc.NewServer(), ln.Addr().String())
+		err = c.Start(context.Background(), func(error) {})
+		assert.Error(t, err)
+	}
+
+	// serves until stopped
+	{
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := ln.Addr().String()
+		ln.Close()
+
+		c := GRPCServer(grpc.NewServer(), addr)
+		err = c.Start(context.Background(), func(err error) {
+			t.Errorf("unexpected failure: %v", err)
+		})
+		require.NoError(t, err)
+
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		conn.Close()
+
+		err = c.Stop(context.Background())
+		require.NoError(t, err)
+		_, err = net.Dial("tcp", addr)
+		assert.Error(t, err)
+	}
+
+	// in-flight RPCs are cut off once the drain timeout is up
+	{
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := ln.Addr().String()
+		ln.Close()
+
+		// the unknown service handler never returns until the stream is closed
+		server := grpc.NewServer(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+			<-stream.Context().Done()
+			return stream.Context().Err()
+		}))
+		c := GRPCServer(server, addr)
+		require.NoError(t, c.Start(context.Background(), func(error) {}))
+
+		conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+		defer conn.Close()
+		stream, err := conn.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/test.Test/Wait")
+		require.NoError(t, err)
+		require.NoError(t, stream.CloseSend())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, c.Stop(ctx), context.DeadlineExceeded)
+	}
+}
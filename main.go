@@ -9,11 +9,25 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/joho/godotenv"
+	"github.com/levenlabs/go-llog"
 	"github.com/levenlabs/order-up/api"
+	"github.com/levenlabs/order-up/auth"
+	"github.com/levenlabs/order-up/grpcapi"
+	"github.com/levenlabs/order-up/grpcapi/orderspb"
+	"github.com/levenlabs/order-up/lifecycle"
 	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/orders"
+	"github.com/levenlabs/order-up/ratelimit"
+	"github.com/levenlabs/order-up/services"
 	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/tax"
+	"google.golang.org/grpc"
 )
 
 func main() {
@@ -21,44 +35,214 @@ func main() {
 	if err != nil {
 		fmt.Println("Env vars not loaded. May experience degraded performance.")
 	}
+
+	// subcommands have their own flags so they're run before the service's flags
+	// are parsed
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			code := runImport(os.Args[2:])
+			llog.Flush()
+			os.Exit(code)
+		case "admin":
+			code := runAdmin(os.Args[2:])
+			llog.Flush()
+			os.Exit(code)
+		}
+	}
+
 	// flag.String returns a pointer to a string value that is set after
 	// flag.Parse() is called
 	addr := flag.String("listen-addr", "localhost:8888", "the address to listen on for API requests")
+	grpcAddr := flag.String("grpc-listen-addr", "localhost:8889", "the address to listen on for gRPC requests from internal services, empty disables the gRPC server")
+	drainTimeout := flag.Duration("drain-timeout", 15*time.Second, "how long to wait for in-flight work to finish when shutting down")
+	startTimeout := flag.Duration("start-timeout", 15*time.Second, "how long to wait for storage to be ready when starting")
+	rateLimitStore := flag.String("rate-limit-store", "memory", "where rate limit buckets are kept, either memory or storage which shares them between replicas")
+	taxRules := flag.String("tax-rules", "", "path to a JSON file of tax rules for each jurisdiction, orders aren't taxed without it")
+	shippingMethods := flag.String("shipping-methods", "", "path to a JSON file of shipping methods and their prices, orders can't choose a shipping method without it")
 	flag.Parse()
 
+	// the context is cancelled once we receive an interrupt signal (Ctrl+C) or a
+	// SIGTERM, which is what orchestrators send before killing the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// readiness starts out not ready and the lifecycle manager flips it once
+	// everything is started and back again before draining so the orchestrator
+	// stops sending us new requests
+	readiness := new(api.Readiness)
+
+	authCfg, err := authConfigFromEnv()
+	if err != nil {
+		llog.Error("error loading auth config", llog.ErrKV(err))
+		llog.Flush()
+		os.Exit(1)
+	}
+
+	var taxCalc tax.Calculator
+	if *taxRules != "" {
+		taxCalc, err = loadTaxRules(*taxRules)
+		if err != nil {
+			llog.Error("error loading tax rules", llog.ErrKV(err))
+			llog.Flush()
+			os.Exit(1)
+		}
+	}
+
+	var shipping orders.ShippingMethods
+	if *shippingMethods != "" {
+		shipping, err = loadShippingMethods(*shippingMethods)
+		if err != nil {
+			llog.Error("error loading shipping methods", llog.ErrKV(err))
+			llog.Flush()
+			os.Exit(1)
+		}
+	}
+
+	manager := lifecycle.New(*drainTimeout, readiness)
+
+	// storage is added first so that it's started first and stopped last since
+	// the HTTP server depends on it
+	var stor *storage.Instance
+	manager.Add(lifecycle.Component{
+		Name: "storage",
+		Start: func(ctx context.Context, fail func(error)) error {
+			ctx, cancel := context.WithTimeout(ctx, *startTimeout)
+			defer cancel()
+			var err error
+			stor, err = storage.Open(ctx, "")
+			return err
+		},
+		Stop: func(ctx context.Context) error {
+			return stor.Close(ctx)
+		},
+	})
+
+	// the gRPC server checks the same credentials as the HTTP API in its
+	// interceptors, the service is registered on it once storage is open
+	var grpcServer *grpc.Server
+	if *grpcAddr != "" {
+		grpcServer = grpc.NewServer(
+			grpc.UnaryInterceptor(grpcapi.UnaryInterceptor),
+			grpc.StreamInterceptor(grpcapi.StreamInterceptor),
+		)
+	}
+
 	server := new(http.Server)
 	// we dereference the address flag and set it on the server so the
-	// ListenAndServe call later knows what address to Listen on
+	// Serve call later knows what address to Listen on
 	server.Addr = *addr
-	// here we're calling the api package's Handler() function to get an instance of
-	// an http.Handler that we can set as the server's Handler
-	// on every HTTP request the server will call the handler's ServeHTTP function
-	server.Handler = api.Handler(
-		storage.New(""),
-		// we would replace these with actual clients that talk to the underlying services
-		// but for this contrived service we just iuggno
-		mocks.NewMockedService(unimplementedHandler),
-		mocks.NewMockedService(unimplementedHandler),
-	)
-
-	// if we just called ListenAndServe directly then we would never return since
-	// ListenAndServe starts listening for HTTP requests and blocks until the
-	// server is shutdown
-	go server.ListenAndServe()
-	// we want to gracefully shutdown the server right before the process stops
-	// the context isn't that important for this service but you could call something
-	// like context.WithTimeout if you wanted to only give the HTTP server a limited
-	// amount of time to shutdown
-	defer server.Shutdown(context.Background())
-
-	// if main returns then the process stops running so we instead wait for an
-	// interrupt signal (Ctrl+C) by creating a channel, passing it to the signal
-	// package and then waiting to receive something from the channel
-	ch := make(chan os.Signal)
-	signal.Notify(ch, os.Interrupt)
-	// once we receive something over this channel we will continue the function
-	// and end up returning, causing the process to stop
-	<-ch
+	manager.Add(lifecycle.Component{
+		Name: "http handler",
+		Start: func(ctx context.Context, fail func(error)) error {
+			// here we're calling the api package's Handler() function to get an
+			// instance of an http.Handler that we can set as the server's Handler
+			// on every HTTP request the server will call the handler's ServeHTTP
+			// function
+			// this has to happen once storage has been opened
+			var limiter ratelimit.Limiter
+			switch *rateLimitStore {
+			case "memory":
+				limiter = ratelimit.NewMemory()
+			case "storage":
+				limiter = ratelimit.NewShared(stor)
+			default:
+				return fmt.Errorf("unknown rate limit store: %q", *rateLimitStore)
+			}
+			// the options that change how orders are handled are shared with the
+			// gRPC server
+			var orderOpts []orders.Option
+			if taxCalc != nil {
+				orderOpts = append(orderOpts, orders.WithTaxCalculator(taxCalc))
+			}
+			if shipping != nil {
+				orderOpts = append(orderOpts, orders.WithShippingMethods(shipping))
+			}
+			opts := []api.Option{
+				api.WithReadiness(readiness),
+				api.WithAuth(authCfg),
+				api.WithRateLimit(api.RateLimitConfig{
+					Limiter: limiter,
+					Limits:  api.DefaultRateLimits(),
+				}),
+				api.WithOrderOptions(orderOpts...),
+			}
+			// we would replace these with actual clients that talk to the underlying services
+			// but for this contrived service we just iuggno
+			fulfillmentService := services.NewHTTPFulfillmentService(mocks.NewMockedService(unimplementedHandler))
+			chargeService := services.NewHTTPChargeService(mocks.NewMockedService(unimplementedHandler))
+			server.Handler = api.Handler(stor, fulfillmentService, chargeService, opts...)
+			if grpcServer != nil {
+				svc := orders.New(stor, chargeService, fulfillmentService, orderOpts...)
+				orderspb.RegisterOrderServiceServer(grpcServer, grpcapi.NewServer(svc, grpcapi.WithAuth(auth.New(stor, authCfg))))
+			}
+			return nil
+		},
+	})
+	// any background workers should be added here, after storage and before the
+	// server, so they're stopped after the server stops handing them work
+	manager.Add(lifecycle.HTTPServer(server))
+	if grpcServer != nil {
+		manager.Add(lifecycle.GRPCServer(grpcServer, *grpcAddr))
+	}
+
+	// Run blocks until the context is cancelled by a signal or something fails
+	if err := manager.Run(ctx); err != nil {
+		llog.Error("exiting after error", llog.ErrKV(err))
+		// llog writes asynchronously so make sure everything is written before we
+		// exit since os.Exit doesn't run deferred functions
+		llog.Flush()
+		os.Exit(1)
+	}
+	llog.Flush()
+}
+
+// authConfigFromEnv builds the JWT verification config from the environment.
+// API keys don't need any config since they're stored in the database.
+// JWT_HS256_SECRETS is a comma-separated list of shared secrets and
+// JWT_RS256_PUBLIC_KEY_FILE is the path to a PEM-encoded RSA public key.
+func authConfigFromEnv() (auth.Config, error) {
+	cfg := auth.Config{
+		Issuer:   os.Getenv("JWT_ISSUER"),
+		Audience: os.Getenv("JWT_AUDIENCE"),
+	}
+	for _, secret := range strings.Split(os.Getenv("JWT_HS256_SECRETS"), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			cfg.HS256Secrets = append(cfg.HS256Secrets, []byte(secret))
+		}
+	}
+	if path := os.Getenv("JWT_RS256_PUBLIC_KEY_FILE"); path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return auth.Config{}, fmt.Errorf("error reading RS256 public key: %w", err)
+		}
+		key, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return auth.Config{}, fmt.Errorf("error parsing RS256 public key: %w", err)
+		}
+		cfg.RS256PublicKeys = append(cfg.RS256PublicKeys, key)
+	}
+	return cfg, nil
+}
+
+// loadTaxRules reads the tax rule table from the JSON file at path
+func loadTaxRules(path string) (tax.RuleTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening tax rules: %w", err)
+	}
+	defer f.Close()
+	return tax.LoadRuleTable(f)
+}
+
+// loadShippingMethods reads the shipping methods from the JSON file at path
+func loadShippingMethods(path string) (orders.ShippingMethods, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening shipping methods: %w", err)
+	}
+	defer f.Close()
+	return orders.LoadShippingMethods(f)
 }
 
 var unimplementedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
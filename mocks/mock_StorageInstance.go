@@ -4,6 +4,7 @@ package mocks
 
 import (
 	context "context"
+	time "time"
 
 	storage "github.com/levenlabs/order-up/storage"
 	mock "github.com/stretchr/testify/mock"
@@ -14,6 +15,155 @@ type MockStorageInstance struct {
 	mock.Mock
 }
 
+// AddOrderPayment provides a mock function with given fields: ctx, id, payment
+func (_m *MockStorageInstance) AddOrderPayment(ctx context.Context, id string, payment storage.Payment) error {
+	ret := _m.Called(ctx, id, payment)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, storage.Payment) error); ok {
+		r0 = rf(ctx, id, payment)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CompleteIdempotencyRecord provides a mock function with given fields: ctx, rec
+func (_m *MockStorageInstance) CompleteIdempotencyRecord(ctx context.Context, rec storage.IdempotencyRecord) error {
+	ret := _m.Called(ctx, rec)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, storage.IdempotencyRecord) error); ok {
+		r0 = rf(ctx, rec)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteAPIKey provides a mock function with given fields: ctx, id
+func (_m *MockStorageInstance) DeleteAPIKey(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteIdempotencyRecord provides a mock function with given fields: ctx, key
+func (_m *MockStorageInstance) DeleteIdempotencyRecord(ctx context.Context, key string) error {
+	ret := _m.Called(ctx, key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeletePromotion provides a mock function with given fields: ctx, code
+func (_m *MockStorageInstance) DeletePromotion(ctx context.Context, code string) error {
+	ret := _m.Called(ctx, code)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, code)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// EachOrder provides a mock function with given fields: ctx, filter, fn
+func (_m *MockStorageInstance) EachOrder(ctx context.Context, filter storage.OrderFilter, fn func(storage.Order) error) error {
+	ret := _m.Called(ctx, filter, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, storage.OrderFilter, func(storage.Order) error) error); ok {
+		r0 = rf(ctx, filter, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetAPIKeyByHash provides a mock function with given fields: ctx, hash
+func (_m *MockStorageInstance) GetAPIKeyByHash(ctx context.Context, hash string) (storage.APIKey, error) {
+	ret := _m.Called(ctx, hash)
+
+	var r0 storage.APIKey
+	if rf, ok := ret.Get(0).(func(context.Context, string) storage.APIKey); ok {
+		r0 = rf(ctx, hash)
+	} else {
+		r0 = ret.Get(0).(storage.APIKey)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, hash)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAPIKeys provides a mock function with given fields: ctx
+func (_m *MockStorageInstance) GetAPIKeys(ctx context.Context) ([]storage.APIKey, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []storage.APIKey
+	if rf, ok := ret.Get(0).(func(context.Context) []storage.APIKey); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]storage.APIKey)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetIdempotencyRecord provides a mock function with given fields: ctx, key
+func (_m *MockStorageInstance) GetIdempotencyRecord(ctx context.Context, key string) (storage.IdempotencyRecord, error) {
+	ret := _m.Called(ctx, key)
+
+	var r0 storage.IdempotencyRecord
+	if rf, ok := ret.Get(0).(func(context.Context, string) storage.IdempotencyRecord); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Get(0).(storage.IdempotencyRecord)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetOrder provides a mock function with given fields: ctx, id
 func (_m *MockStorageInstance) GetOrder(ctx context.Context, id string) (storage.Order, error) {
 	ret := _m.Called(ctx, id)
@@ -35,13 +185,13 @@ func (_m *MockStorageInstance) GetOrder(ctx context.Context, id string) (storage
 	return r0, r1
 }
 
-// GetOrders provides a mock function with given fields: ctx, status
-func (_m *MockStorageInstance) GetOrders(ctx context.Context, status storage.OrderStatus) ([]storage.Order, error) {
-	ret := _m.Called(ctx, status)
+// GetOrders provides a mock function with given fields: ctx, filter
+func (_m *MockStorageInstance) GetOrders(ctx context.Context, filter storage.OrderFilter) ([]storage.Order, error) {
+	ret := _m.Called(ctx, filter)
 
 	var r0 []storage.Order
-	if rf, ok := ret.Get(0).(func(context.Context, storage.OrderStatus) []storage.Order); ok {
-		r0 = rf(ctx, status)
+	if rf, ok := ret.Get(0).(func(context.Context, storage.OrderFilter) []storage.Order); ok {
+		r0 = rf(ctx, filter)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]storage.Order)
@@ -49,8 +199,73 @@ func (_m *MockStorageInstance) GetOrders(ctx context.Context, status storage.Ord
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, storage.OrderStatus) error); ok {
-		r1 = rf(ctx, status)
+	if rf, ok := ret.Get(1).(func(context.Context, storage.OrderFilter) error); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetPromotion provides a mock function with given fields: ctx, code
+func (_m *MockStorageInstance) GetPromotion(ctx context.Context, code string) (storage.Promotion, error) {
+	ret := _m.Called(ctx, code)
+
+	var r0 storage.Promotion
+	if rf, ok := ret.Get(0).(func(context.Context, string) storage.Promotion); ok {
+		r0 = rf(ctx, code)
+	} else {
+		r0 = ret.Get(0).(storage.Promotion)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, code)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetPromotions provides a mock function with given fields: ctx
+func (_m *MockStorageInstance) GetPromotions(ctx context.Context) ([]storage.Promotion, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []storage.Promotion
+	if rf, ok := ret.Get(0).(func(context.Context) []storage.Promotion); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]storage.Promotion)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// InsertAPIKey provides a mock function with given fields: ctx, key
+func (_m *MockStorageInstance) InsertAPIKey(ctx context.Context, key storage.APIKey) (string, error) {
+	ret := _m.Called(ctx, key)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, storage.APIKey) string); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, storage.APIKey) error); ok {
+		r1 = rf(ctx, key)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -58,6 +273,20 @@ func (_m *MockStorageInstance) GetOrders(ctx context.Context, status storage.Ord
 	return r0, r1
 }
 
+// InsertIdempotencyRecord provides a mock function with given fields: ctx, rec, expiresAt
+func (_m *MockStorageInstance) InsertIdempotencyRecord(ctx context.Context, rec storage.IdempotencyRecord, expiresAt time.Time) error {
+	ret := _m.Called(ctx, rec, expiresAt)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, storage.IdempotencyRecord, time.Time) error); ok {
+		r0 = rf(ctx, rec, expiresAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // InsertOrder provides a mock function with given fields: ctx, order
 func (_m *MockStorageInstance) InsertOrder(ctx context.Context, order storage.Order) (string, error) {
 	ret := _m.Called(ctx, order)
@@ -79,13 +308,115 @@ func (_m *MockStorageInstance) InsertOrder(ctx context.Context, order storage.Or
 	return r0, r1
 }
 
-// SetOrderStatus provides a mock function with given fields: ctx, id, status
-func (_m *MockStorageInstance) SetOrderStatus(ctx context.Context, id string, status storage.OrderStatus) error {
-	ret := _m.Called(ctx, id, status)
+// InsertOrders provides a mock function with given fields: ctx, orders, atomic
+func (_m *MockStorageInstance) InsertOrders(ctx context.Context, orders []storage.Order, atomic bool) ([]string, []error, error) {
+	ret := _m.Called(ctx, orders, atomic)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(context.Context, []storage.Order, bool) []string); ok {
+		r0 = rf(ctx, orders, atomic)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 []error
+	if rf, ok := ret.Get(1).(func(context.Context, []storage.Order, bool) []error); ok {
+		r1 = rf(ctx, orders, atomic)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).([]error)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, []storage.Order, bool) error); ok {
+		r2 = rf(ctx, orders, atomic)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// InsertPromotion provides a mock function with given fields: ctx, promo
+func (_m *MockStorageInstance) InsertPromotion(ctx context.Context, promo storage.Promotion) error {
+	ret := _m.Called(ctx, promo)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, storage.Promotion) error); ok {
+		r0 = rf(ctx, promo)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Ping provides a mock function with given fields: ctx
+func (_m *MockStorageInstance) Ping(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RedeemPromotion provides a mock function with given fields: ctx, code, customerEmail
+func (_m *MockStorageInstance) RedeemPromotion(ctx context.Context, code string, customerEmail string) error {
+	ret := _m.Called(ctx, code, customerEmail)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, code, customerEmail)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ReleasePromotion provides a mock function with given fields: ctx, code, customerEmail
+func (_m *MockStorageInstance) ReleasePromotion(ctx context.Context, code string, customerEmail string) error {
+	ret := _m.Called(ctx, code, customerEmail)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, code, customerEmail)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetOrderStatus provides a mock function with given fields: ctx, id, status, version
+func (_m *MockStorageInstance) SetOrderStatus(ctx context.Context, id string, status storage.OrderStatus, version int64) error {
+	ret := _m.Called(ctx, id, status, version)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, storage.OrderStatus, int64) error); ok {
+		r0 = rf(ctx, id, status, version)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateOrder provides a mock function with given fields: ctx, order, changes
+func (_m *MockStorageInstance) UpdateOrder(ctx context.Context, order storage.Order, changes []storage.FieldChange) error {
+	ret := _m.Called(ctx, order, changes)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, storage.OrderStatus) error); ok {
-		r0 = rf(ctx, id, status)
+	if rf, ok := ret.Get(0).(func(context.Context, storage.Order, []storage.FieldChange) error); ok {
+		r0 = rf(ctx, order, changes)
 	} else {
 		r0 = ret.Error(0)
 	}
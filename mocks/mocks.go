@@ -1,5 +1,8 @@
 // Package mocks contains code to mock an external service via an *http.Client and
-// generated code for mocking *storage.Instance.
+// generated code for mocking *storage.Instance and the services in the services
+// package.
 package mocks
 
 //go:generate go run github.com/vektra/mockery/v2@latest --name=StorageInstance --inpackage
+//go:generate go run github.com/vektra/mockery/v2@latest --name=ChargeService --dir=../services --output=. --outpkg=mocks --structname=MockChargeService --filename=mock_ChargeService.go
+//go:generate go run github.com/vektra/mockery/v2@latest --name=FulfillmentService --dir=../services --output=. --outpkg=mocks --structname=MockFulfillmentService --filename=mock_FulfillmentService.go
@@ -4,6 +4,7 @@ package mocks
 
 import (
 	"context"
+	"time"
 
 	"github.com/levenlabs/order-up/storage"
 )
@@ -13,15 +14,92 @@ type StorageInstance interface {
 	// GetOrder should return the order with the given ID. If that ID isn't found then
 	// the special ErrOrderNotFound error should be returned.
 	GetOrder(ctx context.Context, id string) (storage.Order, error)
-	// GetOrders should return all orders with the given status. If status is the
-	// special -1 value then it should return all orders regardless of their status.
-	GetOrders(ctx context.Context, status storage.OrderStatus) ([]storage.Order, error)
-	// SetOrderStatus should update the order with the given ID and set the status
-	// field. If that ID isn't found then the special ErrOrderNotFound error should
-	// be returned.
-	SetOrderStatus(ctx context.Context, id string, status storage.OrderStatus) error
+	// GetOrders should return all orders matching the filter, sorted by ID. A
+	// zero value filter with Status set to OrderStatusAny returns every order.
+	GetOrders(ctx context.Context, filter storage.OrderFilter) ([]storage.Order, error)
+	// EachOrder should call fn with every order matching the filter, sorted by ID,
+	// without loading all of them into memory at once. If fn returns an error then
+	// EachOrder should stop and return it.
+	EachOrder(ctx context.Context, filter storage.OrderFilter, fn func(storage.Order) error) error
+	// SetOrderStatus should update the order with the given ID, set the status
+	// field, increment its version and append an entry to the order's history with
+	// the actor and reason from the context. The order is only updated if its
+	// version is still version. If that ID isn't found then the special
+	// ErrOrderNotFound error and if its version changed then ErrVersionConflict
+	// should be returned.
+	SetOrderStatus(ctx context.Context, id string, status storage.OrderStatus, version int64) error
+	// AddOrderPayment should append payment to the payments of the order with the
+	// given ID and increment its version without checking it. If that ID isn't
+	// found then the special ErrOrderNotFound error should be returned.
+	AddOrderPayment(ctx context.Context, id string, payment storage.Payment) error
 	// InsertOrder should fill in the order's ID with a unique identifier if it's not
 	// already set and then insert it into the database. It should return the order's
 	// ID. If the order already exists then ErrOrderExists should be returned.
 	InsertOrder(ctx context.Context, order storage.Order) (string, error)
+	// InsertOrders should insert every order with a single bulk write, filling in
+	// the IDs of the orders that don't have one. It should return the ID of every
+	// order and, at the same index, the error for each order that couldn't be
+	// inserted or nil if it was. If atomic is true then either every order is
+	// inserted or none are and the orders that didn't fail themselves get the
+	// special ErrBatchAborted error. The final error means the whole batch failed.
+	InsertOrders(ctx context.Context, orders []storage.Order, atomic bool) ([]string, []error, error)
+	// UpdateOrder should replace the customer email, notes, line items, tax,
+	// jurisdiction, addresses and shipping method of the order with order's,
+	// increment its version and append a history entry with the changes and the
+	// actor from the context. Only pending orders whose version is still
+	// order.Version can be updated. If the order's ID isn't found then the special
+	// ErrOrderNotFound error, if it's no longer pending then ErrOrderNotPending and
+	// if its version changed then ErrVersionConflict should be returned.
+	UpdateOrder(ctx context.Context, order storage.Order, changes []storage.FieldChange) error
+	// Ping should return nil if the database is reachable and the schema has been
+	// ensured. It's used to decide whether the service is ready to accept traffic.
+	Ping(ctx context.Context) error
+	// InsertAPIKey should fill in the key's ID with a unique identifier if it's not
+	// already set and then insert it into the database. It should return the key's
+	// ID.
+	InsertAPIKey(ctx context.Context, key storage.APIKey) (string, error)
+	// GetAPIKeyByHash should return the API key with the given hash. If no key has
+	// that hash then the special ErrAPIKeyNotFound error should be returned.
+	GetAPIKeyByHash(ctx context.Context, hash string) (storage.APIKey, error)
+	// GetAPIKeys should return all of the API keys
+	GetAPIKeys(ctx context.Context) ([]storage.APIKey, error)
+	// DeleteAPIKey should delete the API key with the given ID. If that ID isn't
+	// found then the special ErrAPIKeyNotFound error should be returned.
+	DeleteAPIKey(ctx context.Context, id string) error
+	// InsertPromotion should insert the promotion into the database. If a promotion
+	// with the same code already exists then ErrPromotionExists should be returned.
+	InsertPromotion(ctx context.Context, promo storage.Promotion) error
+	// GetPromotion should return the promotion with the given code. If that code
+	// isn't found then the special ErrPromotionNotFound error should be returned.
+	GetPromotion(ctx context.Context, code string) (storage.Promotion, error)
+	// GetPromotions should return all of the promotions
+	GetPromotions(ctx context.Context) ([]storage.Promotion, error)
+	// DeletePromotion should delete the promotion with the given code. If that code
+	// isn't found then the special ErrPromotionNotFound error should be returned.
+	DeletePromotion(ctx context.Context, code string) error
+	// RedeemPromotion should atomically record that the customer used the
+	// promotion. If the promotion doesn't exist then ErrPromotionNotFound, if it
+	// expired then ErrPromotionExpired and if it was already redeemed the maximum
+	// number of times in total or by the customer then ErrPromotionLimitReached
+	// should be returned.
+	RedeemPromotion(ctx context.Context, code, customerEmail string) error
+	// ReleasePromotion should undo a previous RedeemPromotion for the customer, like
+	// when the order using it couldn't be created
+	ReleasePromotion(ctx context.Context, code, customerEmail string) error
+	// InsertIdempotencyRecord should insert the record, which is still in
+	// progress, unless there's already a record with the same key in which case
+	// ErrIdempotencyRecordExists should be returned. The record can be deleted
+	// after expiresAt.
+	InsertIdempotencyRecord(ctx context.Context, rec storage.IdempotencyRecord, expiresAt time.Time) error
+	// GetIdempotencyRecord should return the record with the given key. If there
+	// is no record then the special ErrIdempotencyRecordNotFound error should be
+	// returned.
+	GetIdempotencyRecord(ctx context.Context, key string) (storage.IdempotencyRecord, error)
+	// CompleteIdempotencyRecord should save the response's status, header and
+	// body on the record with the same key. If there is no record then the
+	// special ErrIdempotencyRecordNotFound error should be returned.
+	CompleteIdempotencyRecord(ctx context.Context, rec storage.IdempotencyRecord) error
+	// DeleteIdempotencyRecord should delete the record with the given key so the
+	// key can be used again. It's not an error if there is no record.
+	DeleteIdempotencyRecord(ctx context.Context, key string) error
 }
@@ -0,0 +1,114 @@
+package orders
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/validation"
+)
+
+// MaxBatchOrders is the most orders a single CreateBatch call can create
+const MaxBatchOrders = 500
+
+// CreateBatch creates every order in args just like Create but a failed order
+// doesn't stop the rest unless atomic is true in which case either every order
+// is created or none are and the orders that didn't fail themselves get
+// storage.ErrBatchAborted. It returns the orders and, at the same index, the
+// error for each order that couldn't be created. The final error means the
+// whole batch failed, like when there are too many orders or the database is
+// unreachable, and none of the orders were created.
+func (s *Service) CreateBatch(ctx context.Context, args []CreateArgs, atomic bool, customerEmail string) ([]storage.Order, []error, error) {
+	switch {
+	case len(args) < 1:
+		return nil, nil, validation.Violations{{Field: "orders", Message: "a batch must contain at least one order"}}
+	case len(args) > MaxBatchOrders:
+		return nil, nil, validation.Violations{{Field: "orders", Message: fmt.Sprintf("a batch cannot contain more than %d orders", MaxBatchOrders)}}
+	}
+
+	batch := make([]storage.Order, len(args))
+	errs := make([]error, len(args))
+	for idx, orderArgs := range args {
+		batch[idx], errs[idx] = s.NewOrder(ctx, orderArgs, customerEmail)
+	}
+	if atomic && abortBatch(errs) {
+		return batch, errs, nil
+	}
+
+	// the promotions are redeemed right before the orders are inserted, just like
+	// in Create, and released again for every redeemed order that isn't
+	// inserted. RedeemPromotions already releases them when it fails.
+	redeemed := make([]bool, len(batch))
+	for idx, order := range batch {
+		if errs[idx] == nil {
+			errs[idx] = s.RedeemPromotions(ctx, order)
+			redeemed[idx] = errs[idx] == nil
+		}
+	}
+	releaseFailed := func() {
+		for idx, order := range batch {
+			if redeemed[idx] && errs[idx] != nil {
+				s.ReleasePromotions(ctx, order)
+				redeemed[idx] = false
+			}
+		}
+	}
+	if atomic && abortBatch(errs) {
+		releaseFailed()
+		return batch, errs, nil
+	}
+
+	// only the orders that are still fine are inserted and insertIdxs maps them
+	// back to their index in the batch
+	var insert []storage.Order
+	var insertIdxs []int
+	for idx, order := range batch {
+		if errs[idx] == nil {
+			insert = append(insert, order)
+			insertIdxs = append(insertIdxs, idx)
+		}
+	}
+	if len(insert) == 0 {
+		return batch, errs, nil
+	}
+	ids, insertErrs, err := s.store.InsertOrders(ctx, insert, atomic)
+	if err != nil {
+		for _, idx := range insertIdxs {
+			errs[idx] = err
+		}
+		releaseFailed()
+		return nil, nil, fmt.Errorf("error inserting orders: %w", err)
+	}
+	for n, idx := range insertIdxs {
+		if insertErrs[n] != nil {
+			errs[idx] = fmt.Errorf("error inserting order: %w", insertErrs[n])
+			continue
+		}
+		batch[idx].ID = ids[n]
+		// every order starts at version 1, see storage.Order
+		batch[idx].Version = 1
+	}
+	releaseFailed()
+	return batch, errs, nil
+}
+
+// abortBatch replaces the nil errors in errs with storage.ErrBatchAborted if
+// any of them isn't nil and returns true if it did
+func abortBatch(errs []error) bool {
+	failed := false
+	for _, err := range errs {
+		if err != nil {
+			failed = true
+			break
+		}
+	}
+	if !failed {
+		return false
+	}
+	for idx := range errs {
+		if errs[idx] == nil {
+			errs[idx] = storage.ErrBatchAborted
+		}
+	}
+	return true
+}
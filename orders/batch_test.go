@@ -0,0 +1,97 @@
+package orders
+
+import (
+	"context"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateBatch(t *testing.T) {
+	ctx := context.Background()
+	percent := storage.Promotion{Code: "SUMMER10", Kind: storage.PromotionKindPercentage, PercentOff: 10}
+	valid := CreateArgs{
+		CustomerEmail: "test@test",
+		LineItems:     []storage.LineItem{{Description: "item 1", Quantity: 1, PriceCents: 1000}},
+	}
+	// expValid is valid once it's been built by NewOrder
+	expValid := storage.Order{
+		CustomerEmail: "test@test",
+		Currency:      storage.DefaultCurrency,
+		LineItems:     valid.LineItems,
+	}
+	promo := valid
+	promo.PromoCodes = []string{"SUMMER10"}
+	expPromo := expValid
+	expPromo.LineItems = append(expValid.LineItems, storage.LineItem{
+		Description: "Promotion SUMMER10", Quantity: 1, PriceCents: -100, Kind: storage.LineItemKindDiscount,
+	})
+	expPromo.PromoCodes = []string{"SUMMER10"}
+	invalid := CreateArgs{CustomerEmail: "test@test"}
+
+	// every order gets its own result and failures don't stop the rest, the
+	// promotion of an order that wasn't inserted is given back
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetPromotion", ctx, "SUMMER10").Return(percent, nil).Once()
+		stor.On("RedeemPromotion", ctx, "SUMMER10", "test@test").Return(nil).Once()
+		stor.On("InsertOrders", ctx, []storage.Order{expValid, expPromo}, false).
+			Return([]string{"id1", "id2"}, []error{nil, storage.ErrOrderExists}, nil).Once()
+		stor.On("ReleasePromotion", ctx, "SUMMER10", "test@test").Return(nil).Once()
+		batch, errs, err := New(stor, nil, nil).CreateBatch(ctx, []CreateArgs{valid, invalid, promo}, false, "")
+		require.NoError(t, err)
+		require.Len(t, batch, 3)
+		assert.Equal(t, "id1", batch[0].ID)
+		assert.EqualValues(t, 1, batch[0].Version)
+		assert.NoError(t, errs[0])
+		assert.IsType(t, validation.Violations{}, errs[1])
+		assert.ErrorIs(t, errs[2], storage.ErrOrderExists)
+		stor.AssertExpectations(t)
+	}
+
+	// with atomic nothing is redeemed or inserted if any order is invalid
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetPromotion", ctx, "SUMMER10").Return(percent, nil).Once()
+		_, errs, err := New(stor, nil, nil).CreateBatch(ctx, []CreateArgs{promo, invalid}, true, "")
+		require.NoError(t, err)
+		assert.ErrorIs(t, errs[0], storage.ErrBatchAborted)
+		assert.IsType(t, validation.Violations{}, errs[1])
+		stor.AssertExpectations(t)
+	}
+
+	// the whole batch fails if storage does and the promotions are given back
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetPromotion", ctx, "SUMMER10").Return(percent, nil).Once()
+		stor.On("RedeemPromotion", ctx, "SUMMER10", "test@test").Return(nil).Once()
+		stor.On("InsertOrders", ctx, []storage.Order{expPromo}, true).Return(nil, nil, assert.AnError).Once()
+		stor.On("ReleasePromotion", ctx, "SUMMER10", "test@test").Return(nil).Once()
+		_, _, err := New(stor, nil, nil).CreateBatch(ctx, []CreateArgs{promo}, true, "")
+		assert.ErrorIs(t, err, assert.AnError)
+		stor.AssertExpectations(t)
+	}
+
+	// customers can only create their own orders
+	{
+		stor := new(mocks.MockStorageInstance)
+		_, errs, err := New(stor, nil, nil).CreateBatch(ctx, []CreateArgs{valid}, false, "other@test")
+		require.NoError(t, err)
+		assert.ErrorIs(t, errs[0], ErrCustomerMismatch)
+		stor.AssertExpectations(t)
+	}
+
+	// the batch itself is validated
+	{
+		stor := new(mocks.MockStorageInstance)
+		_, _, err := New(stor, nil, nil).CreateBatch(ctx, nil, false, "")
+		assert.Equal(t, validation.Violations{{Field: "orders", Message: "a batch must contain at least one order"}}, err)
+		_, _, err = New(stor, nil, nil).CreateBatch(ctx, make([]CreateArgs, MaxBatchOrders+1), false, "")
+		assert.Equal(t, validation.Violations{{Field: "orders", Message: "a batch cannot contain more than 500 orders"}}, err)
+		stor.AssertExpectations(t)
+	}
+}
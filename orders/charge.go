@@ -0,0 +1,193 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/levenlabs/go-llog"
+	"github.com/levenlabs/order-up/services"
+	"github.com/levenlabs/order-up/storage"
+)
+
+// ChargeResult is the result of Charge
+type ChargeResult struct {
+	// Charged is the order's total that was charged to the card
+	Charged storage.Money
+	// ChargeID is the charge service's ID for the charge, it's empty if the
+	// order's total was zero and nothing was charged
+	ChargeID string
+	// Version is the order's version after it was marked as charged
+	Version int64
+}
+
+// Charge charges the order's total to the card and marks the order as charged.
+// Orders that were already charged, fulfilled or cancelled, or that another
+// request is in the middle of changing, can't be charged.
+func (s *Service) Charge(ctx context.Context, id, cardToken string, preconds ...Precondition) (ChargeResult, error) {
+	order, err := s.getOrder(ctx, id, preconds)
+	if err != nil {
+		return ChargeResult{}, err
+	}
+	if order.Status == storage.OrderStatusCharged || order.Status == storage.OrderStatusFulfilled ||
+		order.Status == storage.OrderStatusCancelled || order.Status.InProgress() {
+		return ChargeResult{}, &TransitionError{Action: ActionCharge, Status: order.Status}
+	}
+	total := order.Total()
+	if total.Amount < 0 {
+		return ChargeResult{}, ErrNegativeTotal
+	}
+
+	// the order is marked as charging first so a concurrent request, like
+	// another charge or an edit, can't change it while the card is charged
+	version, err := s.claim(ctx, order, storage.OrderStatusCharging)
+	if err != nil {
+		return ChargeResult{}, err
+	}
+
+	// there's nothing to charge for a free order
+	var charge services.Charge
+	if total.Amount != 0 {
+		charge, err = s.charges.Charge(ctx, services.ChargeArgs{
+			CardToken:   cardToken,
+			AmountCents: total.Amount,
+			Currency:    total.Currency,
+		})
+		if err != nil {
+			s.release(ctx, order.ID, order.Status, version)
+			return ChargeResult{}, fmt.Errorf("error charging order: %w", err)
+		}
+		// the charge is recorded before the status changes so that the money
+		// that moved is on the order even if the status can't be changed. If it
+		// can't be recorded the order is left charging for admin reconcile.
+		version, err = s.recordPayment(ctx, order.ID, version, storage.PaymentKindCharge, "", charge)
+		if err != nil {
+			return ChargeResult{}, err
+		}
+	}
+
+	// if this service crashed before this line then the order is left charging
+	// with the charge in its payments, admin reconcile reports it so it can be
+	// fixed by hand
+	err = s.store.SetOrderStatus(ctx, order.ID, storage.OrderStatusCharged, version)
+	if errors.Is(err, storage.ErrVersionConflict) && total.Amount != 0 {
+		// the order was changed even though it was marked as charging, like with
+		// admin force-status, so the amount we charged can't be trusted and we
+		// give it back
+		refund, rerr := s.charges.Refund(ctx, services.RefundArgs{
+			ChargeID:    charge.ID,
+			AmountCents: charge.AmountCents,
+			Currency:    charge.Currency,
+		})
+		if rerr != nil {
+			llog.Error("error refunding charge after version conflict", llog.ErrKV(rerr), llog.KV{
+				"orderID":     order.ID,
+				"chargeID":    charge.ID,
+				"amountCents": charge.AmountCents,
+				"currency":    charge.Currency,
+			})
+		} else {
+			// the order isn't ours anymore so a refund that can't be recorded is
+			// only logged by recordPayment
+			s.recordPayment(ctx, order.ID, version, storage.PaymentKindRefund, charge.ID, refund)
+		}
+	}
+	if err != nil {
+		return ChargeResult{}, fmt.Errorf("error updating order to charged: %w", err)
+	}
+	return ChargeResult{Charged: total, ChargeID: charge.ID, Version: version + 1}, nil
+}
+
+// CancelResult is the result of Cancel
+type CancelResult struct {
+	// Refunded is the amount that was refunded to the card, which is negative,
+	// or zero if the order wasn't charged
+	Refunded storage.Money
+	// Refunds are the charge service's refunds that were made, one for each
+	// charge that had anything left to give back
+	Refunds []services.Charge
+	// Cancelled is true if the order's status was changed. Only charged orders
+	// are, pending ones are left as they are.
+	Cancelled bool
+	// Version is the order's version after it was cancelled
+	Version int64
+}
+
+// Cancel refunds what's left of every charge in a charged order's payments and
+// marks it as cancelled. The refunds go back to the cards that were charged so
+// no card is needed. Fulfilled orders, and ones that another request is in the
+// middle of changing, can't be cancelled and charged orders with a total but no
+// recorded charge return ErrChargeNotRecorded.
+func (s *Service) Cancel(ctx context.Context, id string, preconds ...Precondition) (CancelResult, error) {
+	order, err := s.getOrder(ctx, id, preconds)
+	if err != nil {
+		return CancelResult{}, err
+	}
+	res := CancelResult{
+		Refunded: storage.Money{Currency: order.Currency.OrDefault()},
+		Version:  order.Version,
+	}
+	switch order.Status {
+	case storage.OrderStatusPending, storage.OrderStatusCancelled:
+		return res, nil
+	case storage.OrderStatusCharged:
+	default:
+		return CancelResult{}, &TransitionError{Action: ActionCancel, Status: order.Status}
+	}
+
+	refundable := order.Refundable()
+	if len(refundable) == 0 && order.Total().Amount != 0 {
+		return CancelResult{}, ErrChargeNotRecorded
+	}
+
+	// the order is marked as cancelling first so a concurrent cancel or fulfill
+	// can't refund or ship it too
+	version, err := s.claim(ctx, order, storage.OrderStatusCancelling)
+	if err != nil {
+		return CancelResult{}, err
+	}
+
+	// refund the charges in a stable order so retries and logs are predictable
+	chargeIDs := make([]string, 0, len(refundable))
+	for chargeID := range refundable {
+		chargeIDs = append(chargeIDs, chargeID)
+	}
+	sort.Strings(chargeIDs)
+	for _, chargeID := range chargeIDs {
+		left := refundable[chargeID]
+		refund, err := s.charges.Refund(ctx, services.RefundArgs{
+			ChargeID:    chargeID,
+			AmountCents: left.Amount,
+			Currency:    left.Currency,
+		})
+		if err != nil {
+			// the refunds that were already made are recorded so a retry only
+			// refunds what's left
+			s.release(ctx, order.ID, storage.OrderStatusCharged, version)
+			return CancelResult{}, fmt.Errorf("error refunding charge %s: %w", chargeID, err)
+		}
+		// a refund that can't be recorded leaves the order cancelling for admin
+		// reconcile since a retry couldn't tell it was already refunded
+		version, err = s.recordPayment(ctx, order.ID, version, storage.PaymentKindRefund, chargeID, refund)
+		if err != nil {
+			return CancelResult{}, err
+		}
+		res.Refunded.Amount += refund.AmountCents
+		res.Refunds = append(res.Refunds, refund)
+	}
+
+	err = s.store.SetOrderStatus(ctx, order.ID, storage.OrderStatusCancelled, version)
+	if err != nil {
+		// At this point it would just be an issue with setting the status. The refund has already occurred.
+		// The order is left cancelling, which admin reconcile reports, so it can be fixed by hand.
+		llog.Error("error cancelling refunded order", llog.ErrKV(err), llog.KV{
+			"orderID":     order.ID,
+			"refundCents": res.Refunded.Amount,
+		})
+		return CancelResult{}, fmt.Errorf("error cancelling order: %w", err)
+	}
+	res.Cancelled = true
+	res.Version = version + 1
+	return res, nil
+}
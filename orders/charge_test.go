@@ -0,0 +1,360 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/services"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testChargedAt = time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+
+// testCharged returns testOrder(storage.OrderStatusCharged) with a recorded
+// charge of its total
+func testCharged() storage.Order {
+	order := testOrder(storage.OrderStatusCharged)
+	order.Payments = []storage.Payment{{
+		ID:     "ch_1",
+		Kind:   storage.PaymentKindCharge,
+		Amount: storage.Money{Amount: 220, Currency: "EUR"},
+		At:     testChargedAt,
+	}}
+	return order
+}
+
+func TestCharge(t *testing.T) {
+	ctx := storage.WithActor(context.Background(), "admin")
+	chargeArgs := services.ChargeArgs{CardToken: "amex", AmountCents: 220, Currency: "EUR"}
+	charge := services.Charge{ID: "ch_1", AmountCents: 220, Currency: "EUR", CreatedAt: testChargedAt}
+	chargePayment := storage.Payment{
+		ID:     "ch_1",
+		Kind:   storage.PaymentKindCharge,
+		Amount: storage.Money{Amount: 220, Currency: "EUR"},
+		At:     testChargedAt,
+		Actor:  "admin",
+	}
+
+	// the order is marked as charging, the total is charged, recorded in the
+	// payments and the order is marked as charged, each of which increments the
+	// version
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(testOrder(storage.OrderStatusPending), nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCharging, int64(3)).Return(nil).Once()
+		stor.On("AddOrderPayment", ctx, "a", chargePayment).Return(nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCharged, int64(5)).Return(nil).Once()
+		charges := new(mocks.MockChargeService)
+		charges.On("Charge", ctx, chargeArgs).Return(charge, nil).Once()
+		res, err := New(stor, charges, nil).Charge(ctx, "a", "amex")
+		require.NoError(t, err)
+		assert.Equal(t, ChargeResult{
+			Charged:  storage.Money{Amount: 220, Currency: "EUR"},
+			ChargeID: "ch_1",
+			Version:  6,
+		}, res)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// a payment that couldn't be recorded fails the charge and leaves the order
+	// charging for admin reconcile instead of guessing its version
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(testOrder(storage.OrderStatusPending), nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCharging, int64(3)).Return(nil).Once()
+		stor.On("AddOrderPayment", ctx, "a", chargePayment).Return(errors.New("down")).Once()
+		stor.On("GetOrder", ctx, "a").Return(storage.Order{}, errors.New("down")).Once()
+		charges := new(mocks.MockChargeService)
+		charges.On("Charge", ctx, chargeArgs).Return(charge, nil).Once()
+		_, err := New(stor, charges, nil).Charge(ctx, "a", "amex")
+		assert.EqualError(t, err, "error recording charge ch_1: down")
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// but a write that failed after the payment was added, like a timeout, is
+	// found when the order is read again
+	{
+		recorded := testOrder(storage.OrderStatusCharging)
+		recorded.Version = 5
+		recorded.Payments = []storage.Payment{chargePayment}
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(testOrder(storage.OrderStatusPending), nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCharging, int64(3)).Return(nil).Once()
+		stor.On("AddOrderPayment", ctx, "a", chargePayment).Return(context.DeadlineExceeded).Once()
+		stor.On("GetOrder", ctx, "a").Return(recorded, nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCharged, int64(5)).Return(nil).Once()
+		charges := new(mocks.MockChargeService)
+		charges.On("Charge", ctx, chargeArgs).Return(charge, nil).Once()
+		res, err := New(stor, charges, nil).Charge(ctx, "a", "amex")
+		require.NoError(t, err)
+		assert.EqualValues(t, 6, res.Version)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// orders that were charged or fulfilled can't be charged again, cancelled
+	// orders can't be charged at all and neither can ones that another request
+	// is changing
+	for _, status := range []storage.OrderStatus{
+		storage.OrderStatusCharged,
+		storage.OrderStatusFulfilled,
+		storage.OrderStatusCancelled,
+		storage.OrderStatusCharging,
+		storage.OrderStatusCancelling,
+		storage.OrderStatusFulfilling,
+	} {
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(testOrder(status), nil).Once()
+		charges := new(mocks.MockChargeService)
+		_, err := New(stor, charges, nil).Charge(ctx, "a", "amex")
+		assert.Equal(t, &TransitionError{Action: ActionCharge, Status: status}, err)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// an order with a negative total isn't claimed or charged
+	{
+		order := testOrder(storage.OrderStatusPending)
+		order.LineItems = append(order.LineItems, storage.LineItem{
+			Description: "Promotion OLD",
+			Quantity:    1,
+			PriceCents:  -1000,
+			Kind:        storage.LineItemKindDiscount,
+		})
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(order, nil).Once()
+		charges := new(mocks.MockChargeService)
+		_, err := New(stor, charges, nil).Charge(ctx, "a", "amex")
+		assert.ErrorIs(t, err, ErrNegativeTotal)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// a declined card puts the order back to pending and nothing is recorded
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(testOrder(storage.OrderStatusPending), nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCharging, int64(3)).Return(nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusPending, int64(4)).Return(nil).Once()
+		charges := new(mocks.MockChargeService)
+		charges.On("Charge", ctx, chargeArgs).Return(services.Charge{}, fmt.Errorf("%w: 402", services.ErrChargeDeclined)).Once()
+		_, err := New(stor, charges, nil).Charge(ctx, "a", "amex")
+		assert.ErrorIs(t, err, services.ErrChargeDeclined)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// an order that changed since it was loaded isn't charged at all
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(testOrder(storage.OrderStatusPending), nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCharging, int64(3)).Return(storage.ErrVersionConflict).Once()
+		charges := new(mocks.MockChargeService)
+		_, err := New(stor, charges, nil).Charge(ctx, "a", "amex")
+		assert.ErrorIs(t, err, storage.ErrVersionConflict)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// an order that was changed while it was charging, like by an admin, is
+	// refunded from that charge and both are recorded
+	{
+		refund := services.Charge{ID: "re_1", AmountCents: -220, Currency: "EUR", CreatedAt: testChargedAt}
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(testOrder(storage.OrderStatusPending), nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCharging, int64(3)).Return(nil).Once()
+		stor.On("AddOrderPayment", ctx, "a", chargePayment).Return(nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCharged, int64(5)).Return(storage.ErrVersionConflict).Once()
+		stor.On("AddOrderPayment", ctx, "a", storage.Payment{
+			ID:       "re_1",
+			Kind:     storage.PaymentKindRefund,
+			ChargeID: "ch_1",
+			Amount:   storage.Money{Amount: -220, Currency: "EUR"},
+			At:       testChargedAt,
+			Actor:    "admin",
+		}).Return(nil).Once()
+		charges := new(mocks.MockChargeService)
+		charges.On("Charge", ctx, chargeArgs).Return(charge, nil).Once()
+		charges.On("Refund", ctx, services.RefundArgs{ChargeID: "ch_1", AmountCents: 220, Currency: "EUR"}).Return(refund, nil).Once()
+		_, err := New(stor, charges, nil).Charge(ctx, "a", "amex")
+		assert.ErrorIs(t, err, storage.ErrVersionConflict)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx := context.Background()
+	refundArgs := services.RefundArgs{ChargeID: "ch_1", AmountCents: 220, Currency: "EUR"}
+	refund := services.Charge{ID: "re_1", AmountCents: -220, Currency: "EUR", CreatedAt: testChargedAt}
+	refundPayment := storage.Payment{
+		ID:       "re_1",
+		Kind:     storage.PaymentKindRefund,
+		ChargeID: "ch_1",
+		Amount:   storage.Money{Amount: -220, Currency: "EUR"},
+		At:       testChargedAt,
+	}
+
+	// charged orders are marked as cancelling, refunded from their charge, the
+	// refund is recorded and they're marked as cancelled
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(testCharged(), nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCancelling, int64(3)).Return(nil).Once()
+		stor.On("AddOrderPayment", ctx, "a", refundPayment).Return(nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCancelled, int64(5)).Return(nil).Once()
+		charges := new(mocks.MockChargeService)
+		charges.On("Refund", ctx, refundArgs).Return(refund, nil).Once()
+		res, err := New(stor, charges, nil).Cancel(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, CancelResult{
+			Refunded:  storage.Money{Amount: -220, Currency: "EUR"},
+			Refunds:   []services.Charge{refund},
+			Cancelled: true,
+			Version:   6,
+		}, res)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// a refund that couldn't be recorded leaves the order cancelling for admin
+	// reconcile
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(testCharged(), nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCancelling, int64(3)).Return(nil).Once()
+		stor.On("AddOrderPayment", ctx, "a", refundPayment).Return(errors.New("down")).Once()
+		cancelling := testCharged()
+		cancelling.Status = storage.OrderStatusCancelling
+		cancelling.Version = 4
+		stor.On("GetOrder", ctx, "a").Return(cancelling, nil).Once()
+		charges := new(mocks.MockChargeService)
+		charges.On("Refund", ctx, refundArgs).Return(refund, nil).Once()
+		_, err := New(stor, charges, nil).Cancel(ctx, "a")
+		assert.Error(t, err)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// only what's left of each charge is refunded
+	{
+		order := testCharged()
+		order.Payments = append(order.Payments,
+			storage.Payment{
+				ID:       "re_0",
+				Kind:     storage.PaymentKindRefund,
+				ChargeID: "ch_1",
+				Amount:   storage.Money{Amount: -20, Currency: "EUR"},
+			},
+			storage.Payment{
+				ID:     "ch_2",
+				Kind:   storage.PaymentKindCharge,
+				Amount: storage.Money{Amount: 20, Currency: "EUR"},
+			},
+		)
+		refund2 := services.Charge{ID: "re_2", AmountCents: -20, Currency: "EUR", CreatedAt: testChargedAt}
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(order, nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCancelling, int64(3)).Return(nil).Once()
+		stor.On("AddOrderPayment", ctx, "a", storage.Payment{
+			ID:       "re_1",
+			Kind:     storage.PaymentKindRefund,
+			ChargeID: "ch_1",
+			Amount:   storage.Money{Amount: -200, Currency: "EUR"},
+			At:       testChargedAt,
+		}).Return(nil).Once()
+		stor.On("AddOrderPayment", ctx, "a", storage.Payment{
+			ID:       "re_2",
+			Kind:     storage.PaymentKindRefund,
+			ChargeID: "ch_2",
+			Amount:   storage.Money{Amount: -20, Currency: "EUR"},
+			At:       testChargedAt,
+		}).Return(nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCancelled, int64(6)).Return(nil).Once()
+		charges := new(mocks.MockChargeService)
+		refund1 := services.Charge{ID: "re_1", AmountCents: -200, Currency: "EUR", CreatedAt: testChargedAt}
+		charges.On("Refund", ctx, services.RefundArgs{ChargeID: "ch_1", AmountCents: 200, Currency: "EUR"}).Return(refund1, nil).Once()
+		charges.On("Refund", ctx, services.RefundArgs{ChargeID: "ch_2", AmountCents: 20, Currency: "EUR"}).Return(refund2, nil).Once()
+		res, err := New(stor, charges, nil).Cancel(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, storage.Money{Amount: -220, Currency: "EUR"}, res.Refunded)
+		assert.Equal(t, []services.Charge{refund1, refund2}, res.Refunds)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// pending orders aren't refunded or changed
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(testOrder(storage.OrderStatusPending), nil).Once()
+		charges := new(mocks.MockChargeService)
+		res, err := New(stor, charges, nil).Cancel(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, CancelResult{Refunded: storage.Money{Currency: "EUR"}, Version: 3}, res)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// fulfilled orders can't be cancelled and neither can ones that another
+	// request is changing
+	for _, status := range []storage.OrderStatus{
+		storage.OrderStatusFulfilled,
+		storage.OrderStatusCharging,
+		storage.OrderStatusCancelling,
+		storage.OrderStatusFulfilling,
+	} {
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(testOrder(status), nil).Once()
+		charges := new(mocks.MockChargeService)
+		_, err := New(stor, charges, nil).Cancel(ctx, "a")
+		assert.Equal(t, &TransitionError{Action: ActionCancel, Status: status}, err)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// a concurrent cancel that claimed the order first means nothing is
+	// refunded twice
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(testCharged(), nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCancelling, int64(3)).Return(storage.ErrVersionConflict).Once()
+		charges := new(mocks.MockChargeService)
+		_, err := New(stor, charges, nil).Cancel(ctx, "a")
+		assert.ErrorIs(t, err, storage.ErrVersionConflict)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// charged orders without a recorded charge can't be refunded
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(testOrder(storage.OrderStatusCharged), nil).Once()
+		charges := new(mocks.MockChargeService)
+		_, err := New(stor, charges, nil).Cancel(ctx, "a")
+		assert.ErrorIs(t, err, ErrChargeNotRecorded)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// a failed refund puts the order back to charged
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(testCharged(), nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCancelling, int64(3)).Return(nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCharged, int64(4)).Return(nil).Once()
+		charges := new(mocks.MockChargeService)
+		charges.On("Refund", ctx, refundArgs).Return(services.Charge{}, fmt.Errorf("%w: 500", services.ErrChargeFailed)).Once()
+		_, err := New(stor, charges, nil).Cancel(ctx, "a")
+		assert.ErrorIs(t, err, services.ErrChargeFailed)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+}
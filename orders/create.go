@@ -0,0 +1,166 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/validation"
+)
+
+// CreateArgs are the arguments for creating an order
+type CreateArgs struct {
+	CustomerEmail string
+	// Currency defaults to storage.DefaultCurrency if it's not set
+	Currency storage.Currency
+	// Jurisdiction decides how the order is taxed. It defaults to the shipping
+	// address's jurisdiction and orders without either aren't taxed.
+	Jurisdiction    string
+	ShippingAddress *storage.Address
+	BillingAddress  *storage.Address
+	ShippingMethod  string
+	LineItems       []storage.LineItem
+	// PromoCodes are promotions to apply to the order, the discounts are added as
+	// line items
+	PromoCodes []string
+}
+
+// ErrCustomerMismatch is returned when a customer tries to create an order for
+// a different customer email
+var ErrCustomerMismatch = errors.New("customerEmail must match the authenticated customer")
+
+// NewOrder builds a pending order from args, including its promotions, shipping
+// and tax, without redeeming the promotions or inserting it. customerEmail is
+// the authenticated customer, if the order is placed by one, and
+// ErrCustomerMismatch is returned if it's not the order's. Invalid fields are
+// returned as validation.Violations.
+func (s *Service) NewOrder(ctx context.Context, args CreateArgs, customerEmail string) (storage.Order, error) {
+	order := storage.Order{
+		CustomerEmail:   storage.NormalizeEmail(args.CustomerEmail),
+		Currency:        args.Currency.OrDefault(),
+		LineItems:       args.LineItems,
+		ShippingAddress: args.ShippingAddress,
+		BillingAddress:  args.BillingAddress,
+		ShippingMethod:  args.ShippingMethod,
+		Status:          storage.OrderStatusPending,
+	}
+	// every violation is returned at once so the caller can fix them all before
+	// trying again
+	if vs := validation.Order(order); len(vs) > 0 {
+		return storage.Order{}, vs
+	}
+	// customers can only place orders for themselves
+	if customerEmail != "" && !strings.EqualFold(customerEmail, order.CustomerEmail) {
+		return storage.Order{}, ErrCustomerMismatch
+	}
+
+	// promotions are applied before tax so that tax is calculated on the
+	// discounted amounts
+	if len(args.PromoCodes) > 0 {
+		vs, err := s.applyPromotions(ctx, &order, args.PromoCodes)
+		if err != nil {
+			return storage.Order{}, err
+		} else if len(vs) > 0 {
+			return storage.Order{}, vs
+		}
+	}
+
+	// shipping is added after the promotions since they only discount products
+	// but before tax since shipping can be taxed too
+	if vs := s.ApplyShipping(&order); len(vs) > 0 {
+		return storage.Order{}, vs
+	}
+
+	// tax is added as line items so that it's included in the total that's
+	// charged
+	if err := s.ApplyTax(ctx, &order, args.Jurisdiction); err != nil {
+		return storage.Order{}, err
+	}
+	if vs := s.ValidateTotal(order); len(vs) > 0 {
+		return storage.Order{}, vs
+	}
+	return order, nil
+}
+
+// ValidateTotal returns a violation if the order's total is negative, which
+// happens when the customer's own discounts are more than their products. It's
+// checked once promotions, shipping and tax are applied since the order could
+// never be charged.
+func (s *Service) ValidateTotal(order storage.Order) validation.Violations {
+	if order.Total().Amount < 0 {
+		return validation.Violations{{Field: "lineItems", Message: "order total cannot be negative"}}
+	}
+	return nil
+}
+
+// Create builds a new order from args, like NewOrder, redeems its promotions and
+// inserts it. The returned order has its ID and version set.
+func (s *Service) Create(ctx context.Context, args CreateArgs, customerEmail string) (storage.Order, error) {
+	order, err := s.NewOrder(ctx, args, customerEmail)
+	if err != nil {
+		return storage.Order{}, err
+	}
+
+	// the promotions are redeemed right before the order is inserted so their
+	// limits are enforced even if multiple orders are placed at once
+	err = s.RedeemPromotions(ctx, order)
+	if err != nil {
+		return storage.Order{}, err
+	}
+
+	id, err := s.store.InsertOrder(ctx, order)
+	if err != nil {
+		s.ReleasePromotions(ctx, order)
+		return storage.Order{}, fmt.Errorf("error inserting order: %w", err)
+	}
+	order.ID = id
+	// every order starts at version 1, see storage.Order
+	order.Version = 1
+	return order, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// MaxPageSize is the largest limit List accepts
+const MaxPageSize = 1000
+
+// ErrInvalidLimit is returned by List when the filter's limit is more than
+// MaxPageSize
+var ErrInvalidLimit = fmt.Errorf("limit must be between 1 and %d", MaxPageSize)
+
+// Get returns the order with the given ID
+func (s *Service) Get(ctx context.Context, id string) (storage.Order, error) {
+	order, err := s.store.GetOrder(ctx, id)
+	if err != nil {
+		return storage.Order{}, fmt.Errorf("error getting order: %w", err)
+	}
+	return order, nil
+}
+
+// List returns the orders matching filter, sorted by ID, and the cursor to pass
+// as filter.AfterID to get the next page. The cursor is only set when
+// filter.Limit is and the page is full. Without a limit every order is
+// returned.
+func (s *Service) List(ctx context.Context, filter storage.OrderFilter) ([]storage.Order, string, error) {
+	if filter.Limit < 0 || filter.Limit > MaxPageSize {
+		return nil, "", ErrInvalidLimit
+	}
+	orders, err := s.store.GetOrders(ctx, filter)
+	if err != nil {
+		return nil, "", fmt.Errorf("error getting orders: %w", err)
+	}
+	var next string
+	if filter.Limit > 0 && int64(len(orders)) == filter.Limit {
+		next = orders[len(orders)-1].ID
+	}
+
+	// by default slices are nil and if we return that the resulting JSON would be
+	// {"orders":null} which some languages/clients have a problem with
+	// instead set it to an empty slice
+	if orders == nil {
+		orders = []storage.Order{}
+	}
+	return orders, next, nil
+}
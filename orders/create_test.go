@@ -0,0 +1,121 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreate(t *testing.T) {
+	ctx := context.Background()
+	args := CreateArgs{
+		CustomerEmail: "test@test",
+		LineItems:     []storage.LineItem{{Description: "item", Quantity: 2, PriceCents: 500}},
+		PromoCodes:    []string{"summer10"},
+	}
+	promo := storage.Promotion{Code: "SUMMER10", Kind: storage.PromotionKindPercentage, PercentOff: 10}
+
+	// the promotion is applied and redeemed before the order is inserted
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetPromotion", ctx, "SUMMER10").Return(promo, nil).Once()
+		stor.On("RedeemPromotion", ctx, "SUMMER10", "test@test").Return(nil).Once()
+		stor.On("InsertOrder", ctx, mock.Anything).Return("a", nil).Once()
+		order, err := New(stor, nil, nil).Create(ctx, args, "")
+		require.NoError(t, err)
+		assert.Equal(t, "a", order.ID)
+		assert.EqualValues(t, 1, order.Version)
+		assert.Equal(t, storage.OrderStatusPending, order.Status)
+		assert.Equal(t, storage.DefaultCurrency, order.Currency)
+		assert.Equal(t, []string{"SUMMER10"}, order.PromoCodes)
+		assert.EqualValues(t, 900, order.Total().Amount)
+		stor.AssertExpectations(t)
+	}
+
+	// invalid orders are returned as violations without touching storage
+	{
+		stor := new(mocks.MockStorageInstance)
+		_, err := New(stor, nil, nil).Create(ctx, CreateArgs{CustomerEmail: "nope"}, "")
+		var vs validation.Violations
+		require.True(t, errors.As(err, &vs))
+		assert.Equal(t, "customerEmail", vs[0].Field)
+		stor.AssertExpectations(t)
+	}
+
+	// customers can only create their own orders
+	{
+		stor := new(mocks.MockStorageInstance)
+		_, err := New(stor, nil, nil).Create(ctx, args, "other@test")
+		assert.ErrorIs(t, err, ErrCustomerMismatch)
+		stor.AssertExpectations(t)
+	}
+
+	// emails are compared case-insensitively and stored lowercased
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetPromotion", ctx, "SUMMER10").Return(promo, nil).Once()
+		stor.On("RedeemPromotion", ctx, "SUMMER10", "test@test").Return(nil).Once()
+		stor.On("InsertOrder", ctx, mock.Anything).Return("a", nil).Once()
+		mixed := args
+		mixed.CustomerEmail = "Test@Test"
+		order, err := New(stor, nil, nil).Create(ctx, mixed, "test@TEST")
+		require.NoError(t, err)
+		assert.Equal(t, "test@test", order.CustomerEmail)
+		stor.AssertExpectations(t)
+	}
+
+	// the promotion is released if the order can't be inserted
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetPromotion", ctx, "SUMMER10").Return(promo, nil).Once()
+		stor.On("RedeemPromotion", ctx, "SUMMER10", "test@test").Return(nil).Once()
+		stor.On("InsertOrder", ctx, mock.Anything).Return("", storage.ErrOrderExists).Once()
+		stor.On("ReleasePromotion", ctx, "SUMMER10", "test@test").Return(nil).Once()
+		_, err := New(stor, nil, nil).Create(ctx, args, "")
+		assert.ErrorIs(t, err, storage.ErrOrderExists)
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+
+	// a full page has the cursor for the next one
+	{
+		filter := storage.OrderFilter{Status: storage.OrderStatusAny, Limit: 2}
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrders", ctx, filter).Return([]storage.Order{{ID: "a"}, {ID: "b"}}, nil).Once()
+		orders, next, err := New(stor, nil, nil).List(ctx, filter)
+		require.NoError(t, err)
+		assert.Len(t, orders, 2)
+		assert.Equal(t, "b", next)
+		stor.AssertExpectations(t)
+	}
+
+	// no orders is an empty slice instead of nil
+	{
+		filter := storage.OrderFilter{Status: storage.OrderStatusPending}
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrders", ctx, filter).Return(nil, nil).Once()
+		orders, next, err := New(stor, nil, nil).List(ctx, filter)
+		require.NoError(t, err)
+		assert.Equal(t, []storage.Order{}, orders)
+		assert.Empty(t, next)
+		stor.AssertExpectations(t)
+	}
+
+	// the page size is limited
+	{
+		stor := new(mocks.MockStorageInstance)
+		_, _, err := New(stor, nil, nil).List(ctx, storage.OrderFilter{Limit: MaxPageSize + 1})
+		assert.ErrorIs(t, err, ErrInvalidLimit)
+		stor.AssertExpectations(t)
+	}
+}
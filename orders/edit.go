@@ -0,0 +1,158 @@
+package orders
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/validation"
+)
+
+// EditArgs are the editable fields of an order. Every field replaces the
+// order's current value, including unset ones. Tax, shipping and discount line
+// items aren't included since they're recalculated from the rest of the order.
+type EditArgs struct {
+	CustomerEmail   string
+	LineItems       []storage.LineItem
+	ShippingAddress *storage.Address
+	BillingAddress  *storage.Address
+	ShippingMethod  string
+	// Jurisdiction defaults to the shipping address's jurisdiction, just like
+	// when creating an order
+	Jurisdiction string
+	Notes        string
+}
+
+// Edit replaces the editable fields of order, which is the order as the caller
+// last saw it, with args and recalculates its promotions, shipping and tax. Only
+// pending orders can be edited and a wrapped storage.ErrVersionConflict is
+// returned if the order changed since it was loaded. customerEmail is the
+// authenticated customer, if the order is edited by one, and they can't give
+// their order to someone else. An edit that doesn't change anything is returned
+// without updating the order.
+func (s *Service) Edit(ctx context.Context, order storage.Order, args EditArgs, customerEmail string) (storage.Order, error) {
+	if order.Status != storage.OrderStatusPending {
+		return storage.Order{}, &TransitionError{Action: ActionEdit, Status: order.Status}
+	}
+	if customerEmail != "" && !strings.EqualFold(customerEmail, args.CustomerEmail) {
+		return storage.Order{}, ErrCustomerMismatch
+	}
+
+	updated := order
+	updated.CustomerEmail = storage.NormalizeEmail(args.CustomerEmail)
+	updated.LineItems = args.LineItems
+	updated.ShippingAddress = args.ShippingAddress
+	updated.BillingAddress = args.BillingAddress
+	updated.ShippingMethod = args.ShippingMethod
+	updated.Notes = args.Notes
+	// only the customer's line items are validated since the rest are
+	// recalculated below
+	if vs := validation.Order(updated); len(vs) > 0 {
+		return storage.Order{}, vs
+	}
+
+	// keep the previous discounts around so that ReapplyPromotions can keep the
+	// discounts of deleted promotions
+	for _, li := range order.LineItems {
+		if li.Kind == storage.LineItemKindDiscount {
+			updated.LineItems = append(updated.LineItems, li)
+		}
+	}
+	vs, err := s.ReapplyPromotions(ctx, &updated)
+	if err != nil {
+		return storage.Order{}, err
+	}
+	vs = append(vs, s.ApplyShipping(&updated)...)
+	if len(vs) > 0 {
+		return storage.Order{}, vs
+	}
+	if err := s.ApplyTax(ctx, &updated, args.Jurisdiction); err != nil {
+		return storage.Order{}, err
+	}
+	if vs := s.ValidateTotal(updated); len(vs) > 0 {
+		return storage.Order{}, vs
+	}
+
+	// an edit that doesn't change anything isn't an edit
+	changes := orderChanges(order, updated)
+	if len(changes) == 0 {
+		return updated, nil
+	}
+	if err := s.store.UpdateOrder(ctx, updated, changes); err != nil {
+		return storage.Order{}, fmt.Errorf("error updating order: %w", err)
+	}
+	updated.Version++
+	return updated, nil
+}
+
+// ShippingArgs are the addresses and shipping method of an order. Every field
+// replaces the order's current value, including unset ones.
+type ShippingArgs struct {
+	ShippingAddress *storage.Address
+	BillingAddress  *storage.Address
+	ShippingMethod  string
+	// Jurisdiction defaults to the shipping address's jurisdiction, just like
+	// when creating an order
+	Jurisdiction string
+}
+
+// SetShipping replaces the addresses and shipping method of order, which is
+// the order as the caller last saw it, and recalculates the shipping line item
+// and tax since they depend on them. Once the order is charged the customer
+// already paid for the shipping so only pending orders can be changed.
+func (s *Service) SetShipping(ctx context.Context, order storage.Order, args ShippingArgs) (storage.Order, error) {
+	if order.Status != storage.OrderStatusPending {
+		return storage.Order{}, &TransitionError{Action: ActionSetShipping, Status: order.Status}
+	}
+
+	updated := order
+	updated.ShippingAddress = args.ShippingAddress
+	updated.BillingAddress = args.BillingAddress
+	updated.ShippingMethod = args.ShippingMethod
+	vs := validation.Addresses(updated)
+	vs = append(vs, s.ApplyShipping(&updated)...)
+	if len(vs) > 0 {
+		return storage.Order{}, vs
+	}
+	if err := s.ApplyTax(ctx, &updated, args.Jurisdiction); err != nil {
+		return storage.Order{}, err
+	}
+
+	// storage.ErrOrderNotPending is returned if the order was charged since it
+	// was loaded and storage.ErrVersionConflict if it was edited
+	if err := s.store.UpdateOrder(ctx, updated, orderChanges(order, updated)); err != nil {
+		return storage.Order{}, fmt.Errorf("error updating order: %w", err)
+	}
+	updated.Version++
+	return updated, nil
+}
+
+// orderChanges returns the editable fields that are different between before
+// and after
+func orderChanges(before, after storage.Order) []storage.FieldChange {
+	fields := []struct {
+		name          string
+		before, after interface{}
+	}{
+		{"customerEmail", before.CustomerEmail, after.CustomerEmail},
+		{"lineItems", before.LineItems, after.LineItems},
+		{"shippingAddress", before.ShippingAddress, after.ShippingAddress},
+		{"billingAddress", before.BillingAddress, after.BillingAddress},
+		{"shippingMethod", before.ShippingMethod, after.ShippingMethod},
+		{"jurisdiction", before.Jurisdiction, after.Jurisdiction},
+		{"notes", before.Notes, after.Notes},
+	}
+	var changes []storage.FieldChange
+	for _, f := range fields {
+		// these are all plain values so encoding them can't fail
+		from, _ := json.Marshal(f.before)
+		to, _ := json.Marshal(f.after)
+		if !bytes.Equal(from, to) {
+			changes = append(changes, storage.FieldChange{Field: f.name, From: from, To: to})
+		}
+	}
+	return changes
+}
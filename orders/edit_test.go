@@ -0,0 +1,199 @@
+package orders
+
+import (
+	"context"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// changedFields matches changes that are for exactly the given fields
+func changedFields(fields ...string) interface{} {
+	return mock.MatchedBy(func(changes []storage.FieldChange) bool {
+		if len(changes) != len(fields) {
+			return false
+		}
+		for idx, change := range changes {
+			if change.Field != fields[idx] {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestEdit(t *testing.T) {
+	ctx := context.Background()
+	widget := storage.LineItem{Description: "widget", Quantity: 1, PriceCents: 1000}
+	discount := storage.LineItem{Description: "Promotion SUMMER10", Quantity: 1, PriceCents: -100, Kind: storage.LineItemKindDiscount}
+	order := storage.Order{
+		ID:            "a",
+		CustomerEmail: "test@test",
+		Currency:      storage.DefaultCurrency,
+		Status:        storage.OrderStatusPending,
+		LineItems:     []storage.LineItem{widget, discount},
+		PromoCodes:    []string{"SUMMER10"},
+		Version:       2,
+	}
+	args := EditArgs{CustomerEmail: "test@test", LineItems: []storage.LineItem{widget}}
+	percent := storage.Promotion{Code: "SUMMER10", Kind: storage.PromotionKindPercentage, PercentOff: 10}
+
+	// the fields are replaced, the discount recalculated and the version
+	// incremented
+	{
+		edited := args
+		edited.CustomerEmail = "Test@Test"
+		edited.LineItems = []storage.LineItem{{Description: "widget", Quantity: 2, PriceCents: 1000}}
+		edited.Notes = "gift"
+		exp := order
+		exp.LineItems = []storage.LineItem{
+			edited.LineItems[0],
+			{Description: "Promotion SUMMER10", Quantity: 1, PriceCents: -200, Kind: storage.LineItemKindDiscount},
+		}
+		exp.Notes = "gift"
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetPromotion", ctx, "SUMMER10").Return(percent, nil).Once()
+		stor.On("UpdateOrder", ctx, exp, changedFields("lineItems", "notes")).Return(nil).Once()
+		updated, err := New(stor, nil, nil).Edit(ctx, order, edited, "test@test")
+		require.NoError(t, err)
+		assert.Equal(t, "test@test", updated.CustomerEmail)
+		assert.EqualValues(t, 3, updated.Version)
+		stor.AssertExpectations(t)
+	}
+
+	// an edit that doesn't change anything doesn't update the order
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetPromotion", ctx, "SUMMER10").Return(percent, nil).Once()
+		updated, err := New(stor, nil, nil).Edit(ctx, order, args, "")
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, updated.Version)
+		stor.AssertExpectations(t)
+	}
+
+	// a deleted promotion keeps its discount but the total still can't be
+	// negative
+	{
+		edited := args
+		edited.LineItems = []storage.LineItem{{Description: "sticker", Quantity: 1, PriceCents: 50}}
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetPromotion", ctx, "SUMMER10").Return(storage.Promotion{}, storage.ErrPromotionNotFound).Once()
+		_, err := New(stor, nil, nil).Edit(ctx, order, edited, "")
+		assert.Equal(t, validation.Violations{{Field: "lineItems", Message: "order total cannot be negative"}}, err)
+		stor.AssertExpectations(t)
+	}
+
+	// only pending orders can be edited
+	{
+		stor := new(mocks.MockStorageInstance)
+		charged := order
+		charged.Status = storage.OrderStatusCharged
+		_, err := New(stor, nil, nil).Edit(ctx, charged, args, "")
+		assert.Equal(t, &TransitionError{Action: ActionEdit, Status: storage.OrderStatusCharged}, err)
+		stor.AssertExpectations(t)
+	}
+
+	// customers can't give their order to someone else
+	{
+		stor := new(mocks.MockStorageInstance)
+		edited := args
+		edited.CustomerEmail = "other@test"
+		_, err := New(stor, nil, nil).Edit(ctx, order, edited, "test@test")
+		assert.ErrorIs(t, err, ErrCustomerMismatch)
+		stor.AssertExpectations(t)
+	}
+
+	// the customer's fields are validated
+	{
+		stor := new(mocks.MockStorageInstance)
+		edited := args
+		edited.LineItems = []storage.LineItem{{Description: "tax", Quantity: 1, PriceCents: 100, Kind: storage.LineItemKindTax}}
+		_, err := New(stor, nil, nil).Edit(ctx, order, edited, "")
+		assert.Equal(t, validation.Violations{
+			{Field: "lineItems[0].kind", Message: "tax, shipping and promotion line items are added automatically"},
+		}, err)
+		stor.AssertExpectations(t)
+	}
+
+	// an order that changed since it was loaded isn't updated
+	{
+		edited := args
+		edited.Notes = "gift"
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetPromotion", ctx, "SUMMER10").Return(percent, nil).Once()
+		stor.On("UpdateOrder", ctx, mock.Anything, changedFields("notes")).Return(storage.ErrVersionConflict).Once()
+		_, err := New(stor, nil, nil).Edit(ctx, order, edited, "")
+		assert.ErrorIs(t, err, storage.ErrVersionConflict)
+		stor.AssertExpectations(t)
+	}
+}
+
+func TestSetShipping(t *testing.T) {
+	ctx := context.Background()
+	methods := WithShippingMethods(ShippingMethods{
+		"standard": {Name: "Standard shipping", Prices: map[storage.Currency]int64{"USD": 500}},
+	})
+	address := &storage.Address{Name: "Wile E. Coyote", Line1: "1 Mesa Rd", City: "Needles", Region: "CA", PostalCode: "92363", Country: "US"}
+	order := storage.Order{
+		ID:            "a",
+		CustomerEmail: "test@test",
+		Currency:      storage.DefaultCurrency,
+		Status:        storage.OrderStatusPending,
+		LineItems:     []storage.LineItem{{Description: "widget", Quantity: 1, PriceCents: 1000}},
+		Version:       2,
+	}
+	args := ShippingArgs{ShippingAddress: address, ShippingMethod: "standard"}
+
+	// the shipping line item is added and the jurisdiction defaults to the
+	// address's
+	{
+		exp := order
+		exp.ShippingAddress = address
+		exp.ShippingMethod = "standard"
+		exp.Jurisdiction = "US-CA"
+		exp.LineItems = append(order.LineItems, storage.LineItem{
+			Description: "Standard shipping", Quantity: 1, PriceCents: 500, TaxCategory: ShippingTaxCategory, Kind: storage.LineItemKindShipping,
+		})
+		stor := new(mocks.MockStorageInstance)
+		stor.On("UpdateOrder", ctx, exp, changedFields("lineItems", "shippingAddress", "shippingMethod", "jurisdiction")).Return(nil).Once()
+		updated, err := New(stor, nil, nil, methods).SetShipping(ctx, order, args)
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, updated.Version)
+		assert.EqualValues(t, 1500, updated.Total().Amount)
+		stor.AssertExpectations(t)
+	}
+
+	// only pending orders can be changed
+	{
+		stor := new(mocks.MockStorageInstance)
+		charged := order
+		charged.Status = storage.OrderStatusCharged
+		_, err := New(stor, nil, nil, methods).SetShipping(ctx, charged, args)
+		assert.Equal(t, &TransitionError{Action: ActionSetShipping, Status: storage.OrderStatusCharged}, err)
+		stor.AssertExpectations(t)
+	}
+
+	// a shipping method needs an address
+	{
+		stor := new(mocks.MockStorageInstance)
+		_, err := New(stor, nil, nil, methods).SetShipping(ctx, order, ShippingArgs{ShippingMethod: "standard"})
+		assert.Equal(t, validation.Violations{
+			{Field: "shippingAddress", Message: "shippingAddress is required with a shipping method"},
+		}, err)
+		stor.AssertExpectations(t)
+	}
+
+	// an order that was charged since it was loaded isn't changed
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("UpdateOrder", ctx, mock.Anything, mock.Anything).Return(storage.ErrOrderNotPending).Once()
+		_, err := New(stor, nil, nil, methods).SetShipping(ctx, order, args)
+		assert.ErrorIs(t, err, storage.ErrOrderNotPending)
+		stor.AssertExpectations(t)
+	}
+}
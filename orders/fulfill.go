@@ -0,0 +1,69 @@
+package orders
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/levenlabs/go-llog"
+	"github.com/levenlabs/order-up/services"
+	"github.com/levenlabs/order-up/storage"
+)
+
+// FulfillResult is the result of Fulfill
+type FulfillResult struct {
+	// Version is the order's version after it was marked as fulfilled
+	Version int64
+}
+
+// Fulfill sends the order's products to the fulfillment service and marks the
+// order as fulfilled. Only charged orders can be fulfilled, which also excludes
+// ones that another request is in the middle of changing.
+func (s *Service) Fulfill(ctx context.Context, id string, preconds ...Precondition) (FulfillResult, error) {
+	order, err := s.getOrder(ctx, id, preconds)
+	if err != nil {
+		return FulfillResult{}, err
+	}
+	if order.Status != storage.OrderStatusCharged {
+		return FulfillResult{}, &TransitionError{Action: ActionFulfill, Status: order.Status}
+	}
+
+	// the order is marked as fulfilling first so a concurrent cancel or fulfill
+	// can't refund or ship it too
+	version, err := s.claim(ctx, order, storage.OrderStatusFulfilling)
+	if err != nil {
+		return FulfillResult{}, err
+	}
+
+	for _, item := range order.LineItems {
+		// only products are shipped, tax and shipping are just part of the charge
+		if item.Kind != storage.LineItemKindProduct {
+			continue
+		}
+		// Assume the fulfillment service handles the logic behind saying if a given
+		// Line item has been fulfilled or not,
+		// based off of the quantity.
+		err := s.fulfillments.Fulfill(ctx, services.FulfillArgs{
+			Description:     item.Description,
+			OrderID:         order.ID,
+			Quantity:        item.Quantity,
+			ShippingAddress: order.ShippingAddress,
+			ShippingMethod:  order.ShippingMethod,
+		})
+		if err != nil {
+			// the fulfillment service ignores line items it was already sent so
+			// the order goes back to charged and fulfilling can be retried
+			s.release(ctx, order.ID, storage.OrderStatusCharged, version)
+			return FulfillResult{}, fmt.Errorf("error fulfilling line items: %w", err)
+		}
+	}
+
+	err = s.store.SetOrderStatus(ctx, order.ID, storage.OrderStatusFulfilled, version)
+	if err != nil {
+		// the fulfillment service already shipped the line items so this
+		// needs to be fixed by hand, the order is left fulfilling which admin
+		// reconcile reports
+		llog.Error("error marking fulfilled order", llog.ErrKV(err), llog.KV{"orderID": order.ID})
+		return FulfillResult{}, fmt.Errorf("error updating order to fulfilled: %w", err)
+	}
+	return FulfillResult{Version: version + 1}, nil
+}
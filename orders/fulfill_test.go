@@ -0,0 +1,88 @@
+package orders
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/services"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFulfill(t *testing.T) {
+	ctx := context.Background()
+	fulfillArgs := services.FulfillArgs{
+		Description:    "widget",
+		Quantity:       2,
+		OrderID:        "a",
+		ShippingMethod: "express",
+	}
+
+	// the order is marked as fulfilling and only the products are sent to the
+	// fulfillment service
+	{
+		order := testOrder(storage.OrderStatusCharged)
+		order.ShippingMethod = "express"
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(order, nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusFulfilling, int64(3)).Return(nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusFulfilled, int64(4)).Return(nil).Once()
+		fulfillments := new(mocks.MockFulfillmentService)
+		fulfillments.On("Fulfill", ctx, fulfillArgs).Return(nil).Once()
+		res, err := New(stor, nil, fulfillments).Fulfill(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, FulfillResult{Version: 5}, res)
+		stor.AssertExpectations(t)
+		fulfillments.AssertExpectations(t)
+	}
+
+	// only charged orders can be fulfilled
+	for _, status := range []storage.OrderStatus{
+		storage.OrderStatusPending,
+		storage.OrderStatusFulfilled,
+		storage.OrderStatusCancelled,
+		storage.OrderStatusCharging,
+		storage.OrderStatusCancelling,
+		storage.OrderStatusFulfilling,
+	} {
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(testOrder(status), nil).Once()
+		fulfillments := new(mocks.MockFulfillmentService)
+		_, err := New(stor, nil, fulfillments).Fulfill(ctx, "a")
+		assert.Equal(t, &TransitionError{Action: ActionFulfill, Status: status}, err)
+		stor.AssertExpectations(t)
+		fulfillments.AssertExpectations(t)
+	}
+
+	// a concurrent cancel or fulfill that claimed the order first means nothing
+	// is sent to the fulfillment service
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(testOrder(storage.OrderStatusCharged), nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusFulfilling, int64(3)).Return(storage.ErrVersionConflict).Once()
+		fulfillments := new(mocks.MockFulfillmentService)
+		_, err := New(stor, nil, fulfillments).Fulfill(ctx, "a")
+		assert.ErrorIs(t, err, storage.ErrVersionConflict)
+		stor.AssertExpectations(t)
+		fulfillments.AssertExpectations(t)
+	}
+
+	// a failed fulfillment puts the order back to charged
+	{
+		order := testOrder(storage.OrderStatusCharged)
+		order.ShippingMethod = "express"
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(order, nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusFulfilling, int64(3)).Return(nil).Once()
+		stor.On("SetOrderStatus", ctx, "a", storage.OrderStatusCharged, int64(4)).Return(nil).Once()
+		fulfillments := new(mocks.MockFulfillmentService)
+		fulfillments.On("Fulfill", ctx, fulfillArgs).Return(fmt.Errorf("%w: 503", services.ErrFulfillmentFailed)).Once()
+		_, err := New(stor, nil, fulfillments).Fulfill(ctx, "a")
+		assert.ErrorIs(t, err, services.ErrFulfillmentFailed)
+		stor.AssertExpectations(t)
+		fulfillments.AssertExpectations(t)
+	}
+}
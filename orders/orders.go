@@ -0,0 +1,257 @@
+// Package orders holds the business logic of orders: creating them with their
+// promotions, shipping and tax, editing and listing them and then charging,
+// cancelling and fulfilling them. None of it knows about HTTP or gRPC, the api
+// and grpcapi packages parse their requests, call the Service and turn its
+// results and errors into their own responses.
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/levenlabs/go-llog"
+	"github.com/levenlabs/order-up/services"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/tax"
+)
+
+// Store is where the orders are read from and written to. It's implemented by
+// *storage.Instance and mocks.MockStorageInstance.
+type Store interface {
+	// GetOrder should return the order with the given ID. If that ID isn't found
+	// then the special ErrOrderNotFound error should be returned.
+	GetOrder(ctx context.Context, id string) (storage.Order, error)
+	// GetOrders should return all orders matching the filter, sorted by ID
+	GetOrders(ctx context.Context, filter storage.OrderFilter) ([]storage.Order, error)
+	// InsertOrder should fill in the order's ID with a unique identifier if it's
+	// not already set, insert it and return its ID. If the order already exists
+	// then ErrOrderExists should be returned.
+	InsertOrder(ctx context.Context, order storage.Order) (string, error)
+	// InsertOrders should insert every order, filling in the IDs of the ones
+	// that don't have one, and return the ID of every order and the error for
+	// each one that couldn't be inserted. If atomic is true then either every
+	// order is inserted or none are.
+	InsertOrders(ctx context.Context, orders []storage.Order, atomic bool) ([]string, []error, error)
+	// UpdateOrder should replace the editable fields of the pending order with
+	// order's and increment its version. If the order is no longer pending then
+	// ErrOrderNotPending and if its version changed then ErrVersionConflict
+	// should be returned.
+	UpdateOrder(ctx context.Context, order storage.Order, changes []storage.FieldChange) error
+	// SetOrderStatus should update the order with the given ID, set the status
+	// field, increment its version and append an entry to the order's history
+	// with the actor and reason from the context. If its version changed then
+	// ErrVersionConflict should be returned.
+	SetOrderStatus(ctx context.Context, id string, status storage.OrderStatus, version int64) error
+	// AddOrderPayment should append the payment to the order's payments and
+	// increment its version without checking it. If that ID isn't found then
+	// the special ErrOrderNotFound error should be returned.
+	AddOrderPayment(ctx context.Context, id string, payment storage.Payment) error
+	// GetPromotion should return the promotion with the given code. If that code
+	// isn't found then the special ErrPromotionNotFound error should be returned.
+	GetPromotion(ctx context.Context, code string) (storage.Promotion, error)
+	// RedeemPromotion should atomically record that the customer used the
+	// promotion or return ErrPromotionNotFound, ErrPromotionExpired or
+	// ErrPromotionLimitReached if they can't.
+	RedeemPromotion(ctx context.Context, code, customerEmail string) error
+	// ReleasePromotion should undo a previous RedeemPromotion for the customer
+	ReleasePromotion(ctx context.Context, code, customerEmail string) error
+}
+
+// ensure *storage.Instance can be used as the Store
+var _ Store = (*storage.Instance)(nil)
+
+// Service creates, edits, lists, charges, cancels and fulfills orders. The actor and
+// reason recorded in the order's history are taken from the context, see
+// storage.WithActor. Errors from storage and the services are returned wrapped,
+// so errors.Is matches them with storage.ErrOrderNotFound,
+// services.ErrChargeDeclined and the like, and invalid orders are returned as
+// validation.Violations.
+type Service struct {
+	store        Store
+	charges      services.ChargeService
+	fulfillments services.FulfillmentService
+	// taxCalculator is nil when orders aren't taxed
+	taxCalculator tax.Calculator
+	// shippingMethods is nil when orders can't have a shipping method
+	shippingMethods ShippingMethods
+}
+
+// Option configures optional behavior on the Service
+type Option func(*Service)
+
+// WithTaxCalculator adds tax to new orders using calc. Without it orders aren't
+// taxed.
+func WithTaxCalculator(calc tax.Calculator) Option {
+	return func(s *Service) {
+		s.taxCalculator = calc
+	}
+}
+
+// WithShippingMethods lets customers choose one of methods when they create an
+// order. Without it orders can't have a shipping method.
+func WithShippingMethods(methods ShippingMethods) Option {
+	return func(s *Service) {
+		s.shippingMethods = methods
+	}
+}
+
+// New returns a Service that keeps the orders in store and sends charges and
+// fulfillments to the given services. Any number of Options can be passed to
+// further configure it.
+func New(store Store, charges services.ChargeService, fulfillments services.FulfillmentService, opts ...Option) *Service {
+	s := &Service{
+		store:        store,
+		charges:      charges,
+		fulfillments: fulfillments,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ErrInvalidTransition is returned, wrapped in a *TransitionError, when the
+// order's status doesn't allow the action
+var ErrInvalidTransition = errors.New("invalid order status transition")
+
+// Action is something that's done to an order
+type Action string
+
+// These are all of the actions a Service does
+const (
+	ActionCharge  Action = "charge"
+	ActionCancel  Action = "cancel"
+	ActionFulfill Action = "fulfill"
+	ActionEdit    Action = "edit"
+	// the shipping action reads as "can't change the shipping of an order
+	// that's charged" in a TransitionError
+	ActionSetShipping Action = "change the shipping of"
+)
+
+// TransitionError is returned when the order's status doesn't allow the action,
+// like charging an order that was already charged. errors.Is matches it with
+// ErrInvalidTransition.
+type TransitionError struct {
+	Action Action
+	Status storage.OrderStatus
+}
+
+// Error implements the error interface
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("can't %s an order that's %s", e.Action, e.Status)
+}
+
+// Is returns true for ErrInvalidTransition
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// ErrChargeNotRecorded is returned when cancelling a charged order that has no
+// charge in its payments to refund, like orders charged before payments were
+// recorded. Those have to be refunded by hand.
+var ErrChargeNotRecorded = errors.New("order has no recorded charge to refund")
+
+// ErrNegativeTotal is returned when charging an order whose total is negative,
+// like one created before negative totals were rejected. A negative amount
+// can't be charged to a card.
+var ErrNegativeTotal = errors.New("order total cannot be negative")
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Precondition is checked against the order before the Service does anything
+// to it. If it returns an error then the order is left alone and the error is
+// returned as is.
+type Precondition func(storage.Order) error
+
+// AtVersion requires the order to still have the given version. Otherwise a
+// wrapped storage.ErrVersionConflict is returned.
+func AtVersion(version int64) Precondition {
+	return func(order storage.Order) error {
+		if order.Version != version {
+			return fmt.Errorf("order has version %d instead of %d: %w", order.Version, version, storage.ErrVersionConflict)
+		}
+		return nil
+	}
+}
+
+// getOrder returns the order with the given ID once it passes every
+// precondition
+func (s *Service) getOrder(ctx context.Context, id string, preconds []Precondition) (storage.Order, error) {
+	order, err := s.store.GetOrder(ctx, id)
+	if err != nil {
+		return storage.Order{}, fmt.Errorf("error getting order: %w", err)
+	}
+	for _, precond := range preconds {
+		if err := precond(order); err != nil {
+			return storage.Order{}, err
+		}
+	}
+	return order, nil
+}
+
+// claim changes the order's status to status, one of the in progress statuses
+// like charging, before anything is sent to the charge or fulfillment services.
+// The change is guarded by the order's version so only one request can claim
+// it and every other request sees the in progress status and is turned away.
+// It returns the order's new version.
+func (s *Service) claim(ctx context.Context, order storage.Order, status storage.OrderStatus) (int64, error) {
+	if err := s.store.SetOrderStatus(ctx, order.ID, status, order.Version); err != nil {
+		return 0, fmt.Errorf("error marking order as %s: %w", status, err)
+	}
+	return order.Version + 1, nil
+}
+
+// release changes a claimed order's status back to status after the action
+// failed so it can be retried. If that fails too the order is left in the in
+// progress status, which admin reconcile reports, and has to be fixed by hand.
+func (s *Service) release(ctx context.Context, orderID string, status storage.OrderStatus, version int64) {
+	if err := s.store.SetOrderStatus(ctx, orderID, status, version); err != nil {
+		llog.Error("error releasing order", llog.ErrKV(err), llog.KV{
+			"orderID": orderID,
+			"status":  status.String(),
+		})
+	}
+}
+
+// recordPayment adds the charge service's charge or refund to the order's
+// payments and returns the order's version after it, which is one more than
+// version. If the write fails the order is read again since the payment might
+// have been added anyway, like when the write timed out. If it wasn't, the
+// payment is logged with everything needed to add it by hand and the error is
+// returned, and the caller should leave the order in its in progress status so
+// admin reconcile reports it.
+func (s *Service) recordPayment(ctx context.Context, orderID string, version int64, kind storage.PaymentKind, chargeID string, charge services.Charge) (int64, error) {
+	payment := storage.Payment{
+		ID:       charge.ID,
+		Kind:     kind,
+		ChargeID: chargeID,
+		Amount:   storage.Money{Amount: charge.AmountCents, Currency: charge.Currency},
+		At:       charge.CreatedAt,
+		Actor:    storage.ActorFromContext(ctx),
+	}
+	err := s.store.AddOrderPayment(ctx, orderID, payment)
+	if err == nil {
+		return version + 1, nil
+	}
+	// the order was claimed at version so the payment landed if it's there and
+	// nothing else changed the order
+	if order, gerr := s.store.GetOrder(ctx, orderID); gerr == nil && order.Version == version+1 {
+		for _, p := range order.Payments {
+			if p.ID == payment.ID && p.Kind == payment.Kind {
+				return order.Version, nil
+			}
+		}
+	}
+	llog.Error("error recording order payment", llog.ErrKV(err), llog.KV{
+		"orderID":     orderID,
+		"paymentID":   payment.ID,
+		"kind":        payment.Kind,
+		"chargeID":    payment.ChargeID,
+		"amountCents": payment.Amount.Amount,
+		"currency":    payment.Amount.Currency,
+	})
+	return 0, fmt.Errorf("error recording %s %s: %w", payment.Kind, payment.ID, err)
+}
@@ -0,0 +1,63 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// testOrder returns an order with the given status that totals 2.20 EUR
+func testOrder(status storage.OrderStatus) storage.Order {
+	return storage.Order{
+		ID:       "a",
+		Status:   status,
+		Currency: "EUR",
+		LineItems: []storage.LineItem{
+			{Description: "widget", Quantity: 2, PriceCents: 100},
+			{Description: "tax", Quantity: 1, PriceCents: 20, Kind: storage.LineItemKindTax},
+		},
+		Version: 3,
+	}
+}
+
+func TestTransitionError(t *testing.T) {
+	err := &TransitionError{Action: ActionFulfill, Status: storage.OrderStatusPending}
+	assert.EqualError(t, err, "can't fulfill an order that's pending")
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+}
+
+func TestPreconditions(t *testing.T) {
+	ctx := context.Background()
+	order := testOrder(storage.OrderStatusPending)
+
+	// a different version is a conflict
+	assert.ErrorIs(t, AtVersion(2)(order), storage.ErrVersionConflict)
+	assert.NoError(t, AtVersion(3)(order))
+
+	// a failed precondition is returned as is and nothing else happens
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(order, nil).Once()
+		charges := new(mocks.MockChargeService)
+		errNope := errors.New("nope")
+		_, err := New(stor, charges, nil).Charge(ctx, "a", "amex", AtVersion(3), func(storage.Order) error {
+			return errNope
+		})
+		assert.Equal(t, errNope, err)
+		stor.AssertExpectations(t)
+		charges.AssertExpectations(t)
+	}
+
+	// errors from storage are wrapped
+	{
+		stor := new(mocks.MockStorageInstance)
+		stor.On("GetOrder", ctx, "a").Return(storage.Order{}, storage.ErrOrderNotFound).Once()
+		_, err := New(stor, nil, nil).Fulfill(ctx, "a")
+		assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+		stor.AssertExpectations(t)
+	}
+}
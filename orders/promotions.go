@@ -0,0 +1,213 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/levenlabs/go-llog"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/validation"
+)
+
+// applyPromotions looks up each of the codes and adds a discount line item to
+// order for them. The promotions aren't redeemed yet so their limits are only
+// checked by RedeemPromotions. Problems with the codes are returned as
+// violations.
+func (s *Service) applyPromotions(ctx context.Context, order *storage.Order, codes []string) (validation.Violations, error) {
+	var vs validation.Violations
+	seen := map[string]bool{}
+	now := time.Now()
+	for idx, code := range codes {
+		field := fmt.Sprintf("promoCodes[%d]", idx)
+		code = validation.NormalizePromoCode(code)
+		if seen[code] {
+			vs = append(vs, validation.Violation{Field: field, Message: "promotion code was already applied"})
+			continue
+		}
+		seen[code] = true
+
+		promo, err := s.store.GetPromotion(ctx, code)
+		if errors.Is(err, storage.ErrPromotionNotFound) {
+			vs = append(vs, validation.Violation{Field: field, Message: "unknown promotion code"})
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error getting promotion: %w", err)
+		}
+		if msg := promotionIneligible(promo, *order, now); msg != "" {
+			vs = append(vs, validation.Violation{Field: field, Message: msg})
+			continue
+		}
+
+		order.LineItems = append(order.LineItems, discountLineItems(promo, order.LineItems)...)
+		order.PromoCodes = append(order.PromoCodes, code)
+	}
+	return vs, nil
+}
+
+// promotionIneligible returns why promo can't be used for order or an empty
+// string if it can be
+func promotionIneligible(promo storage.Promotion, order storage.Order, now time.Time) string {
+	if promo.Expired(now) {
+		return "promotion has expired"
+	}
+	// amounts in the promotion only make sense in the promotion's currency
+	if promo.Currency != "" && promo.Currency != order.Currency &&
+		(promo.Kind == storage.PromotionKindFixed || promo.MinOrderCents > 0) {
+		return fmt.Sprintf("promotion can't be used for orders in %s", order.Currency)
+	}
+	if subtotal := productSubtotal(order.LineItems); subtotal < promo.MinOrderCents {
+		min := storage.Money{Amount: promo.MinOrderCents, Currency: promo.Currency}
+		return fmt.Sprintf("order total must be at least %s", min)
+	}
+	return ""
+}
+
+// productSubtotal returns the total of the line items the customer added, which
+// includes their own discounts but not tax or promotions
+func productSubtotal(lineItems []storage.LineItem) int64 {
+	var total int64
+	for _, li := range lineItems {
+		if li.Kind == storage.LineItemKindProduct {
+			total += li.PriceCents * li.Quantity
+		}
+	}
+	return total
+}
+
+// discountLineItems returns the discount line items for promo. The discount is
+// split between the tax categories of the products in proportion to what's left
+// of their totals after the discounts already in lineItems, so that tax is
+// calculated on the discounted amounts. The discount is never more than what's
+// left, so stacked promotions can't take the order below zero.
+func discountLineItems(promo storage.Promotion, lineItems []storage.LineItem) []storage.LineItem {
+	subtotals := map[string]int64{}
+	for _, li := range lineItems {
+		if li.Kind == storage.LineItemKindProduct || li.Kind == storage.LineItemKindDiscount {
+			subtotals[li.TaxCategory] += li.PriceCents * li.Quantity
+		}
+	}
+
+	// sort the categories so the line items are always in the same order
+	categories := make([]string, 0, len(subtotals))
+	var subtotal int64
+	for category, amount := range subtotals {
+		if amount > 0 {
+			categories = append(categories, category)
+			subtotal += amount
+		}
+	}
+	sort.Strings(categories)
+	if subtotal <= 0 {
+		return nil
+	}
+
+	var discount int64
+	switch promo.Kind {
+	case storage.PromotionKindPercentage:
+		// percentages are of the products' total, not of what's left after other
+		// promotions, and round half up to the nearest minor unit
+		discount = (productSubtotal(lineItems)*promo.PercentOff + 50) / 100
+	case storage.PromotionKindFixed:
+		discount = promo.AmountOffCents
+	}
+	if discount > subtotal {
+		discount = subtotal
+	}
+
+	var discountItems []storage.LineItem
+	remaining := discount
+	for idx, category := range categories {
+		amount := discount * subtotals[category] / subtotal
+		// the last category gets whatever is left over from rounding down
+		if idx == len(categories)-1 {
+			amount = remaining
+		}
+		remaining -= amount
+		if amount == 0 {
+			continue
+		}
+		discountItems = append(discountItems, storage.LineItem{
+			Description: promotionDescription(promo.Code),
+			PriceCents:  -amount,
+			Quantity:    1,
+			TaxCategory: category,
+			Kind:        storage.LineItemKindDiscount,
+		})
+	}
+	return discountItems
+}
+
+// promotionDescription returns the description of the discount line items for
+// the promotion with code
+func promotionDescription(code string) string {
+	return "Promotion " + code
+}
+
+// ReapplyPromotions replaces the discount line items on an edited order with
+// ones calculated from its current products. The promotions were already
+// redeemed so only the minimum order total is checked again. If a promotion was
+// deleted since then its discount is kept as it was. Like when the order was
+// created, each discount is capped at what's left after the ones before it.
+func (s *Service) ReapplyPromotions(ctx context.Context, order *storage.Order) (validation.Violations, error) {
+	var vs validation.Violations
+	lineItems := make([]storage.LineItem, 0, len(order.LineItems))
+	for _, li := range order.LineItems {
+		if li.Kind != storage.LineItemKindDiscount {
+			lineItems = append(lineItems, li)
+		}
+	}
+	for _, code := range order.PromoCodes {
+		promo, err := s.store.GetPromotion(ctx, code)
+		if errors.Is(err, storage.ErrPromotionNotFound) {
+			for _, li := range order.LineItems {
+				if li.Kind == storage.LineItemKindDiscount && li.Description == promotionDescription(code) {
+					lineItems = append(lineItems, li)
+				}
+			}
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error getting promotion: %w", err)
+		}
+		if subtotal := productSubtotal(lineItems); subtotal < promo.MinOrderCents {
+			min := storage.Money{Amount: promo.MinOrderCents, Currency: promo.Currency}
+			vs = append(vs, validation.Violation{Field: "lineItems", Message: fmt.Sprintf("order total must be at least %s for promotion %s", min, code)})
+			continue
+		}
+		lineItems = append(lineItems, discountLineItems(promo, lineItems)...)
+	}
+	order.LineItems = lineItems
+	return vs, nil
+}
+
+// RedeemPromotions redeems every promotion applied to the order. If any of them
+// can't be redeemed then the ones that were already redeemed are released.
+func (s *Service) RedeemPromotions(ctx context.Context, order storage.Order) error {
+	for idx, code := range order.PromoCodes {
+		err := s.store.RedeemPromotion(ctx, code, order.CustomerEmail)
+		if err != nil {
+			s.releasePromotions(ctx, order.CustomerEmail, order.PromoCodes[:idx])
+			return fmt.Errorf("error redeeming promotion %s: %w", code, err)
+		}
+	}
+	return nil
+}
+
+// ReleasePromotions undoes RedeemPromotions for an order that couldn't be
+// inserted
+func (s *Service) ReleasePromotions(ctx context.Context, order storage.Order) {
+	s.releasePromotions(ctx, order.CustomerEmail, order.PromoCodes)
+}
+
+// releasePromotions undoes the redemption of codes. Failures are only logged
+// since the order already failed and the worst case is that a customer can use
+// a promotion one less time.
+func (s *Service) releasePromotions(ctx context.Context, customerEmail string, codes []string) {
+	for _, code := range codes {
+		if err := s.store.ReleasePromotion(ctx, code, customerEmail); err != nil {
+			llog.Warn("error releasing promotion", llog.ErrKV(err), llog.KV{"code": code})
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package orders
+
+import (
+	"testing"
+
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/validation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscountLineItems(t *testing.T) {
+	lineItems := []storage.LineItem{
+		{Description: "shirt", Quantity: 1, PriceCents: 2000, TaxCategory: "clothing"},
+		{Description: "apples", Quantity: 1, PriceCents: 1000, TaxCategory: "grocery"},
+		{Description: "coupon", Quantity: 1, PriceCents: -500, TaxCategory: "grocery"},
+	}
+
+	// the discount is split by category and the last gets the remainder
+	assert.Equal(t, []storage.LineItem{
+		{Description: "Promotion TEN", Quantity: 1, PriceCents: -666, TaxCategory: "clothing", Kind: storage.LineItemKindDiscount},
+		{Description: "Promotion TEN", Quantity: 1, PriceCents: -167, TaxCategory: "grocery", Kind: storage.LineItemKindDiscount},
+	}, discountLineItems(storage.Promotion{Code: "TEN", Kind: storage.PromotionKindFixed, AmountOffCents: 833}, lineItems))
+
+	// a discount is never more than the products' total
+	items := discountLineItems(storage.Promotion{Code: "BIG", Kind: storage.PromotionKindFixed, AmountOffCents: 100000}, lineItems)
+	assert.EqualValues(t, 0, productSubtotal(lineItems)+items[0].PriceCents+items[1].PriceCents)
+
+	// stacked discounts are capped at what's left after the ones before them
+	half := discountLineItems(storage.Promotion{Code: "HALF", Kind: storage.PromotionKindPercentage, PercentOff: 50}, lineItems)
+	stacked := append(append([]storage.LineItem{}, lineItems...), half...)
+	stacked = append(stacked, discountLineItems(storage.Promotion{Code: "SIXTY", Kind: storage.PromotionKindPercentage, PercentOff: 60}, stacked)...)
+	assert.EqualValues(t, 0, storage.Order{LineItems: stacked}.Total().Amount)
+	stacked = append(stacked, discountLineItems(storage.Promotion{Code: "MORE", Kind: storage.PromotionKindFixed, AmountOffCents: 100}, stacked)...)
+	assert.EqualValues(t, 0, storage.Order{LineItems: stacked}.Total().Amount)
+}
+
+func TestValidateTotal(t *testing.T) {
+	s := New(nil, nil, nil)
+	assert.Empty(t, s.ValidateTotal(storage.Order{LineItems: []storage.LineItem{
+		{Description: "shirt", Quantity: 1, PriceCents: 2000},
+		{Description: "Promotion FREE", Quantity: 1, PriceCents: -2000, Kind: storage.LineItemKindDiscount},
+	}}))
+	assert.Equal(t, validation.Violations{{Field: "lineItems", Message: "order total cannot be negative"}},
+		s.ValidateTotal(storage.Order{LineItems: []storage.LineItem{
+			{Description: "shirt", Quantity: 1, PriceCents: 2000},
+			{Description: "Promotion OLD", Quantity: 1, PriceCents: -2500, Kind: storage.LineItemKindDiscount},
+		}}))
+}
@@ -0,0 +1,135 @@
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/tax"
+	"github.com/levenlabs/order-up/validation"
+)
+
+// ShippingTaxCategory is the tax category of shipping line items so tax rules
+// can give shipping its own rate
+const ShippingTaxCategory = "shipping"
+
+// ShippingMethod is a way an order can be shipped
+type ShippingMethod struct {
+	// Name is the description of the shipping line item, like "Standard"
+	Name string `json:"name"`
+	// Prices is the price of the method in the minor unit of each currency it's
+	// offered in. Orders in any other currency can't use the method.
+	Prices map[storage.Currency]int64 `json:"prices"`
+}
+
+// ShippingMethods are the available shipping methods keyed by their code, like
+// "standard"
+type ShippingMethods map[string]ShippingMethod
+
+// LoadShippingMethods decodes ShippingMethods from JSON like
+//
+//	{"standard": {"name": "Standard", "prices": {"USD": 500, "EUR": 450}}}
+func LoadShippingMethods(r io.Reader) (ShippingMethods, error) {
+	var methods ShippingMethods
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&methods); err != nil {
+		return nil, fmt.Errorf("error decoding shipping methods: %w", err)
+	}
+	for code, m := range methods {
+		if m.Name == "" {
+			return nil, fmt.Errorf("shipping method %s has no name", code)
+		}
+		for currency, price := range m.Prices {
+			if !currency.Valid() {
+				return nil, fmt.Errorf("unknown currency %q for shipping method %s", currency, code)
+			}
+			if price < 0 || price > validation.MaxPriceCents {
+				return nil, fmt.Errorf("invalid price %d %s for shipping method %s", price, currency, code)
+			}
+		}
+	}
+	return methods, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ApplyShipping replaces any shipping line item on order with one for its
+// shipping method. Problems with the method are returned as violations.
+func (s *Service) ApplyShipping(order *storage.Order) validation.Violations {
+	lineItems := make([]storage.LineItem, 0, len(order.LineItems)+1)
+	for _, li := range order.LineItems {
+		if li.Kind != storage.LineItemKindShipping {
+			lineItems = append(lineItems, li)
+		}
+	}
+	order.LineItems = lineItems
+	if order.ShippingMethod == "" {
+		return nil
+	}
+
+	var vs validation.Violations
+	method, ok := s.shippingMethods[order.ShippingMethod]
+	if !ok {
+		return append(vs, validation.Violation{Field: "shippingMethod", Message: "unknown shipping method"})
+	}
+	price, ok := method.Prices[order.Currency]
+	if !ok {
+		vs = append(vs, validation.Violation{Field: "shippingMethod", Message: fmt.Sprintf("shipping method isn't available for orders in %s", order.Currency)})
+	}
+	// there's nowhere to ship to without an address
+	if order.ShippingAddress == nil {
+		vs = append(vs, validation.Violation{Field: "shippingAddress", Message: "shippingAddress is required with a shipping method"})
+	}
+	if len(vs) > 0 {
+		return vs
+	}
+
+	order.LineItems = append(order.LineItems, storage.LineItem{
+		Description: method.Name,
+		PriceCents:  price,
+		Quantity:    1,
+		TaxCategory: ShippingTaxCategory,
+		Kind:        storage.LineItemKindShipping,
+	})
+	return nil
+}
+
+// ApplyTax recalculates the tax on order, replacing any earlier tax. If the
+// jurisdiction is empty then the jurisdiction of the shipping address is used
+// instead. A jurisdiction that can't be taxed is returned as
+// validation.Violations.
+func (s *Service) ApplyTax(ctx context.Context, order *storage.Order, jurisdiction string) error {
+	// field is where the caller should look if the jurisdiction isn't supported
+	field := "jurisdiction"
+	if jurisdiction == "" && order.ShippingAddress != nil {
+		jurisdiction = order.ShippingAddress.Jurisdiction()
+		field = "shippingAddress"
+	}
+	order.Jurisdiction = jurisdiction
+
+	// remove the previous tax first since there might not be a jurisdiction
+	// anymore in which case tax.Apply doesn't do anything
+	lineItems := make([]storage.LineItem, 0, len(order.LineItems))
+	for _, li := range order.LineItems {
+		if li.Kind != storage.LineItemKindTax {
+			lineItems = append(lineItems, li)
+		}
+	}
+	order.LineItems = lineItems
+	order.Tax = nil
+
+	if s.taxCalculator == nil {
+		return nil
+	}
+	err := tax.Apply(ctx, s.taxCalculator, order)
+	if errors.Is(err, tax.ErrUnknownJurisdiction) {
+		return validation.Violations{{Field: field, Message: "orders can't be shipped to this jurisdiction"}}
+	} else if err != nil {
+		return fmt.Errorf("error calculating tax: %w", err)
+	}
+	return nil
+}
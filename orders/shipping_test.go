@@ -0,0 +1,95 @@
+package orders
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/levenlabs/order-up/mocks"
+	"github.com/levenlabs/order-up/storage"
+	"github.com/levenlabs/order-up/tax"
+	"github.com/levenlabs/order-up/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadShippingMethods(t *testing.T) {
+	methods, err := LoadShippingMethods(strings.NewReader(`{"standard": {"name": "Standard", "prices": {"USD": 500, "JPY": 700}}}`))
+	require.NoError(t, err)
+	assert.Equal(t, ShippingMethods{
+		"standard": {Name: "Standard", Prices: map[storage.Currency]int64{"USD": 500, "JPY": 700}},
+	}, methods)
+
+	for _, body := range []string{
+		`{"standard": {"prices": {"USD": 500}}}`,
+		`{"standard": {"name": "Standard", "prices": {"XXX": 500}}}`,
+		`{"standard": {"name": "Standard", "prices": {"USD": -1}}}`,
+		`{"standard": {"name": "Standard", "price": 500}}`,
+	} {
+		_, err := LoadShippingMethods(strings.NewReader(body))
+		assert.Error(t, err, body)
+	}
+}
+
+func TestApplyShipping(t *testing.T) {
+	s := New(new(mocks.MockStorageInstance), nil, nil, WithShippingMethods(ShippingMethods{
+		"standard": {Name: "Standard shipping", Prices: map[storage.Currency]int64{"USD": 500}},
+	}))
+	address := &storage.Address{Name: "Wile E. Coyote", Line1: "1 Mesa Rd", City: "Needles", Region: "CA", PostalCode: "92363", Country: "US"}
+
+	// the old shipping line item is replaced
+	{
+		order := storage.Order{
+			Currency:        "USD",
+			ShippingAddress: address,
+			ShippingMethod:  "standard",
+			LineItems: []storage.LineItem{
+				{Description: "item", Quantity: 1, PriceCents: 1000},
+				{Description: "Express shipping", Quantity: 1, PriceCents: 2000, Kind: storage.LineItemKindShipping},
+			},
+		}
+		assert.Empty(t, s.ApplyShipping(&order))
+		assert.Equal(t, []storage.LineItem{
+			{Description: "item", Quantity: 1, PriceCents: 1000},
+			{Description: "Standard shipping", Quantity: 1, PriceCents: 500, TaxCategory: ShippingTaxCategory, Kind: storage.LineItemKindShipping},
+		}, order.LineItems)
+	}
+
+	// the method has to exist in the order's currency and needs an address
+	{
+		order := storage.Order{Currency: "EUR", ShippingMethod: "standard"}
+		assert.Equal(t, validation.Violations{
+			{Field: "shippingMethod", Message: "shipping method isn't available for orders in EUR"},
+			{Field: "shippingAddress", Message: "shippingAddress is required with a shipping method"},
+		}, s.ApplyShipping(&order))
+	}
+}
+
+func TestApplyTax(t *testing.T) {
+	ctx := context.Background()
+	s := New(new(mocks.MockStorageInstance), nil, nil, WithTaxCalculator(tax.RuleTable{
+		"US-CA": {Rates: map[string]tax.Rate{"": 100000}},
+	}))
+
+	// the jurisdiction defaults to the shipping address's
+	{
+		order := storage.Order{
+			Currency:        "USD",
+			ShippingAddress: &storage.Address{Region: "CA", Country: "US"},
+			LineItems:       []storage.LineItem{{Description: "item", Quantity: 1, PriceCents: 1000}},
+		}
+		require.NoError(t, s.ApplyTax(ctx, &order, ""))
+		assert.Equal(t, "US-CA", order.Jurisdiction)
+		assert.EqualValues(t, 1100, order.Total().Amount)
+	}
+
+	// a jurisdiction without rules is a violation of the field it came from
+	{
+		order := storage.Order{
+			Currency:  "USD",
+			LineItems: []storage.LineItem{{Description: "item", Quantity: 1, PriceCents: 1000}},
+		}
+		err := s.ApplyTax(ctx, &order, "US-NV")
+		assert.Equal(t, validation.Violations{{Field: "jurisdiction", Message: "orders can't be shipped to this jurisdiction"}}, err)
+	}
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the Memory limiter removes full buckets so that
+// keys that are no longer used, like old client IPs, don't leak memory
+const sweepInterval = time.Minute
+
+// Memory is a Limiter that keeps the buckets in-process. Each replica has its
+// own buckets so the effective limit is multiplied by the number of replicas.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]bucket
+	limits    map[string]Limit
+	lastSweep time.Time
+	// now is overridden in tests
+	now func() time.Time
+}
+
+// NewMemory returns an empty Memory limiter
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: map[string]bucket{},
+		limits:  map[string]Limit{},
+		now:     time.Now,
+	}
+}
+
+// Take implements the Limiter interface
+func (m *Memory) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if now.Sub(m.lastSweep) >= sweepInterval {
+		m.sweep(now)
+	}
+
+	b, res := m.buckets[key].take(limit, now)
+	m.buckets[key] = b
+	m.limits[key] = limit
+	return res, nil
+}
+
+// sweep removes every bucket that would be full by now since a full bucket is
+// the same as a missing one
+// the caller must hold the lock
+func (m *Memory) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		limit := m.limits[key]
+		// buckets without a rate never refill so they have to be kept
+		if limit.Rate > 0 && now.Sub(b.UpdatedAt) >= limit.durationFor(float64(limit.Burst)-b.Tokens) {
+			delete(m.buckets, key)
+			delete(m.limits, key)
+		}
+	}
+	m.lastSweep = now
+}
@@ -0,0 +1,83 @@
+// Package ratelimit implements token bucket rate limiting, either in-process or
+// shared between replicas through storage
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit describes a token bucket
+type Limit struct {
+	// Rate is how many tokens are added to the bucket every second
+	Rate float64
+	// Burst is the most tokens the bucket can hold which is also how many
+	// requests can be made at once after being idle
+	Burst int
+}
+
+// Result is the outcome of trying to take a token from a bucket
+type Result struct {
+	// Allowed is true if a token was taken and the request can proceed
+	Allowed bool
+	// Limit is the limit that was applied
+	Limit Limit
+	// Remaining is how many whole tokens are left in the bucket
+	Remaining int
+	// RetryAfter is how long until a token will be available, it's only set when
+	// Allowed is false
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Limiter takes tokens from buckets identified by a key
+type Limiter interface {
+	// Take tries to take a single token from the bucket for key and creates a
+	// full bucket if one doesn't exist yet
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// bucket is the state of a single token bucket. Tokens are added lazily based on
+// how much time has passed since UpdatedAt rather than by a background timer.
+type bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// take refills the bucket up to now and then tries to take a token from it
+// it returns the new state of the bucket along with the result
+func (b bucket) take(limit Limit, now time.Time) (bucket, Result) {
+	burst := float64(limit.Burst)
+	// a zero UpdatedAt means this is a new bucket which starts out full
+	if b.UpdatedAt.IsZero() {
+		b.Tokens = burst
+	} else if elapsed := now.Sub(b.UpdatedAt); elapsed > 0 {
+		b.Tokens = math.Min(burst, b.Tokens+elapsed.Seconds()*limit.Rate)
+	}
+	b.UpdatedAt = now
+
+	res := Result{Limit: limit}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = limit.durationFor(1 - b.Tokens)
+	}
+	res.Remaining = int(b.Tokens)
+	res.Reset = limit.durationFor(burst - b.Tokens)
+	return b, res
+}
+
+// durationFor returns how long it takes to add tokens to a bucket
+func (l Limit) durationFor(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	// a limit without a rate never refills
+	if l.Rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(tokens / l.Rate * float64(time.Second))
+}
@@ -0,0 +1,207 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a clock that only moves when the test tells it to
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 1, Burst: 2}
+
+	// a new bucket is full and is emptied by burst requests
+	{
+		clock := &fakeClock{t: time.Unix(1000, 0)}
+		m := NewMemory()
+		m.now = clock.now
+
+		res, err := m.Take(ctx, "a", limit)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 1, res.Remaining)
+		assert.Equal(t, time.Second, res.Reset)
+
+		res, err = m.Take(ctx, "a", limit)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 0, res.Remaining)
+
+		res, err = m.Take(ctx, "a", limit)
+		require.NoError(t, err)
+		assert.False(t, res.Allowed)
+		assert.Equal(t, time.Second, res.RetryAfter)
+		assert.Equal(t, 2*time.Second, res.Reset)
+
+		// other keys have their own buckets
+		res, err = m.Take(ctx, "b", limit)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+
+		// tokens are refilled over time
+		clock.t = clock.t.Add(500 * time.Millisecond)
+		res, err = m.Take(ctx, "a", limit)
+		require.NoError(t, err)
+		assert.False(t, res.Allowed)
+		assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+
+		clock.t = clock.t.Add(500 * time.Millisecond)
+		res, err = m.Take(ctx, "a", limit)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+
+		// but never above the burst
+		clock.t = clock.t.Add(time.Hour)
+		res, err = m.Take(ctx, "a", limit)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 1, res.Remaining)
+	}
+
+	// full buckets are swept but buckets that never refill are kept
+	{
+		clock := &fakeClock{t: time.Unix(1000, 0)}
+		m := NewMemory()
+		m.now = clock.now
+
+		_, err := m.Take(ctx, "a", limit)
+		require.NoError(t, err)
+		_, err = m.Take(ctx, "never", Limit{Burst: 1})
+		require.NoError(t, err)
+
+		clock.t = clock.t.Add(sweepInterval)
+		_, err = m.Take(ctx, "b", limit)
+		require.NoError(t, err)
+		assert.NotContains(t, m.buckets, "a")
+		assert.Contains(t, m.buckets, "never")
+		assert.Contains(t, m.buckets, "b")
+
+		res, err := m.Take(ctx, "never", Limit{Burst: 1})
+		require.NoError(t, err)
+		assert.False(t, res.Allowed)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// fakeStore is an in-memory Store. beforeSwap, if set, is called before every
+// swap so tests can simulate another replica writing in the meantime.
+type fakeStore struct {
+	mu         sync.Mutex
+	buckets    map[string]storage.RateLimitBucket
+	expiresAt  map[string]time.Time
+	beforeSwap func()
+	swaps      int
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		buckets:   map[string]storage.RateLimitBucket{},
+		expiresAt: map[string]time.Time{},
+	}
+}
+
+func (s *fakeStore) GetRateLimitBucket(ctx context.Context, key string) (storage.RateLimitBucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[key]
+	if !ok {
+		return storage.RateLimitBucket{}, storage.ErrRateLimitBucketNotFound
+	}
+	return b, nil
+}
+
+func (s *fakeStore) SwapRateLimitBucket(ctx context.Context, bucket storage.RateLimitBucket, expiresAt time.Time) error {
+	if s.beforeSwap != nil {
+		s.beforeSwap()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.swaps++
+	if s.buckets[bucket.Key].Version != bucket.Version {
+		return storage.ErrRateLimitBucketChanged
+	}
+	bucket.Version++
+	s.buckets[bucket.Key] = bucket
+	s.expiresAt[bucket.Key] = expiresAt
+	return nil
+}
+
+func TestShared(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 1, Burst: 2}
+
+	// tokens are taken from the stored bucket
+	{
+		clock := &fakeClock{t: time.Unix(1000, 0)}
+		store := newFakeStore()
+		s := NewShared(store)
+		s.now = clock.now
+
+		res, err := s.Take(ctx, "a", limit)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, storage.RateLimitBucket{Key: "a", Tokens: 1, UpdatedAt: clock.t, Version: 1}, store.buckets["a"])
+		// the bucket expires once it would be full again
+		assert.Equal(t, clock.t.Add(time.Second), store.expiresAt["a"])
+
+		_, err = s.Take(ctx, "a", limit)
+		require.NoError(t, err)
+		res, err = s.Take(ctx, "a", limit)
+		require.NoError(t, err)
+		assert.False(t, res.Allowed)
+		assert.Equal(t, time.Second, res.RetryAfter)
+	}
+
+	// a concurrent write is retried with the new bucket
+	{
+		clock := &fakeClock{t: time.Unix(1000, 0)}
+		store := newFakeStore()
+		store.buckets["a"] = storage.RateLimitBucket{Key: "a", Tokens: 1, UpdatedAt: clock.t, Version: 3}
+		s := NewShared(store)
+		s.now = clock.now
+
+		store.beforeSwap = func() {
+			// another replica takes the last token before our first swap
+			store.beforeSwap = nil
+			store.mu.Lock()
+			store.buckets["a"] = storage.RateLimitBucket{Key: "a", Tokens: 0, UpdatedAt: clock.t, Version: 4}
+			store.mu.Unlock()
+		}
+		res, err := s.Take(ctx, "a", limit)
+		require.NoError(t, err)
+		assert.False(t, res.Allowed)
+		assert.Equal(t, 2, store.swaps)
+		assert.Equal(t, int64(5), store.buckets["a"].Version)
+	}
+
+	// it gives up after too many conflicts
+	{
+		store := newFakeStore()
+		store.beforeSwap = func() {
+			store.mu.Lock()
+			b := store.buckets["a"]
+			b.Version++
+			store.buckets["a"] = b
+			store.mu.Unlock()
+		}
+		s := NewShared(store)
+		_, err := s.Take(ctx, "a", limit)
+		assert.Error(t, err)
+		assert.Equal(t, maxSwapAttempts, store.swaps)
+	}
+}
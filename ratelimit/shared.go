@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/levenlabs/order-up/storage"
+)
+
+// maxSwapAttempts is how many times Shared retries when another replica updated
+// the same bucket between reading and writing it
+const maxSwapAttempts = 5
+
+// maxBucketTTL bounds how long a bucket is kept in the Store, otherwise a limit
+// that never refills would keep its bucket forever
+const maxBucketTTL = 24 * time.Hour
+
+// Store persists buckets for the Shared limiter. It's implemented by
+// *storage.Instance.
+type Store interface {
+	// GetRateLimitBucket should return the bucket with the given key. If there is
+	// no bucket then the special ErrRateLimitBucketNotFound error should be
+	// returned.
+	GetRateLimitBucket(ctx context.Context, key string) (storage.RateLimitBucket, error)
+	// SwapRateLimitBucket should replace the stored bucket only if its version
+	// still matches bucket.Version, or insert it if bucket.Version is 0, and
+	// increment the version. Otherwise ErrRateLimitBucketChanged should be
+	// returned. The bucket can be deleted after expiresAt.
+	SwapRateLimitBucket(ctx context.Context, bucket storage.RateLimitBucket, expiresAt time.Time) error
+}
+
+// ensure *storage.Instance can be used as the Store for the Shared limiter
+var _ Store = (*storage.Instance)(nil)
+
+// Shared is a Limiter that keeps the buckets in a Store so every replica shares
+// the same limits
+type Shared struct {
+	store Store
+	// now is overridden in tests
+	now func() time.Time
+}
+
+// NewShared returns a Shared limiter backed by store
+func NewShared(store Store) *Shared {
+	return &Shared{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+// Take implements the Limiter interface
+func (s *Shared) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	// this is an optimistic compare-and-swap loop, if another replica took a token
+	// between us reading and writing the bucket then we read it again and retry
+	for attempt := 0; attempt < maxSwapAttempts; attempt++ {
+		stored, err := s.store.GetRateLimitBucket(ctx, key)
+		if err != nil && !errors.Is(err, storage.ErrRateLimitBucketNotFound) {
+			return Result{}, fmt.Errorf("error getting bucket: %w", err)
+		}
+
+		now := s.now()
+		b, res := bucket{Tokens: stored.Tokens, UpdatedAt: stored.UpdatedAt}.take(limit, now)
+		stored.Key = key
+		stored.Tokens = b.Tokens
+		stored.UpdatedAt = b.UpdatedAt
+
+		// once the bucket is full again it's the same as not having one at all so
+		// the store can expire it
+		ttl := res.Reset
+		if ttl > maxBucketTTL {
+			ttl = maxBucketTTL
+		}
+		err = s.store.SwapRateLimitBucket(ctx, stored, now.Add(ttl))
+		if errors.Is(err, storage.ErrRateLimitBucketChanged) {
+			continue
+		} else if err != nil {
+			return Result{}, fmt.Errorf("error storing bucket: %w", err)
+		}
+		return res, nil
+	}
+	return Result{}, fmt.Errorf("bucket %s changed %d times while taking a token", key, maxSwapAttempts)
+}
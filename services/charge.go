@@ -0,0 +1,187 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/levenlabs/order-up/storage"
+)
+
+// ChargeArgs is what's charged to a card
+type ChargeArgs struct {
+	CardToken string `json:"cardToken"`
+	// AmountCents is in the minor unit of Currency which isn't always cents
+	AmountCents int64            `json:"amountCents"`
+	Currency    storage.Currency `json:"currency"`
+}
+
+// RefundArgs is what's refunded from an earlier charge, the money goes back to
+// the card that was charged
+type RefundArgs struct {
+	// ChargeID is the ID of the charge the money is given back from
+	ChargeID string `json:"-"`
+	// AmountCents is the positive amount to give back in the minor unit of
+	// Currency, it can't be more than what's left of the charge
+	AmountCents int64            `json:"amountCents"`
+	Currency    storage.Currency `json:"currency"`
+}
+
+// Charge is a charge, or a refund if the amount is negative, that the charge
+// service made
+type Charge struct {
+	ID          string           `json:"id"`
+	AmountCents int64            `json:"amountCents"`
+	Currency    storage.Currency `json:"currency"`
+	CreatedAt   time.Time        `json:"createdAt"`
+}
+
+// ChargeService charges and refunds cards
+type ChargeService interface {
+	// Charge should charge the card and return the charge that was made. If the
+	// card was declined then an error matching ErrChargeDeclined and for any
+	// other failure one matching ErrChargeFailed should be returned.
+	Charge(ctx context.Context, args ChargeArgs) (Charge, error)
+	// Refund should give the amount back from the charge with args.ChargeID and
+	// return the refund that was made, which has a negative amount. If that
+	// charge isn't found then the special ErrChargeNotFound error and for any
+	// other failure one matching ErrChargeFailed should be returned.
+	Refund(ctx context.Context, args RefundArgs) (Charge, error)
+	// GetCharge should return the charge with the given ID. If that ID isn't
+	// found then the special ErrChargeNotFound error should be returned.
+	GetCharge(ctx context.Context, id string) (Charge, error)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// HTTPChargeService is the ChargeService that talks to the charge service over
+// HTTP
+type HTTPChargeService struct {
+	client *http.Client
+	// mu makes every request to the charge service, charges, refunds and
+	// lookups alike, wait for the one before it
+	mu sync.Mutex
+}
+
+// ensure *HTTPChargeService can be used as the ChargeService
+var _ ChargeService = (*HTTPChargeService)(nil)
+
+// NewHTTPChargeService returns an *HTTPChargeService that makes requests to the
+// charge service with client
+func NewHTTPChargeService(client *http.Client) *HTTPChargeService {
+	return &HTTPChargeService{client: client}
+}
+
+// Ping returns an error if the charge service can't be reached
+func (s *HTTPChargeService) Ping(ctx context.Context) error {
+	return ping(ctx, s.client)
+}
+
+// post makes a POST request to path on the charge service with args as the
+// JSON body and decodes the charge in the 201 response. A 402 returns an error
+// matching declined and a 404 returns notFound, if they're set.
+func (s *HTTPChargeService) post(ctx context.Context, path string, args interface{}, declined, notFound error) (Charge, error) {
+	// encode the arguments as JSON so we can POST them to the charge service
+	// there's a package called "bytes" so we call the variable byts
+	byts, err := json.Marshal(args)
+	if err != nil {
+		return Charge{}, fmt.Errorf("error encoding charge body: %w", err)
+	}
+
+	// the body is JSON but this method accepts a io.Reader so we need to wrap the
+	// byte slice in bytes.NewReader which simply reads over the sent byte slice
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, path, bytes.NewReader(byts))
+	if err != nil {
+		return Charge{}, fmt.Errorf("error creating charge request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	s.mu.Lock()
+	resp, err := s.client.Do(req)
+	s.mu.Unlock()
+
+	if err != nil {
+		return Charge{}, &serviceError{kind: ErrChargeFailed, err: fmt.Errorf("error making charge request: %w", err)}
+	}
+	// we need to make sure we close the body otherwise this will leak memory
+	defer resp.Body.Close()
+	// charges and refunds are created so we expect a 201 response, if we didn't
+	// get that then we must've errored
+	switch {
+	case resp.StatusCode == http.StatusCreated:
+	case resp.StatusCode == http.StatusPaymentRequired && declined != nil:
+		// a 402 means the charge service declined the card which the caller can
+		// fix by using a different card, anything else is our problem
+		return Charge{}, &serviceError{kind: declined, err: responseError("error charging body", resp)}
+	case resp.StatusCode == http.StatusNotFound && notFound != nil:
+		return Charge{}, notFound
+	default:
+		return Charge{}, &serviceError{kind: ErrChargeFailed, err: responseError("error charging body", resp)}
+	}
+
+	// the money already moved so a response we can't read is still a failure
+	// since the caller wouldn't be able to trace the charge
+	var charge Charge
+	if err := json.NewDecoder(resp.Body).Decode(&charge); err != nil {
+		return Charge{}, &serviceError{kind: ErrChargeFailed, err: fmt.Errorf("error decoding charge: %w", err)}
+	}
+	return charge, nil
+}
+
+// Charge implements the ChargeService interface by making a POST request to the
+// /charge endpoint on the charge service
+func (s *HTTPChargeService) Charge(ctx context.Context, args ChargeArgs) (Charge, error) {
+	return s.post(ctx, "/charge", args, ErrChargeDeclined, nil)
+}
+
+// Refund implements the ChargeService interface by making a POST request to the
+// /charges/:id/refunds endpoint on the charge service
+func (s *HTTPChargeService) Refund(ctx context.Context, args RefundArgs) (Charge, error) {
+	// a refund can't be declined so a 402 is just a failure
+	return s.post(ctx, "/charges/"+url.PathEscape(args.ChargeID)+"/refunds", args, nil, ErrChargeNotFound)
+}
+
+// GetCharge implements the ChargeService interface by making a GET request to
+// the /charges/:id endpoint on the charge service
+func (s *HTTPChargeService) GetCharge(ctx context.Context, id string) (Charge, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/charges/"+url.PathEscape(id), nil)
+	if err != nil {
+		return Charge{}, fmt.Errorf("error creating charge request: %w", err)
+	}
+	s.mu.Lock()
+	resp, err := s.client.Do(req)
+	s.mu.Unlock()
+
+	if err != nil {
+		return Charge{}, &serviceError{kind: ErrChargeFailed, err: fmt.Errorf("error making charge request: %w", err)}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return Charge{}, ErrChargeNotFound
+	default:
+		return Charge{}, &serviceError{kind: ErrChargeFailed, err: responseError("error getting charge", resp)}
+	}
+
+	var charge Charge
+	if err := json.NewDecoder(resp.Body).Decode(&charge); err != nil {
+		return Charge{}, &serviceError{kind: ErrChargeFailed, err: fmt.Errorf("error decoding charge: %w", err)}
+	}
+	return charge, nil
+}
+
+// responseError returns an error with the response's status code and body. We
+// opportunistically try to read the body in case it contains an error but if it
+// fails then that's not the end of the world so that error is ignored.
+func responseError(msg string, resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	return fmt.Errorf("%s: %d %s", msg, resp.StatusCode, body)
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPChargeService(t *testing.T) {
+	ctx := context.Background()
+	createdAt := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	// charges are POSTed to /charge and refunds to the charge's refunds, a 201
+	// means it went through and has the charge service's charge
+	{
+		var paths []string
+		var bodies []map[string]interface{}
+		charges := NewHTTPChargeService(newClient(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPost, r.Method)
+			paths = append(paths, r.URL.Path)
+			var body map[string]interface{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			bodies = append(bodies, body)
+			w.WriteHeader(http.StatusCreated)
+			if r.URL.Path == "/charge" {
+				w.Write([]byte(`{"id":"ch_1","amountCents":220,"currency":"EUR","createdAt":"2022-03-04T05:06:07Z"}`))
+			} else {
+				w.Write([]byte(`{"id":"re_1","amountCents":-220,"currency":"EUR","createdAt":"2022-03-04T05:06:07Z"}`))
+			}
+		}))
+		charge, err := charges.Charge(ctx, ChargeArgs{CardToken: "amex", AmountCents: 220, Currency: "EUR"})
+		require.NoError(t, err)
+		assert.Equal(t, Charge{ID: "ch_1", AmountCents: 220, Currency: "EUR", CreatedAt: createdAt}, charge)
+		refund, err := charges.Refund(ctx, RefundArgs{ChargeID: "ch_1", AmountCents: 220, Currency: "EUR"})
+		require.NoError(t, err)
+		assert.Equal(t, Charge{ID: "re_1", AmountCents: -220, Currency: "EUR", CreatedAt: createdAt}, refund)
+
+		assert.Equal(t, []string{"/charge", "/charges/ch_1/refunds"}, paths)
+		// refunds don't need the card since they go back to the charged one
+		assert.Equal(t, []map[string]interface{}{
+			{"cardToken": "amex", "amountCents": float64(220), "currency": "EUR"},
+			{"amountCents": float64(220), "currency": "EUR"},
+		}, bodies)
+	}
+
+	// a 402 is a declined card and anything else is a failure
+	for code, kind := range map[int]error{
+		http.StatusPaymentRequired:     ErrChargeDeclined,
+		http.StatusBadRequest:          ErrChargeFailed,
+		http.StatusInternalServerError: ErrChargeFailed,
+	} {
+		charges := NewHTTPChargeService(newClient(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		}))
+		_, err := charges.Charge(ctx, ChargeArgs{CardToken: "amex", AmountCents: 220})
+		assert.ErrorIs(t, err, kind, code)
+	}
+
+	// a refund is never declined, only failed, and its charge might not exist
+	for code, kind := range map[int]error{
+		http.StatusPaymentRequired: ErrChargeFailed,
+		http.StatusNotFound:        ErrChargeNotFound,
+	} {
+		charges := NewHTTPChargeService(newClient(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		}))
+		_, err := charges.Refund(ctx, RefundArgs{ChargeID: "ch_1", AmountCents: 220})
+		assert.ErrorIs(t, err, kind, code)
+		assert.NotErrorIs(t, err, ErrChargeDeclined)
+	}
+
+	// a charge that can't be decoded can't be traced so it's a failure
+	{
+		charges := NewHTTPChargeService(newClient(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		}))
+		_, err := charges.Charge(ctx, ChargeArgs{CardToken: "amex", AmountCents: 220})
+		assert.ErrorIs(t, err, ErrChargeFailed)
+	}
+
+	// transport errors are failures
+	_, err := NewHTTPChargeService(unreachable).Charge(ctx, ChargeArgs{})
+	assert.ErrorIs(t, err, ErrChargeFailed)
+}
+
+func TestHTTPChargeServiceGetCharge(t *testing.T) {
+	ctx := context.Background()
+	charges := NewHTTPChargeService(newClient(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		switch r.URL.Path {
+		case "/charges/ch_1":
+			w.Write([]byte(`{"id":"ch_1","amountCents":220,"currency":"EUR","createdAt":"2022-03-04T05:06:07Z"}`))
+		case "/charges/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	charge, err := charges.GetCharge(ctx, "ch_1")
+	require.NoError(t, err)
+	assert.Equal(t, Charge{
+		ID:          "ch_1",
+		AmountCents: 220,
+		Currency:    "EUR",
+		CreatedAt:   time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC),
+	}, charge)
+
+	_, err = charges.GetCharge(ctx, "ch_2")
+	assert.Equal(t, ErrChargeNotFound, err)
+
+	_, err = charges.GetCharge(ctx, "broken")
+	assert.ErrorIs(t, err, ErrChargeFailed)
+}
+
+func TestHTTPChargeServiceSerialized(t *testing.T) {
+	ctx := context.Background()
+	// the handler records the most requests it was ever handling at once
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+	charges := NewHTTPChargeService(newClient(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		}
+		w.Write([]byte(`{"id":"ch_1","amountCents":220,"currency":"EUR"}`))
+	}))
+
+	// charges and lookups are never sent at the same time
+	var wg sync.WaitGroup
+	for n := 0; n < 5; n++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := charges.Charge(ctx, ChargeArgs{CardToken: "amex", AmountCents: 220, Currency: "EUR"})
+			assert.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := charges.GetCharge(ctx, "ch_1")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, maxInFlight)
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/levenlabs/order-up/storage"
+)
+
+// FulfillArgs is a line item that's sent to the fulfillment service to be
+// shipped
+type FulfillArgs struct {
+	Description string `json:"description"`
+	Quantity    int64  `json:"quantity"`
+	OrderID     string `json:"orderID"`
+	// ShippingAddress is where to ship the line item, it's nil for orders that
+	// were created without one
+	ShippingAddress *storage.Address `json:"shippingAddress,omitempty"`
+	// ShippingMethod is the code of the shipping method the customer chose
+	ShippingMethod string `json:"shippingMethod,omitempty"`
+}
+
+// FulfillmentStatus is where the fulfillment service is with an order
+type FulfillmentStatus string
+
+// These are all of the statuses the fulfillment service reports
+const (
+	// FulfillmentStatusPending means the line items were received but haven't
+	// shipped yet
+	FulfillmentStatusPending FulfillmentStatus = "pending"
+	// FulfillmentStatusShipped means every line item shipped
+	FulfillmentStatusShipped FulfillmentStatus = "shipped"
+	// FulfillmentStatusCancelled means the order was cancelled before it shipped
+	FulfillmentStatusCancelled FulfillmentStatus = "cancelled"
+)
+
+// FulfillmentService ships line items
+type FulfillmentService interface {
+	// Fulfill should ship the line item. If it can't then an error matching
+	// ErrFulfillmentFailed should be returned.
+	Fulfill(ctx context.Context, args FulfillArgs) error
+	// Cancel should stop shipping the order's line items. If the service doesn't
+	// know about the order then the special ErrFulfillmentNotFound error and for
+	// any other failure one matching ErrFulfillmentFailed should be returned.
+	Cancel(ctx context.Context, orderID string) error
+	// GetStatus should return the order's fulfillment status. If the service
+	// doesn't know about the order then the special ErrFulfillmentNotFound error
+	// should be returned.
+	GetStatus(ctx context.Context, orderID string) (FulfillmentStatus, error)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// HTTPFulfillmentService is the FulfillmentService that talks to the
+// fulfillment service over HTTP
+type HTTPFulfillmentService struct {
+	client *http.Client
+}
+
+// ensure *HTTPFulfillmentService can be used as the FulfillmentService
+var _ FulfillmentService = (*HTTPFulfillmentService)(nil)
+
+// NewHTTPFulfillmentService returns an *HTTPFulfillmentService that makes
+// requests to the fulfillment service with client
+func NewHTTPFulfillmentService(client *http.Client) *HTTPFulfillmentService {
+	return &HTTPFulfillmentService{client: client}
+}
+
+// Ping returns an error if the fulfillment service can't be reached
+func (s *HTTPFulfillmentService) Ping(ctx context.Context) error {
+	return ping(ctx, s.client)
+}
+
+// do makes the request and returns the response if it's a 200 OK. A 404 returns
+// notFound if it's set, which is only the case for the paths with an order ID.
+func (s *HTTPFulfillmentService) do(req *http.Request, notFound error) (*http.Response, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, &serviceError{kind: ErrFulfillmentFailed, err: fmt.Errorf("error making fulfillment request: %w", err)}
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && notFound != nil {
+		return nil, notFound
+	}
+	return nil, &serviceError{kind: ErrFulfillmentFailed, err: responseError("error fulfilling body", resp)}
+}
+
+// Fulfill implements the FulfillmentService interface by making a PUT request
+// to the /fulfill endpoint on the fulfillment service
+func (s *HTTPFulfillmentService) Fulfill(ctx context.Context, args FulfillArgs) error {
+	byts, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("error encoding fulfill body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, "/fulfill", bytes.NewReader(byts))
+	if err != nil {
+		return fmt.Errorf("error creating fulfill request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// /fulfill expects a 200 OK, if we didn't get that then we must've errored
+	// For the purposes of this exercise we'll assume that a 200 means the entire
+	// line item was fulfilled. In reality this is more complicated.
+	resp, err := s.do(req, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Cancel implements the FulfillmentService interface by making a DELETE request
+// to the /fulfill/:orderID endpoint on the fulfillment service
+func (s *HTTPFulfillmentService) Cancel(ctx context.Context, orderID string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, "/fulfill/"+url.PathEscape(orderID), nil)
+	if err != nil {
+		return fmt.Errorf("error creating cancel request: %w", err)
+	}
+	resp, err := s.do(req, ErrFulfillmentNotFound)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// fulfillmentStatusRes is the body of the GET /fulfill/:orderID endpoint
+// exposed by the fulfillment service
+type fulfillmentStatusRes struct {
+	Status FulfillmentStatus `json:"status"`
+}
+
+// GetStatus implements the FulfillmentService interface by making a GET request
+// to the /fulfill/:orderID endpoint on the fulfillment service
+func (s *HTTPFulfillmentService) GetStatus(ctx context.Context, orderID string) (FulfillmentStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/fulfill/"+url.PathEscape(orderID), nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating status request: %w", err)
+	}
+	resp, err := s.do(req, ErrFulfillmentNotFound)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var res fulfillmentStatusRes
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", &serviceError{kind: ErrFulfillmentFailed, err: fmt.Errorf("error decoding status: %w", err)}
+	}
+	return res.Status, nil
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPFulfillmentService(t *testing.T) {
+	ctx := context.Background()
+	args := FulfillArgs{Description: "widget", Quantity: 2, OrderID: "a"}
+
+	// the line item is PUT to /fulfill and a 200 means it was fulfilled
+	{
+		fulfillments := NewHTTPFulfillmentService(newClient(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPut, r.Method)
+			assert.Equal(t, "/fulfill", r.URL.Path)
+			var got FulfillArgs
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+			assert.Equal(t, args, got)
+		}))
+		assert.NoError(t, fulfillments.Fulfill(ctx, args))
+	}
+
+	// anything else is a failure, even a 404
+	for _, code := range []int{http.StatusNotFound, http.StatusServiceUnavailable} {
+		fulfillments := NewHTTPFulfillmentService(newClient(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		}))
+		assert.ErrorIs(t, fulfillments.Fulfill(ctx, args), ErrFulfillmentFailed, code)
+	}
+	assert.ErrorIs(t, NewHTTPFulfillmentService(unreachable).Fulfill(ctx, args), ErrFulfillmentFailed)
+}
+
+func TestHTTPFulfillmentServiceOrders(t *testing.T) {
+	ctx := context.Background()
+	fulfillments := NewHTTPFulfillmentService(newClient(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /fulfill/a":
+			w.Write([]byte(`{"status":"shipped"}`))
+		case "DELETE /fulfill/a":
+		case "GET /fulfill/broken", "DELETE /fulfill/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	// the status is read from GET /fulfill/:orderID
+	status, err := fulfillments.GetStatus(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, FulfillmentStatusShipped, status)
+	_, err = fulfillments.GetStatus(ctx, "b")
+	assert.Equal(t, ErrFulfillmentNotFound, err)
+	_, err = fulfillments.GetStatus(ctx, "broken")
+	assert.ErrorIs(t, err, ErrFulfillmentFailed)
+
+	// and cancelling is a DELETE to the same path
+	assert.NoError(t, fulfillments.Cancel(ctx, "a"))
+	assert.Equal(t, ErrFulfillmentNotFound, fulfillments.Cancel(ctx, "b"))
+	assert.ErrorIs(t, fulfillments.Cancel(ctx, "broken"), ErrFulfillmentFailed)
+}
@@ -0,0 +1,73 @@
+// Package services talks to the charge and fulfillment services that orders
+// depend on. Each one is an interface so that a different provider can be
+// plugged in, and the HTTP implementations make requests with an *http.Client
+// whose transport fills in the service's host since every request is made to a
+// relative path.
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrChargeDeclined is returned when the charge service declined the card,
+	// the caller can retry with a different one
+	ErrChargeDeclined = errors.New("card declined")
+
+	// ErrChargeNotFound is returned when the charge service doesn't have the
+	// requested charge
+	ErrChargeNotFound = errors.New("charge not found")
+
+	// ErrChargeFailed is returned when the charge service couldn't be reached or
+	// errored
+	ErrChargeFailed = errors.New("charge failed")
+
+	// ErrFulfillmentNotFound is returned when the fulfillment service doesn't
+	// know about the order
+	ErrFulfillmentNotFound = errors.New("fulfillment not found")
+
+	// ErrFulfillmentFailed is returned when the fulfillment service couldn't be
+	// reached or errored
+	ErrFulfillmentFailed = errors.New("fulfillment failed")
+)
+
+// serviceError is an error from a service. errors.Is matches it with kind, like
+// ErrChargeFailed, as well as anything in err.
+type serviceError struct {
+	kind error
+	err  error
+}
+
+// Error implements the error interface
+func (e *serviceError) Error() string {
+	return fmt.Sprintf("%v: %v", e.kind, e.err)
+}
+
+// Is returns true for the error's kind
+func (e *serviceError) Is(target error) bool {
+	return target == e.kind
+}
+
+// Unwrap returns the underlying error
+func (e *serviceError) Unwrap() error {
+	return e.err
+}
+
+// ping makes a request to the service's /healthz path. Neither service
+// documents a health endpoint so any response at all means the service is
+// reachable, only transport errors fail.
+func ping(ctx context.Context, client *http.Client) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/healthz", nil)
+	if err != nil {
+		return fmt.Errorf("error creating health request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making health request: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
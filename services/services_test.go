@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// transportFunc is a function implementing the http.RoundTripper interface.
+// mocks.NewMockedService does the same but the mocks package imports this one.
+type transportFunc func(r *http.Request) (*http.Response, error)
+
+// RoundTrip implmements the http.RoundTripper interface by calling the
+// underlying function
+func (fn transportFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return fn(r)
+}
+
+// newClient returns an *http.Client that calls handler for every request
+func newClient(handler http.HandlerFunc) *http.Client {
+	return &http.Client{
+		Transport: transportFunc(func(r *http.Request) (*http.Response, error) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			return w.Result(), nil
+		}),
+	}
+}
+
+// unreachable is an *http.Client that can't reach its service
+var unreachable = &http.Client{
+	Transport: transportFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	}),
+}
+
+func TestPing(t *testing.T) {
+	ctx := context.Background()
+
+	// any response means the service is reachable
+	reachable := newClient(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/healthz", r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	})
+	assert.NoError(t, NewHTTPChargeService(reachable).Ping(ctx))
+	assert.NoError(t, NewHTTPFulfillmentService(reachable).Ping(ctx))
+
+	assert.Error(t, NewHTTPChargeService(unreachable).Ping(ctx))
+	assert.Error(t, NewHTTPFulfillmentService(unreachable).Ping(ctx))
+}
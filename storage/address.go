@@ -0,0 +1,30 @@
+package storage
+
+// Address is a postal address used for shipping or billing an order
+type Address struct {
+	// Name is who the order is shipped to or billed to
+	Name string `json:"name"`
+	// Line1 is the street address
+	Line1 string `json:"line1"`
+	// Line2 is the apartment, suite or unit, if any
+	Line2 string `json:"line2,omitempty"`
+	// City is the city, town or locality
+	City string `json:"city"`
+	// Region is the state, province or other subdivision, if the country has
+	// them. It's the subdivision part of an ISO 3166-2 code, like CA for US-CA.
+	Region string `json:"region,omitempty"`
+	// PostalCode is the ZIP or postal code, if the country has them
+	PostalCode string `json:"postalCode,omitempty"`
+	// Country is the ISO 3166-1 alpha-2 country code, like US
+	Country string `json:"country"`
+}
+
+// Jurisdiction returns the tax jurisdiction for the address, which is the
+// ISO 3166-2 subdivision code if there's a region and otherwise the country
+// code
+func (a Address) Jurisdiction() string {
+	if a.Region == "" {
+		return a.Country
+	}
+	return a.Country + "-" + a.Region
+}
@@ -0,0 +1,22 @@
+package storage
+
+import "time"
+
+// APIKey is a static credential that can be used to authenticate with the API.
+// Only a hash of the key is ever stored, the key itself is shown once when it's
+// created.
+type APIKey struct {
+	// ID is the unique identifier for the key, it's safe to show and log unlike
+	// the key itself
+	ID string `json:"id"`
+	// Name is a human-readable description of who or what uses the key
+	Name string `json:"name"`
+	// Hash is the hex-encoded SHA-256 hash of the key
+	// it's never returned over the API since that would make it easier to
+	// brute-force
+	Hash string `json:"-"`
+	// Scopes are the permissions granted to whoever presents the key
+	Scopes []string `json:"scopes"`
+	// CreatedAt is when the key was created
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -4,13 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 var (
@@ -20,26 +21,85 @@ var (
 	// ErrOrderExists is returned when a new order is being inserted but an order
 	// with the same ID already exists
 	ErrOrderExists = errors.New("order already exists")
+
+	// ErrOrderNotPending is returned when an order is being updated but it was
+	// already charged, fulfilled or cancelled
+	ErrOrderNotPending = errors.New("order is not pending")
+
+	// ErrVersionConflict is returned when an order is being updated but its
+	// version isn't the expected one because someone else updated it first
+	ErrVersionConflict = errors.New("order version conflict")
+
+	// ErrBatchAborted is returned for the orders of an atomic batch that weren't
+	// inserted because a different order in the batch couldn't be
+	ErrBatchAborted = errors.New("another order in the batch failed")
+
+	// ErrSchemaNotReady is returned by Ping when the schema hasn't been ensured
+	// yet so the instance shouldn't be used
+	ErrSchemaNotReady = errors.New("schema not ready")
+
+	// ErrAPIKeyNotFound is returned when the specified API key cannot be found
+	ErrAPIKeyNotFound = errors.New("api key not found")
+
+	// ErrPromotionNotFound is returned when the specified promotion cannot be
+	// found
+	ErrPromotionNotFound = errors.New("promotion not found")
+
+	// ErrPromotionExists is returned when a new promotion is being inserted but a
+	// promotion with the same code already exists
+	ErrPromotionExists = errors.New("promotion already exists")
+
+	// ErrPromotionExpired is returned when a promotion is being redeemed after it
+	// expired
+	ErrPromotionExpired = errors.New("promotion expired")
+
+	// ErrPromotionLimitReached is returned when a promotion is being redeemed but
+	// it has already been redeemed the maximum number of times in total or by the
+	// customer
+	ErrPromotionLimitReached = errors.New("promotion redemption limit reached")
+
+	// ErrRateLimitBucketNotFound is returned when the specified rate limit bucket
+	// cannot be found
+	ErrRateLimitBucketNotFound = errors.New("rate limit bucket not found")
+
+	// ErrRateLimitBucketChanged is returned when a rate limit bucket is being
+	// swapped but another writer changed it first
+	ErrRateLimitBucketChanged = errors.New("rate limit bucket changed")
+
+	// ErrIdempotencyRecordNotFound is returned when the specified idempotency
+	// record isn't found
+	ErrIdempotencyRecordNotFound = errors.New("idempotency record not found")
+
+	// ErrIdempotencyRecordExists is returned when inserting an idempotency record
+	// for a key that already has one
+	ErrIdempotencyRecordExists = errors.New("idempotency record already exists")
 )
 
 ////////////////////////////////////////////////////////////////////////////////
 
+// Ping should return nil if the database is reachable and the schema has been
+// ensured. It's used to decide whether the service is ready to accept traffic.
+func (i *Instance) Ping(ctx context.Context) error {
+	if atomic.LoadInt32(&i.schemaReady) == 0 {
+		return ErrSchemaNotReady
+	}
+
+	// the primary is the only member we write to so that's the one that needs to
+	// be reachable
+	if err := i.client.Ping(ctx, readpref.Primary()); err != nil {
+		return fmt.Errorf("Ping: %w", err)
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
 // GetOrder should return the order with the given ID. If that ID isn't found then
 // the special ErrOrderNotFound error should be returned.
 func (i *Instance) GetOrder(ctx context.Context, id string) (Order, error) {
 	// TODO: get order from DB based on the id
-	mongoUri := os.Getenv("MONGO_URI")
-	currentDb := os.Getenv("MONGO_DATABASE_NAME")
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoUri))
-
-	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
-			panic(err)
-		}
-	}()
-
-	collection := client.Database(currentDb).Collection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	collection := i.orders()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	if id != "" {
@@ -58,11 +118,12 @@ func (i *Instance) GetOrder(ctx context.Context, id string) (Order, error) {
 			if err == mongo.ErrNoDocuments {
 				return Order{}, ErrOrderNotFound
 			} else {
-				return Order{}, fmt.Errorf("InsertOrder: %v", err)
+				return Order{}, fmt.Errorf("GetOrder: %v", err)
 			}
 		} else {
 			// No error, this means it successfully found an order.
-			fmt.Println("order exists.")
+			// orders stored before currencies were supported don't have one
+			resultDoc.Currency = resultDoc.Currency.OrDefault()
 			return resultDoc, nil
 		}
 	}
@@ -71,217 +132,790 @@ func (i *Instance) GetOrder(ctx context.Context, id string) (Order, error) {
 
 ////////////////////////////////////////////////////////////////////////////////
 
-// GetOrders should return all orders with the given status. If status is the
-// special -1 value then it should return all orders regardless of their status.
-func (i *Instance) GetOrders(ctx context.Context, status OrderStatus) ([]Order, error) {
-	mongoUri := os.Getenv("MONGO_URI")
-	currentDb := os.Getenv("MONGO_DATABASE_NAME")
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoUri))
-
-	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
-			panic(err)
-		}
-	}()
-
-	collection := client.Database(currentDb).Collection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// GetOrders should return all orders matching the filter, sorted by ID. A zero
+// value filter with Status set to OrderStatusAny returns every order.
+func (i *Instance) GetOrders(ctx context.Context, filter OrderFilter) ([]Order, error) {
+	collection := i.orders()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	var orderResults []Order
+	// sorting by ID is what makes AfterID useful for paging
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if filter.Limit > 0 {
+		opts.SetLimit(filter.Limit)
+	}
+	cur, err := collection.Find(ctx, filter.bson(), opts)
+	if err != nil {
+		return nil, fmt.Errorf("GetOrders: %v", err)
+	}
+	defer cur.Close(ctx)
 
-	if status == -1 {
-		cur, err := collection.Find(ctx, bson.D{})
+	var orderResults []Order
+	for cur.Next(ctx) {
+		result := Order{}
 
+		err := cur.Decode(&result)
 		if err != nil {
 			return nil, fmt.Errorf("GetOrders: %v", err)
 		}
+		result.Currency = result.Currency.OrDefault()
 
-		for cur.Next(ctx) {
-			result := Order{}
-
-			err := cur.Decode(&result)
-			if err != nil {
-				return nil, fmt.Errorf("GetOrders: %v", err)
-			}
+		orderResults = append(orderResults, result)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, fmt.Errorf("GetOrders: %v", err)
+	}
 
-			orderResults = append(orderResults, result)
-		}
+	return orderResults, nil
+}
 
-		return orderResults, nil
-	} else {
-		filter := bson.D{{Key: "status", Value: status}}
-		// Didn't realize, in the event of an existing document we want to error.
-		cur, err := collection.Find(ctx, filter, nil)
+// eachOrderBatchSize is how many orders EachOrder reads from the database at a
+// time
+const eachOrderBatchSize = 100
+
+// EachOrder calls fn with every order matching the filter, sorted by ID. Unlike
+// GetOrders the orders are read from a cursor a batch at a time so memory use
+// doesn't grow with the number of orders. There's no timeout other than ctx's
+// since going through every order can take a while. If fn returns an error then
+// EachOrder stops and returns it. The filter's Limit is ignored.
+func (i *Instance) EachOrder(ctx context.Context, filter OrderFilter, fn func(Order) error) error {
+	collection := i.orders()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetBatchSize(eachOrderBatchSize)
+	cur, err := collection.Find(ctx, filter.bson(), opts)
+	if err != nil {
+		return fmt.Errorf("EachOrder: %w", err)
+	}
+	defer cur.Close(ctx)
 
-		if err != nil {
-			return nil, fmt.Errorf("GetOrders: %v", err)
+	for cur.Next(ctx) {
+		var order Order
+		if err := cur.Decode(&order); err != nil {
+			return fmt.Errorf("EachOrder: %w", err)
+		}
+		order.Currency = order.Currency.OrDefault()
+		if err := fn(order); err != nil {
+			return err
 		}
+	}
+	if err := cur.Err(); err != nil {
+		return fmt.Errorf("EachOrder: %w", err)
+	}
+	return nil
+}
 
-		for cur.Next(ctx) {
-			result := Order{}
+// bson converts the filter into a query document for the orders collection
+func (f OrderFilter) bson() bson.D {
+	filter := bson.D{}
+	if f.Status != OrderStatusAny {
+		filter = append(filter, bson.E{Key: "status", Value: statusFilter(f.Status)})
+	}
+	if f.CustomerEmail != "" {
+		filter = append(filter, bson.E{Key: "customerEmail", Value: NormalizeEmail(f.CustomerEmail)})
+	}
+	if f.AfterID != "" {
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$gt", Value: f.AfterID}}})
+	}
+	return filter
+}
 
-			err := cur.Decode(&result)
-			if err != nil {
-				return nil, fmt.Errorf("GetOrders: %v", err)
-			}
+////////////////////////////////////////////////////////////////////////////////
 
-			orderResults = append(orderResults, result)
-		}
+// SetOrderStatus should update the order with the given ID, set the status
+// field, increment its version and append an entry to the order's history with
+// the actor and reason from the context. The order is only updated if its
+// version is still version. If that ID isn't found then the special
+// ErrOrderNotFound error and if its version changed then ErrVersionConflict
+// should be returned.
+func (i *Instance) SetOrderStatus(ctx context.Context, id string, status OrderStatus, version int64) error {
+	collection := i.orders()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
-		return orderResults, nil
+	// the version is part of the filter so the status can't be changed based on
+	// an old copy of the order, like charging an order that was edited after it
+	// was loaded
+	filter := bson.D{
+		{Key: "_id", Value: id},
+		{Key: "version", Value: versionFilter(version)},
+	}
+	// every status change is also appended to the history along with whoever
+	// made the change
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: status},
+		}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: int64(1)}}},
+		{Key: "$push", Value: bson.D{
+			{Key: "history", Value: HistoryEntry{
+				Status: status,
+				At:     time.Now().UTC(),
+				Actor:  ActorFromContext(ctx),
+				Reason: ReasonFromContext(ctx),
+			}},
+		}},
+	}
+	res, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("SetOrderStatus: %w", err)
+	}
+	if res.MatchedCount > 0 {
+		return nil
 	}
 
-	// TODO: get orders from DB based based on the status sent, unless status is -1
-	// return nil, errors.New("unimplemented")
+	// nothing matched so either the order doesn't exist or its version changed
+	n, err := collection.CountDocuments(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return fmt.Errorf("SetOrderStatus: %w", err)
+	}
+	if n == 0 {
+		return ErrOrderNotFound
+	}
+	return ErrVersionConflict
 }
 
-////////////////////////////////////////////////////////////////////////////////
+// AddOrderPayment should append payment to the payments of the order with the
+// given ID and increment its version. The version isn't checked since the money
+// already moved and has to be recorded no matter what happened to the order in
+// the meantime. If that ID isn't found then the special ErrOrderNotFound error
+// should be returned.
+func (i *Instance) AddOrderPayment(ctx context.Context, id string, payment Payment) error {
+	collection := i.orders()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
-// SetOrderStatus should update the order with the given ID and set the status
-// field. If that ID isn't found then the special ErrOrderNotFound error should
-// be returned.
-func (i *Instance) SetOrderStatus(ctx context.Context, id string, status OrderStatus) error {
-	mongoUri := os.Getenv("MONGO_URI")
-	currentDb := os.Getenv("MONGO_DATABASE_NAME")
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoUri))
-
-	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
-			panic(err)
-		}
-	}()
+	// the version is incremented like for every other update so ETags and
+	// watchers see the new payment
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: int64(1)}}},
+		{Key: "$push", Value: bson.D{{Key: "payments", Value: payment}}},
+	}
+	res, err := collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}}, update)
+	if err != nil {
+		return fmt.Errorf("AddOrderPayment: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrOrderNotFound
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
 
-	collection := client.Database(currentDb).Collection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// InsertOrder should fill in the order's ID with a unique identifier if it's not
+// already set and then insert it into the database. It should return the order's
+// ID. If the order already exists then ErrOrderExists should be returned.
+func (i *Instance) InsertOrder(ctx context.Context, order Order) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	if id != "" {
-		// Find item.
-		// Abstract this fetch by id chunk out.
-		filter := bson.D{{Key: "_id", Value: id}}
-		res := collection.FindOne(ctx, filter, nil)
+	id := order.ID
+	if id == "" {
+		id = uuid.New().String()
+	}
+	// the insert fails on the _id index if the order already exists, which is
+	// atomic unlike checking for it first
+	_, err := i.orders().InsertOne(ctx, orderFields(id, order))
+	if mongo.IsDuplicateKeyError(err) {
+		return "", ErrOrderExists
+	} else if err != nil {
+		return "", fmt.Errorf("InsertOrder: %w", err)
+	}
+	return id, nil
+}
 
-		var resultDoc map[string]interface{}
+// orderFields returns every field of the order that's stored when it's inserted.
+// Every new order starts at version 1.
+func orderFields(id string, order Order) bson.D {
+	fields := append(bson.D{
+		{Key: "_id", Value: id},
+		{Key: "id", Value: id},
+		{Key: "currency", Value: order.Currency},
+		{Key: "status", Value: order.Status},
+		{Key: "promoCodes", Value: order.PromoCodes},
+		{Key: "version", Value: int64(1)},
+	}, editableOrderFields(order)...)
+	// payments are left out rather than stored as null when there aren't any
+	// since AddOrderPayment can't push onto null
+	if len(order.Payments) > 0 {
+		fields = append(fields, bson.E{Key: "payments", Value: order.Payments})
+	}
+	return fields
+}
 
-		err := res.Decode(&resultDoc)
+// InsertOrders inserts every order with a single bulk write, filling in the IDs
+// of the orders that don't have one. It returns the ID of every order and, at
+// the same index, the error for each order that couldn't be inserted, like
+// ErrOrderExists, or nil if it was. If atomic is true then either every order is
+// inserted or none are and the orders that didn't fail themselves get
+// ErrBatchAborted. The final error means the whole batch failed, like when the
+// database is unreachable, and none of the orders should be assumed inserted.
+func (i *Instance) InsertOrders(ctx context.Context, orders []Order, atomic bool) ([]string, []error, error) {
+	collection := i.orders()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
+	ids := make([]string, len(orders))
+	docs := make([]interface{}, len(orders))
+	for idx, order := range orders {
+		ids[idx] = order.ID
+		if ids[idx] == "" {
+			ids[idx] = uuid.New().String()
+		}
+		docs[idx] = orderFields(ids[idx], order)
+	}
+
+	var err error
+	if atomic {
+		// InsertMany on its own keeps the orders before the one that failed so it
+		// has to happen in a transaction, which requires a replica set
+		var sess mongo.Session
+		sess, err = i.client.StartSession()
 		if err != nil {
-			// Error there. If it is due to no documents, skip. If it is anything else, return out.
-			if err == mongo.ErrNoDocuments {
-				return ErrOrderNotFound
-			} else {
-				return fmt.Errorf("SetOrderStatus: %v", err)
-			}
+			return nil, nil, fmt.Errorf("InsertOrders: %w", err)
+		}
+		defer sess.EndSession(ctx)
+		_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+			return collection.InsertMany(sc, docs)
+		})
+	} else {
+		// unordered so that every order is attempted even if an earlier one fails
+		_, err = collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	}
+
+	errs := make([]error, len(orders))
+	if err == nil {
+		return ids, errs, nil
+	}
+	// anything other than errors for individual orders means we don't know what
+	// happened to the batch
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) || bwe.WriteConcernError != nil || len(bwe.WriteErrors) == 0 {
+		return nil, nil, fmt.Errorf("InsertOrders: %w", err)
+	}
+	for _, we := range bwe.WriteErrors {
+		if mongo.IsDuplicateKeyError(we.WriteError) {
+			errs[we.Index] = ErrOrderExists
 		} else {
-			// No error, this means it successfully found an order.
-			// Then update.
-			update := bson.D{{Key: "$set", Value: bson.D{
-				{Key: "status", Value: status},
-			}},
-			}
-			fmt.Println("order exists. Updating.")
-			_, err := collection.UpdateOne(ctx, filter, update)
-			if err != nil {
-				return fmt.Errorf("SetOrderStatus: %v", err)
+			errs[we.Index] = fmt.Errorf("InsertOrders: %w", we.WriteError)
+		}
+	}
+	if atomic {
+		for idx := range errs {
+			if errs[idx] == nil {
+				errs[idx] = ErrBatchAborted
 			}
+		}
+	}
+	return ids, errs, nil
+}
 
-			return nil
+// editableOrderFields returns the fields of the order that can be changed by
+// UpdateOrder while it's pending
+func editableOrderFields(order Order) bson.D {
+	return bson.D{
+		{Key: "customerEmail", Value: order.CustomerEmail},
+		{Key: "notes", Value: order.Notes},
+		{Key: "lineItems", Value: order.LineItems},
+		{Key: "jurisdiction", Value: order.Jurisdiction},
+		{Key: "tax", Value: order.Tax},
+		{Key: "shippingAddress", Value: order.ShippingAddress},
+		{Key: "billingAddress", Value: order.BillingAddress},
+		{Key: "shippingMethod", Value: order.ShippingMethod},
+	}
+}
 
-		}
+////////////////////////////////////////////////////////////////////////////////
+
+// UpdateOrder should replace the customer email, notes, line items, tax,
+// jurisdiction, addresses and shipping method of the order with order's,
+// increment its version and append a history entry with the changes and the
+// actor from the context. Only pending orders whose version is still
+// order.Version can be updated. If the order's ID isn't found then the special
+// ErrOrderNotFound error, if it's no longer pending then ErrOrderNotPending and
+// if its version changed then ErrVersionConflict should be returned.
+func (i *Instance) UpdateOrder(ctx context.Context, order Order, changes []FieldChange) error {
+	collection := i.orders()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// the status is part of the filter so an order that's charged at the same time
+	// isn't changed after the customer paid for it and the version is so that an
+	// edit based on an old copy of the order doesn't overwrite a newer one
+	filter := bson.D{
+		{Key: "_id", Value: order.ID},
+		{Key: "status", Value: statusFilter(OrderStatusPending)},
+		{Key: "version", Value: versionFilter(order.Version)},
+	}
+	update := bson.D{
+		{Key: "$set", Value: editableOrderFields(order)},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: int64(1)}}},
+		{Key: "$push", Value: bson.D{
+			{Key: "history", Value: HistoryEntry{
+				Status:  OrderStatusPending,
+				At:      time.Now().UTC(),
+				Actor:   ActorFromContext(ctx),
+				Changes: changes,
+			}},
+		}},
+	}
+	res, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("UpdateOrder: %w", err)
+	}
+	if res.MatchedCount > 0 {
+		return nil
+	}
+
+	// nothing matched so figure out which part of the filter didn't
+	var current struct {
+		Status  OrderStatus `bson:"status"`
+		Version int64       `bson:"version"`
+	}
+	err = collection.FindOne(ctx, bson.D{{Key: "_id", Value: order.ID}}).Decode(&current)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrOrderNotFound
+	} else if err != nil {
+		return fmt.Errorf("UpdateOrder: %w", err)
+	}
+	if current.Status != OrderStatusPending {
+		return ErrOrderNotPending
+	}
+	return ErrVersionConflict
+}
 
+// versionFilter matches the given order version. Orders stored before versions
+// existed don't have the field at all so version 0 also matches a missing field.
+func versionFilter(version int64) interface{} {
+	if version == 0 {
+		return bson.D{{Key: "$in", Value: bson.A{int64(0), nil}}}
 	}
-	// If id is not found, then return err.
-	return ErrOrderNotFound
+	return version
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
-// InsertOrder should fill in the order's ID with a unique identifier if it's not
-// already set and then insert it into the database. It should return the order's
-// ID. If the order already exists then ErrOrderExists should be returned.
-func (i *Instance) InsertOrder(ctx context.Context, order Order) (string, error) {
-	mongoUri := os.Getenv("MONGO_URI")
-	currentDb := os.Getenv("MONGO_DATABASE_NAME")
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoUri))
+// InsertAPIKey should fill in the key's ID with a unique identifier if it's not
+// already set and then insert it into the database. It should return the key's
+// ID.
+func (i *Instance) InsertAPIKey(ctx context.Context, key APIKey) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if key.ID == "" {
+		key.ID = uuid.New().String()
+	}
+	_, err := i.apiKeys().InsertOne(ctx, bson.D{
+		{Key: "_id", Value: key.ID},
+		{Key: "id", Value: key.ID},
+		{Key: "name", Value: key.Name},
+		{Key: "hash", Value: key.Hash},
+		{Key: "scopes", Value: key.Scopes},
+		{Key: "createdAt", Value: key.CreatedAt},
+	})
+	if err != nil {
+		return "", fmt.Errorf("InsertAPIKey: %w", err)
+	}
+	return key.ID, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
 
-	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
-			panic(err)
+// GetAPIKeyByHash should return the API key with the given hash. If no key has
+// that hash then the special ErrAPIKeyNotFound error should be returned.
+func (i *Instance) GetAPIKeyByHash(ctx context.Context, hash string) (APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var key APIKey
+	err := i.apiKeys().FindOne(ctx, bson.D{{Key: "hash", Value: hash}}).Decode(&key)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return APIKey{}, ErrAPIKeyNotFound
 		}
-	}()
+		return APIKey{}, fmt.Errorf("GetAPIKeyByHash: %w", err)
+	}
+	return key, nil
+}
 
-	collection := client.Database(currentDb).Collection("orders")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+////////////////////////////////////////////////////////////////////////////////
+
+// GetAPIKeys should return all of the API keys
+func (i *Instance) GetAPIKeys(ctx context.Context) ([]APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	if order.ID != "" {
-		filter := bson.D{{Key: "_id", Value: order.ID}}
-		// Didn't realize, in the event of an existing document we want to error.
-		res := collection.FindOne(ctx, filter, nil)
+	cur, err := i.apiKeys().Find(ctx, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("GetAPIKeys: %w", err)
+	}
+	var keys []APIKey
+	if err := cur.All(ctx, &keys); err != nil {
+		return nil, fmt.Errorf("GetAPIKeys: %w", err)
+	}
+	return keys, nil
+}
 
-		var resultDoc map[string]interface{}
+////////////////////////////////////////////////////////////////////////////////
 
-		err := res.Decode(&resultDoc)
+// DeleteAPIKey should delete the API key with the given ID. If that ID isn't
+// found then the special ErrAPIKeyNotFound error should be returned.
+func (i *Instance) DeleteAPIKey(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
-		if err != nil {
-			// Error there. If it is due to no documents, skip. If it is anything else, return out.
-			if err == mongo.ErrNoDocuments {
-				fmt.Println("No documents found. Proceeding with insert.")
-			} else {
-				return "", fmt.Errorf("InsertOrder: %v", err)
-			}
-		} else {
-			// No error, this means it successfully found an order.
-			fmt.Println("order exists.")
-			return "", ErrOrderExists
+	res, err := i.apiKeys().DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return fmt.Errorf("DeleteAPIKey: %w", err)
+	}
+	if res.DeletedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// GetRateLimitBucket should return the bucket with the given key. If there is
+// no bucket then the special ErrRateLimitBucketNotFound error should be
+// returned.
+func (i *Instance) GetRateLimitBucket(ctx context.Context, key string) (RateLimitBucket, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var bucket RateLimitBucket
+	err := i.rateLimits().FindOne(ctx, bson.D{{Key: "_id", Value: key}}).Decode(&bucket)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return RateLimitBucket{}, ErrRateLimitBucketNotFound
 		}
+		return RateLimitBucket{}, fmt.Errorf("GetRateLimitBucket: %w", err)
+	}
+	return bucket, nil
+}
 
-		opts := options.Update().SetUpsert(true)
+////////////////////////////////////////////////////////////////////////////////
 
-		update := bson.D{{Key: "$set", Value: bson.D{
-			{Key: "_id", Value: order.ID},
-			{Key: "id", Value: order.ID},
-			{Key: "customerEmail", Value: order.CustomerEmail},
-			{Key: "status", Value: order.Status},
-			{Key: "lineItems", Value: order.LineItems},
-		}},
+// SwapRateLimitBucket should replace the stored bucket only if its version
+// still matches bucket.Version, or insert it if bucket.Version is 0, and
+// increment the version. Otherwise ErrRateLimitBucketChanged should be
+// returned. The bucket can be deleted after expiresAt.
+func (i *Instance) SwapRateLimitBucket(ctx context.Context, bucket RateLimitBucket, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	doc := bson.D{
+		{Key: "_id", Value: bucket.Key},
+		{Key: "key", Value: bucket.Key},
+		{Key: "tokens", Value: bucket.Tokens},
+		{Key: "updatedAt", Value: bucket.UpdatedAt},
+		{Key: "version", Value: bucket.Version + 1},
+		// the TTL index created in ensureSchema deletes the bucket after this
+		{Key: "expiresAt", Value: expiresAt},
+	}
+
+	if bucket.Version == 0 {
+		_, err := i.rateLimits().InsertOne(ctx, doc)
+		if mongo.IsDuplicateKeyError(err) {
+			// another writer created the bucket first
+			return ErrRateLimitBucketChanged
+		} else if err != nil {
+			return fmt.Errorf("SwapRateLimitBucket: %w", err)
 		}
+		return nil
+	}
 
-		_, err = collection.UpdateOne(ctx, filter, update, opts)
-		if err != nil {
-			return "", fmt.Errorf("error: InsertOrder: %v", err)
+	res, err := i.rateLimits().ReplaceOne(ctx, bson.D{
+		{Key: "_id", Value: bucket.Key},
+		{Key: "version", Value: bucket.Version},
+	}, doc)
+	if err != nil {
+		return fmt.Errorf("SwapRateLimitBucket: %w", err)
+	}
+	// if nothing matched then either the version changed or the bucket expired in
+	// the meantime, either way the caller needs to read it again
+	if res.MatchedCount == 0 {
+		return ErrRateLimitBucketChanged
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// InsertPromotion should insert the promotion into the database. If a promotion
+// with the same code already exists then ErrPromotionExists should be returned.
+func (i *Instance) InsertPromotion(ctx context.Context, promo Promotion) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := i.promotions().InsertOne(ctx, bson.D{
+		{Key: "_id", Value: promo.Code},
+		{Key: "code", Value: promo.Code},
+		{Key: "kind", Value: promo.Kind},
+		{Key: "percentOff", Value: promo.PercentOff},
+		{Key: "amountOffCents", Value: promo.AmountOffCents},
+		{Key: "currency", Value: promo.Currency},
+		{Key: "expiresAt", Value: promo.ExpiresAt},
+		{Key: "maxRedemptions", Value: promo.MaxRedemptions},
+		{Key: "maxRedemptionsPerCustomer", Value: promo.MaxRedemptionsPerCustomer},
+		{Key: "minOrderCents", Value: promo.MinOrderCents},
+		{Key: "redemptions", Value: int64(0)},
+		{Key: "createdAt", Value: promo.CreatedAt},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrPromotionExists
+	} else if err != nil {
+		return fmt.Errorf("InsertPromotion: %w", err)
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// GetPromotion should return the promotion with the given code. If that code
+// isn't found then the special ErrPromotionNotFound error should be returned.
+func (i *Instance) GetPromotion(ctx context.Context, code string) (Promotion, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var promo Promotion
+	err := i.promotions().FindOne(ctx, bson.D{{Key: "_id", Value: code}}).Decode(&promo)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return Promotion{}, ErrPromotionNotFound
 		}
+		return Promotion{}, fmt.Errorf("GetPromotion: %w", err)
+	}
+	return promo, nil
+}
 
-		// No error, assume document was upserted.
+////////////////////////////////////////////////////////////////////////////////
 
-		return order.ID, nil
-	} else {
-		new_id := uuid.New().String()
-		opts := options.Update().SetUpsert(true)
-		filter := bson.D{{Key: "_id", Value: new_id}}
-
-		update := bson.D{{Key: "$set", Value: bson.D{
-			{Key: "_id", Value: new_id},
-			{Key: "id", Value: new_id},
-			{Key: "customerEmail", Value: order.CustomerEmail},
-			{Key: "status", Value: order.Status},
-			{Key: "lineItems", Value: order.LineItems},
+// GetPromotions should return all of the promotions
+func (i *Instance) GetPromotions(ctx context.Context) ([]Promotion, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cur, err := i.promotions().Find(ctx, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("GetPromotions: %w", err)
+	}
+	var promos []Promotion
+	if err := cur.All(ctx, &promos); err != nil {
+		return nil, fmt.Errorf("GetPromotions: %w", err)
+	}
+	return promos, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// DeletePromotion should delete the promotion with the given code. If that code
+// isn't found then the special ErrPromotionNotFound error should be returned.
+func (i *Instance) DeletePromotion(ctx context.Context, code string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := i.promotions().DeleteOne(ctx, bson.D{{Key: "_id", Value: code}})
+	if err != nil {
+		return fmt.Errorf("DeletePromotion: %w", err)
+	}
+	if res.DeletedCount == 0 {
+		return ErrPromotionNotFound
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// RedeemPromotion should atomically record that the customer used the
+// promotion. If the promotion doesn't exist then ErrPromotionNotFound, if it
+// expired then ErrPromotionExpired and if it was already redeemed the maximum
+// number of times in total or by the customer then ErrPromotionLimitReached
+// should be returned.
+func (i *Instance) RedeemPromotion(ctx context.Context, code, customerEmail string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	promo, err := i.GetPromotion(ctx, code)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if promo.Expired(now) {
+		return ErrPromotionExpired
+	}
+
+	// the customer's redemptions are counted first using an upsert that only
+	// matches if the customer is under their limit. If they're at their limit the
+	// upsert tries to insert a second document with the same _id which fails with
+	// a duplicate key error, so two concurrent requests can't both get the last
+	// redemption.
+	customerFilter := bson.D{{Key: "_id", Value: redemptionID(code, customerEmail)}}
+	if promo.MaxRedemptionsPerCustomer > 0 {
+		customerFilter = append(customerFilter, bson.E{Key: "count", Value: bson.D{{Key: "$lt", Value: promo.MaxRedemptionsPerCustomer}}})
+	}
+	_, err = i.promotionRedemptions().UpdateOne(ctx, customerFilter, bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "count", Value: int64(1)}}},
+		{Key: "$setOnInsert", Value: bson.D{
+			{Key: "code", Value: code},
+			{Key: "customerEmail", Value: customerEmail},
 		}},
-		}
+	}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrPromotionLimitReached
+	} else if err != nil {
+		return fmt.Errorf("RedeemPromotion: %w", err)
+	}
 
-		res, err := collection.UpdateOne(ctx, filter, update, opts)
-		if err != nil {
-			return "", ErrOrderExists
+	// the total is limited the same way except that the promotion has to exist
+	// already so nothing is upserted
+	filter := bson.D{{Key: "_id", Value: code}}
+	if promo.MaxRedemptions > 0 {
+		filter = append(filter, bson.E{Key: "redemptions", Value: bson.D{{Key: "$lt", Value: promo.MaxRedemptions}}})
+	}
+	res, err := i.promotions().UpdateOne(ctx, filter, bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "redemptions", Value: int64(1)}}},
+	})
+	if err == nil && res.MatchedCount == 1 {
+		return nil
+	}
+
+	// give the customer's redemption back since the promotion wasn't redeemed
+	if undoErr := i.releaseCustomerRedemption(ctx, code, customerEmail); undoErr != nil {
+		return fmt.Errorf("RedeemPromotion: error undoing customer redemption: %w", undoErr)
+	}
+	if err != nil {
+		return fmt.Errorf("RedeemPromotion: %w", err)
+	}
+	return ErrPromotionLimitReached
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ReleasePromotion should undo a previous RedeemPromotion for the customer, like
+// when the order using it couldn't be created
+func (i *Instance) ReleasePromotion(ctx context.Context, code, customerEmail string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := i.promotions().UpdateOne(ctx, bson.D{
+		{Key: "_id", Value: code},
+		{Key: "redemptions", Value: bson.D{{Key: "$gt", Value: 0}}},
+	}, bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "redemptions", Value: int64(-1)}}},
+	})
+	if err != nil {
+		return fmt.Errorf("ReleasePromotion: %w", err)
+	}
+	if err := i.releaseCustomerRedemption(ctx, code, customerEmail); err != nil {
+		return fmt.Errorf("ReleasePromotion: %w", err)
+	}
+	return nil
+}
+
+// releaseCustomerRedemption decrements the customer's redemptions of the
+// promotion
+func (i *Instance) releaseCustomerRedemption(ctx context.Context, code, customerEmail string) error {
+	_, err := i.promotionRedemptions().UpdateOne(ctx, bson.D{
+		{Key: "_id", Value: redemptionID(code, customerEmail)},
+		{Key: "count", Value: bson.D{{Key: "$gt", Value: 0}}},
+	}, bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "count", Value: int64(-1)}}},
+	})
+	return err
+}
+
+// redemptionID is the _id of the document counting a customer's redemptions of
+// a promotion
+func redemptionID(code, customerEmail string) string {
+	return code + "|" + NormalizeEmail(customerEmail)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// InsertIdempotencyRecord should insert the record, which is still in progress,
+// unless there's already a record with the same key in which case
+// ErrIdempotencyRecordExists should be returned. The record can be deleted after
+// expiresAt.
+func (i *Instance) InsertIdempotencyRecord(ctx context.Context, rec IdempotencyRecord, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := i.idempotencyRecords().InsertOne(ctx, bson.D{
+		{Key: "_id", Value: rec.Key},
+		{Key: "key", Value: rec.Key},
+		{Key: "requestHash", Value: rec.RequestHash},
+		{Key: "status", Value: rec.Status},
+		{Key: "createdAt", Value: rec.CreatedAt},
+		// the TTL index created in ensureSchema deletes the record after this
+		{Key: "expiresAt", Value: expiresAt},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrIdempotencyRecordExists
+	} else if err != nil {
+		return fmt.Errorf("InsertIdempotencyRecord: %w", err)
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// GetIdempotencyRecord should return the record with the given key. If there is
+// no record then the special ErrIdempotencyRecordNotFound error should be
+// returned.
+func (i *Instance) GetIdempotencyRecord(ctx context.Context, key string) (IdempotencyRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var rec IdempotencyRecord
+	err := i.idempotencyRecords().FindOne(ctx, bson.D{{Key: "_id", Value: key}}).Decode(&rec)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return IdempotencyRecord{}, ErrIdempotencyRecordNotFound
 		}
+		return IdempotencyRecord{}, fmt.Errorf("GetIdempotencyRecord: %w", err)
+	}
+	return rec, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
 
-		fmt.Printf("%d documents inserted\n", res.UpsertedCount)
+// CompleteIdempotencyRecord should save the response's status, header and body
+// on the record with the same key. If there is no record then the special
+// ErrIdempotencyRecordNotFound error should be returned.
+func (i *Instance) CompleteIdempotencyRecord(ctx context.Context, rec IdempotencyRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
-		return new_id, nil
+	res, err := i.idempotencyRecords().UpdateOne(ctx, bson.D{{Key: "_id", Value: rec.Key}}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: rec.Status},
+			{Key: "header", Value: rec.Header},
+			{Key: "body", Value: rec.Body},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("CompleteIdempotencyRecord: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrIdempotencyRecordNotFound
 	}
-	// res, err := collection.InsertOne(ctx, bson.D{{Key: "name", Value: "pi"}, {Key: "value", Value: 3.14159}})
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// DeleteIdempotencyRecord should delete the record with the given key so the
+// key can be used again. It's not an error if there is no record.
+func (i *Instance) DeleteIdempotencyRecord(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
-	// fmt.Println(res)
-	// TODO: if the order's ID field is empty, generate a random ID, then insert
-	// into the database
+	_, err := i.idempotencyRecords().DeleteOne(ctx, bson.D{{Key: "_id", Value: key}})
+	if err != nil {
+		return fmt.Errorf("DeleteIdempotencyRecord: %w", err)
+	}
+	return nil
 }
@@ -2,14 +2,16 @@ package storage
 
 import (
 	"context"
-	"crypto/rand"
 	"errors"
 	"fmt"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -23,31 +25,22 @@ func setupSuite(tb testing.TB) func(tb testing.TB) {
 
 	return func(tb testing.TB) {
 		ctx := context.TODO()
-		// defer ctx.Done()
-		// TODO: move to env var. Clean up.
-		client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI("mongodb://localhost:27017"))
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(os.Getenv("MONGO_URI")))
 		if err != nil {
 			panic(err)
 		}
-		collection := client.Database("order-up-tests").Collection("orders")
-		collection.Drop(ctx)
+		defer client.Disconnect(ctx)
+		// every test shares the database so it's dropped, along with every
+		// collection in it, before the next test runs
+		client.Database(testDatabase()).Drop(ctx)
 		fmt.Println("Tearing down")
 	}
 }
 
-func randomDatabase() string {
-	// make a backing array with length 12 and a slice with length 12 as well
-	b := make([]byte, 12)
-	// rand.Read will read up to len(b) with random bytes, in this case 12
-	_, err := rand.Read(b)
-	if err != nil {
-		// rand.Read should never error unless we run out of entropy and since this
-		// is just in tests anyways it's easier to just panic
-		panic(err)
-	}
-	// return a random database name prefixed with orders_test_ and suffixed with
-	// the hexadecimal formatting of the random bytes
-	return fmt.Sprintf("orders_test_%x", b)
+// testDatabase returns the database the tests use, which is MONGO_DATABASE_NAME
+// from .env.test
+func testDatabase() string {
+	return os.Getenv("MONGO_DATABASE_NAME")
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -57,12 +50,13 @@ func TestGetOrder(t *testing.T) {
 	defer teardownSuite(t)
 	// the context isn't meaningful for these tests so we just use a new one
 	ctx := context.Background()
-	// make a new instance with a random database so this test is isolated from
-	// the others
-	inst := New(randomDatabase())
+	// make a new instance with the test database, which the teardown drops so
+	// this test is isolated from the others
+	inst := New(testDatabase())
 	order := Order{
 		ID:            "test",
 		CustomerEmail: "test@test",
+		Currency:      DefaultCurrency,
 		LineItems: []LineItem{
 			{
 				Description: "item 1",
@@ -76,6 +70,8 @@ func TestGetOrder(t *testing.T) {
 			},
 		},
 		Status: OrderStatusCharged,
+		// every inserted order starts at version 1
+		Version: 1,
 	}
 	id, err := inst.InsertOrder(ctx, order)
 	// the require package fails the whole test immediately if this fails which is
@@ -105,12 +101,13 @@ func TestGetOrders(t *testing.T) {
 	defer teardownSuite(t)
 	// the context isn't meaningful for these tests so we just use a new one
 	ctx := context.Background()
-	// make a new instance with a random database so this test is isolated from
-	// the others
-	inst := New(randomDatabase())
+	// make a new instance with the test database, which the teardown drops so
+	// this test is isolated from the others
+	inst := New(testDatabase())
 	order1 := Order{
 		ID:            "test1",
 		CustomerEmail: "test@test",
+		Currency:      DefaultCurrency,
 		LineItems: []LineItem{
 			{
 				Description: "item 1",
@@ -123,7 +120,8 @@ func TestGetOrders(t *testing.T) {
 				PriceCents:  5000,
 			},
 		},
-		Status: OrderStatusCharged,
+		Status:  OrderStatusCharged,
+		Version: 1,
 	}
 	_, err := inst.InsertOrder(ctx, order1)
 	// the require package fails the whole test immediately if this fails which is
@@ -134,6 +132,7 @@ func TestGetOrders(t *testing.T) {
 	order2 := Order{
 		ID:            "test2",
 		CustomerEmail: "test@test",
+		Currency:      DefaultCurrency,
 		LineItems: []LineItem{
 			{
 				Description: "item 3",
@@ -146,13 +145,14 @@ func TestGetOrders(t *testing.T) {
 				PriceCents:  1000,
 			},
 		},
-		Status: OrderStatusFulfilled,
+		Status:  OrderStatusFulfilled,
+		Version: 1,
 	}
 	_, err = inst.InsertOrder(ctx, order2)
 	require.NoError(t, err)
 
 	// returns all if -1 is sent
-	got, err := inst.GetOrders(ctx, -1)
+	got, err := inst.GetOrders(ctx, OrderFilter{Status: OrderStatusAny})
 	require.NoError(t, err)
 	// assert.Equal returns true if the assertion passes so we can use that as
 	// a conditional around dependent tests so we don't end up having a bunch of
@@ -163,23 +163,126 @@ func TestGetOrders(t *testing.T) {
 	}
 
 	// only returns the matching status
-	got, err = inst.GetOrders(ctx, OrderStatusCharged)
+	got, err = inst.GetOrders(ctx, OrderFilter{Status: OrderStatusCharged})
 	require.NoError(t, err)
 	if assert.Len(t, got, 1) {
 		assert.Contains(t, got, order1)
 	}
 
 	// only returns the matching status
-	got, err = inst.GetOrders(ctx, OrderStatusFulfilled)
+	got, err = inst.GetOrders(ctx, OrderFilter{Status: OrderStatusFulfilled})
 	require.NoError(t, err)
 	if assert.Len(t, got, 1) {
 		assert.Contains(t, got, order2)
 	}
 
 	// returns none and no error if none match
-	got, err = inst.GetOrders(ctx, OrderStatusPending)
+	got, err = inst.GetOrders(ctx, OrderFilter{Status: OrderStatusPending})
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	// only returns the matching customer
+	got, err = inst.GetOrders(ctx, OrderFilter{Status: OrderStatusAny, CustomerEmail: order1.CustomerEmail})
+	require.NoError(t, err)
+	assert.Len(t, got, 2)
+	got, err = inst.GetOrders(ctx, OrderFilter{Status: OrderStatusAny, CustomerEmail: "other@test"})
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	// pages through the orders sorted by ID
+	got, err = inst.GetOrders(ctx, OrderFilter{Status: OrderStatusAny, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []Order{order1}, got)
+	got, err = inst.GetOrders(ctx, OrderFilter{Status: OrderStatusAny, Limit: 1, AfterID: order1.ID})
+	require.NoError(t, err)
+	assert.Equal(t, []Order{order2}, got)
+	got, err = inst.GetOrders(ctx, OrderFilter{Status: OrderStatusAny, Limit: 1, AfterID: order2.ID})
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestEachOrder(t *testing.T) {
+	teardownSuite := setupSuite(t)
+	defer teardownSuite(t)
+	ctx := context.Background()
+	inst := New(testDatabase())
+	// more orders than a single batch so the cursor has to get more
+	var ids []string
+	for n := 0; n < eachOrderBatchSize+5; n++ {
+		id, err := inst.InsertOrder(ctx, Order{
+			ID:            fmt.Sprintf("order%03d", n),
+			CustomerEmail: "test@test",
+			Status:        OrderStatusCharged,
+		})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	// every order is passed to fn in order
+	var got []string
+	err := inst.EachOrder(ctx, OrderFilter{Status: OrderStatusCharged}, func(order Order) error {
+		got = append(got, order.ID)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ids, got)
+
+	// the filter is applied
+	got = nil
+	err = inst.EachOrder(ctx, OrderFilter{Status: OrderStatusPending}, func(order Order) error {
+		got = append(got, order.ID)
+		return nil
+	})
 	require.NoError(t, err)
 	assert.Empty(t, got)
+
+	// errors from fn stop the iteration
+	got = nil
+	err = inst.EachOrder(ctx, OrderFilter{Status: OrderStatusAny}, func(order Order) error {
+		got = append(got, order.ID)
+		return assert.AnError
+	})
+	assert.True(t, errors.Is(err, assert.AnError), "%#v", err)
+	assert.Len(t, got, 1)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestLegacyOrderStatus(t *testing.T) {
+	teardownSuite := setupSuite(t)
+	defer teardownSuite(t)
+	ctx := context.Background()
+	inst := New(testDatabase())
+
+	// orders stored before statuses had names have integer statuses
+	_, err := inst.orders().InsertOne(ctx, bson.D{
+		{Key: "_id", Value: "legacy"},
+		{Key: "customerEmail", Value: "test@test"},
+		{Key: "lineItems", Value: bson.A{}},
+		{Key: "status", Value: int64(OrderStatusPending)},
+		{Key: "version", Value: int64(1)},
+	})
+	require.NoError(t, err)
+
+	got, err := inst.GetOrder(ctx, "legacy")
+	require.NoError(t, err)
+	assert.Equal(t, OrderStatusPending, got.Status)
+
+	// they're still found by status and can still be edited
+	orders, err := inst.GetOrders(ctx, OrderFilter{Status: OrderStatusPending})
+	require.NoError(t, err)
+	assert.Len(t, orders, 1)
+	got.Notes = "note"
+	require.NoError(t, inst.UpdateOrder(ctx, got, nil))
+
+	// and the status is stored as its name once it changes
+	require.NoError(t, inst.SetOrderStatus(ctx, "legacy", OrderStatusCharged, 2))
+	raw, err := inst.orders().FindOne(ctx, bson.D{{Key: "_id", Value: "legacy"}}).DecodeBytes()
+	require.NoError(t, err)
+	assert.Equal(t, "charged", raw.Lookup("status").StringValue())
+	orders, err = inst.GetOrders(ctx, OrderFilter{Status: OrderStatusCharged})
+	require.NoError(t, err)
+	assert.Len(t, orders, 1)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -189,9 +292,9 @@ func TestSetOrderStatus(t *testing.T) {
 	defer teardownSuite(t)
 	// the context isn't meaningful for these tests so we just use a new one
 	ctx := context.Background()
-	// make a new instance with a random database so this test is isolated from
-	// the others
-	inst := New(randomDatabase())
+	// make a new instance with the test database, which the teardown drops so
+	// this test is isolated from the others
+	inst := New(testDatabase())
 	id, err := inst.InsertOrder(ctx, Order{
 		ID:            "test1",
 		CustomerEmail: "test@test",
@@ -214,16 +317,28 @@ func TestSetOrderStatus(t *testing.T) {
 	// if we can't do this
 	require.NoError(t, err)
 
-	// returns all if -1 is sent
-	err = inst.SetOrderStatus(ctx, id, OrderStatusFulfilled)
+	// updates the status, bumps the version and records the actor and reason
+	err = inst.SetOrderStatus(WithReason(WithActor(ctx, "admin:test"), "stuck order"), id, OrderStatusFulfilled, 1)
 	require.NoError(t, err)
 
 	got, err := inst.GetOrder(ctx, id)
 	require.NoError(t, err)
 	assert.Equal(t, OrderStatusFulfilled, got.Status)
+	assert.EqualValues(t, 2, got.Version)
+	if assert.NotEmpty(t, got.History) {
+		last := got.History[len(got.History)-1]
+		assert.Equal(t, "admin:test", last.Actor)
+		assert.Equal(t, "stuck order", last.Reason)
+	}
+
+	// returns a conflict for an older version
+	err = inst.SetOrderStatus(ctx, id, OrderStatusCancelled, 1)
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, ErrVersionConflict), "%#v", err)
+	}
 
 	// returns not found
-	err = inst.SetOrderStatus(ctx, "not found", OrderStatusFulfilled)
+	err = inst.SetOrderStatus(ctx, "not found", OrderStatusFulfilled, 1)
 	// assert.Equal returns true if the assertion passes so we can use that as
 	// a conditional around dependent tests so we don't end up having a bunch of
 	// failed assertions
@@ -232,6 +347,62 @@ func TestSetOrderStatus(t *testing.T) {
 	}
 }
 
+func TestAddOrderPayment(t *testing.T) {
+	teardownSuite := setupSuite(t)
+	defer teardownSuite(t)
+	ctx := context.Background()
+	inst := New(testDatabase())
+	id, err := inst.InsertOrder(ctx, Order{
+		ID:            "test1",
+		CustomerEmail: "test@test",
+		LineItems:     []LineItem{{Description: "item 1", Quantity: 1, PriceCents: 1000}},
+		Status:        OrderStatusCharged,
+	})
+	require.NoError(t, err)
+
+	// payments are appended in order and every one increments the version
+	charge := Payment{
+		ID:     "ch_1",
+		Kind:   PaymentKindCharge,
+		Amount: Money{Amount: 1000, Currency: DefaultCurrency},
+		At:     time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC),
+		Actor:  "test",
+	}
+	refund := Payment{
+		ID:       "re_1",
+		Kind:     PaymentKindRefund,
+		ChargeID: "ch_1",
+		Amount:   Money{Amount: -1000, Currency: DefaultCurrency},
+		At:       time.Date(2022, 3, 5, 5, 6, 7, 0, time.UTC),
+	}
+	require.NoError(t, inst.AddOrderPayment(ctx, id, charge))
+	require.NoError(t, inst.AddOrderPayment(ctx, id, refund))
+
+	got, err := inst.GetOrder(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, []Payment{charge, refund}, got.Payments)
+	assert.EqualValues(t, 3, got.Version)
+
+	// returns not found
+	err = inst.AddOrderPayment(ctx, "not found", charge)
+	assert.True(t, errors.Is(err, ErrOrderNotFound), "%#v", err)
+
+	// payments are kept when an order is inserted with them, like by the
+	// importer, and more can be added after
+	id, err = inst.InsertOrder(ctx, Order{
+		ID:            "test2",
+		CustomerEmail: "test@test",
+		LineItems:     []LineItem{{Description: "item 1", Quantity: 1, PriceCents: 1000}},
+		Status:        OrderStatusCancelled,
+		Payments:      []Payment{charge},
+	})
+	require.NoError(t, err)
+	require.NoError(t, inst.AddOrderPayment(ctx, id, refund))
+	got, err = inst.GetOrder(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, []Payment{charge, refund}, got.Payments)
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 func TestInsertOrder(t *testing.T) {
@@ -239,9 +410,9 @@ func TestInsertOrder(t *testing.T) {
 	defer teardownSuite(t)
 	// the context isn't meaningful for these tests so we just use a new one
 	ctx := context.Background()
-	// make a new instance with a random database so this test is isolated from
-	// the others
-	inst := New(randomDatabase())
+	// make a new instance with the test database, which the teardown drops so
+	// this test is isolated from the others
+	inst := New(testDatabase())
 	order1 := Order{
 		ID:            "test1",
 		CustomerEmail: "test@test",
@@ -275,10 +446,34 @@ func TestInsertOrder(t *testing.T) {
 		assert.True(t, errors.Is(err, ErrOrderExists), "%#v", err)
 	}
 
+	// only one of several concurrent inserts with the same ID succeeds
+	{
+		order := order1
+		order.ID = "test-concurrent"
+		errs := make(chan error, 5)
+		for n := 0; n < cap(errs); n++ {
+			go func() {
+				_, err := inst.InsertOrder(ctx, order)
+				errs <- err
+			}()
+		}
+		var inserted int
+		for n := 0; n < cap(errs); n++ {
+			if err := <-errs; err == nil {
+				inserted++
+			} else {
+				assert.True(t, errors.Is(err, ErrOrderExists), "%#v", err)
+			}
+		}
+		assert.Equal(t, 1, inserted)
+	}
+
 	// fills in an ID
 	order2 := Order{
 		CustomerEmail: "test@test",
+		Currency:      DefaultCurrency,
 		Status:        OrderStatusCharged,
+		Version:       1,
 	}
 	id, err = inst.InsertOrder(ctx, order2)
 	require.NoError(t, err)
@@ -290,3 +485,196 @@ func TestInsertOrder(t *testing.T) {
 		assert.Equal(t, order2, got)
 	}
 }
+
+func TestInsertOrders(t *testing.T) {
+	teardownSuite := setupSuite(t)
+	defer teardownSuite(t)
+	ctx := context.Background()
+	inst := New(testDatabase())
+	existing := Order{
+		ID:            "exists",
+		CustomerEmail: "test@test",
+		Currency:      DefaultCurrency,
+		Version:       1,
+	}
+	_, err := inst.InsertOrder(ctx, existing)
+	require.NoError(t, err)
+
+	// every order is attempted and only the existing one fails
+	{
+		orders := []Order{
+			{CustomerEmail: "test@test", Currency: DefaultCurrency, Version: 1},
+			existing,
+			{ID: "new", CustomerEmail: "test@test", Currency: DefaultCurrency, Version: 1},
+		}
+		ids, errs, err := inst.InsertOrders(ctx, orders, false)
+		require.NoError(t, err)
+		require.Len(t, ids, 3)
+		require.Len(t, errs, 3)
+		assert.NoError(t, errs[0])
+		assert.True(t, errors.Is(errs[1], ErrOrderExists), "%#v", errs[1])
+		assert.NoError(t, errs[2])
+		assert.Equal(t, "new", ids[2])
+
+		orders[0].ID = ids[0]
+		got, err := inst.GetOrder(ctx, ids[0])
+		require.NoError(t, err)
+		assert.Equal(t, orders[0], got)
+	}
+
+	// nothing is inserted if any order fails
+	{
+		orders := []Order{
+			{ID: "atomic", CustomerEmail: "test@test", Currency: DefaultCurrency, Version: 1},
+			existing,
+		}
+		_, errs, err := inst.InsertOrders(ctx, orders, true)
+		// transactions only work on replica sets
+		var se mongo.ServerError
+		if errors.As(err, &se) && se.HasErrorCode(20) {
+			t.Skip("transactions aren't supported by this database")
+		}
+		require.NoError(t, err)
+		assert.True(t, errors.Is(errs[0], ErrBatchAborted), "%#v", errs[0])
+		assert.True(t, errors.Is(errs[1], ErrOrderExists), "%#v", errs[1])
+
+		_, err = inst.GetOrder(ctx, "atomic")
+		assert.True(t, errors.Is(err, ErrOrderNotFound), "%#v", err)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestUpdateOrder(t *testing.T) {
+	teardownSuite := setupSuite(t)
+	defer teardownSuite(t)
+	// the context isn't meaningful for these tests so we just use a new one
+	ctx := context.Background()
+	// make a new instance with the test database, which the teardown drops so
+	// this test is isolated from the others
+	inst := New(testDatabase())
+	order := Order{
+		ID:            "test1",
+		CustomerEmail: "test@test",
+		Currency:      DefaultCurrency,
+		LineItems: []LineItem{
+			{
+				Description: "item 1",
+				Quantity:    1,
+				PriceCents:  1000,
+			},
+		},
+		Status: OrderStatusPending,
+	}
+	_, err := inst.InsertOrder(ctx, order)
+	require.NoError(t, err)
+
+	// replaces the shipping fields and line items and bumps the version
+	order.Version = 1
+	order.ShippingAddress = &Address{Name: "name", Line1: "1 Main St", City: "city", Region: "CA", Country: "US"}
+	order.ShippingMethod = "standard"
+	order.Jurisdiction = "US-CA"
+	order.Notes = "leave it on the porch"
+	order.LineItems = append(order.LineItems, LineItem{
+		Description: "Standard",
+		Quantity:    1,
+		PriceCents:  500,
+		Kind:        LineItemKindShipping,
+	})
+	changes := []FieldChange{{Field: "notes", From: []byte(`""`), To: []byte(`"leave it on the porch"`)}}
+	err = inst.UpdateOrder(WithActor(ctx, "user:test"), order, changes)
+	require.NoError(t, err)
+
+	got, err := inst.GetOrder(ctx, order.ID)
+	require.NoError(t, err)
+	if assert.Len(t, got.History, 1) {
+		assert.Equal(t, "user:test", got.History[0].Actor)
+		assert.Equal(t, changes, got.History[0].Changes)
+	}
+	got.History = nil
+	order.Version = 2
+	assert.Equal(t, order, got)
+
+	// returns a conflict for an older version
+	order.Version = 1
+	err = inst.UpdateOrder(ctx, order, nil)
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, ErrVersionConflict), "%#v", err)
+	}
+	order.Version = 2
+
+	// returns not pending once the order is charged
+	err = inst.SetOrderStatus(ctx, order.ID, OrderStatusCharged, order.Version)
+	require.NoError(t, err)
+	err = inst.UpdateOrder(ctx, order, nil)
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, ErrOrderNotPending), "%#v", err)
+	}
+
+	// returns not found
+	order.ID = "not found"
+	err = inst.UpdateOrder(ctx, order, nil)
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, ErrOrderNotFound), "%#v", err)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestIdempotencyRecord(t *testing.T) {
+	teardownSuite := setupSuite(t)
+	defer teardownSuite(t)
+	// the context isn't meaningful for these tests so we just use a new one
+	ctx := context.Background()
+	// make a new instance with the test database, which the teardown drops so
+	// this test is isolated from the others
+	inst := New(testDatabase())
+	// mongo only stores milliseconds so the time is truncated to compare it
+	rec := IdempotencyRecord{
+		Key:         "apikey:1:key1",
+		RequestHash: "hash",
+		CreatedAt:   time.Now().UTC().Truncate(time.Millisecond),
+	}
+	expiresAt := rec.CreatedAt.Add(time.Hour)
+
+	// returns not found before it's inserted
+	_, err := inst.GetIdempotencyRecord(ctx, rec.Key)
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, ErrIdempotencyRecordNotFound), "%#v", err)
+	}
+	err = inst.CompleteIdempotencyRecord(ctx, rec)
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, ErrIdempotencyRecordNotFound), "%#v", err)
+	}
+
+	// a key can only be inserted once
+	err = inst.InsertIdempotencyRecord(ctx, rec, expiresAt)
+	require.NoError(t, err)
+	err = inst.InsertIdempotencyRecord(ctx, rec, expiresAt)
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, ErrIdempotencyRecordExists), "%#v", err)
+	}
+	got, err := inst.GetIdempotencyRecord(ctx, rec.Key)
+	require.NoError(t, err)
+	assert.Equal(t, rec, got)
+
+	// completing saves the response
+	rec.Status = 201
+	rec.Header = map[string]string{"Content-Type": "application/json"}
+	rec.Body = []byte(`{"id":"1"}`)
+	err = inst.CompleteIdempotencyRecord(ctx, rec)
+	require.NoError(t, err)
+	got, err = inst.GetIdempotencyRecord(ctx, rec.Key)
+	require.NoError(t, err)
+	assert.Equal(t, rec, got)
+
+	// deleting lets the key be used again
+	err = inst.DeleteIdempotencyRecord(ctx, rec.Key)
+	require.NoError(t, err)
+	_, err = inst.GetIdempotencyRecord(ctx, rec.Key)
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, ErrIdempotencyRecordNotFound), "%#v", err)
+	}
+	err = inst.DeleteIdempotencyRecord(ctx, rec.Key)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// HistoryEntry records a single change to an order
+type HistoryEntry struct {
+	// Status is the status the order had after the change
+	Status OrderStatus `json:"status"`
+	// At is when the change happened
+	At time.Time `json:"at"`
+	// Actor identifies the authenticated principal that made the change, it's
+	// empty if the change was made without authentication
+	Actor string `json:"actor,omitempty"`
+	// Reason explains why the change was made, it's only set for changes made by
+	// hand like with the admin command
+	Reason string `json:"reason,omitempty"`
+	// Changes lists the fields that were edited, it's empty if only the status
+	// changed
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// FieldChange records the old and new value of a single field that was edited
+type FieldChange struct {
+	// Field is the name of the field, like lineItems
+	Field string `json:"field"`
+	// From is the JSON encoding of the value before the change, it's null if the
+	// field wasn't set
+	From json.RawMessage `json:"from"`
+	// To is the JSON encoding of the value after the change, it's null if the
+	// field was removed
+	To json.RawMessage `json:"to"`
+}
+
+// actorKey is the context key for the actor. It's an unexported type so no other
+// package can collide with it.
+type actorKey struct{}
+
+// WithActor returns a copy of ctx that records actor on every history entry
+// written with it. The api package sets this to the authenticated principal.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set by WithActor or an empty string if
+// there isn't one
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// reasonKey is the context key for the reason
+type reasonKey struct{}
+
+// WithReason returns a copy of ctx that records reason on every history entry
+// written with it
+func WithReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, reasonKey{}, reason)
+}
+
+// ReasonFromContext returns the reason set by WithReason or an empty string if
+// there isn't one
+func ReasonFromContext(ctx context.Context) string {
+	reason, _ := ctx.Value(reasonKey{}).(string)
+	return reason
+}
@@ -0,0 +1,24 @@
+package storage
+
+import "time"
+
+// IdempotencyRecord is the saved response to a request that was made with an
+// Idempotency-Key header so that retrying the request returns the same
+// response instead of doing the work again
+type IdempotencyRecord struct {
+	// Key is the idempotency key scoped to whoever made the request
+	Key string `json:"key"`
+	// RequestHash identifies the request the key was first used for so that
+	// reusing the key for a different request can be rejected
+	RequestHash string `json:"requestHash"`
+	// Status is the HTTP status code of the response. It's 0 while the first
+	// request is still being handled.
+	Status int `json:"status"`
+	// Header holds the response headers that are replayed, like Content-Type
+	// and ETag
+	Header map[string]string `json:"header,omitempty"`
+	// Body is the response body
+	Body []byte `json:"body,omitempty"`
+	// CreatedAt is when the first request with the key was received
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Currency is an ISO 4217 currency code like USD
+type Currency string
+
+// DefaultCurrency is used for orders that were created before orders had a
+// currency or that didn't specify one
+const DefaultCurrency Currency = "USD"
+
+// minorUnits holds how many digits are after the decimal point for each
+// supported currency. Amounts are always stored in the smallest unit so a JPY
+// amount of 500 is ¥500 while a USD amount of 500 is $5.00.
+var minorUnits = map[Currency]int{
+	"AUD": 2,
+	"BHD": 3,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"INR": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"MXN": 2,
+	"NZD": 2,
+	"SEK": 2,
+	"USD": 2,
+}
+
+// Valid returns true if c is a supported currency
+func (c Currency) Valid() bool {
+	_, ok := minorUnits[c]
+	return ok
+}
+
+// MinorUnits returns how many digits are after the decimal point for c. It
+// returns 0 for unsupported currencies.
+func (c Currency) MinorUnits() int {
+	return minorUnits[c]
+}
+
+// OrDefault returns c or DefaultCurrency if c is empty
+func (c Currency) OrDefault() Currency {
+	if c == "" {
+		return DefaultCurrency
+	}
+	return c
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Money is an amount in a specific currency. Amount is always in the currency's
+// minor unit, like cents for USD, so that there's never any rounding.
+type Money struct {
+	Amount   int64    `json:"amount"`
+	Currency Currency `json:"currency"`
+}
+
+// String formats the money with the correct number of decimals for its
+// currency, like 12.34 USD or 1234 JPY
+func (m Money) String() string {
+	units := m.Currency.MinorUnits()
+	sign := ""
+	// formatting the absolute value as an unsigned number avoids overflowing
+	// when negating the smallest int64
+	abs := uint64(m.Amount)
+	if m.Amount < 0 {
+		sign = "-"
+		abs = -abs
+	}
+	digits := strconv.FormatUint(abs, 10)
+	if units == 0 {
+		return fmt.Sprintf("%s%s %s", sign, digits, m.Currency)
+	}
+	// pad with leading zeros so there's always at least one digit before the
+	// decimal point
+	if len(digits) <= units {
+		digits = strings.Repeat("0", units-len(digits)+1) + digits
+	}
+	split := len(digits) - units
+	return fmt.Sprintf("%s%s.%s %s", sign, digits[:split], digits[split:], m.Currency)
+}
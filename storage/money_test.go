@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money Money
+		exp   string
+	}{
+		{Money{Amount: 1234, Currency: "USD"}, "12.34 USD"},
+		{Money{Amount: 5, Currency: "USD"}, "0.05 USD"},
+		{Money{Amount: -150, Currency: "EUR"}, "-1.50 EUR"},
+		{Money{Amount: 1234, Currency: "JPY"}, "1234 JPY"},
+		{Money{Amount: 1234, Currency: "KWD"}, "1.234 KWD"},
+		{Money{Amount: 0, Currency: "GBP"}, "0.00 GBP"},
+		{Money{Amount: math.MinInt64, Currency: "JPY"}, "-9223372036854775808 JPY"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.exp, test.money.String())
+	}
+}
+
+func TestOrderTotal(t *testing.T) {
+	order := Order{
+		LineItems: []LineItem{
+			{PriceCents: 500, Quantity: 2},
+			{PriceCents: -100, Quantity: 1},
+		},
+	}
+	// orders without a currency are in the default currency
+	assert.Equal(t, Money{Amount: 900, Currency: DefaultCurrency}, order.Total())
+
+	order.Currency = "JPY"
+	assert.Equal(t, Money{Amount: 900, Currency: "JPY"}, order.Total())
+	assert.True(t, Currency("JPY").Valid())
+	assert.False(t, Currency("jpy").Valid())
+	assert.Equal(t, 0, Currency("JPY").MinorUnits())
+}
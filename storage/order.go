@@ -1,5 +1,7 @@
 package storage
 
+import "strings"
+
 // OrderStatus describes the current status of the order
 type OrderStatus int64
 
@@ -16,18 +18,85 @@ const (
 
 	// OrderStatusCancelled means the order has been forcibly cancelled.
 	OrderStatusCancelled OrderStatus = 3
+
+	// OrderStatusCharging means a request is charging the order. It's set before
+	// the charge service is called so no other request can change the order in
+	// the meantime, and then changed to charged or back to what it was before.
+	OrderStatusCharging OrderStatus = 4
+
+	// OrderStatusCancelling means a request is refunding and cancelling the
+	// order, like OrderStatusCharging
+	OrderStatusCancelling OrderStatus = 5
+
+	// OrderStatusFulfilling means a request is sending the order to the
+	// fulfillment service, like OrderStatusCharging
+	OrderStatusFulfilling OrderStatus = 6
+
+	// OrderStatusAny isn't a real status, it's used in an OrderFilter to match
+	// orders regardless of their status
+	OrderStatusAny OrderStatus = -1
 )
 
+// OrderFilter limits which orders are returned by GetOrders. Every set field
+// must match.
+type OrderFilter struct {
+	// Status limits the orders to ones with this status unless it's
+	// OrderStatusAny
+	// be careful since the zero value is OrderStatusPending
+	Status OrderStatus
+	// CustomerEmail, if set, limits the orders to ones placed by this customer
+	CustomerEmail string
+	// AfterID, if set, limits the orders to ones with an ID that sorts after it
+	// which is used to page through orders by passing the last ID of the
+	// previous page
+	AfterID string
+	// Limit, if greater than 0, is the most orders GetOrders returns
+	Limit int64
+}
+
 // LineItem is a single charge on an order. The product of the PriceCents and
 // Quantity is the total price of the line item.
 type LineItem struct {
 	// Description is a product ID or a discount ID
 	Description string `json:"description"`
 	// PriceCents is the individual price that should be multiplied against
-	// quantity. For discounts, this value might be less than 0.
+	// quantity. For discounts, this value might be less than 0. Despite the name
+	// it's in the minor unit of the order's currency which isn't always cents.
 	PriceCents int64 `json:"priceCents"`
 	// Quantity is how many descriptions this line item represents
 	Quantity int64 `json:"quantity"`
+	// Currency, if set, must match the order's currency. It's optional since a
+	// line item is always in the order's currency.
+	Currency Currency `json:"currency,omitempty"`
+	// TaxCategory decides which tax rate applies to the line item, like grocery
+	// or clothing. An empty category uses the jurisdiction's default rate.
+	TaxCategory string `json:"taxCategory,omitempty"`
+	// Kind is what the line item represents
+	Kind LineItemKind `json:"kind,omitempty"`
+}
+
+// LineItemKind is what a line item represents
+type LineItemKind string
+
+const (
+	// LineItemKindProduct is a product or a discount that the customer added.
+	// It's the zero value so line items stored before kinds existed are products.
+	LineItemKindProduct LineItemKind = ""
+	// LineItemKindTax is tax that was added when the order was created
+	LineItemKindTax LineItemKind = "tax"
+	// LineItemKindDiscount is a discount from a promotion that was added when the
+	// order was created
+	LineItemKindDiscount LineItemKind = "discount"
+	// LineItemKindShipping is the cost of the order's shipping method that was
+	// added when the order was created or its shipping method was changed
+	LineItemKindShipping LineItemKind = "shipping"
+)
+
+// NormalizeEmail returns email in the form it's stored and filtered on. Email
+// addresses are compared case-insensitively since customers don't always type
+// theirs the same way.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
 
 // Order represents a single order for one or more products
@@ -37,11 +106,43 @@ type Order struct {
 	ID string `json:"id"`
 	// CustomerEmail is the email address of the customer who placed the order
 	CustomerEmail string `json:"customerEmail"`
+	// Currency is the currency of every line item in the order. Orders created
+	// before currencies were supported are returned with DefaultCurrency.
+	Currency Currency `json:"currency"`
 	// LineItems holds the actual products, or discounts, that apply to the order
 	LineItems []LineItem `json:"lineItems"`
+	// Jurisdiction is where the order is shipped to for tax purposes, like
+	// US-CA. It's an ISO 3166-2 subdivision code or an ISO 3166-1 country code.
+	Jurisdiction string `json:"jurisdiction,omitempty"`
+	// ShippingAddress is where the order is shipped to
+	ShippingAddress *Address `json:"shippingAddress,omitempty"`
+	// BillingAddress is the address of the card the order is charged to
+	BillingAddress *Address `json:"billingAddress,omitempty"`
+	// ShippingMethod is the code of the shipping method the customer chose, its
+	// cost is a line item with LineItemKindShipping
+	ShippingMethod string `json:"shippingMethod,omitempty"`
+	// Notes are free-form instructions from the customer, like where to leave
+	// the package
+	Notes string `json:"notes,omitempty"`
+	// PromoCodes are the codes of the promotions that were redeemed for the
+	// order's discount line items
+	PromoCodes []string `json:"promoCodes,omitempty"`
+	// Tax is how the tax line items were calculated. It's nil if the order wasn't
+	// taxed.
+	Tax *TaxBreakdown `json:"tax,omitempty"`
 	// Status represents the current state of the order throughout the
 	// pending->charged->fulfilled lifecycle
 	Status OrderStatus `json:"status"`
+	// History holds every status change and edit in the order they happened
+	History []HistoryEntry `json:"history,omitempty"`
+	// Payments is the ledger of every charge and refund made for the order in the
+	// order they happened. Orders charged before the ledger existed don't have
+	// any.
+	Payments []Payment `json:"payments,omitempty"`
+	// Version starts at 1 when the order is inserted and is incremented by every
+	// update so concurrent updates can be detected. Orders stored before versions
+	// existed have version 0.
+	Version int64 `json:"version"`
 }
 
 // TotalCents is a helper function that loops over each line item and totals up
@@ -53,3 +154,11 @@ func (o Order) TotalCents() int64 {
 	}
 	return total
 }
+
+// Total returns the order's total in its currency
+func (o Order) Total() Money {
+	return Money{
+		Amount:   o.TotalCents(),
+		Currency: o.Currency.OrDefault(),
+	}
+}
@@ -0,0 +1,60 @@
+package storage
+
+import "time"
+
+// PaymentKind is whether a payment took money from the customer or gave it back
+type PaymentKind string
+
+const (
+	// PaymentKindCharge is money that was charged to the customer's card
+	PaymentKindCharge PaymentKind = "charge"
+	// PaymentKindRefund is money that was given back from an earlier charge
+	PaymentKindRefund PaymentKind = "refund"
+)
+
+// Payment is a single movement of money made by the charge service. Every
+// payment is kept on the order, in the order they were made, so each one can be
+// traced back to the charge service.
+type Payment struct {
+	// ID is the charge service's ID for the charge or refund
+	ID string `json:"id"`
+	// Kind is whether this was a charge or a refund
+	Kind PaymentKind `json:"kind"`
+	// ChargeID is the ID of the charge that a refund gave money back from, it's
+	// empty for charges
+	ChargeID string `json:"chargeID,omitempty"`
+	// Amount is positive for charges and negative for refunds
+	Amount Money `json:"amount"`
+	// At is when the charge service made the payment
+	At time.Time `json:"at"`
+	// Actor identifies the principal that made the payment, like the actor of a
+	// history entry
+	Actor string `json:"actor,omitempty"`
+}
+
+// Refundable returns how much of each charge in the order's payments hasn't
+// been refunded yet, keyed by the charge's ID. Charges that were fully refunded
+// aren't included.
+func (o Order) Refundable() map[string]Money {
+	refundable := map[string]Money{}
+	for _, p := range o.Payments {
+		if p.Kind == PaymentKindCharge {
+			refundable[p.ID] = p.Amount
+		}
+	}
+	for _, p := range o.Payments {
+		if p.Kind != PaymentKindRefund {
+			continue
+		}
+		if m, ok := refundable[p.ChargeID]; ok {
+			m.Amount += p.Amount.Amount
+			refundable[p.ChargeID] = m
+		}
+	}
+	for id, m := range refundable {
+		if m.Amount <= 0 {
+			delete(refundable, id)
+		}
+	}
+	return refundable
+}
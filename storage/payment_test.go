@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefundable(t *testing.T) {
+	eur := func(amount int64) Money { return Money{Amount: amount, Currency: "EUR"} }
+	order := Order{Payments: []Payment{
+		{ID: "ch_1", Kind: PaymentKindCharge, Amount: eur(1000)},
+		{ID: "ch_2", Kind: PaymentKindCharge, Amount: eur(500)},
+		{ID: "re_1", Kind: PaymentKindRefund, ChargeID: "ch_1", Amount: eur(-300)},
+		{ID: "re_2", Kind: PaymentKindRefund, ChargeID: "ch_2", Amount: eur(-500)},
+	}}
+	// what's left of partially refunded charges is refundable and fully
+	// refunded charges aren't
+	assert.Equal(t, map[string]Money{"ch_1": eur(700)}, order.Refundable())
+
+	// there's nothing to refund without any payments
+	assert.Empty(t, Order{}.Refundable())
+}
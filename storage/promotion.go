@@ -0,0 +1,51 @@
+package storage
+
+import "time"
+
+// PromotionKind is how a promotion discounts an order
+type PromotionKind string
+
+const (
+	// PromotionKindPercentage takes PercentOff percent off of the order's products
+	PromotionKindPercentage PromotionKind = "percentage"
+	// PromotionKindFixed takes AmountOffCents off of the order's products
+	PromotionKindFixed PromotionKind = "fixed"
+)
+
+// Promotion is a discount code that customers can use when placing an order
+type Promotion struct {
+	// Code is what customers enter to use the promotion and uniquely identifies
+	// it. Codes are always upper case.
+	Code string `json:"code"`
+	// Kind decides whether PercentOff or AmountOffCents applies
+	Kind PromotionKind `json:"kind"`
+	// PercentOff is the percentage taken off for PromotionKindPercentage
+	PercentOff int64 `json:"percentOff,omitempty"`
+	// AmountOffCents is the amount taken off for PromotionKindFixed in the minor
+	// unit of Currency
+	AmountOffCents int64 `json:"amountOffCents,omitempty"`
+	// Currency is the currency of AmountOffCents and MinOrderCents. Orders in any
+	// other currency can't use the promotion unless it's a percentage without a
+	// minimum.
+	Currency Currency `json:"currency,omitempty"`
+	// ExpiresAt, if set, is when the promotion can no longer be used
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// MaxRedemptions, if not 0, is how many times the promotion can be used in
+	// total
+	MaxRedemptions int64 `json:"maxRedemptions,omitempty"`
+	// MaxRedemptionsPerCustomer, if not 0, is how many times each customer can
+	// use the promotion
+	MaxRedemptionsPerCustomer int64 `json:"maxRedemptionsPerCustomer,omitempty"`
+	// MinOrderCents is the smallest total of the order's products, before tax and
+	// discounts, that the promotion can be used for
+	MinOrderCents int64 `json:"minOrderCents,omitempty"`
+	// Redemptions is how many times the promotion has been used
+	Redemptions int64 `json:"redemptions"`
+	// CreatedAt is when the promotion was created
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Expired returns true if the promotion can no longer be used at now
+func (p Promotion) Expired(now time.Time) bool {
+	return p.ExpiresAt != nil && !now.Before(*p.ExpiresAt)
+}
@@ -0,0 +1,18 @@
+package storage
+
+import "time"
+
+// RateLimitBucket is the persisted state of a token bucket that's shared by
+// every replica
+type RateLimitBucket struct {
+	// Key identifies the bucket, like the route group and the client
+	Key string `json:"key"`
+	// Tokens is how many tokens were left in the bucket at UpdatedAt
+	Tokens float64 `json:"tokens"`
+	// UpdatedAt is when tokens were last taken from the bucket
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Version is incremented on every write so concurrent writers can detect
+	// that the bucket changed underneath them. A bucket that was never stored has
+	// a version of 0.
+	Version int64 `json:"version"`
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// orderStatusNames are the names statuses are encoded as. OrderStatusAny isn't
+// here since it's never stored or sent.
+var orderStatusNames = map[OrderStatus]string{
+	OrderStatusPending:    "pending",
+	OrderStatusCharged:    "charged",
+	OrderStatusFulfilled:  "fulfilled",
+	OrderStatusCancelled:  "cancelled",
+	OrderStatusCharging:   "charging",
+	OrderStatusCancelling: "cancelling",
+	OrderStatusFulfilling: "fulfilling",
+}
+
+// InProgress returns true for the statuses that mean a request is in the middle
+// of charging, cancelling or fulfilling the order. An order that stays in one
+// means the request failed before it could finish and has to be fixed by hand.
+func (s OrderStatus) InProgress() bool {
+	switch s {
+	case OrderStatusCharging, OrderStatusCancelling, OrderStatusFulfilling:
+		return true
+	default:
+		return false
+	}
+}
+
+// String implements the fmt.Stringer interface and returns the status's name,
+// like charged
+func (s OrderStatus) String() string {
+	if name, ok := orderStatusNames[s]; ok {
+		return name
+	}
+	if s == OrderStatusAny {
+		return "any"
+	}
+	return "OrderStatus(" + strconv.FormatInt(int64(s), 10) + ")"
+}
+
+// ParseOrderStatus returns the status with the given name, like charged. It
+// doesn't accept any, callers that filter by status have to handle that
+// themselves.
+func ParseOrderStatus(name string) (OrderStatus, error) {
+	for status, n := range orderStatusNames {
+		if n == name {
+			return status, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown order status: %q", name)
+}
+
+// known returns true if the status has a name. Unknown statuses, which should
+// only exist if the database was edited by hand, are encoded as integers so
+// they aren't lost and can still be found by admin.Reconcile.
+func (s OrderStatus) known() bool {
+	_, ok := orderStatusNames[s]
+	return ok
+}
+
+// MarshalJSON implements the json.Marshaler interface. The status is encoded as
+// its name, the api package converts it back to an integer for v1 responses.
+func (s OrderStatus) MarshalJSON() ([]byte, error) {
+	if !s.known() {
+		return json.Marshal(int64(s))
+	}
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. It accepts the
+// status's name or, like before statuses had names, its integer.
+func (s *OrderStatus) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		return s.setName(name)
+	}
+	var i int64
+	if err := json.Unmarshal(b, &i); err != nil {
+		return fmt.Errorf("order status must be a name or an integer: %s", b)
+	}
+	*s = OrderStatus(i)
+	return nil
+}
+
+// MarshalBSONValue implements the bson.ValueMarshaler interface. New and updated
+// orders store the status's name.
+func (s OrderStatus) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if !s.known() {
+		return bsontype.Int64, bsoncore.AppendInt64(nil, int64(s)), nil
+	}
+	return bsontype.String, bsoncore.AppendString(nil, s.String()), nil
+}
+
+// UnmarshalBSONValue implements the bson.ValueUnmarshaler interface. Orders
+// stored before statuses had names have integers, which are still accepted.
+func (s *OrderStatus) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	v := bson.RawValue{Type: t, Value: data}
+	if name, ok := v.StringValueOK(); ok {
+		return s.setName(name)
+	}
+	if i, ok := v.Int64OK(); ok {
+		*s = OrderStatus(i)
+		return nil
+	}
+	if i, ok := v.Int32OK(); ok {
+		*s = OrderStatus(i)
+		return nil
+	}
+	return fmt.Errorf("cannot decode %v into an order status", t)
+}
+
+// statusFilter matches the given status whether it was stored as its name or,
+// before statuses had names, as an integer
+func statusFilter(status OrderStatus) bson.D {
+	return bson.D{{Key: "$in", Value: bson.A{status, int64(status)}}}
+}
+
+// Value implements the driver.Valuer interface so the status is stored as its
+// name in SQL databases too
+func (s OrderStatus) Value() (driver.Value, error) {
+	if !s.known() {
+		return int64(s), nil
+	}
+	return s.String(), nil
+}
+
+// Scan implements the sql.Scanner interface. It accepts the status's name or
+// its integer.
+func (s *OrderStatus) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case string:
+		return s.setName(src)
+	case []byte:
+		return s.setName(string(src))
+	case int64:
+		*s = OrderStatus(src)
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into an order status", src)
+	}
+}
+
+// setName sets s to the status with the given name
+func (s *OrderStatus) setName(name string) error {
+	status, err := ParseOrderStatus(name)
+	if err != nil {
+		return err
+	}
+	*s = status
+	return nil
+}
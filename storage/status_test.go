@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestOrderStatusString(t *testing.T) {
+	assert.Equal(t, "pending", OrderStatusPending.String())
+	assert.Equal(t, "cancelled", OrderStatusCancelled.String())
+	assert.Equal(t, "any", OrderStatusAny.String())
+	assert.Equal(t, "OrderStatus(7)", OrderStatus(7).String())
+
+	status, err := ParseOrderStatus("fulfilled")
+	require.NoError(t, err)
+	assert.Equal(t, OrderStatusFulfilled, status)
+	_, err = ParseOrderStatus("any")
+	assert.Error(t, err)
+}
+
+func TestOrderStatusInProgress(t *testing.T) {
+	for _, status := range []OrderStatus{OrderStatusCharging, OrderStatusCancelling, OrderStatusFulfilling} {
+		assert.True(t, status.InProgress(), status.String())
+	}
+	for _, status := range []OrderStatus{OrderStatusPending, OrderStatusCharged, OrderStatusFulfilled, OrderStatusCancelled, OrderStatusAny} {
+		assert.False(t, status.InProgress(), status.String())
+	}
+}
+
+func TestOrderStatusJSON(t *testing.T) {
+	b, err := json.Marshal(HistoryEntry{Status: OrderStatusCharged})
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"status":"charged"`)
+	// unknown statuses aren't lost
+	b, err = json.Marshal(OrderStatus(7))
+	require.NoError(t, err)
+	assert.Equal(t, `7`, string(b))
+
+	// names and the legacy integers are both accepted
+	tests := []struct {
+		in  string
+		exp OrderStatus
+	}{
+		{`"pending"`, OrderStatusPending},
+		{`"cancelled"`, OrderStatusCancelled},
+		{`1`, OrderStatusCharged},
+		{`7`, OrderStatus(7)},
+	}
+	for _, test := range tests {
+		var status OrderStatus
+		require.NoError(t, json.Unmarshal([]byte(test.in), &status), test.in)
+		assert.Equal(t, test.exp, status, test.in)
+	}
+	for _, in := range []string{`"unknown"`, `true`, `1.5`} {
+		var status OrderStatus
+		assert.Error(t, json.Unmarshal([]byte(in), &status), in)
+	}
+}
+
+func TestOrderStatusBSON(t *testing.T) {
+	b, err := bson.Marshal(bson.D{{Key: "status", Value: OrderStatusFulfilled}})
+	require.NoError(t, err)
+	assert.Equal(t, "fulfilled", bson.Raw(b).Lookup("status").StringValue())
+
+	// documents stored before statuses had names have integers
+	for _, v := range []interface{}{"charged", int64(1), int32(1)} {
+		b, err := bson.Marshal(bson.D{{Key: "status", Value: v}})
+		require.NoError(t, err)
+		var doc struct {
+			Status OrderStatus `bson:"status"`
+		}
+		require.NoError(t, bson.Unmarshal(b, &doc), "%v", v)
+		assert.Equal(t, OrderStatusCharged, doc.Status, "%v", v)
+	}
+
+	// the filter matches either
+	b, err = bson.Marshal(bson.D{{Key: "status", Value: statusFilter(OrderStatusCharged)}})
+	require.NoError(t, err)
+	in := bson.Raw(b).Lookup("status", "$in").Array()
+	assert.Equal(t, "charged", in.Index(0).Value().StringValue())
+	assert.Equal(t, int64(1), in.Index(1).Value().Int64())
+}
+
+func TestOrderStatusSQL(t *testing.T) {
+	v, err := OrderStatusCharged.Value()
+	require.NoError(t, err)
+	assert.Equal(t, "charged", v)
+
+	for _, src := range []interface{}{"charged", []byte("charged"), int64(1)} {
+		var status OrderStatus
+		require.NoError(t, status.Scan(src), "%v", src)
+		assert.Equal(t, OrderStatusCharged, status)
+	}
+	var status OrderStatus
+	assert.Error(t, status.Scan(nil))
+	assert.Error(t, status.Scan("unknown"))
+}
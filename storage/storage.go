@@ -4,9 +4,15 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/levenlabs/go-llog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Instance holds a database connection for use in the storage methods
@@ -17,46 +23,153 @@ type Instance struct {
 	// you should use this as the database name for all of your methods to simplify
 	// testing
 	database string
-	// this is where you'd store any database connections like a *mongo.Client or
-	// *sql.DB
+	// client is the connection pool shared by all of the storage methods, it's
+	// opened once in Open and released in Close
+	client *mongo.Client
+
+	// schemaReady is set to 1 once ensureSchema has succeeded so Ping can report
+	// whether the instance is actually usable
+	// it's an int32 rather than a bool so it can be read with the atomic package
+	schemaReady int32
 }
 
+// New calls Open with a 15 second timeout and fatals if it fails. It's meant for
+// tests and tools where there's nothing sensible to do on failure other than
+// exit.
 func New(overrideDatabase string) *Instance {
+	// give Open only 15 seconds to complete
+	// after 15 seconds the context will return DeadlineExceeded errors which should
+	// cause any functions downstream to error out
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	// if we don't call cancel then the ctx will leak so we make sure that cancel
+	// is called no matter what when we're done
+	defer cancel()
+	inst, err := Open(ctx, overrideDatabase)
+	if err != nil {
+		llog.Fatal("failed to open storage", llog.ErrKV(err))
+	}
+	return inst
+}
+
+// Open connects to the database pointed to by the MONGO_URI environment variable
+// and ensures the schema is set up. Every collection is in the overrideDatabase
+// database or, if that's empty, the one named by MONGO_DATABASE_NAME. The returned Instance should be closed with
+// Close once it's no longer needed.
+func Open(ctx context.Context, overrideDatabase string) (*Instance, error) {
 	// create a pointer to an Instance that we will return after initialization
 	inst := &Instance{}
 	// if they sent overrideDatabase then use that, like for tests, otherwise
-	// fallback to a static name for production, staging, etc
-	// you might have some environmental variables or flags instead to do this but
-	// for this project this is sufficient
-	if overrideDatabase != "" {
+	// fallback to MONGO_DATABASE_NAME and then a static name for production,
+	// staging, etc
+	switch {
+	case overrideDatabase != "":
 		inst.database = overrideDatabase
-	} else {
+	case os.Getenv("MONGO_DATABASE_NAME") != "":
+		inst.database = os.Getenv("MONGO_DATABASE_NAME")
+	default:
 		inst.database = "order_up"
 	}
 
-	// TODO: code for connecting to the database and storing the connected driver
-	// instance on inst
+	// Connect doesn't actually talk to the database, it only validates the URI
+	// and starts the background connection pool so failures to reach the
+	// database show up in ensureSchema or Ping instead
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(os.Getenv("MONGO_URI")))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database: %w", err)
+	}
+	inst.client = client
 
-	// give the ensureSchema function only 15 seconds to complete
-	// after 15 seconds the context will return DeadlineExceeded errors which should
-	// cause any functions downstream to error out
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	// if we don't call cancel then the ctx will leak so we make sure that cancel
-	// is called no matter what when we're done
-	defer cancel()
 	// we want to make sure the database is ready to accept requests and if that
-	// fails we need to fatal
+	// fails the caller shouldn't use the instance
 	if err := inst.ensureSchema(ctx); err != nil {
-		llog.Fatal("failed to ensure schema", llog.ErrKV(err))
+		// we're already failing so there's nothing useful to do with this error
+		_ = client.Disconnect(ctx)
+		return nil, fmt.Errorf("error ensuring schema: %w", err)
 	}
-	return inst
+	atomic.StoreInt32(&inst.schemaReady, 1)
+	return inst, nil
+}
+
+// Close releases the database connections. The Instance can't be used after
+// Close has been called.
+func (i *Instance) Close(ctx context.Context) error {
+	// Ping should start failing as soon as we begin closing
+	atomic.StoreInt32(&i.schemaReady, 0)
+	return i.client.Disconnect(ctx)
+}
+
+// orders returns the collection holding all of the orders
+func (i *Instance) orders() *mongo.Collection {
+	return i.client.Database(i.database).Collection("orders")
+}
+
+// promotions returns the collection holding all of the promotions
+func (i *Instance) promotions() *mongo.Collection {
+	return i.client.Database(i.database).Collection("promotions")
+}
+
+// promotionRedemptions returns the collection counting how many times each
+// customer redeemed each promotion
+func (i *Instance) promotionRedemptions() *mongo.Collection {
+	return i.client.Database(i.database).Collection("promotion_redemptions")
+}
+
+// rateLimits returns the collection holding the shared rate limit buckets
+func (i *Instance) rateLimits() *mongo.Collection {
+	return i.client.Database(i.database).Collection("rate_limits")
+}
+
+// idempotencyRecords returns the collection holding the saved responses for
+// requests made with an Idempotency-Key header
+func (i *Instance) idempotencyRecords() *mongo.Collection {
+	return i.client.Database(i.database).Collection("idempotency_records")
+}
+
+// apiKeys returns the collection holding all of the API keys
+func (i *Instance) apiKeys() *mongo.Collection {
+	return i.client.Database(i.database).Collection("api_keys")
 }
 
 func (i *Instance) ensureSchema(ctx context.Context) error {
-	// TODO: this is where you'll do any schema setup (CREATE DATABASE or CREATE
-	// TABLE), if necessary, and since this will be called every time the service
-	// starts or every time you run tests, it should not fail if the schema is
-	// already setup
-	// for example you might need to add a unique index on the order's ID field ;)
+	// this will be called every time the service starts or every time you run
+	// tests so it should not fail if the schema is already setup
+	// CreateOne is a no-op if an identical index already exists
+
+	// API keys are looked up by their hash on every authenticated request and two
+	// keys can never share a hash
+	_, err := i.apiKeys().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("error creating api key hash index: %w", err)
+	}
+
+	// customers list their own orders so GetOrders filters on the email
+	_, err = i.orders().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "customerEmail", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating order customer index: %w", err)
+	}
+
+	// rate limit buckets are deleted by the database once they would be full
+	// again since a full bucket is the same as a missing one
+	_, err = i.rateLimits().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return fmt.Errorf("error creating rate limit expiry index: %w", err)
+	}
+
+	// idempotency records are only kept long enough for clients to retry
+	_, err = i.idempotencyRecords().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return fmt.Errorf("error creating idempotency record expiry index: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,25 @@
+package storage
+
+// TaxBreakdown explains how the tax on an order was calculated. The tax itself
+// is charged through line items with LineItemKindTax so it's included in
+// TotalCents.
+type TaxBreakdown struct {
+	// Jurisdiction is the order's jurisdiction when the tax was calculated
+	Jurisdiction string `json:"jurisdiction"`
+	// Lines has the tax for each tax category in the order
+	Lines []TaxLine `json:"lines"`
+	// TotalCents is the sum of the tax on every line
+	TotalCents int64 `json:"totalCents"`
+}
+
+// TaxLine is the tax for a single tax category
+type TaxLine struct {
+	// Category is the tax category of the line items that were taxed
+	Category string `json:"category"`
+	// RatePPM is the tax rate in parts per million so 7.25% is 72500
+	RatePPM int64 `json:"ratePPM"`
+	// TaxableCents is the total of the line items in the category
+	TaxableCents int64 `json:"taxableCents"`
+	// TaxCents is the tax after rounding
+	TaxCents int64 `json:"taxCents"`
+}
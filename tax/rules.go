@@ -0,0 +1,113 @@
+package tax
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/levenlabs/order-up/storage"
+)
+
+// Jurisdiction holds the tax rules for a single jurisdiction
+type Jurisdiction struct {
+	// Rates maps a tax category to its rate. The rate for the empty category is
+	// the default for line items without a category or with a category that's
+	// not listed. Line items that don't match any rate aren't taxed.
+	Rates map[string]Rate `json:"rates"`
+	// Rounding decides how fractions of a minor unit are rounded
+	Rounding Rounding `json:"rounding,omitempty"`
+	// PerLineItem rounds the tax on each line item separately instead of once for
+	// each category, which some jurisdictions require
+	PerLineItem bool `json:"perLineItem,omitempty"`
+}
+
+// rate returns the rate for category and false if there's no rate
+func (j Jurisdiction) rate(category string) (Rate, bool) {
+	if r, ok := j.Rates[category]; ok {
+		return r, true
+	}
+	r, ok := j.Rates[""]
+	return r, ok
+}
+
+// RuleTable is a Calculator that uses a fixed table of rates for each
+// jurisdiction
+type RuleTable map[string]Jurisdiction
+
+// LoadRuleTable decodes a RuleTable from JSON like
+//
+//	{"US-CA": {"rates": {"": "7.25", "grocery": "0"}, "rounding": "half_up"}}
+func LoadRuleTable(r io.Reader) (RuleTable, error) {
+	var table RuleTable
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&table); err != nil {
+		return nil, fmt.Errorf("error decoding tax rules: %w", err)
+	}
+	for name, j := range table {
+		if !j.Rounding.Valid() {
+			return nil, fmt.Errorf("unknown rounding %q for jurisdiction %s", j.Rounding, name)
+		}
+	}
+	return table, nil
+}
+
+// Calculate implements the Calculator interface. Line items are grouped by
+// their tax category and the tax for each category is rounded once unless the
+// jurisdiction rounds per line item. Discounts reduce the taxable amount of
+// their category but never below zero.
+func (t RuleTable) Calculate(ctx context.Context, order storage.Order) (storage.TaxBreakdown, error) {
+	j, ok := t[order.Jurisdiction]
+	if !ok {
+		return storage.TaxBreakdown{}, fmt.Errorf("%w: %s", ErrUnknownJurisdiction, order.Jurisdiction)
+	}
+
+	lines := map[string]*storage.TaxLine{}
+	for _, li := range order.LineItems {
+		// discounts from promotions reduce the taxable amount just like discounts
+		// added by the customer
+		if li.Kind == storage.LineItemKindTax {
+			continue
+		}
+		rate, ok := j.rate(li.TaxCategory)
+		if !ok {
+			continue
+		}
+		line, ok := lines[li.TaxCategory]
+		if !ok {
+			line = &storage.TaxLine{Category: li.TaxCategory, RatePPM: int64(rate)}
+			lines[li.TaxCategory] = line
+		}
+		amount := li.PriceCents * li.Quantity
+		line.TaxableCents += amount
+		if j.PerLineItem {
+			line.TaxCents += j.Rounding.apply(amount, rate)
+		}
+	}
+
+	breakdown := storage.TaxBreakdown{
+		Jurisdiction: order.Jurisdiction,
+		Lines:        make([]storage.TaxLine, 0, len(lines)),
+	}
+	for _, line := range lines {
+		// a category that's been discounted below zero isn't refunded tax
+		if line.TaxableCents < 0 {
+			line.TaxableCents = 0
+		}
+		if !j.PerLineItem {
+			line.TaxCents = j.Rounding.apply(line.TaxableCents, Rate(line.RatePPM))
+		}
+		if line.TaxCents < 0 {
+			line.TaxCents = 0
+		}
+		breakdown.Lines = append(breakdown.Lines, *line)
+		breakdown.TotalCents += line.TaxCents
+	}
+	// map iteration is random so sort the lines to keep the breakdown stable
+	sort.Slice(breakdown.Lines, func(a, b int) bool {
+		return breakdown.Lines[a].Category < breakdown.Lines[b].Category
+	})
+	return breakdown, nil
+}
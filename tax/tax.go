@@ -0,0 +1,185 @@
+// Package tax calculates the tax on orders. The Calculator interface lets the
+// calculation be replaced, like with a third-party tax service, while RuleTable
+// is a built-in implementation based on a fixed table of rates.
+package tax
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/levenlabs/order-up/storage"
+)
+
+// ErrUnknownJurisdiction is returned by a Calculator when it doesn't know how to
+// tax orders in the order's jurisdiction
+var ErrUnknownJurisdiction = errors.New("unknown tax jurisdiction")
+
+// Calculator calculates the tax on an order
+type Calculator interface {
+	// Calculate returns the tax for the order's product and discount line items.
+	// Any tax line items already on the order should be ignored. If the order's jurisdiction
+	// isn't supported then ErrUnknownJurisdiction should be returned.
+	Calculate(ctx context.Context, order storage.Order) (storage.TaxBreakdown, error)
+}
+
+// Apply calculates the tax on order with calc and adds a tax line item for
+// every tax category that has tax along with the breakdown. Orders without a
+// jurisdiction aren't taxed.
+func Apply(ctx context.Context, calc Calculator, order *storage.Order) error {
+	if order.Jurisdiction == "" {
+		return nil
+	}
+	breakdown, err := calc.Calculate(ctx, *order)
+	if err != nil {
+		return err
+	}
+
+	// drop any tax line items from an earlier calculation so they're not counted
+	// twice
+	lineItems := make([]storage.LineItem, 0, len(order.LineItems)+len(breakdown.Lines))
+	for _, li := range order.LineItems {
+		if li.Kind != storage.LineItemKindTax {
+			lineItems = append(lineItems, li)
+		}
+	}
+	for _, line := range breakdown.Lines {
+		if line.TaxCents == 0 {
+			continue
+		}
+		lineItems = append(lineItems, storage.LineItem{
+			Description: description(breakdown.Jurisdiction, line),
+			PriceCents:  line.TaxCents,
+			Quantity:    1,
+			TaxCategory: line.Category,
+			Kind:        storage.LineItemKindTax,
+		})
+	}
+	order.LineItems = lineItems
+	order.Tax = &breakdown
+	return nil
+}
+
+// description returns the description for a tax line item, like
+// "Tax US-CA clothing 7.25%"
+func description(jurisdiction string, line storage.TaxLine) string {
+	parts := []string{"Tax", jurisdiction}
+	if line.Category != "" {
+		parts = append(parts, line.Category)
+	}
+	parts = append(parts, Rate(line.RatePPM).String())
+	return strings.Join(parts, " ")
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Rate is a tax rate in parts per million so that rates like 8.875% can be
+// represented exactly
+type Rate int64
+
+// ParseRate parses a percentage like "7.25" or "7.25%" into a Rate
+func ParseRate(s string) (Rate, error) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "%")
+	// big.Rat parses decimals exactly unlike float64
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("invalid tax rate %q", s)
+	}
+	// percent to parts per million
+	r.Mul(r, big.NewRat(10000, 1))
+	if !r.IsInt() {
+		return 0, fmt.Errorf("tax rate %q has too many decimals", s)
+	}
+	if r.Sign() < 0 || r.Num().Cmp(big.NewInt(1000000)) > 0 {
+		return 0, fmt.Errorf("tax rate %q must be between 0 and 100", s)
+	}
+	return Rate(r.Num().Int64()), nil
+}
+
+// String formats the rate as a percentage like 7.25%
+func (r Rate) String() string {
+	s := new(big.Rat).SetFrac64(int64(r), 10000).FloatString(4)
+	// drop the trailing zeros and the decimal point if nothing is left after it
+	s = strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+	return s + "%"
+}
+
+// UnmarshalJSON parses a rate from a string percentage like "7.25"
+func (r *Rate) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("tax rate must be a string like \"7.25\": %w", err)
+	}
+	parsed, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Rounding decides how fractions of a minor unit are rounded
+type Rounding string
+
+const (
+	// RoundHalfUp rounds half a minor unit away from zero which is the most
+	// common rule. It's used if no rounding is specified.
+	RoundHalfUp Rounding = "half_up"
+	// RoundHalfEven rounds half a minor unit to the nearest even number which is
+	// also known as banker's rounding
+	RoundHalfEven Rounding = "half_even"
+	// RoundUp always rounds any fraction away from zero
+	RoundUp Rounding = "up"
+	// RoundDown always truncates any fraction
+	RoundDown Rounding = "down"
+)
+
+// Valid returns true if r is a known rounding rule or empty
+func (r Rounding) Valid() bool {
+	switch r {
+	case "", RoundHalfUp, RoundHalfEven, RoundUp, RoundDown:
+		return true
+	}
+	return false
+}
+
+// apply returns amount * rate rounded to a whole minor unit. The math is done
+// with big.Int since the intermediate value can overflow an int64.
+func (r Rounding) apply(amount int64, rate Rate) int64 {
+	num := new(big.Int).Mul(big.NewInt(amount), big.NewInt(int64(rate)))
+	den := big.NewInt(1000000)
+	// QuoRem truncates towards zero
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Sign() == 0 {
+		return quo.Int64()
+	}
+
+	// away is 1 or -1 depending on which direction is away from zero
+	away := int64(num.Sign())
+	// compare twice the remainder with the denominator to see if the fraction is
+	// below, at or above a half
+	half := new(big.Int).Abs(rem)
+	half.Mul(half, big.NewInt(2))
+	cmp := half.Cmp(den)
+
+	var roundAway bool
+	switch r {
+	case RoundDown:
+		roundAway = false
+	case RoundUp:
+		roundAway = true
+	case RoundHalfEven:
+		roundAway = cmp > 0 || (cmp == 0 && quo.Bit(0) == 1)
+	default:
+		roundAway = cmp >= 0
+	}
+	if roundAway {
+		quo.Add(quo, big.NewInt(away))
+	}
+	return quo.Int64()
+}
@@ -0,0 +1,168 @@
+package tax
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in  string
+		exp Rate
+		str string
+	}{
+		{"7.25", 72500, "7.25%"},
+		{"8.875%", 88750, "8.875%"},
+		{"0", 0, "0%"},
+		{"20", 200000, "20%"},
+		{"100", 1000000, "100%"},
+	}
+	for _, test := range tests {
+		r, err := ParseRate(test.in)
+		require.NoError(t, err, test.in)
+		assert.Equal(t, test.exp, r, test.in)
+		assert.Equal(t, test.str, r.String(), test.in)
+	}
+
+	for _, in := range []string{"", "abc", "-1", "101", "1.23456"} {
+		_, err := ParseRate(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestRounding(t *testing.T) {
+	// 10% of 5 and 15 is exactly half a minor unit and 10% of 14 is below a half
+	tests := []struct {
+		rounding Rounding
+		amount   int64
+		exp      int64
+	}{
+		{RoundHalfUp, 5, 1},
+		{RoundHalfUp, 15, 2},
+		{RoundHalfUp, 14, 1},
+		{RoundHalfUp, -5, -1},
+		{RoundHalfEven, 5, 0},
+		{RoundHalfEven, 15, 2},
+		{RoundHalfEven, 16, 2},
+		{RoundUp, 11, 2},
+		{RoundUp, -11, -2},
+		{RoundDown, 19, 1},
+		{RoundDown, 20, 2},
+		{"", 5, 1},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.exp, test.rounding.apply(test.amount, 100000), "%s %d", test.rounding, test.amount)
+	}
+
+	// large amounts don't overflow while multiplying
+	assert.Equal(t, int64(4611686018427387904), RoundHalfUp.apply(1<<62, 1000000))
+}
+
+func TestRuleTable(t *testing.T) {
+	ctx := context.Background()
+	table, err := LoadRuleTable(strings.NewReader(`{
+		"US-CA": {"rates": {"": "7.25", "grocery": "0"}},
+		"US-NY": {"rates": {"clothing": "4"}, "rounding": "down", "perLineItem": true}
+	}`))
+	require.NoError(t, err)
+
+	// categories are taxed separately and discounts reduce their category
+	{
+		breakdown, err := table.Calculate(ctx, storage.Order{
+			Jurisdiction: "US-CA",
+			LineItems: []storage.LineItem{
+				{Description: "laptop", PriceCents: 100000, Quantity: 1},
+				{Description: "discount", PriceCents: -10001, Quantity: 1},
+				{Description: "apples", PriceCents: 300, Quantity: 5, TaxCategory: "grocery"},
+				// unknown categories use the default rate
+				{Description: "sponge", PriceCents: 500, Quantity: 1, TaxCategory: "household"},
+				// existing tax is ignored
+				{Description: "old tax", PriceCents: 999, Quantity: 1, Kind: storage.LineItemKindTax},
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, storage.TaxBreakdown{
+			Jurisdiction: "US-CA",
+			Lines: []storage.TaxLine{
+				{Category: "", RatePPM: 72500, TaxableCents: 89999, TaxCents: 6525},
+				{Category: "grocery", RatePPM: 0, TaxableCents: 1500, TaxCents: 0},
+				{Category: "household", RatePPM: 72500, TaxableCents: 500, TaxCents: 36},
+			},
+			TotalCents: 6561,
+		}, breakdown)
+	}
+
+	// per line item rounding and untaxed categories
+	{
+		breakdown, err := table.Calculate(ctx, storage.Order{
+			Jurisdiction: "US-NY",
+			LineItems: []storage.LineItem{
+				{Description: "shirt", PriceCents: 1999, Quantity: 1, TaxCategory: "clothing"},
+				{Description: "socks", PriceCents: 499, Quantity: 1, TaxCategory: "clothing"},
+				{Description: "bread", PriceCents: 300, Quantity: 1, TaxCategory: "grocery"},
+			},
+		})
+		require.NoError(t, err)
+		// 79.96 and 19.96 are each rounded down rather than 99.92 once
+		assert.Equal(t, storage.TaxBreakdown{
+			Jurisdiction: "US-NY",
+			Lines: []storage.TaxLine{
+				{Category: "clothing", RatePPM: 40000, TaxableCents: 2498, TaxCents: 98},
+			},
+			TotalCents: 98,
+		}, breakdown)
+	}
+
+	// unknown jurisdictions are an error
+	{
+		_, err := table.Calculate(ctx, storage.Order{Jurisdiction: "US-TX"})
+		assert.True(t, errors.Is(err, ErrUnknownJurisdiction))
+	}
+
+	// invalid tables are rejected
+	{
+		_, err := LoadRuleTable(strings.NewReader(`{"US-CA": {"rates": {"": "7.25"}, "rounding": "sideways"}}`))
+		assert.Error(t, err)
+		_, err = LoadRuleTable(strings.NewReader(`{"US-CA": {"rates": {"": 7.25}}}`))
+		assert.Error(t, err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	table := RuleTable{"US-CA": {Rates: map[string]Rate{"": 72500, "grocery": 0}}}
+
+	order := storage.Order{
+		Jurisdiction: "US-CA",
+		LineItems: []storage.LineItem{
+			{Description: "laptop", PriceCents: 100000, Quantity: 1},
+			{Description: "apples", PriceCents: 300, Quantity: 5, TaxCategory: "grocery"},
+		},
+	}
+	require.NoError(t, Apply(ctx, table, &order))
+	// categories without tax don't get a line item
+	assert.Equal(t, []storage.LineItem{
+		{Description: "laptop", PriceCents: 100000, Quantity: 1},
+		{Description: "apples", PriceCents: 300, Quantity: 5, TaxCategory: "grocery"},
+		{Description: "Tax US-CA 7.25%", PriceCents: 7250, Quantity: 1, Kind: storage.LineItemKindTax},
+	}, order.LineItems)
+	assert.EqualValues(t, 7250, order.Tax.TotalCents)
+	assert.EqualValues(t, 108750, order.TotalCents())
+
+	// applying again replaces the tax rather than adding more
+	require.NoError(t, Apply(ctx, table, &order))
+	assert.Len(t, order.LineItems, 3)
+	assert.EqualValues(t, 108750, order.TotalCents())
+
+	// orders without a jurisdiction aren't taxed
+	untaxed := storage.Order{LineItems: []storage.LineItem{{Description: "laptop", PriceCents: 100000, Quantity: 1}}}
+	require.NoError(t, Apply(ctx, table, &untaxed))
+	assert.Nil(t, untaxed.Tax)
+	assert.Len(t, untaxed.LineItems, 1)
+}
@@ -0,0 +1,381 @@
+// Package validation checks orders before they're stored. It's used by the api
+// package for incoming requests but doesn't depend on it so anything else that
+// creates orders can apply the same rules.
+package validation
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/levenlabs/order-up/storage"
+)
+
+// These are the limits applied to every order
+const (
+	// MaxLineItems is the most line items a single order can have
+	MaxLineItems = 100
+	// MaxDescriptionLength is the most characters a line item's description can
+	// have
+	MaxDescriptionLength = 500
+	// MaxQuantity is the largest quantity a single line item can have
+	MaxQuantity = 10000
+	// MaxPriceCents is the largest price for a single unit of a line item
+	MaxPriceCents = 100000000
+	// MinPriceCents is the smallest price for a single unit of a line item.
+	// Negative prices are allowed for discounts but the order's total can never
+	// be negative.
+	MinPriceCents = -MaxPriceCents
+	// MaxAddressFieldLength is the most characters any field of an address can
+	// have
+	MaxAddressFieldLength = 100
+	// MaxNotesLength is the most characters an order's notes can have
+	MaxNotesLength = 1000
+)
+
+// Violation describes a single invalid field
+type Violation struct {
+	// Field is the path to the field, like lineItems[0].quantity
+	Field string
+	// Message describes what's wrong with the field
+	Message string
+}
+
+// Violations is every violation found while validating something. A nil
+// Violations means it's valid.
+type Violations []Violation
+
+// Error implements the error interface so Violations can be returned as an
+// error by callers that don't care about the individual fields
+func (vs Violations) Error() string {
+	msgs := make([]string, len(vs))
+	for i, v := range vs {
+		msgs[i] = fmt.Sprintf("%s: %s", v.Field, v.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// add appends a violation for field
+func (vs *Violations) add(field, format string, args ...interface{}) {
+	*vs = append(*vs, Violation{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Order validates a new or edited order's customer email, currency, addresses,
+// notes and line items and returns every violation rather than stopping at the
+// first one
+func Order(order storage.Order) Violations {
+	return orderViolations(order, LineItem)
+}
+
+// ImportedOrder is like Order but for orders brought over from another system,
+// which already have their tax, shipping and discount line items. Those have
+// to agree with the order's tax breakdown, shipping method and promo codes
+// since nothing recalculates them.
+func ImportedOrder(order storage.Order) Violations {
+	vs := orderViolations(order, lineItemFields)
+	var taxCents int64
+	var hasTax bool
+	for i, li := range order.LineItems {
+		field := fmt.Sprintf("lineItems[%d]", i)
+		switch li.Kind {
+		case storage.LineItemKindProduct:
+		case storage.LineItemKindTax:
+			hasTax = true
+			taxCents += li.PriceCents * li.Quantity
+		case storage.LineItemKindShipping:
+			if order.ShippingMethod == "" {
+				vs.add(field+".kind", "shipping line items need the order's shippingMethod")
+			} else if li.PriceCents < 0 {
+				vs.add(field+".priceCents", "shipping cannot be negative")
+			}
+		case storage.LineItemKindDiscount:
+			if len(order.PromoCodes) == 0 {
+				vs.add(field+".kind", "discount line items need the order's promoCodes")
+			} else if li.PriceCents > 0 {
+				vs.add(field+".priceCents", "discounts cannot be positive")
+			}
+		default:
+			vs.add(field+".kind", "unknown line item kind")
+		}
+	}
+	switch {
+	case hasTax && order.Tax == nil:
+		vs.add("tax", "tax line items need the order's tax breakdown")
+	case order.Tax != nil && order.Tax.TotalCents != taxCents:
+		vs.add("tax", "tax line items total %d but the tax breakdown is %d", taxCents, order.Tax.TotalCents)
+	}
+	return vs
+}
+
+// orderViolations validates order with lineItem validating each line item
+func orderViolations(order storage.Order, lineItem func(string, storage.LineItem) Violations) Violations {
+	var vs Violations
+	vs = append(vs, Email("customerEmail", order.CustomerEmail)...)
+	if !order.Currency.Valid() {
+		vs.add("currency", "must be a supported ISO 4217 currency code")
+	}
+	vs = append(vs, Addresses(order)...)
+	if !utf8.ValidString(order.Notes) {
+		vs.add("notes", "notes must be valid UTF-8")
+	} else if utf8.RuneCountInString(order.Notes) > MaxNotesLength {
+		vs.add("notes", "notes cannot be longer than %d characters", MaxNotesLength)
+	}
+
+	switch {
+	case len(order.LineItems) < 1:
+		vs.add("lineItems", "an order must contain at least one line item")
+	case len(order.LineItems) > MaxLineItems:
+		vs.add("lineItems", "an order cannot contain more than %d line items", MaxLineItems)
+	}
+	for i, li := range order.LineItems {
+		field := fmt.Sprintf("lineItems[%d]", i)
+		vs = append(vs, lineItem(field, li)...)
+		// line items don't need a currency but if they have one it has to be the
+		// order's since we can't add amounts in different currencies
+		if li.Currency != "" && li.Currency != order.Currency {
+			vs.add(field+".currency", "must match the order's currency %s", order.Currency)
+		}
+	}
+
+	// the total is only meaningful if the individual line items are valid
+	if len(vs) == 0 {
+		total, ok := TotalCents(order.LineItems)
+		if !ok {
+			vs.add("lineItems", "an order's total is too large")
+		} else if total < 0 {
+			vs.add("lineItems", "an order's total cannot be less than 0")
+		}
+	}
+	return vs
+}
+
+// Email validates that email is a bare RFC 5322 address like
+// someone@example.com, without a display name or angle brackets
+func Email(field, email string) Violations {
+	var vs Violations
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		vs.add(field, "must be an email address")
+	}
+	return vs
+}
+
+// Addresses validates the order's shipping and billing addresses if they're set
+func Addresses(order storage.Order) Violations {
+	var vs Violations
+	if order.ShippingAddress != nil {
+		vs = append(vs, Address("shippingAddress", *order.ShippingAddress)...)
+	}
+	if order.BillingAddress != nil {
+		vs = append(vs, Address("billingAddress", *order.BillingAddress)...)
+	}
+	return vs
+}
+
+// Address validates a shipping or billing address. field is the path to the
+// address and is used as the prefix for the violations. Postal codes and
+// regions aren't required since not every country has them.
+func Address(field string, addr storage.Address) Violations {
+	var vs Violations
+	required := []struct {
+		name, value string
+	}{
+		{"name", addr.Name},
+		{"line1", addr.Line1},
+		{"city", addr.City},
+	}
+	for _, f := range required {
+		if strings.TrimSpace(f.value) == "" {
+			vs.add(field+"."+f.name, "%s is required", f.name)
+		}
+	}
+	lengths := []struct {
+		name, value string
+	}{
+		{"name", addr.Name},
+		{"line1", addr.Line1},
+		{"line2", addr.Line2},
+		{"city", addr.City},
+		{"region", addr.Region},
+		{"postalCode", addr.PostalCode},
+	}
+	for _, f := range lengths {
+		if !utf8.ValidString(f.value) {
+			vs.add(field+"."+f.name, "%s must be valid UTF-8", f.name)
+		} else if utf8.RuneCountInString(f.value) > MaxAddressFieldLength {
+			vs.add(field+"."+f.name, "%s cannot be longer than %d characters", f.name, MaxAddressFieldLength)
+		}
+	}
+	if len(addr.Country) != 2 || strings.Trim(addr.Country, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		vs.add(field+".country", "must be an ISO 3166-1 alpha-2 country code like US")
+	}
+	// the region ends up in the tax jurisdiction so it has to be the code, like
+	// CA, and not the name
+	if addr.Region != "" && (len(addr.Region) > 3 || strings.Trim(addr.Region, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789") != "") {
+		vs.add(field+".region", "must be the subdivision part of an ISO 3166-2 code like CA")
+	}
+	return vs
+}
+
+// LineItem validates a single line item. field is the path to the line item
+// and is used as the prefix for the violations.
+func LineItem(field string, li storage.LineItem) Violations {
+	vs := lineItemFields(field, li)
+	// tax, shipping and promotions are added to the order automatically so they
+	// can't be added by hand
+	if li.Kind != storage.LineItemKindProduct {
+		vs.add(field+".kind", "tax, shipping and promotion line items are added automatically")
+	}
+	return vs
+}
+
+// lineItemFields validates everything about a line item except its kind
+func lineItemFields(field string, li storage.LineItem) Violations {
+	var vs Violations
+	switch n := utf8.RuneCountInString(li.Description); {
+	case strings.TrimSpace(li.Description) == "":
+		vs.add(field+".description", "description is required")
+	case !utf8.ValidString(li.Description):
+		vs.add(field+".description", "description must be valid UTF-8")
+	case n > MaxDescriptionLength:
+		vs.add(field+".description", "description cannot be longer than %d characters", MaxDescriptionLength)
+	}
+	switch {
+	case li.Quantity < 1:
+		vs.add(field+".quantity", "quantity must be positive")
+	case li.Quantity > MaxQuantity:
+		vs.add(field+".quantity", "quantity cannot be more than %d", MaxQuantity)
+	}
+	if li.PriceCents < MinPriceCents || li.PriceCents > MaxPriceCents {
+		vs.add(field+".priceCents", "priceCents must be between %d and %d", MinPriceCents, MaxPriceCents)
+	}
+	return vs
+}
+
+// TotalCents is like storage.Order.TotalCents except that it returns false
+// instead of silently wrapping around if the total overflows an int64
+func TotalCents(lineItems []storage.LineItem) (int64, bool) {
+	var total int64
+	for _, li := range lineItems {
+		sub, ok := mulInt64(li.PriceCents, li.Quantity)
+		if !ok {
+			return 0, false
+		}
+		if total, ok = addInt64(total, sub); !ok {
+			return 0, false
+		}
+	}
+	return total, true
+}
+
+// mulInt64 multiplies a and b and returns false if the result overflows
+func mulInt64(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	// MinInt64 can't be negated so the unsigned math below doesn't work for it
+	if a == math.MinInt64 || b == math.MinInt64 {
+		return 0, false
+	}
+	neg := (a < 0) != (b < 0)
+	hi, lo := bits.Mul64(abs(a), abs(b))
+	if hi != 0 || lo > math.MaxInt64 {
+		// the one result that doesn't fit in MaxInt64 but is still valid is
+		// exactly MinInt64
+		if neg && hi == 0 && lo == 1<<63 {
+			return math.MinInt64, true
+		}
+		return 0, false
+	}
+	if neg {
+		return -int64(lo), true
+	}
+	return int64(lo), true
+}
+
+// addInt64 adds a and b and returns false if the result overflows
+func addInt64(a, b int64) (int64, bool) {
+	c := a + b
+	// overflow happened if both operands have the same sign and the result has a
+	// different one
+	if (a > 0 && b > 0 && c < 0) || (a < 0 && b < 0 && c >= 0) {
+		return 0, false
+	}
+	return c, true
+}
+
+// abs returns the absolute value of n which must not be MinInt64
+func abs(n int64) uint64 {
+	if n < 0 {
+		return uint64(-n)
+	}
+	return uint64(n)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// MaxPromoCodeLength is the longest a promotion code can be
+const MaxPromoCodeLength = 32
+
+// Promotion validates a new promotion. Its code must already be normalized with
+// NormalizePromoCode.
+func Promotion(promo storage.Promotion) Violations {
+	var vs Violations
+	switch {
+	case promo.Code == "":
+		vs.add("code", "code is required")
+	case len(promo.Code) > MaxPromoCodeLength:
+		vs.add("code", "code cannot be longer than %d characters", MaxPromoCodeLength)
+	case strings.TrimLeft(promo.Code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-") != "":
+		vs.add("code", "code can only contain letters, numbers, underscores and dashes")
+	}
+
+	switch promo.Kind {
+	case storage.PromotionKindPercentage:
+		if promo.PercentOff < 1 || promo.PercentOff > 100 {
+			vs.add("percentOff", "percentOff must be between 1 and 100")
+		}
+		if promo.AmountOffCents != 0 {
+			vs.add("amountOffCents", "amountOffCents can only be set for fixed promotions")
+		}
+	case storage.PromotionKindFixed:
+		if promo.AmountOffCents < 1 || promo.AmountOffCents > MaxPriceCents {
+			vs.add("amountOffCents", "amountOffCents must be between 1 and %d", MaxPriceCents)
+		}
+		if promo.PercentOff != 0 {
+			vs.add("percentOff", "percentOff can only be set for percentage promotions")
+		}
+	default:
+		vs.add("kind", "kind must be %s or %s", storage.PromotionKindPercentage, storage.PromotionKindFixed)
+	}
+
+	// amounts are meaningless without knowing their currency
+	needsCurrency := promo.Kind == storage.PromotionKindFixed || promo.MinOrderCents != 0
+	if promo.Currency != "" && !promo.Currency.Valid() {
+		vs.add("currency", "must be a supported ISO 4217 currency code")
+	} else if needsCurrency && promo.Currency == "" {
+		vs.add("currency", "currency is required for fixed promotions and minimum order totals")
+	}
+
+	if promo.MinOrderCents < 0 || promo.MinOrderCents > MaxPriceCents {
+		vs.add("minOrderCents", "minOrderCents must be between 0 and %d", MaxPriceCents)
+	}
+	if promo.MaxRedemptions < 0 {
+		vs.add("maxRedemptions", "maxRedemptions cannot be negative")
+	}
+	if promo.MaxRedemptionsPerCustomer < 0 {
+		vs.add("maxRedemptionsPerCustomer", "maxRedemptionsPerCustomer cannot be negative")
+	}
+	return vs
+}
+
+// NormalizePromoCode returns code in the form it's stored in so that codes are
+// case-insensitive
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
@@ -0,0 +1,215 @@
+package validation
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/levenlabs/order-up/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrder(t *testing.T) {
+	valid := storage.LineItem{Description: "item 1", Quantity: 1, PriceCents: 1000}
+
+	// a valid order has no violations
+	assert.Nil(t, Order(storage.Order{
+		CustomerEmail: "test@example.com",
+		Currency:      storage.DefaultCurrency,
+		LineItems:     []storage.LineItem{valid, {Description: "discount", Quantity: 1, PriceCents: -500}},
+	}))
+
+	// every violation is returned at once
+	assert.Equal(t, Violations{
+		{Field: "customerEmail", Message: "must be an email address"},
+		{Field: "lineItems[0].description", Message: "description is required"},
+		{Field: "lineItems[0].quantity", Message: "quantity must be positive"},
+		{Field: "lineItems[1].quantity", Message: "quantity cannot be more than 10000"},
+		{Field: "lineItems[1].priceCents", Message: "priceCents must be between -100000000 and 100000000"},
+	}, Order(storage.Order{
+		CustomerEmail: "Someone <test@example.com>",
+		Currency:      storage.DefaultCurrency,
+		LineItems: []storage.LineItem{
+			{Description: " ", Quantity: 0, PriceCents: 100},
+			{Description: "item", Quantity: MaxQuantity + 1, PriceCents: MaxPriceCents + 1},
+		},
+	}))
+
+	// an order needs at least one line item but not too many
+	assert.Equal(t, Violations{{Field: "lineItems", Message: "an order must contain at least one line item"}},
+		Order(storage.Order{CustomerEmail: "test@test", Currency: storage.DefaultCurrency}))
+	tooMany := make([]storage.LineItem, MaxLineItems+1)
+	for i := range tooMany {
+		tooMany[i] = valid
+	}
+	assert.Equal(t, Violations{{Field: "lineItems", Message: "an order cannot contain more than 100 line items"}},
+		Order(storage.Order{CustomerEmail: "test@test", Currency: storage.DefaultCurrency, LineItems: tooMany}))
+
+	// the total can't be negative
+	assert.Equal(t, Violations{{Field: "lineItems", Message: "an order's total cannot be less than 0"}},
+		Order(storage.Order{
+			CustomerEmail: "test@test",
+			Currency:      storage.DefaultCurrency,
+			LineItems:     []storage.LineItem{valid, {Description: "huge discount", Quantity: 1, PriceCents: -10000}},
+		}))
+
+	// the currency must be supported and line items must be in the same currency
+	assert.Equal(t, Violations{{Field: "currency", Message: "must be a supported ISO 4217 currency code"}},
+		Order(storage.Order{CustomerEmail: "test@test", Currency: "usd", LineItems: []storage.LineItem{valid}}))
+	assert.Equal(t, Violations{{Field: "lineItems[1].currency", Message: "must match the order's currency JPY"}},
+		Order(storage.Order{
+			CustomerEmail: "test@test",
+			Currency:      "JPY",
+			LineItems:     []storage.LineItem{{Description: "item", Quantity: 1, PriceCents: 500, Currency: "JPY"}, {Description: "item", Quantity: 1, PriceCents: 500, Currency: "USD"}},
+		}))
+
+	// tax can't be added by hand
+	assert.Equal(t, Violations{{Field: "li.kind", Message: "tax, shipping and promotion line items are added automatically"}},
+		LineItem("li", storage.LineItem{Description: "tax", Quantity: 1, PriceCents: 100, Kind: storage.LineItemKindTax}))
+
+	// notes are optional but limited
+	assert.Equal(t, Violations{{Field: "notes", Message: "notes cannot be longer than 1000 characters"}},
+		Order(storage.Order{
+			CustomerEmail: "test@test",
+			Currency:      storage.DefaultCurrency,
+			Notes:         strings.Repeat("a", MaxNotesLength+1),
+			LineItems:     []storage.LineItem{valid},
+		}))
+
+	// descriptions are limited by characters rather than bytes
+	assert.Nil(t, LineItem("li", storage.LineItem{Description: strings.Repeat("é", MaxDescriptionLength), Quantity: 1}))
+	assert.Equal(t, Violations{{Field: "li.description", Message: "description cannot be longer than 500 characters"}},
+		LineItem("li", storage.LineItem{Description: strings.Repeat("a", MaxDescriptionLength+1), Quantity: 1}))
+}
+
+func TestImportedOrder(t *testing.T) {
+	order := storage.Order{
+		CustomerEmail:  "test@test",
+		Currency:       storage.DefaultCurrency,
+		ShippingMethod: "standard",
+		PromoCodes:     []string{"SUMMER10"},
+		Tax:            &storage.TaxBreakdown{TotalCents: 90},
+		LineItems: []storage.LineItem{
+			{Description: "item", Quantity: 1, PriceCents: 1000},
+			{Description: "Promotion SUMMER10", Quantity: 1, PriceCents: -100, Kind: storage.LineItemKindDiscount},
+			{Description: "Standard shipping", Quantity: 1, PriceCents: 500, Kind: storage.LineItemKindShipping},
+			{Description: "Tax", Quantity: 1, PriceCents: 90, Kind: storage.LineItemKindTax},
+		},
+	}
+
+	// tax, shipping and discount line items are allowed when the order has what
+	// they were calculated from
+	assert.Nil(t, ImportedOrder(order))
+
+	// but they have to agree with it
+	wrong := order
+	wrong.Tax = &storage.TaxBreakdown{TotalCents: 80}
+	wrong.LineItems = append([]storage.LineItem{}, order.LineItems...)
+	wrong.LineItems[1].PriceCents = 100
+	wrong.LineItems[2].PriceCents = -500
+	wrong.LineItems = append(wrong.LineItems, storage.LineItem{Description: "gift", Quantity: 1, PriceCents: 100, Kind: "gift"})
+	assert.Equal(t, Violations{
+		{Field: "lineItems[1].priceCents", Message: "discounts cannot be positive"},
+		{Field: "lineItems[2].priceCents", Message: "shipping cannot be negative"},
+		{Field: "lineItems[4].kind", Message: "unknown line item kind"},
+		{Field: "tax", Message: "tax line items total 90 but the tax breakdown is 80"},
+	}, ImportedOrder(wrong))
+}
+
+func TestAddress(t *testing.T) {
+	valid := storage.Address{Name: "Wile E. Coyote", Line1: "1 Mesa Rd", City: "Needles", Region: "CA", Country: "US"}
+	assert.Nil(t, Address("addr", valid))
+
+	// the region and postal code are optional
+	noRegion := valid
+	noRegion.Region = ""
+	assert.Nil(t, Address("addr", noRegion))
+
+	assert.Equal(t, Violations{
+		{Field: "addr.name", Message: "name is required"},
+		{Field: "addr.city", Message: "city is required"},
+		{Field: "addr.line2", Message: "line2 cannot be longer than 100 characters"},
+		{Field: "addr.country", Message: "must be an ISO 3166-1 alpha-2 country code like US"},
+		{Field: "addr.region", Message: "must be the subdivision part of an ISO 3166-2 code like CA"},
+	}, Address("addr", storage.Address{
+		Line1:   "1 Mesa Rd",
+		Line2:   strings.Repeat("a", MaxAddressFieldLength+1),
+		Region:  "California",
+		Country: "us",
+	}))
+
+	// addresses are checked as part of the order
+	assert.Equal(t, Violations{{Field: "shippingAddress.country", Message: "must be an ISO 3166-1 alpha-2 country code like US"}},
+		Order(storage.Order{
+			CustomerEmail:   "test@test",
+			Currency:        storage.DefaultCurrency,
+			ShippingAddress: &storage.Address{Name: "a", Line1: "b", City: "c"},
+			LineItems:       []storage.LineItem{{Description: "item", Quantity: 1}},
+		}))
+}
+
+func TestEmail(t *testing.T) {
+	for _, email := range []string{"test@test", "first.last+tag@example.co.uk"} {
+		assert.Nil(t, Email("email", email), email)
+	}
+	for _, email := range []string{"", "invalid", "@example.com", "a@", "Name <a@example.com>", " a@example.com"} {
+		assert.NotNil(t, Email("email", email), email)
+	}
+}
+
+func TestTotalCents(t *testing.T) {
+	total, ok := TotalCents([]storage.LineItem{
+		{PriceCents: 100, Quantity: 3},
+		{PriceCents: -50, Quantity: 2},
+	})
+	assert.True(t, ok)
+	assert.EqualValues(t, 200, total)
+
+	_, ok = TotalCents([]storage.LineItem{{PriceCents: math.MaxInt64, Quantity: 2}})
+	assert.False(t, ok)
+
+	_, ok = TotalCents([]storage.LineItem{
+		{PriceCents: math.MaxInt64, Quantity: 1},
+		{PriceCents: 1, Quantity: 1},
+	})
+	assert.False(t, ok)
+
+	_, ok = TotalCents([]storage.LineItem{{PriceCents: math.MinInt64, Quantity: -1}})
+	assert.False(t, ok)
+
+	total, ok = TotalCents([]storage.LineItem{{PriceCents: math.MinInt64 / 2, Quantity: 2}})
+	assert.True(t, ok)
+	assert.EqualValues(t, int64(math.MinInt64), total)
+}
+
+func TestPromotion(t *testing.T) {
+	// valid promotions have no violations
+	assert.Nil(t, Promotion(storage.Promotion{Code: "SUMMER-10", Kind: storage.PromotionKindPercentage, PercentOff: 10}))
+	assert.Nil(t, Promotion(storage.Promotion{Code: "FIVE_OFF", Kind: storage.PromotionKindFixed, AmountOffCents: 500, Currency: "USD"}))
+
+	// every violation is returned at once
+	assert.Equal(t, Violations{
+		{Field: "code", Message: "code can only contain letters, numbers, underscores and dashes"},
+		{Field: "percentOff", Message: "percentOff must be between 1 and 100"},
+		{Field: "amountOffCents", Message: "amountOffCents can only be set for fixed promotions"},
+		{Field: "currency", Message: "currency is required for fixed promotions and minimum order totals"},
+		{Field: "maxRedemptions", Message: "maxRedemptions cannot be negative"},
+	}, Promotion(storage.Promotion{
+		Code:           "TEN OFF",
+		Kind:           storage.PromotionKindPercentage,
+		PercentOff:     101,
+		AmountOffCents: 100,
+		MinOrderCents:  1000,
+		MaxRedemptions: -1,
+	}))
+
+	// the kind has to be known
+	assert.Equal(t, Violations{
+		{Field: "code", Message: "code is required"},
+		{Field: "kind", Message: "kind must be percentage or fixed"},
+	}, Promotion(storage.Promotion{}))
+}
+
+func TestNormalizePromoCode(t *testing.T) {
+	assert.Equal(t, "SUMMER10", NormalizePromoCode(" summer10\n"))
+}